@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// job holds the inputs of a request waiting to be classified by the batcher
+type job struct {
+	inputs [][][][]precision.Float
+	k      int
+	result chan jobResult
+}
+
+type jobResult struct {
+	predictions [][]cnn.Prediction
+	err         error
+}
+
+// batcher groups concurrent requests into micro-batches. A batch is run as
+// soon as maxBatch inputs are queued or maxWait has elapsed since the first
+// one arrived, whichever comes first. The inputs of a request always go in
+// the same batch.
+type batcher struct {
+	model    *cnn.CNN
+	maxBatch int
+	maxWait  time.Duration
+	jobs     chan job
+	metrics  *metrics
+}
+
+// newBatcher starts workers goroutines pulling batches from a shared queue
+func newBatcher(model *cnn.CNN, maxBatch int, maxWait time.Duration, workers int, m *metrics) *batcher {
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	if workers < 1 {
+		workers = 1
+	}
+
+	b := &batcher{
+		model:    model,
+		maxBatch: maxBatch,
+		maxWait:  maxWait,
+		jobs:     make(chan job, maxBatch*workers),
+		metrics:  m,
+	}
+	for i := 0; i < workers; i++ {
+		go b.run()
+	}
+	return b
+}
+
+// predict queues the inputs of a request and waits for their top-k predictions
+func (b *batcher) predict(inputs [][][][]precision.Float, k int) ([][]cnn.Prediction, error) {
+	j := job{inputs: inputs, k: k, result: make(chan jobResult, 1)}
+	b.jobs <- j
+	r := <-j.result
+	return r.predictions, r.err
+}
+
+// run collects and processes batches until the job queue is closed
+func (b *batcher) run() {
+	for first := range b.jobs {
+		batch := []job{first}
+		size := len(first.inputs)
+		timer := time.NewTimer(b.maxWait)
+
+	collect:
+		for size < b.maxBatch {
+			select {
+			case j, ok := <-b.jobs:
+				if !ok {
+					break collect
+				}
+				batch = append(batch, j)
+				size += len(j.inputs)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		b.process(batch)
+	}
+}
+
+// process classifies the inputs of a batch in a single call and answers
+// every job of it. Inputs are checked first so that a malformed tensor only
+// fails its own request.
+func (b *batcher) process(batch []job) {
+	var inputs [][][][]precision.Float
+	var valid []job
+	k := 1
+	for _, j := range batch {
+		if err := b.checkInputs(j.inputs); err != nil {
+			j.result <- jobResult{err: err}
+			continue
+		}
+		valid = append(valid, j)
+		inputs = append(inputs, j.inputs...)
+		if j.k > k {
+			k = j.k
+		}
+	}
+	if len(inputs) == 0 {
+		return
+	}
+	b.metrics.observeBatch(len(inputs))
+
+	predictions, err := b.model.PredictTopKBatch(inputs, k)
+	for _, j := range valid {
+		if err != nil {
+			j.result <- jobResult{err: err}
+			continue
+		}
+		// Predictions are sorted, the first j.k ones are the top j.k
+		result := predictions[:len(j.inputs):len(j.inputs)]
+		predictions = predictions[len(j.inputs):]
+		for i, p := range result {
+			if len(p) > j.k {
+				result[i] = p[:j.k]
+			}
+		}
+		j.result <- jobResult{predictions: result}
+	}
+}
+
+// checkInputs verifies that the model can process every input of a request
+func (b *batcher) checkInputs(inputs [][][][]precision.Float) error {
+	for i, input := range inputs {
+		if err := b.model.CheckInput(input); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// close stops the workers once the queued jobs are processed
+func (b *batcher) close() {
+	close(b.jobs)
+}
@@ -0,0 +1,45 @@
+// Command cnn-serve exposes a model saved by cnn.EncodeCNN over HTTP.
+//
+// Endpoints:
+//
+//	POST /predict  classify a PNG/JPEG upload (raw body or multipart "image"
+//	               field) or JSON tensors {"input": ..., "inputs": [...], "k": n}
+//	GET  /healthz  liveness probe
+//	GET  /readyz   readiness probe, succeeds once the model is loaded
+//	GET  /metrics  Prometheus text metrics
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+)
+
+func main() {
+	modelPath := flag.String("model", "/tmp/cnn.json", "model file saved by cnn.EncodeCNN")
+	addr := flag.String("addr", "localhost:8080", "listen address")
+	topK := flag.Int("k", 3, "number of classes returned by default")
+	maxBatch := flag.Int("batch", 16, "largest micro-batch")
+	maxWait := flag.Duration("batch-wait", 2*time.Millisecond, "longest time a request waits for its batch to fill up")
+	workers := flag.Int("workers", 1, "number of goroutines processing batches")
+	flag.Parse()
+
+	s := newServer(options{TopK: *topK, MaxBatch: *maxBatch, MaxWait: *maxWait, Workers: *workers})
+
+	// Load the model in the background so that liveness probes succeed while
+	// readiness only turns green once the model is usable
+	go func() {
+		model, err := cnn.LoadCNN(*modelPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		s.setModel(model)
+		log.Printf("Model %s loaded (%d layers)", *modelPath, len(model.Layers))
+	}()
+
+	log.Printf("Listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, s.handler()))
+}
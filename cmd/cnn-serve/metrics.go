@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// batchBuckets are the upper bounds of the batch size histogram
+var batchBuckets = []float64{1, 2, 4, 8, 16, 32, 64}
+
+// histogram is a cumulative histogram in the Prometheus sense
+type histogram struct {
+	bounds []float64
+	counts []uint64 // One per bound, plus the +Inf bucket
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(v float64) {
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+// write prints the histogram in the Prometheus text exposition format
+func (h *histogram) write(w io.Writer, name, labels string) {
+	sep := ""
+	if labels != "" {
+		sep = ","
+	}
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"%g\"} %d\n", name, labels, sep, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, labels, sep, cumulative)
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// requestKey identifies a request counter
+type requestKey struct {
+	path string
+	code int
+}
+
+// metrics holds the server statistics exposed on /metrics
+type metrics struct {
+	mu       sync.Mutex
+	requests map[requestKey]uint64
+	latency  map[string]*histogram
+	batches  *histogram
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: map[requestKey]uint64{},
+		latency:  map[string]*histogram{},
+		batches:  newHistogram(batchBuckets),
+	}
+}
+
+// observeRequest records the outcome and duration of an HTTP request
+func (m *metrics) observeRequest(path string, code int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[requestKey{path, code}]++
+	h, ok := m.latency[path]
+	if !ok {
+		h = newHistogram(latencyBuckets)
+		m.latency[path] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// observeBatch records the size of a processed micro-batch
+func (m *metrics) observeBatch(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.batches.observe(float64(size))
+}
+
+// write prints all metrics in the Prometheus text exposition format
+func (m *metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].path != keys[j].path {
+			return keys[i].path < keys[j].path
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	fmt.Fprintln(w, "# HELP cnn_http_requests_total Number of HTTP requests by path and status code.")
+	fmt.Fprintln(w, "# TYPE cnn_http_requests_total counter")
+	for _, k := range keys {
+		fmt.Fprintf(w, "cnn_http_requests_total{path=%q,code=\"%d\"} %d\n", k.path, k.code, m.requests[k])
+	}
+
+	paths := make([]string, 0, len(m.latency))
+	for p := range m.latency {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	fmt.Fprintln(w, "# HELP cnn_http_request_duration_seconds HTTP request latency by path.")
+	fmt.Fprintln(w, "# TYPE cnn_http_request_duration_seconds histogram")
+	for _, p := range paths {
+		m.latency[p].write(w, "cnn_http_request_duration_seconds", fmt.Sprintf("path=%q", p))
+	}
+
+	fmt.Fprintln(w, "# HELP cnn_batch_size Number of inputs per processed micro-batch.")
+	fmt.Fprintln(w, "# TYPE cnn_batch_size histogram")
+	m.batches.write(w, "cnn_batch_size", "")
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register JPEG decoding
+	_ "image/png"  // Register PNG decoding
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// maxUploadSize bounds the size of a /predict request body
+const maxUploadSize = 10 << 20
+
+// options configures a server
+type options struct {
+	TopK     int           // Number of classes returned when the request does not ask for a specific k
+	MaxBatch int           // Largest micro-batch
+	MaxWait  time.Duration // Longest time a request waits for its batch to fill up
+	Workers  int           // Number of goroutines processing batches
+}
+
+// server answers prediction requests against a single shared model
+type server struct {
+	opts    options
+	metrics *metrics
+	model   atomic.Pointer[cnn.CNN]
+	batcher atomic.Pointer[batcher]
+}
+
+// tensorRequest is the JSON body accepted by /predict
+type tensorRequest struct {
+	Input  [][][]precision.Float   `json:"input"`  // A single input volume
+	Inputs [][][][]precision.Float `json:"inputs"` // Several input volumes
+	K      int                     `json:"k"`
+}
+
+// predictResponse is the JSON body returned by /predict, one entry per input
+type predictResponse struct {
+	Predictions [][]cnn.Prediction `json:"predictions"`
+}
+
+func newServer(opts options) *server {
+	return &server{opts: opts, metrics: newMetrics()}
+}
+
+// setModel makes the server ready to answer predictions with model
+func (s *server) setModel(model *cnn.CNN) {
+	b := newBatcher(model, s.opts.MaxBatch, s.opts.MaxWait, s.opts.Workers, s.metrics)
+	s.model.Store(model)
+	if old := s.batcher.Swap(b); old != nil {
+		old.close()
+	}
+}
+
+// handler returns the HTTP routes of the server
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/predict", s.instrument("/predict", s.handlePredict))
+	mux.HandleFunc("/healthz", s.instrument("/healthz", s.handleHealth))
+	mux.HandleFunc("/readyz", s.instrument("/readyz", s.handleReady))
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+// instrument records request count and latency for a handler
+func (s *server) instrument(path string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, code: http.StatusOK}
+		h(rec, r)
+		s.metrics.observeRequest(path, rec.code, time.Since(start))
+	}
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if s.model.Load() == nil {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.metrics.write(w)
+}
+
+// handlePredict classifies an uploaded PNG/JPEG image or JSON tensors
+func (s *server) handlePredict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	model, b := s.model.Load(), s.batcher.Load()
+	if model == nil || b == nil {
+		http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	inputs, k, err := s.decodeInputs(model, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	predictions, err := b.predict(inputs, k)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	response := predictResponse{Predictions: predictions}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// decodeInputs extracts the input volumes and the requested k from a request
+func (s *server) decodeInputs(model *cnn.CNN, r *http.Request) ([][][][]precision.Float, int, error) {
+	k := s.opts.TopK
+	if v := r.URL.Query().Get("k"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, 0, fmt.Errorf("invalid k %q", v)
+		}
+		k = n
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case mediaType == "application/json":
+		var req tensorRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return nil, 0, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		if req.K > 0 {
+			k = req.K
+		}
+		inputs := req.Inputs
+		if req.Input != nil {
+			inputs = append([][][][]precision.Float{req.Input}, inputs...)
+		}
+		if len(inputs) == 0 {
+			return nil, 0, errors.New("JSON body has no input")
+		}
+		return inputs, k, nil
+
+	case mediaType == "multipart/form-data":
+		file, _, err := r.FormFile("image")
+		if err != nil {
+			return nil, 0, fmt.Errorf("missing image field: %w", err)
+		}
+		defer file.Close()
+		input, err := decodeImage(model, file)
+		if err != nil {
+			return nil, 0, err
+		}
+		return [][][][]precision.Float{input}, k, nil
+
+	case strings.HasPrefix(mediaType, "image/"):
+		input, err := decodeImage(model, r.Body)
+		if err != nil {
+			return nil, 0, err
+		}
+		return [][][][]precision.Float{input}, k, nil
+	}
+
+	return nil, 0, fmt.Errorf("unsupported content type %q", mediaType)
+}
+
+// decodeImage reads a PNG or JPEG image and preprocesses it with the model metadata
+func decodeImage(model *cnn.CNN, r io.Reader) ([][][]precision.Float, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode image: %w", err)
+	}
+	return model.Metadata.Preprocess(img)
+}
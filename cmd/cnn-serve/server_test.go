@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func newTestModel() *cnn.CNN {
+	cn := cnn.NewCNN()
+	cn.AddConvLayer(8, 1, 2, 3, 1)
+	cn.AddMaxPoolingLayer(6, 2, 2, 2)
+	cn.AddFullyConnectedLayer(3, 2, 4)
+	cn.Metadata = cnn.Metadata{
+		InputShape:   []int{1, 8, 8},
+		ChannelOrder: cnn.ChannelsGray,
+		Scale:        1 / 255.0,
+		Labels:       []string{"a", "b", "c", "d"},
+	}
+	return cn
+}
+
+func newTestServer(t *testing.T, model *cnn.CNN) *httptest.Server {
+	s := newServer(options{TopK: 2, MaxBatch: 4, MaxWait: time.Millisecond, Workers: 2})
+	if model != nil {
+		s.setModel(model)
+	}
+	ts := httptest.NewServer(s.handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func decodePredictions(t *testing.T, resp *http.Response) predictResponse {
+	t.Helper()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("Unexpected status %d: %s", resp.StatusCode, body)
+	}
+	var p predictResponse
+	if err := json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func pngBytes(t *testing.T, size int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestReadiness(t *testing.T) {
+	ts := newTestServer(t, nil)
+
+	resp, _ := http.Get(ts.URL + "/healthz")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("/healthz returned %d", resp.StatusCode)
+	}
+	resp, _ = http.Get(ts.URL + "/readyz")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("/readyz returned %d before the model was loaded", resp.StatusCode)
+	}
+}
+
+func TestPredictImage(t *testing.T) {
+	ts := newTestServer(t, newTestModel())
+
+	resp, err := http.Post(ts.URL+"/predict?k=3", "image/png", bytes.NewReader(pngBytes(t, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := decodePredictions(t, resp)
+	if len(p.Predictions) != 1 || len(p.Predictions[0]) != 3 {
+		t.Errorf("Unexpected predictions %+v", p)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, _ := mw.CreateFormFile("image", "digit.png")
+	fw.Write(pngBytes(t, 8))
+	mw.Close()
+	resp, err = http.Post(ts.URL+"/predict", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p = decodePredictions(t, resp)
+	if len(p.Predictions) != 1 || len(p.Predictions[0]) != 2 || p.Predictions[0][0].Label == "" {
+		t.Errorf("Unexpected predictions %+v", p)
+	}
+}
+
+func TestPredictTensors(t *testing.T) {
+	model := newTestModel()
+	ts := newTestServer(t, model)
+
+	input := make([][][]precision.Float, 1)
+	input[0] = make([][]precision.Float, 8)
+	for y := range input[0] {
+		input[0][y] = make([]precision.Float, 8)
+		input[0][y][y] = 1
+	}
+	class, _, _ := model.Predict(input)
+
+	body, _ := json.Marshal(tensorRequest{Inputs: [][][][]precision.Float{input, input}, K: 1})
+
+	// Concurrent requests exercise the micro-batching
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := http.Post(ts.URL+"/predict", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			p := decodePredictions(t, resp)
+			if len(p.Predictions) != 2 || p.Predictions[1][0].Class != class {
+				t.Errorf("Unexpected predictions %+v, expected class %d", p, class)
+			}
+		}()
+	}
+	wg.Wait()
+
+	resp, _ := http.Post(ts.URL+"/predict", "application/json", strings.NewReader(`{"input": [[[1, 2]]]}`))
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Wrongly shaped tensor returned %d", resp.StatusCode)
+	}
+
+	resp, _ = http.Get(ts.URL + "/metrics")
+	metrics, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`cnn_http_requests_total{path="/predict",code="200"} 8`,
+		`cnn_http_requests_total{path="/predict",code="400"} 1`,
+		`cnn_http_request_duration_seconds_count{path="/predict"} 9`,
+		`cnn_batch_size_count`,
+	} {
+		if !strings.Contains(string(metrics), want) {
+			t.Errorf("Metrics do not contain %q:\n%s", want, metrics)
+		}
+	}
+}
+
+func TestBatcherGroupsRequests(t *testing.T) {
+	model := newTestModel()
+	m := newMetrics()
+	// The batch only runs once full, so both requests must share it
+	b := newBatcher(model, 4, time.Minute, 1, m)
+	defer b.close()
+
+	inputs := make([][][][]precision.Float, 4)
+	for i := range inputs {
+		inputs[i] = [][][]precision.Float{make([][]precision.Float, 8)}
+		for y := range inputs[i][0] {
+			inputs[i][0][y] = make([]precision.Float, 8)
+			inputs[i][0][y][(y+i)%8] = 1
+		}
+	}
+
+	var wg sync.WaitGroup
+	for r, k := range []int{1, 3} {
+		wg.Add(1)
+		go func(request [][][][]precision.Float, k int) {
+			defer wg.Done()
+			predictions, err := b.predict(request, k)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for i, input := range request {
+				expected, _ := model.PredictTopK(input, k)
+				if !reflect.DeepEqual(predictions[i], expected) {
+					t.Errorf("input %d with k %d: got %+v, expected %+v", i, k, predictions[i], expected)
+				}
+			}
+		}(inputs[2*r:2*r+2], k)
+	}
+	wg.Wait()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.batches.count != 1 || m.batches.sum != 4 {
+		t.Errorf("%d batches of %g inputs in total, expected a single one of 4", m.batches.count, m.batches.sum)
+	}
+}
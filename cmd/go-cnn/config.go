@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// config holds the settings shared by the subcommands. JSON keys match the
+// flag names so that a config file and the command line are interchangeable.
+type config struct {
+	Dataset        string  `json:"dataset"`         // Directory holding the MNIST files
+	Model          string  `json:"model"`           // Model file to read, or to write when training
+	Init           string  `json:"init"`            // Model to resume training from (optional)
+	Spec           string  `json:"spec"`            // JSON or YAML model specification (optional)
+	Epochs         int     `json:"epochs"`          // Passes over the training set
+	BatchSize      int     `json:"batch"`           // Samples between two accuracy reports
+	AccuracyTarget float64 `json:"accuracy-target"` // Training stops once a batch reaches it
+	Seed           int64   `json:"seed"`            // Seed for initialization, shuffling and augmentation
+	Augment        bool    `json:"augment"`         // Randomly shift and rotate training images
+	TopK           int     `json:"k"`               // Number of classes printed by predict
+	Pprof          string  `json:"pprof"`           // Address of the pprof server, empty to disable
+	Out            string  `json:"out"`             // Output file of convert
+	MetadataFile   string  `json:"metadata"`        // JSON metadata embedded by convert (optional)
+	Indent         bool    `json:"indent"`          // Indent the JSON written by convert
+	Precision      string  `json:"precision"`       // Storage type of the parameters written by convert
+	Calibration    int     `json:"calibration"`     // Training images used to calibrate quantization
+	PerChannel     bool    `json:"per-channel"`     // Quantize weights with one scale per channel
+	QAT            string  `json:"qat"`             // Granularity of quantization-aware training, empty to disable
+	Sparsity       float64 `json:"sparsity"`        // Fraction of the weights to prune
+	Structured     bool    `json:"structured"`      // Prune whole filters and inputs instead of single weights
+	Diagnostics    bool    `json:"diagnostics"`     // Check for NaN and Inf and report layer statistics while training
+	LogDir         string  `json:"logdir"`          // Directory of the training metrics, empty to disable
+	Dashboard      bool    `json:"dashboard"`       // Serve a live training dashboard on the pprof server
+}
+
+func defaultConfig() config {
+	return config{
+		Dataset:        "datasets/mnist",
+		Model:          "/tmp/cnn.json",
+		Epochs:         10,
+		BatchSize:      500,
+		AccuracyTarget: 0.98,
+		Seed:           1,
+		TopK:           3,
+		Calibration:    500,
+	}
+}
+
+// bind registers the flags of the given config fields on a flag set
+func (c *config) bind(fs *flag.FlagSet, names ...string) {
+	for _, name := range names {
+		switch name {
+		case "dataset":
+			fs.StringVar(&c.Dataset, name, c.Dataset, "directory holding the MNIST files")
+		case "model":
+			fs.StringVar(&c.Model, name, c.Model, "model file")
+		case "init":
+			fs.StringVar(&c.Init, name, c.Init, "model to resume training from")
+		case "spec":
+			fs.StringVar(&c.Spec, name, c.Spec, "JSON or YAML model specification")
+		case "epochs":
+			fs.IntVar(&c.Epochs, name, c.Epochs, "passes over the training set")
+		case "batch":
+			fs.IntVar(&c.BatchSize, name, c.BatchSize, "samples between two accuracy reports")
+		case "accuracy-target":
+			fs.Float64Var(&c.AccuracyTarget, name, c.AccuracyTarget, "stop training once a batch reaches this accuracy")
+		case "seed":
+			fs.Int64Var(&c.Seed, name, c.Seed, "seed for initialization, shuffling and augmentation")
+		case "augment":
+			fs.BoolVar(&c.Augment, name, c.Augment, "randomly shift and rotate training images")
+		case "k":
+			fs.IntVar(&c.TopK, name, c.TopK, "number of classes to print")
+		case "pprof":
+			fs.StringVar(&c.Pprof, name, c.Pprof, "address of the pprof server, e.g. localhost:6060")
+		case "out":
+			fs.StringVar(&c.Out, name, c.Out, "output model file")
+		case "metadata":
+			fs.StringVar(&c.MetadataFile, name, c.MetadataFile, "JSON file holding the metadata to embed")
+		case "indent":
+			fs.BoolVar(&c.Indent, name, c.Indent, "indent the JSON output")
+		case "precision":
+			fs.StringVar(&c.Precision, name, c.Precision, "storage type of the parameters: float32, float64, float16 or bfloat16 (default: keep)")
+		case "calibration":
+			fs.IntVar(&c.Calibration, name, c.Calibration, "number of training images used to calibrate activations")
+		case "per-channel":
+			fs.BoolVar(&c.PerChannel, name, c.PerChannel, "quantize weights with one scale per filter or neuron")
+		case "qat":
+			fs.StringVar(&c.QAT, name, c.QAT, "train with fake int8 quantization: per-tensor or per-channel")
+		case "sparsity":
+			fs.Float64Var(&c.Sparsity, name, c.Sparsity, "fraction of the weights to prune, 0 to disable")
+		case "structured":
+			fs.BoolVar(&c.Structured, name, c.Structured, "prune whole filters and inputs, and remove the filters from the model")
+		case "diagnostics":
+			fs.BoolVar(&c.Diagnostics, name, c.Diagnostics, "stop on NaN or Inf and print layer statistics after every epoch")
+		case "logdir":
+			fs.StringVar(&c.LogDir, name, c.LogDir, "directory receiving CSV, JSON lines and TensorBoard metrics")
+		case "dashboard":
+			fs.BoolVar(&c.Dashboard, name, c.Dashboard, "serve a live training dashboard at /dashboard/ on the -pprof address (default localhost:6060)")
+		default:
+			panic("unknown config field " + name)
+		}
+	}
+}
+
+// parseConfig parses the command line of a subcommand using the given
+// config fields. Values from -config are loaded first, then overridden by
+// the flags explicitly set on the command line.
+func parseConfig(fs *flag.FlagSet, args []string, names ...string) (config, error) {
+	cfg := defaultConfig()
+	configPath := fs.String("config", "", "JSON config file, keys match the flag names")
+	cfg.bind(fs, names...)
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if *configPath == "" {
+		return cfg, nil
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		return cfg, err
+	}
+	fileCfg := defaultConfig()
+	if err := json.Unmarshal(data, &fileCfg); err != nil {
+		return cfg, fmt.Errorf("%s: %w", *configPath, err)
+	}
+
+	// Replay the flags set on the command line on top of the file values
+	override := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	fileCfg.bind(override, names...)
+	var setErr error
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && setErr == nil {
+			setErr = override.Set(f.Name, f.Value.String())
+		}
+	})
+	return fileCfg, setErr
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "model", "out", "metadata", "indent", "precision")
+	if err != nil {
+		return err
+	}
+	if cfg.Out == "" {
+		return errors.New("no output file given, use -out")
+	}
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+
+	if cfg.MetadataFile != "" {
+		data, err := os.ReadFile(cfg.MetadataFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &cn.Metadata); err != nil {
+			return fmt.Errorf("%s: %w", cfg.MetadataFile, err)
+		}
+	}
+
+	if cfg.Precision != "" {
+		if cn.Precision, err = precision.Parse(cfg.Precision); err != nil {
+			return err
+		}
+	}
+
+	data := cnn.EncodeCNN(cn)
+	if cfg.Indent {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, data, "", "  "); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	if err := os.WriteFile(cfg.Out, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("%s converted to %s\n", cfg.Model, cfg.Out)
+	return nil
+}
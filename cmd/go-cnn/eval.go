@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/petar/GoMNIST"
+)
+
+func runEval(args []string) error {
+	fs := flag.NewFlagSet("eval", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "dataset", "model", "pprof")
+	if err != nil {
+		return err
+	}
+	startPprof(cfg.Pprof)
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+	if len(cn.Metadata.InputShape) == 0 {
+		cn.Metadata = mnistMetadata()
+	}
+
+	_, testData, err := GoMNIST.Load(cfg.Dataset)
+	if err != nil {
+		return fmt.Errorf("loading MNIST dataset: %w", err)
+	}
+
+	classes := len(cn.Metadata.Labels)
+	confusion := make([][]int, classes)
+	for i := range confusion {
+		confusion[i] = make([]int, classes)
+	}
+
+	correct := 0
+	for i := range testData.Images {
+		class, _, err := cn.PredictImage(testData.Images[i])
+		if err != nil {
+			return err
+		}
+		label := int(testData.Labels[i])
+		if class == label {
+			correct++
+		}
+		if label < classes && class < classes {
+			confusion[label][class]++
+		}
+	}
+
+	fmt.Printf("Accuracy: %.2fpct (%d/%d)\n\n", 100*float64(correct)/float64(testData.Count()), correct, testData.Count())
+
+	// Confusion matrix, rows are the expected labels and columns the predictions
+	fmt.Printf("%6s", "")
+	for _, label := range cn.Metadata.Labels {
+		fmt.Printf("%6s", label)
+	}
+	fmt.Printf("%8s\n", "recall")
+	for i, row := range confusion {
+		fmt.Printf("%6s", cn.Metadata.Labels[i])
+		total := 0
+		for _, n := range row {
+			fmt.Printf("%6d", n)
+			total += n
+		}
+		recall := 0.0
+		if total > 0 {
+			recall = float64(row[i]) / float64(total)
+		}
+		fmt.Printf("%7.1f%%\n", recall*100)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+// Command go-cnn trains, evaluates and inspects CNN models.
+//
+// Usage:
+//
+//	go-cnn <command> [flags]
+//
+// Commands:
+//
+//	train     train a model on the MNIST dataset
+//	eval      measure the accuracy of a model on the MNIST test set
+//	predict   classify image files
+//	summary   print the layers of a model
+//	convert   rewrite a model file in the current format or another precision
+//	inspect   print the metadata and weight statistics of a model
+//	quantize  convert a model to int8 and compare its accuracy
+//	prune     remove the smallest weights or filters of a model
+//
+// Every command accepts -config, a JSON file whose keys match the flag
+// names; flags given on the command line take precedence over the file.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// command is a go-cnn subcommand
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) error
+}
+
+var commands = []command{
+	{"train", "train a model on the MNIST dataset", runTrain},
+	{"eval", "measure the accuracy of a model on the MNIST test set", runEval},
+	{"predict", "classify image files", runPredict},
+	{"summary", "print the layers of a model", runSummary},
+	{"convert", "rewrite a model file in the current format or another precision", runConvert},
+	{"inspect", "print the metadata and weight statistics of a model", runInspect},
+	{"quantize", "convert a model to int8 and compare its accuracy", runQuantize},
+	{"prune", "remove the smallest weights or filters of a model", runPrune},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: go-cnn <command> [flags]")
+	fmt.Fprintln(os.Stderr, "\nCommands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-9s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'go-cnn <command> -h' for the flags of a command.")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, c := range commands {
+		if c.name == os.Args[1] {
+			if err := c.run(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "go-cnn %s: %v\n", c.name, err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "go-cnn: unknown command %q\n", os.Args[1])
+	usage()
+	os.Exit(2)
+}
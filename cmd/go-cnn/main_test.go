@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestParseConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "train.json")
+	if err := os.WriteFile(path, []byte(`{"epochs": 3, "batch": 50, "augment": true}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("train", flag.ContinueOnError)
+	cfg, err := parseConfig(fs, []string{"-config", path, "-batch", "20"}, "epochs", "batch", "augment", "seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Epochs != 3 || cfg.BatchSize != 20 || !cfg.Augment || cfg.Seed != defaultConfig().Seed {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
+
+func TestConvertAndInspect(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "legacy.json")
+	out := filepath.Join(dir, "model.json")
+	metadata := filepath.Join(dir, "metadata.json")
+
+	// Strip the metadata to simulate a model saved by an older version
+	cn := newMNISTModel(1)
+	cn.Metadata = cnn.Metadata{}
+	if err := cnn.SaveCNN(cn, in); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(metadata, []byte(`{"InputShape": [1, 28, 28], "Scale": 0.5}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runConvert([]string{"-model", in, "-out", out, "-metadata", metadata, "-indent"}); err != nil {
+		t.Fatal(err)
+	}
+	converted, err := cnn.LoadCNN(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if converted.Metadata.Scale != 0.5 || len(converted.Layers) != 5 {
+		t.Errorf("Unexpected converted model %+v", converted.Metadata)
+	}
+
+	for _, run := range []func([]string) error{runSummary, runInspect} {
+		if err := run([]string{"-model", out}); err != nil {
+			t.Error(err)
+		}
+	}
+
+	half := filepath.Join(dir, "model-f16.json")
+	if err := runConvert([]string{"-model", out, "-out", half, "-precision", "float16"}); err != nil {
+		t.Fatal(err)
+	}
+	if converted, err = cnn.LoadCNN(half); err != nil {
+		t.Fatal(err)
+	}
+	if converted.Precision != precision.Float16 || converted.Metadata.Scale != 0.5 {
+		t.Errorf("Unexpected float16 model: precision %q, metadata %+v", converted.Precision, converted.Metadata)
+	}
+	if err := runConvert([]string{"-model", out, "-out", half, "-precision", "float8"}); err == nil {
+		t.Error("convert accepted an unknown precision")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "model.json")
+	out := filepath.Join(dir, "pruned.json")
+	if err := cnn.SaveCNN(newMNISTModel(1), in); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := runPrune([]string{"-model", in, "-out", out, "-sparsity", "0.5", "-structured"}); err != nil {
+		t.Fatal(err)
+	}
+	pruned, err := cnn.LoadCNN(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if depth := pruned.Layers[0].OutputShape()[0]; depth != 3 {
+		t.Errorf("first convolution has %d filters after pruning half of 6", depth)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+	"github.com/petar/GoMNIST"
+)
+
+// mnistMetadata describes the preprocessing of MNIST digits
+func mnistMetadata() cnn.Metadata {
+	return cnn.Metadata{
+		InputShape:   []int{1, GoMNIST.Height, GoMNIST.Width},
+		ChannelOrder: cnn.ChannelsGray,
+		Scale:        1 / 255.0,
+		Labels:       []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+	}
+}
+
+// newMNISTModel builds the default MNIST architecture, with weights drawn from seed
+func newMNISTModel(seed int64) *cnn.CNN {
+	cn := cnn.NewCNN()
+	cn.SetSeed(seed)
+	cn.AddConvLayer(28, 1, 6, 5, 1)
+	cn.AddMaxPoolingLayer(24, 6, 2, 2)
+	cn.AddConvLayer(12, 6, 9, 3, 1)
+	cn.AddMaxPoolingLayer(10, 9, 2, 2)
+	cn.AddFullyConnectedLayer(5, 9, 10)
+	cn.Metadata = mnistMetadata()
+	return cn
+}
+
+// mnistDataset exposes a GoMNIST set to the augmentation loader,
+// preprocessing images with the model metadata
+type mnistDataset struct {
+	set      *GoMNIST.Set
+	metadata *cnn.Metadata
+}
+
+func (d mnistDataset) Len() int {
+	return d.set.Count()
+}
+
+func (d mnistDataset) Get(index int) ([][][]precision.Float, int) {
+	input, err := d.metadata.Preprocess(d.set.Images[index])
+	if err != nil {
+		panic(err)
+	}
+	return input, int(d.set.Labels[index])
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	_ "image/jpeg" // Register JPEG decoding
+	_ "image/png"  // Register PNG decoding
+	"os"
+
+	"github.com/ofauchon/go-cnn/cnn"
+)
+
+func runPredict(args []string) error {
+	fs := flag.NewFlagSet("predict", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: go-cnn predict [flags] image...")
+		fs.PrintDefaults()
+	}
+	cfg, err := parseConfig(fs, args, "model", "k")
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no image given")
+	}
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range fs.Args() {
+		img, err := readImage(path)
+		if err != nil {
+			return err
+		}
+		input, err := cn.Metadata.Preprocess(img)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		predictions, err := cn.PredictTopK(input, cfg.TopK)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		fmt.Println(path)
+		for _, p := range predictions {
+			fmt.Printf("  %-10s %6.2f%%\n", p.Label, p.Probability*100)
+		}
+	}
+	return nil
+}
+
+// readImage decodes a PNG or JPEG file
+func readImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return img, nil
+}
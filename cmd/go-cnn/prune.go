@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/prune"
+)
+
+func runPrune(args []string) error {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "model", "out", "sparsity", "structured")
+	if err != nil {
+		return err
+	}
+	if cfg.Out == "" {
+		return errors.New("no output file given, use -out")
+	}
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+
+	// Pruning at once loses accuracy; fine-tune the result with train -init,
+	// or prune gradually with train -sparsity
+	if err := prune.Prune(cn, pruneMethod(cfg.Structured), cfg.Sparsity); err != nil {
+		return err
+	}
+	fmt.Printf("Sparsity: %.1fpct\n", prune.Sparsity(cn)*100)
+	if cfg.Structured {
+		fmt.Printf("Filters removed: %d\n", prune.Shrink(cn))
+	}
+	cn.Summary(os.Stdout)
+
+	if err := cnn.SaveCNN(cn, cfg.Out); err != nil {
+		return err
+	}
+	fmt.Println("Pruned model saved to:", cfg.Out)
+	return nil
+}
+
+// pruneMethod returns the pruning method selected by -structured
+func pruneMethod(structured bool) prune.Method {
+	if structured {
+		return prune.Structured
+	}
+	return prune.Unstructured
+}
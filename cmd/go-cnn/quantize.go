@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+	"github.com/ofauchon/go-cnn/cnn/quant"
+	"github.com/petar/GoMNIST"
+)
+
+func runQuantize(args []string) error {
+	fs := flag.NewFlagSet("quantize", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "dataset", "model", "out", "calibration", "per-channel")
+	if err != nil {
+		return err
+	}
+	if cfg.Out == "" {
+		return errors.New("no output file given, use -out")
+	}
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+	if len(cn.Metadata.InputShape) == 0 {
+		cn.Metadata = mnistMetadata()
+	}
+
+	trainData, testData, err := GoMNIST.Load(cfg.Dataset)
+	if err != nil {
+		return fmt.Errorf("loading MNIST dataset: %w", err)
+	}
+
+	// Calibrate on training images, compare on the test set
+	calibration := make([][][][]precision.Float, 0, cfg.Calibration)
+	train := mnistDataset{set: trainData, metadata: &cn.Metadata}
+	for i := 0; i < cfg.Calibration && i < train.Len(); i++ {
+		input, _ := train.Get(i)
+		calibration = append(calibration, input)
+	}
+
+	granularity := quant.PerTensor
+	if cfg.PerChannel {
+		granularity = quant.PerChannel
+	}
+	m, err := quant.Quantize(cn, calibration, granularity)
+	if err != nil {
+		return err
+	}
+
+	test := mnistDataset{set: testData, metadata: &cn.Metadata}
+	inputs := make([][][][]precision.Float, test.Len())
+	labels := make([]int, test.Len())
+	for i := range inputs {
+		inputs[i], labels[i] = test.Get(i)
+	}
+	report, err := quant.Compare(cn, m, inputs, labels)
+	if err != nil {
+		return err
+	}
+	report.Write(os.Stdout)
+
+	if err := quant.Save(m, cfg.Out); err != nil {
+		return err
+	}
+	fmt.Printf("%s quantized %s to %s\n", cfg.Model, granularity, cfg.Out)
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func runSummary(args []string) error {
+	fs := flag.NewFlagSet("summary", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "model", "spec", "out")
+	if err != nil {
+		return err
+	}
+
+	var cn *cnn.CNN
+	if cfg.Spec != "" {
+		// A spec is summarized without training, which also validates it
+		spec, err := cnn.LoadSpec(cfg.Spec)
+		if err != nil {
+			return err
+		}
+		if cn, err = spec.Build(); err != nil {
+			return fmt.Errorf("%s: %w", cfg.Spec, err)
+		}
+	} else if cn, err = cnn.LoadCNN(cfg.Model); err != nil {
+		return err
+	}
+	cn.Summary(os.Stdout)
+
+	// Write the architecture back out as a JSON or YAML spec
+	if cfg.Out != "" {
+		spec, err := cnn.NewSpec(cn)
+		if err != nil {
+			return err
+		}
+		if err := spec.Save(cfg.Out); err != nil {
+			return err
+		}
+		fmt.Println("Spec written to:", cfg.Out)
+	}
+	return nil
+}
+
+func runInspect(args []string) error {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "model")
+	if err != nil {
+		return err
+	}
+
+	cn, err := cnn.LoadCNN(cfg.Model)
+	if err != nil {
+		return err
+	}
+
+	m := cn.Metadata
+	fmt.Println("Metadata:")
+	fmt.Printf("  Input shape:   %v\n", m.InputShape)
+	fmt.Printf("  Channel order: %s\n", m.ChannelOrder)
+	fmt.Printf("  Scale:         %g\n", m.Scale)
+	fmt.Printf("  Mean / Std:    %v / %v\n", m.Mean, m.Std)
+	fmt.Printf("  Labels:        %s\n", strings.Join(m.Labels, " "))
+	if cn.Precision != "" {
+		fmt.Printf("  Precision:     %s\n", cn.Precision)
+	}
+
+	fmt.Println("\nParameters:")
+	fmt.Printf("  %-4s %-22s %-8s %8s %10s %10s %10s %10s %7s\n", "#", "Layer", "Tensor", "Count", "Min", "Max", "Mean", "Std", "Zeros")
+	for i, layer := range cn.Layers {
+		for _, t := range layerTensors(layer) {
+			s := tensorStats(t.values)
+			fmt.Printf("  %-4d %-22s %-8s %8d %10.4f %10.4f %10.4f %10.4f %6.1f%%\n",
+				i, cnn.LayerName(layer), t.name, len(t.values), s.min, s.max, s.mean, s.std, s.zeros*100)
+		}
+	}
+	return nil
+}
+
+// tensor is a named, flattened parameter tensor
+type tensor struct {
+	name   string
+	values []precision.Float
+}
+
+// layerTensors returns the parameters of a layer
+func layerTensors(layer cnn.Layer) []tensor {
+	switch layer := layer.(type) {
+	case *layers.ConvLayer:
+		var kernels []precision.Float
+		for _, k := range layer.Kernels {
+			for _, c := range k {
+				for _, row := range c {
+					kernels = append(kernels, row...)
+				}
+			}
+		}
+		return []tensor{{"kernels", kernels}, {"biases", layer.Biases}}
+	case *layers.FullyConnectedLayer:
+		var weights []precision.Float
+		for _, row := range layer.Weights {
+			weights = append(weights, row...)
+		}
+		return []tensor{{"weights", weights}, {"biases", layer.Biases}}
+	}
+	return nil
+}
+
+type stats struct {
+	min, max, mean, std, zeros float64
+}
+
+func tensorStats(values []precision.Float) stats {
+	if len(values) == 0 {
+		return stats{}
+	}
+	s := stats{min: math.Inf(1), max: math.Inf(-1)}
+	sum, sumSq := 0.0, 0.0
+	for _, v := range values {
+		f := float64(v)
+		s.min = math.Min(s.min, f)
+		s.max = math.Max(s.max, f)
+		sum += f
+		sumSq += f * f
+		if v == 0 {
+			s.zeros++
+		}
+	}
+	n := float64(len(values))
+	s.mean = sum / n
+	s.std = math.Sqrt(math.Max(0, sumSq/n-s.mean*s.mean))
+	s.zeros /= n
+	return s
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	_ "net/http/pprof" // Register the profiling handlers
+	"os"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/augment"
+	"github.com/ofauchon/go-cnn/cnn/dashboard"
+	"github.com/ofauchon/go-cnn/cnn/prune"
+	"github.com/ofauchon/go-cnn/cnn/quant"
+	"github.com/ofauchon/go-cnn/cnn/trainlog"
+	"github.com/petar/GoMNIST"
+)
+
+func runTrain(args []string) error {
+	fs := flag.NewFlagSet("train", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args, "dataset", "model", "init", "spec", "epochs", "batch", "accuracy-target", "seed", "augment", "pprof", "qat", "sparsity", "structured", "diagnostics", "logdir", "dashboard")
+	if err != nil {
+		return err
+	}
+	if cfg.BatchSize < 1 {
+		return errors.New("batch size must be positive")
+	}
+	if cfg.Dashboard && cfg.Pprof == "" {
+		cfg.Pprof = "localhost:6060"
+	}
+
+	trainData, _, err := GoMNIST.Load(cfg.Dataset)
+	if err != nil {
+		return fmt.Errorf("loading MNIST dataset: %w", err)
+	}
+	fmt.Printf("MNIST OK: TRAIN count:%d dimensions:%dx%d\n", trainData.Count(), trainData.NRow, trainData.NCol)
+
+	cn := newMNISTModel(cfg.Seed)
+	switch {
+	case cfg.Init != "" && cfg.Spec != "":
+		return errors.New("-init and -spec are mutually exclusive")
+	case cfg.Init != "":
+		if cn, err = cnn.LoadCNN(cfg.Init); err != nil {
+			return err
+		}
+		if len(cn.Metadata.InputShape) == 0 {
+			cn.Metadata = mnistMetadata()
+		}
+	case cfg.Spec != "":
+		spec, err := cnn.LoadSpec(cfg.Spec)
+		if err != nil {
+			return err
+		}
+		if spec.Seed == 0 {
+			spec.Seed = cfg.Seed
+		}
+		if cn, err = spec.Build(); err != nil {
+			return fmt.Errorf("%s: %w", cfg.Spec, err)
+		}
+		if len(cn.Metadata.Labels) == 0 {
+			cn.Metadata.Labels = mnistMetadata().Labels
+		}
+	}
+	if cfg.QAT != "" {
+		// Usually fine-tuning a model given with -init before quantize
+		if err := quant.PrepareQAT(cn, quant.Granularity(cfg.QAT)); err != nil {
+			return err
+		}
+	}
+	cn.Summary(os.Stdout)
+
+	loader := augment.NewLoader(mnistDataset{trainData, &cn.Metadata}, len(cn.Metadata.Labels), cfg.BatchSize, cfg.Seed)
+	loader.Shuffle = true
+	if cfg.Augment {
+		loader.Transform = augment.RandomApply(0.5, augment.Compose(
+			augment.Shift{MaxDX: 2, MaxDY: 2},
+			augment.Rotate{MaxDegrees: 10},
+		))
+	}
+
+	// Pruning ramps up over the first three quarters of training, leaving
+	// the last ones to recover from it
+	var pruner *prune.Pruner
+	if cfg.Sparsity > 0 {
+		pruner = &prune.Pruner{Method: pruneMethod(cfg.Structured), Schedule: prune.Schedule{
+			FinalSparsity: cfg.Sparsity,
+			EndStep:       cfg.Epochs * trainData.Count() * 3 / 4,
+			Frequency:     cfg.BatchSize,
+		}}
+		if err := pruner.Schedule.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Diagnostics {
+		cn.Diagnostics = cnn.NewDiagnostics()
+	}
+
+	var logger *trainlog.Logger
+	if cfg.LogDir != "" {
+		if logger, err = trainlog.Open(cfg.LogDir); err != nil {
+			return err
+		}
+		defer logger.Close()
+	}
+
+	// The dashboard shares the HTTP server of pprof
+	var board *dashboard.Dashboard
+	if cfg.Dashboard {
+		board = dashboard.New(cn.Metadata.Labels)
+		board.Register(http.DefaultServeMux)
+		fmt.Printf("Dashboard: http://%s%s\n", cfg.Pprof, dashboard.Path)
+	}
+	startPprof(cfg.Pprof)
+
+	cn.SetTraining(true)
+	accuracy := 0.0
+	step := 0
+	start := time.Now()
+	for epoch := 1; epoch <= cfg.Epochs && accuracy < cfg.AccuracyTarget; epoch++ {
+		loader.Reset()
+		seen := 0
+		if board != nil {
+			board.ResetConfusion()
+		}
+
+		for batch := loader.Next(); batch != nil && accuracy < cfg.AccuracyTarget; batch = loader.Next() {
+			correct, loss := 0, 0.0
+			for i, sample := range batch {
+				output := cn.ForwardPropagate(sample.Image)
+				predicted := cnn.Argmax(output)
+				if predicted == sample.Label {
+					correct++
+				}
+				if logger != nil || board != nil {
+					loss += cn.TargetLoss(output, sample.Target)
+				}
+				if board != nil {
+					board.Observe(sample.Image, sample.Label, predicted)
+				}
+				cn.BackPropagateTarget(sample.Target)
+				if cn.Diagnostics != nil {
+					if err := cn.Diagnostics.Err(); err != nil {
+						cn.Diagnostics.Report(os.Stdout)
+						return fmt.Errorf("epoch %d, sample %d: %w", epoch, seen+i+1, err)
+					}
+				}
+
+				step++
+				if pruner != nil {
+					if _, err := pruner.Step(cn, step); err != nil {
+						return err
+					}
+				}
+			}
+			seen += len(batch)
+			accuracy = float64(correct) / float64(len(batch))
+
+			fmt.Printf("Epoch: %d, Samples: %d, Acc: %.2fpct, Elapsed: %s\n", epoch, seen, accuracy*100, time.Since(start).Round(time.Second))
+			if logger != nil {
+				if err := logger.Scalars(step, map[string]float64{
+					trainlog.Loss:         loss / float64(len(batch)),
+					trainlog.Accuracy:     accuracy,
+					trainlog.LearningRate: trainlog.OptimizerLearningRate(cn.Optimizer),
+					trainlog.Epoch:        float64(epoch),
+				}); err != nil {
+					return err
+				}
+			}
+			if board != nil {
+				board.Record(step, epoch, loss/float64(len(batch)), accuracy)
+				board.ShowFilters(cn)
+			}
+		}
+
+		if cn.Diagnostics != nil {
+			cn.Diagnostics.Report(os.Stdout)
+			cn.Diagnostics.Reset()
+		}
+
+		if logger != nil {
+			if err := logger.Weights(step, cn); err != nil {
+				return err
+			}
+		}
+	}
+
+	if pruner != nil {
+		fmt.Printf("Sparsity: %.1fpct\n", prune.Sparsity(cn)*100)
+		if cfg.Structured {
+			fmt.Printf("Filters removed: %d\n", prune.Shrink(cn))
+			cn.Summary(os.Stdout)
+		}
+	}
+
+	if err := cnn.SaveCNN(cn, cfg.Model); err != nil {
+		return err
+	}
+	fmt.Println("CNN model saved to:", cfg.Model)
+	return nil
+}
+
+// startPprof serves the profiling endpoints in the background when addr is set
+func startPprof(addr string) {
+	if addr == "" {
+		return
+	}
+	go func() {
+		log.Println(http.ListenAndServe(addr, nil))
+	}()
+}
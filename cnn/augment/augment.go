@@ -0,0 +1,100 @@
+// Package augment provides composable random transforms used to augment
+// training images on the fly.
+//
+// Images use the same [depth][height][width] layout as the cnn package.
+// Transforms never modify their input in place and draw all randomness
+// from the *rand.Rand they are given, so a seeded Loader produces the same
+// augmented stream on every run.
+package augment
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Transform represents a random modification of a single image
+type Transform interface {
+	Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float
+}
+
+// TransformFunc adapts an ordinary function to the Transform interface
+type TransformFunc func(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float
+
+// Apply calls f(image, rng)
+func (f TransformFunc) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	return f(image, rng)
+}
+
+// Compose chains several transforms, applying them in the given order
+func Compose(transforms ...Transform) Transform {
+	return TransformFunc(func(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+		for _, t := range transforms {
+			image = t.Apply(image, rng)
+		}
+		return image
+	})
+}
+
+// RandomApply applies t with probability p and returns the image unchanged otherwise
+func RandomApply(p float64, t Transform) Transform {
+	return TransformFunc(func(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+		if rng.Float64() < p {
+			return t.Apply(image, rng)
+		}
+		return image
+	})
+}
+
+// shape returns the depth, height and width of an image
+func shape(image [][][]precision.Float) (int, int, int) {
+	if len(image) == 0 || len(image[0]) == 0 {
+		return len(image), 0, 0
+	}
+	return len(image), len(image[0]), len(image[0][0])
+}
+
+func make3D(d1, d2, d3 int) [][][]precision.Float {
+	x := make([][][]precision.Float, d1)
+	for i1 := 0; i1 < d1; i1++ {
+		x[i1] = make([][]precision.Float, d2)
+		for i2 := 0; i2 < d2; i2++ {
+			x[i1][i2] = make([]precision.Float, d3)
+		}
+	}
+	return x
+}
+
+func clone3D(data [][][]precision.Float) [][][]precision.Float {
+	cloned := make([][][]precision.Float, len(data))
+	for i := range data {
+		cloned[i] = make([][]precision.Float, len(data[i]))
+		for j := range data[i] {
+			cloned[i][j] = make([]precision.Float, len(data[i][j]))
+			copy(cloned[i][j], data[i][j])
+		}
+	}
+	return cloned
+}
+
+// bilinear samples channel c of image at the fractional position (y, x).
+// Positions outside the image read as zero.
+func bilinear(image [][][]precision.Float, c int, y, x float64) precision.Float {
+	_, h, w := shape(image)
+	y0 := int(math.Floor(y))
+	x0 := int(math.Floor(x))
+	dy := precision.Float(y - float64(y0))
+	dx := precision.Float(x - float64(x0))
+
+	at := func(yy, xx int) precision.Float {
+		if yy < 0 || yy >= h || xx < 0 || xx >= w {
+			return 0
+		}
+		return image[c][yy][xx]
+	}
+
+	top := at(y0, x0)*(1-dx) + at(y0, x0+1)*dx
+	bottom := at(y0+1, x0)*(1-dx) + at(y0+1, x0+1)*dx
+	return top*(1-dy) + bottom*dy
+}
@@ -0,0 +1,139 @@
+package augment
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// rampDataset returns 4x4 single channel images filled with index+position
+type rampDataset int
+
+func (d rampDataset) Len() int {
+	return int(d)
+}
+
+func (d rampDataset) Get(index int) ([][][]precision.Float, int) {
+	image := make3D(1, 4, 4)
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			image[0][y][x] = precision.Float(index + y*4 + x)
+		}
+	}
+	return image, index % 3
+}
+
+func TestHorizontalFlip(t *testing.T) {
+	input := [][][]precision.Float{{{1, 2, 3}, {4, 5, 6}}}
+	expected := [][][]precision.Float{{{3, 2, 1}, {6, 5, 4}}}
+
+	output := HorizontalFlip{P: 1}.Apply(input, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(output, expected) {
+		t.Errorf("HorizontalFlip did not produce the expected output. Got %v, expected %v", output, expected)
+	}
+	if input[0][0][0] != 1 {
+		t.Errorf("HorizontalFlip modified its input")
+	}
+}
+
+func TestRotateZeroIsIdentity(t *testing.T) {
+	input, _ := rampDataset(1).Get(0)
+	output := Rotate{MaxDegrees: 0}.Apply(input, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(output, input) {
+		t.Errorf("Rotate by 0 degrees changed the image. Got %v, expected %v", output, input)
+	}
+}
+
+func TestDegenerateParameters(t *testing.T) {
+	input, _ := rampDataset(1).Get(0)
+	rng := rand.New(rand.NewSource(1))
+
+	// Negative offsets are treated as zero rather than panicking
+	for _, tr := range []Transform{Shift{MaxDX: -1, MaxDY: -2}, RandomCrop{Padding: -1}} {
+		if output := tr.Apply(input, rng); !reflect.DeepEqual(output, input) {
+			t.Errorf("%+v changed the image to %v", tr, output)
+		}
+	}
+
+	// Without smoothing the displacement stays finite
+	output := Elastic{Alpha: 1, Sigma: 0}.Apply(input, rng)
+	for _, row := range output[0] {
+		for _, v := range row {
+			if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+				t.Fatalf("Elastic with Sigma 0 gave %v", output)
+			}
+		}
+	}
+}
+
+func TestLoaderIsReproducible(t *testing.T) {
+	newLoader := func() *Loader {
+		l := NewLoader(rampDataset(10), 3, 4, 7)
+		l.Shuffle = true
+		l.Transform = Compose(Shift{MaxDX: 1, MaxDY: 1}, GaussianNoise{Std: 0.1})
+		l.BatchTransform = Mixup{Alpha: 0.4}
+		return l
+	}
+
+	a, b := newLoader(), newLoader()
+	count := 0
+	for batchA, batchB := a.Next(), b.Next(); batchA != nil; batchA, batchB = a.Next(), b.Next() {
+		if !reflect.DeepEqual(batchA, batchB) {
+			t.Fatalf("Loaders with the same seed produced different batches")
+		}
+		count += len(batchA)
+	}
+	if count != 10 {
+		t.Errorf("Loader produced %d samples, expected 10", count)
+	}
+}
+
+func TestLoaderShufflesFirstEpoch(t *testing.T) {
+	l := NewLoader(rampDataset(10), 3, 0, 7)
+	l.Shuffle = true
+
+	var indices []int
+	for batch := l.Next(); batch != nil; batch = l.Next() {
+		if len(batch) != 1 {
+			t.Fatalf("batch of %d samples, expected 1 for a batch size of 0", len(batch))
+		}
+		indices = append(indices, int(batch[0].Image[0][0][0]))
+	}
+	if len(indices) != 10 {
+		t.Fatalf("Loader produced %d samples, expected 10", len(indices))
+	}
+	sorted := true
+	for i := range indices {
+		sorted = sorted && indices[i] == i
+	}
+	if sorted {
+		t.Error("Shuffle set after NewLoader did not shuffle the first epoch")
+	}
+}
+
+func TestCutMixEmptyImages(t *testing.T) {
+	batch := []Sample{{Image: make3D(1, 0, 0), Target: []precision.Float{1, 0}}}
+	if out := (CutMix{Alpha: 1}).ApplyBatch(batch, rand.New(rand.NewSource(1))); !reflect.DeepEqual(out, batch) {
+		t.Errorf("CutMix changed an empty image to %+v", out)
+	}
+}
+
+func TestMixTargetsSumToOne(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+	batch := NewLoader(rampDataset(8), 3, 8, 1).Next()
+
+	for _, bt := range []BatchTransform{Mixup{Alpha: 0.2}, CutMix{Alpha: 1}} {
+		for _, s := range bt.ApplyBatch(batch, rng) {
+			sum := precision.Float(0)
+			for _, v := range s.Target {
+				sum += v
+			}
+			if math.Abs(float64(sum-1)) > 1e-5 {
+				t.Errorf("%T produced target %v which does not sum to 1", bt, s.Target)
+			}
+		}
+	}
+}
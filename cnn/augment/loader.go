@@ -0,0 +1,99 @@
+package augment
+
+import (
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Dataset gives indexed access to labelled images
+type Dataset interface {
+	Len() int
+	Get(index int) (image [][][]precision.Float, label int)
+}
+
+// Loader iterates over a Dataset in batches, augmenting every sample on the fly
+type Loader struct {
+	Dataset    Dataset
+	NumClasses int
+	BatchSize  int  // At least 1
+	Shuffle    bool // Read when an epoch starts, including the first one
+
+	// Transform is applied to each sample, BatchTransform to each batch.
+	// Both are optional.
+	Transform      Transform
+	BatchTransform BatchTransform
+
+	rng   *rand.Rand
+	order []int
+	pos   int
+}
+
+// NewLoader creates a new Loader whose shuffling and augmentation are
+// driven by a random generator seeded with seed. A batchSize below 1 is
+// raised to 1. The first epoch starts with the first call to Next, so
+// Shuffle can be set after NewLoader.
+func NewLoader(dataset Dataset, numClasses, batchSize int, seed int64) *Loader {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Loader{
+		Dataset:    dataset,
+		NumClasses: numClasses,
+		BatchSize:  batchSize,
+		rng:        rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Reset starts a new epoch, reshuffling the dataset if Shuffle is set
+func (l *Loader) Reset() {
+	l.order = make([]int, l.Dataset.Len())
+	for i := range l.order {
+		l.order[i] = i
+	}
+	if l.Shuffle {
+		l.rng.Shuffle(len(l.order), func(i, j int) {
+			l.order[i], l.order[j] = l.order[j], l.order[i]
+		})
+	}
+	l.pos = 0
+}
+
+// Next returns the next batch of augmented samples.
+// It returns nil once the epoch is exhausted; call Reset to start another one.
+func (l *Loader) Next() []Sample {
+	if l.order == nil {
+		l.Reset()
+	}
+	if l.pos >= len(l.order) {
+		return nil
+	}
+	end := l.pos + l.BatchSize
+	if end > len(l.order) {
+		end = len(l.order)
+	}
+
+	batch := make([]Sample, 0, end-l.pos)
+	for _, index := range l.order[l.pos:end] {
+		image, label := l.Dataset.Get(index)
+		if l.Transform != nil {
+			image = l.Transform.Apply(image, l.rng)
+		}
+		batch = append(batch, Sample{Image: image, Label: label, Target: OneHot(label, l.NumClasses)})
+	}
+	l.pos = end
+
+	if l.BatchTransform != nil {
+		batch = l.BatchTransform.ApplyBatch(batch, l.rng)
+	}
+	return batch
+}
+
+// OneHot returns a target vector of size n with a 1 at index label
+func OneHot(label, n int) []precision.Float {
+	target := make([]precision.Float, n)
+	if label >= 0 && label < n {
+		target[label] = 1
+	}
+	return target
+}
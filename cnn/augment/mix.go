@@ -0,0 +1,148 @@
+package augment
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Sample is one training example. Target holds the desired output of the
+// network, one value per class, which mixing transforms blend together.
+type Sample struct {
+	Image  [][][]precision.Float
+	Label  int
+	Target []precision.Float
+}
+
+// BatchTransform represents a random modification of a whole batch,
+// used for transforms that combine several samples
+type BatchTransform interface {
+	ApplyBatch(batch []Sample, rng *rand.Rand) []Sample
+}
+
+// Mixup blends every sample with another sample of the batch
+// (Zhang et al., 2017). The mixing ratio is drawn from Beta(Alpha, Alpha).
+type Mixup struct {
+	Alpha float64
+}
+
+// ApplyBatch performs mixup on the batch
+func (m Mixup) ApplyBatch(batch []Sample, rng *rand.Rand) []Sample {
+	lambda := precision.Float(sampleBeta(m.Alpha, rng))
+	perm := rng.Perm(len(batch))
+
+	out := make([]Sample, len(batch))
+	for i, a := range batch {
+		b := batch[perm[i]]
+		image := clone3D(a.Image)
+		for c := range image {
+			for y := range image[c] {
+				for x := range image[c][y] {
+					image[c][y][x] = lambda*a.Image[c][y][x] + (1-lambda)*b.Image[c][y][x]
+				}
+			}
+		}
+		out[i] = Sample{Image: image, Label: a.Label, Target: mixTargets(a.Target, b.Target, lambda)}
+	}
+	return out
+}
+
+// CutMix pastes a random rectangle of another sample of the batch into
+// every sample (Yun et al., 2019). The area ratio is drawn from
+// Beta(Alpha, Alpha) and the targets are mixed by the actual pasted area.
+type CutMix struct {
+	Alpha float64
+}
+
+// ApplyBatch performs cutmix on the batch
+func (cm CutMix) ApplyBatch(batch []Sample, rng *rand.Rand) []Sample {
+	lambda := sampleBeta(cm.Alpha, rng)
+	perm := rng.Perm(len(batch))
+
+	out := make([]Sample, len(batch))
+	for i, a := range batch {
+		b := batch[perm[i]]
+		depth, h, w := shape(a.Image)
+		if h == 0 || w == 0 {
+			// Nothing to paste
+			out[i] = a
+			continue
+		}
+
+		// Box covering a (1 - lambda) fraction of the image, clipped to its bounds
+		cutH := int(float64(h) * math.Sqrt(1-lambda))
+		cutW := int(float64(w) * math.Sqrt(1-lambda))
+		cy := rng.Intn(h)
+		cx := rng.Intn(w)
+		top, bottom := clamp(cy-cutH/2, 0, h), clamp(cy+cutH/2, 0, h)
+		left, right := clamp(cx-cutW/2, 0, w), clamp(cx+cutW/2, 0, w)
+
+		image := clone3D(a.Image)
+		for c := 0; c < depth; c++ {
+			for y := top; y < bottom; y++ {
+				for x := left; x < right; x++ {
+					image[c][y][x] = b.Image[c][y][x]
+				}
+			}
+		}
+
+		kept := precision.Float(1) - precision.Float((bottom-top)*(right-left))/precision.Float(h*w)
+		out[i] = Sample{Image: image, Label: a.Label, Target: mixTargets(a.Target, b.Target, kept)}
+	}
+	return out
+}
+
+// mixTargets returns lambda*a + (1-lambda)*b
+func mixTargets(a, b []precision.Float, lambda precision.Float) []precision.Float {
+	mixed := make([]precision.Float, len(a))
+	for i := range a {
+		mixed[i] = lambda*a[i] + (1-lambda)*b[i]
+	}
+	return mixed
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
+
+// sampleBeta draws from Beta(alpha, alpha) using two Gamma variates
+func sampleBeta(alpha float64, rng *rand.Rand) float64 {
+	if alpha <= 0 {
+		return 1
+	}
+	x := sampleGamma(alpha, rng)
+	y := sampleGamma(alpha, rng)
+	if x+y == 0 {
+		return 0.5
+	}
+	return x / (x + y)
+}
+
+// sampleGamma draws from Gamma(shape, 1) with the Marsaglia-Tsang method
+func sampleGamma(shape float64, rng *rand.Rand) float64 {
+	if shape < 1 {
+		// Boost the shape above 1 and correct with a uniform power
+		return sampleGamma(shape+1, rng) * math.Pow(rng.Float64(), 1/shape)
+	}
+	d := shape - 1.0/3.0
+	c := 1 / math.Sqrt(9*d)
+	for {
+		x := rng.NormFloat64()
+		v := 1 + c*x
+		if v <= 0 {
+			continue
+		}
+		v = v * v * v
+		u := rng.Float64()
+		if math.Log(u) < 0.5*x*x+d-d*v+d*math.Log(v) {
+			return d * v
+		}
+	}
+}
@@ -0,0 +1,306 @@
+package augment
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Shift translates the image by a random integer offset in
+// [-MaxDX, MaxDX] x [-MaxDY, MaxDY]. Uncovered pixels are set to zero.
+// Negative maxima are treated as zero.
+type Shift struct {
+	MaxDX int
+	MaxDY int
+}
+
+// Apply performs the random translation
+func (s Shift) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	depth, h, w := shape(image)
+	dx := randomOffset(s.MaxDX, rng)
+	dy := randomOffset(s.MaxDY, rng)
+
+	out := make3D(depth, h, w)
+	for c := 0; c < depth; c++ {
+		for y := 0; y < h; y++ {
+			srcY := y - dy
+			if srcY < 0 || srcY >= h {
+				continue
+			}
+			for x := 0; x < w; x++ {
+				srcX := x - dx
+				if srcX < 0 || srcX >= w {
+					continue
+				}
+				out[c][y][x] = image[c][srcY][srcX]
+			}
+		}
+	}
+	return out
+}
+
+// randomOffset draws an integer in [-max, max], or returns 0 when max is
+// not positive
+func randomOffset(max int, rng *rand.Rand) int {
+	if max <= 0 {
+		return 0
+	}
+	return rng.Intn(2*max+1) - max
+}
+
+// RandomCrop zero-pads the image by Padding pixels on every side and crops
+// a random window of the original size out of the padded image, which is
+// a Shift by up to Padding pixels in both directions.
+type RandomCrop struct {
+	Padding int
+}
+
+// Apply performs the random crop
+func (rc RandomCrop) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	return Shift{MaxDX: rc.Padding, MaxDY: rc.Padding}.Apply(image, rng)
+}
+
+// Rotate rotates the image around its center by a random angle in
+// [-MaxDegrees, MaxDegrees], using bilinear interpolation.
+type Rotate struct {
+	MaxDegrees float64
+}
+
+// Apply performs the random rotation
+func (r Rotate) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	angle := (rng.Float64()*2 - 1) * r.MaxDegrees * math.Pi / 180
+	cos, sin := math.Cos(angle), math.Sin(angle)
+	return affine(image, cos, -sin, sin, cos)
+}
+
+// Scale zooms the image around its center by a random factor in [Min, Max],
+// using bilinear interpolation.
+type Scale struct {
+	Min float64
+	Max float64
+}
+
+// Apply performs the random zoom
+func (s Scale) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	factor := s.Min + rng.Float64()*(s.Max-s.Min)
+	if factor <= 0 {
+		return image
+	}
+	return affine(image, 1/factor, 0, 0, 1/factor)
+}
+
+// affine resamples the image around its center. The matrix (a b; c d) maps
+// output coordinates to input coordinates, i.e. it is the inverse of the
+// visible transformation.
+func affine(image [][][]precision.Float, a, b, c, d float64) [][][]precision.Float {
+	depth, h, w := shape(image)
+	cy := float64(h-1) / 2
+	cx := float64(w-1) / 2
+
+	out := make3D(depth, h, w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ox := float64(x) - cx
+			oy := float64(y) - cy
+			srcX := a*ox + b*oy + cx
+			srcY := c*ox + d*oy + cy
+			for ch := 0; ch < depth; ch++ {
+				out[ch][y][x] = bilinear(image, ch, srcY, srcX)
+			}
+		}
+	}
+	return out
+}
+
+// HorizontalFlip mirrors the image left to right with probability P
+type HorizontalFlip struct {
+	P float64
+}
+
+// Apply performs the random flip
+func (f HorizontalFlip) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	if rng.Float64() >= f.P {
+		return image
+	}
+	depth, h, w := shape(image)
+	out := make3D(depth, h, w)
+	for c := 0; c < depth; c++ {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out[c][y][x] = image[c][y][w-1-x]
+			}
+		}
+	}
+	return out
+}
+
+// Elastic applies the elastic distortion described by Simard et al. (2003):
+// a random displacement field is smoothed with a Gaussian of standard
+// deviation Sigma and scaled by Alpha pixels. A Sigma of zero or less
+// leaves the field unsmoothed.
+type Elastic struct {
+	Alpha float64
+	Sigma float64
+}
+
+// Apply performs the random elastic distortion
+func (e Elastic) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	depth, h, w := shape(image)
+	dx := e.field(h, w, rng)
+	dy := e.field(h, w, rng)
+
+	out := make3D(depth, h, w)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			srcX := float64(x) + dx[y][x]
+			srcY := float64(y) + dy[y][x]
+			for c := 0; c < depth; c++ {
+				out[c][y][x] = bilinear(image, c, srcY, srcX)
+			}
+		}
+	}
+	return out
+}
+
+// field returns a smoothed and scaled random displacement field
+func (e Elastic) field(h, w int, rng *rand.Rand) [][]float64 {
+	raw := make([][]float64, h)
+	for y := range raw {
+		raw[y] = make([]float64, w)
+		for x := range raw[y] {
+			raw[y][x] = rng.Float64()*2 - 1
+		}
+	}
+
+	// Separable Gaussian blur, truncated at 3 sigmas
+	radius := 0
+	kernel := []float64{1}
+	if e.Sigma > 0 {
+		radius = int(math.Ceil(3 * e.Sigma))
+		kernel = make([]float64, 2*radius+1)
+		sum := 0.0
+		for i := range kernel {
+			d := float64(i - radius)
+			kernel[i] = math.Exp(-d * d / (2 * e.Sigma * e.Sigma))
+			sum += kernel[i]
+		}
+		for i := range kernel {
+			kernel[i] /= sum
+		}
+	}
+
+	tmp := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		tmp[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			for k := -radius; k <= radius; k++ {
+				if xx := x + k; xx >= 0 && xx < w {
+					tmp[y][x] += raw[y][xx] * kernel[k+radius]
+				}
+			}
+		}
+	}
+
+	field := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		field[y] = make([]float64, w)
+		for x := 0; x < w; x++ {
+			for k := -radius; k <= radius; k++ {
+				if yy := y + k; yy >= 0 && yy < h {
+					field[y][x] += tmp[yy][x] * kernel[k+radius]
+				}
+			}
+			field[y][x] *= e.Alpha
+		}
+	}
+	return field
+}
+
+// GaussianNoise adds zero-mean Gaussian noise of standard deviation Std to every pixel
+type GaussianNoise struct {
+	Std float64
+}
+
+// Apply adds the noise
+func (g GaussianNoise) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	out := clone3D(image)
+	for c := range out {
+		for y := range out[c] {
+			for x := range out[c][y] {
+				out[c][y][x] += precision.Float(rng.NormFloat64() * g.Std)
+			}
+		}
+	}
+	return out
+}
+
+// ColorJitter randomly changes brightness and contrast. A brightness offset
+// is drawn in [-Brightness, Brightness] and a contrast factor in
+// [1-Contrast, 1+Contrast]; contrast is applied around each channel's mean.
+type ColorJitter struct {
+	Brightness float64
+	Contrast   float64
+}
+
+// Apply performs the random brightness and contrast change
+func (j ColorJitter) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	brightness := precision.Float((rng.Float64()*2 - 1) * j.Brightness)
+	contrast := precision.Float(1 + (rng.Float64()*2-1)*j.Contrast)
+
+	out := clone3D(image)
+	for c := range out {
+		mean := precision.Float(0)
+		count := 0
+		for y := range out[c] {
+			for x := range out[c][y] {
+				mean += out[c][y][x]
+				count++
+			}
+		}
+		if count > 0 {
+			mean /= precision.Float(count)
+		}
+
+		for y := range out[c] {
+			for x := range out[c][y] {
+				out[c][y][x] = (out[c][y][x]-mean)*contrast + mean + brightness
+			}
+		}
+	}
+	return out
+}
+
+// Cutout zeroes a Size x Size square centered at a random pixel. The square
+// may be partially outside the image.
+type Cutout struct {
+	Size int
+}
+
+// Apply performs the random cutout
+func (co Cutout) Apply(image [][][]precision.Float, rng *rand.Rand) [][][]precision.Float {
+	depth, h, w := shape(image)
+	if h == 0 || w == 0 {
+		return image
+	}
+	cy := rng.Intn(h)
+	cx := rng.Intn(w)
+	top := cy - co.Size/2
+	left := cx - co.Size/2
+
+	out := clone3D(image)
+	for c := 0; c < depth; c++ {
+		for y := top; y < top+co.Size; y++ {
+			if y < 0 || y >= h {
+				continue
+			}
+			for x := left; x < left+co.Size; x++ {
+				if x < 0 || x >= w {
+					continue
+				}
+				out[c][y][x] = 0
+			}
+		}
+	}
+	return out
+}
@@ -1,29 +1,105 @@
 package cnn
 
 import (
+	"math/rand"
+
 	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
 )
 
 // Layer interface represents the common behavior of ConvLayer, MaxPoolingLayer, and FullyConnectedLayer
 type Layer interface {
-	ForwardPropagate(input [][][]float32) [][][]float32
-	BackPropagate(error [][][]float32) [][][]float32
-	GetOutput(index int) float32
+	ForwardPropagate(input [][][]precision.Float) [][][]precision.Float
+	BackPropagate(error [][][]precision.Float) [][][]precision.Float
+	Infer(input [][][]precision.Float) [][][]precision.Float // Forward pass that leaves the layer untouched
+	GetOutput(index int) precision.Float
+	InputShape() []int  // Depth, height and width of the expected input
+	OutputShape() []int // Depth, height and width of the produced output
+}
+
+// ModeSwitcher is implemented by layers which compute differently during
+// training and inference, such as DropoutLayer
+type ModeSwitcher interface {
+	SetTraining(training bool)
+}
+
+// Resizable is implemented by layers whose Infer takes inputs of other
+// spatial sizes than the one they were built for. A network made of
+// resizable layers only, a fully convolutional network, infers and predicts
+// on inputs of any size its windows fit in, while it still trains on the
+// size it was built for.
+type Resizable interface {
+	// OutputShapeFor returns the shape of the output Infer computes for an
+	// input of the given shape, or nil if the layer cannot process it
+	OutputShapeFor(input []int) []int
 }
 
 // CNN represents a Convolutional Neural Network
 type CNN struct {
-	Layers []Layer
+	Layers    []Layer
+	Metadata  Metadata
+	Optimizer Optimizer      // Defaults to SGD with layers.DefaultLearningRate
+	Loss      string         // Loss minimized by backpropagation, LossMSE if empty
+	Precision precision.Type // Storage type of the parameters in model files, float32 if empty
+
+	// Regularization penalizes, clips and constrains the parameters in Update
+	Regularization Regularization
+
+	// Diagnostics checks for NaN and Inf and gathers layer statistics
+	// while training, disabled if nil
+	Diagnostics *Diagnostics
+
+	// Initializer fills the weights of the layers added by AddConvLayer and
+	// AddFullyConnectedLayer, layers.HeNormal if nil
+	Initializer layers.Initializer
+	rng         *rand.Rand // Generator of the weights, see SetSeed
+
+	// Buffers of training, reused between steps
+	target      []precision.Float
+	targetLayer Layer // Last layer when target was allocated
+	outputError [][][]precision.Float
+	errorLayer  Layer             // Last layer when outputError was allocated
+	output      []precision.Float // Flattened output, when the last layer is not flat
+	params      []layers.Param
 }
 
 // NewCNN creates a new empty CNN object
 func NewCNN() *CNN {
-	return &CNN{Layers: []Layer{}}
+	return &CNN{Layers: []Layer{}, Optimizer: NewSGD(layers.DefaultLearningRate, 0)}
+}
+
+// SetSeed seeds the generator of the weights and dropout masks of the
+// layers added from now on. Networks built in the same way from the same
+// seed are identical, while each of their layers gets its own weights and
+// masks. Networks which are not seeded use layers.DefaultSeed.
+func (c *CNN) SetSeed(seed int64) {
+	c.rng = rand.New(rand.NewSource(seed))
+}
+
+// generator returns the generator of the network, seeded with
+// layers.DefaultSeed unless SetSeed was called
+func (c *CNN) generator() *rand.Rand {
+	if c.rng == nil {
+		c.SetSeed(layers.DefaultSeed)
+	}
+	return c.rng
+}
+
+// initialize fills the weights of a new layer from the generator of the network
+func (c *CNN) initialize(layer interface {
+	Initialize(layers.Initializer, *rand.Rand)
+}) {
+	init := c.Initializer
+	if init == nil {
+		init = layers.HeNormal{}
+	}
+	layer.Initialize(init, c.generator())
 }
 
 // AddConvLayer adds a convolutional layer to the neural network
 func (c *CNN) AddConvLayer(inputSize, inputDepth, numFilters, kernelSize, stride int) {
 	convLayer := layers.NewConvLayer(inputSize, inputDepth, numFilters, kernelSize, stride)
+	c.initialize(convLayer)
 	c.Layers = append(c.Layers, convLayer)
 }
 
@@ -33,57 +109,191 @@ func (c *CNN) AddMaxPoolingLayer(inputSize, inputDepth, kernelSize, stride int)
 	c.Layers = append(c.Layers, mxplLayer)
 }
 
+// AddAvgPoolingLayer adds an average pooling layer to the neural network
+func (c *CNN) AddAvgPoolingLayer(inputSize, inputDepth, poolSize, stride int) {
+	c.Layers = append(c.Layers, layers.NewAvgPoolingLayer(inputSize, inputDepth, poolSize, stride))
+}
+
+// AddGlobalAvgPoolingLayer adds a global average pooling layer to the neural network
+func (c *CNN) AddGlobalAvgPoolingLayer(depth, height, width int) {
+	c.Layers = append(c.Layers, layers.NewGlobalAvgPoolingLayer(depth, height, width))
+}
+
+// AddGlobalMaxPoolingLayer adds a global max pooling layer to the neural network
+func (c *CNN) AddGlobalMaxPoolingLayer(depth, height, width int) {
+	c.Layers = append(c.Layers, layers.NewGlobalMaxPoolingLayer(depth, height, width))
+}
+
 // AddFullyConnectedLayer adds a fully connected layer to the neural network
 func (c *CNN) AddFullyConnectedLayer(inputWidth, inputDepth, outputSize int) {
 	fclLayer := layers.NewFullyConnectedLayer(inputWidth, inputDepth, outputSize)
+	c.initialize(fclLayer)
 	c.Layers = append(c.Layers, fclLayer)
 }
 
+// AddInstanceNormLayer adds an instance normalization layer to the neural network
+func (c *CNN) AddInstanceNormLayer(depth, height, width int) {
+	c.Layers = append(c.Layers, layers.NewInstanceNormLayer(depth, height, width))
+}
+
+// AddDropoutLayer adds a dropout layer to the neural network, its masks
+// seeded from the generator of the network. It panics if rate is not in
+// [0, 1).
+func (c *CNN) AddDropoutLayer(depth, height, width int, rate precision.Float) {
+	dropoutLayer := layers.NewDropoutLayer(depth, height, width, rate)
+	dropoutLayer.Seed = c.generator().Int63()
+	c.Layers = append(c.Layers, dropoutLayer)
+}
+
+// AddSpatialDropoutLayer adds a spatial dropout layer to the neural network,
+// its masks seeded from the generator of the network. It panics if rate is
+// not in [0, 1).
+func (c *CNN) AddSpatialDropoutLayer(depth, height, width int, rate precision.Float) {
+	spatialDropoutLayer := layers.NewSpatialDropoutLayer(depth, height, width, rate)
+	spatialDropoutLayer.Seed = c.generator().Int63()
+	c.Layers = append(c.Layers, spatialDropoutLayer)
+}
+
+// SetTraining switches the layers between training and inference mode.
+// Layers start in training mode; Infer always computes in inference mode.
+func (c *CNN) SetTraining(training bool) {
+	for _, layer := range c.Layers {
+		if l, ok := layer.(ModeSwitcher); ok {
+			l.SetTraining(training)
+		}
+	}
+}
+
 // ForwardPropagate performs forward propagation through the CNN
-func (c *CNN) ForwardPropagate(image [][][]float32) []float32 {
+func (c *CNN) ForwardPropagate(image [][][]precision.Float) []precision.Float {
 	output := image
 
 	// Forward propagate through each layer of the network
-	for _, layer := range c.Layers {
+	for i, layer := range c.Layers {
 		output = layer.ForwardPropagate(output)
+		if c.Diagnostics != nil {
+			c.Diagnostics.observeOutput(i, layer, output)
+		}
 	}
 
 	// Flatten and return the output of the final layer
-	return flattenOutput(output)
+	return flattenOutput(output, &c.output)
+}
+
+// Infer performs forward propagation through the CNN without storing any
+// state in the layers. Unlike ForwardPropagate it is safe to call from
+// several goroutines at once, as long as the network is not being trained.
+func (c *CNN) Infer(image [][][]precision.Float) []precision.Float {
+	output := image
+
+	for _, layer := range c.Layers {
+		output = layer.Infer(output)
+	}
+
+	var buffer []precision.Float
+	return flattenOutput(output, &buffer)
 }
 
 // LastLayerError calculates the error of the last layer of the network
 // It returns a slice with errors correction for every neuron
-func (c *CNN) LastLayerError(label int) [][][]float32 {
-	var error []float32
+func (c *CNN) LastLayerError(label int) [][][]precision.Float {
+	last := c.Layers[len(c.Layers)-1]
+	if c.targetLayer != last {
+		c.target = make([]precision.Float, outputSize(last))
+		c.targetLayer = last
+	}
+	target := c.target
+	for i := range target {
+		target[i] = 0
+	}
+	target[label] = 1
 
-	corrFactor := (2.0 / 10.0)
+	return c.TargetError(target)
+}
 
-	// Calculate the error for each output neuron
-	for i := 0; i < 10; i++ {
-		desired := float32(0)
-		if label == i {
-			desired = 1
+// TargetError calculates the error of the last layer of the network against
+// a target output vector, such as the soft targets produced by mixup.
+// The error is the derivative of the network loss with respect to each output.
+// The returned error is overwritten by the next call.
+func (c *CNN) TargetError(target []precision.Float) [][][]precision.Float {
+	last := c.Layers[len(c.Layers)-1]
+	if c.errorLayer != last || len(c.outputError)*len(c.outputError[0])*len(c.outputError[0][0]) != len(target) {
+		c.outputError = shapeVolume(make([]precision.Float, len(target)), last.OutputShape())
+		c.errorLayer = last
+	}
+
+	corrFactor := 1.0 / precision.Float(len(target))
+
+	// Calculate the error for each output neuron, stored in the order of the
+	// flattened output
+	i := 0
+	for _, m := range c.outputError {
+		for _, row := range m {
+			for x := range row {
+				row[x] = corrFactor * lossDerivative(c.Loss, last.GetOutput(i), target[i])
+				i++
+			}
 		}
-		lastIndex := len(c.Layers) - 1
-		error = append(error, float32(corrFactor)*(c.Layers[lastIndex].GetOutput(i)-desired))
 	}
 
-	return [][][]float32{{error}}
+	return c.outputError
 }
 
-// BackPropagate performs backpropagation through the CNN
+// BackPropagate performs backpropagation through the CNN and updates its parameters
 func (c *CNN) BackPropagate(label int) {
-	// Retrieve the last layer error to backpropagate
-	error := c.LastLayerError(label)
+	c.backPropagateError(c.LastLayerError(label))
+	c.Update()
+}
+
+// BackPropagateTarget performs backpropagation through the CNN towards a
+// target output vector and updates its parameters
+func (c *CNN) BackPropagateTarget(target []precision.Float) {
+	c.backPropagateError(c.TargetError(target))
+	c.Update()
+}
 
+// backPropagateError propagates the last layer error backwards through the
+// layers, accumulating the gradients of their parameters
+func (c *CNN) backPropagateError(error [][][]precision.Float) {
 	// Iterate backwards through the layers and backpropagate the error
 	for i := len(c.Layers) - 1; i >= 0; i-- {
 		error = c.Layers[i].BackPropagate(error)
+		if c.Diagnostics != nil {
+			c.Diagnostics.observeError(i, c.Layers[i], error)
+		}
 	}
 }
 
-// flattenOutput flattens the output of the final layer
-func flattenOutput(output [][][]float32) []float32 {
-	return output[0][0]
+// flattenOutput flattens the output of the final layer. Flat outputs, such
+// as those of fully connected layers, are returned as is; others are copied
+// into buffer, which is reused when it is large enough.
+func flattenOutput(output [][][]precision.Float, buffer *[]precision.Float) []precision.Float {
+	if len(output) == 1 && len(output[0]) == 1 {
+		return output[0][0]
+	}
+	*buffer = (*buffer)[:0]
+	for _, m := range output {
+		for _, row := range m {
+			*buffer = append(*buffer, row...)
+		}
+	}
+	return *buffer
+}
+
+// outputSize returns the number of values produced by a layer
+func outputSize(l Layer) int {
+	shape := l.OutputShape()
+	return shape[0] * shape[1] * shape[2]
+}
+
+// shapeVolume returns a volume of the given shape backed by values
+func shapeVolume(values []precision.Float, shape []int) [][][]precision.Float {
+	volume := make([][][]precision.Float, shape[0])
+	for d := range volume {
+		volume[d] = make([][]precision.Float, shape[1])
+		for y := range volume[d] {
+			volume[d][y], values = values[:shape[2]], values[shape[2]:]
+		}
+	}
+	return volume
 }
@@ -0,0 +1,306 @@
+// Package dashboard serves a live view of a training run over HTTP: loss and
+// accuracy curves, the confusion matrix, recently misclassified samples and
+// the convolution filters. The page is embedded in the binary and needs no
+// external resources; updates are streamed to it with server-sent events.
+package dashboard
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Path is where Register mounts the dashboard
+const Path = "/dashboard/"
+
+// Limits of the state kept by a Dashboard
+const (
+	MaxPoints  = 10000 // Points of the curves, the oldest are dropped first
+	MaxSamples = 24    // Misclassified samples
+)
+
+//go:embed static
+var static embed.FS
+
+// Point is a measure of the training progress
+type Point struct {
+	Step     int     `json:"step"`
+	Epoch    int     `json:"epoch"`
+	Loss     float64 `json:"loss"`
+	Accuracy float64 `json:"accuracy"`
+}
+
+// MarshalJSON writes NaN and infinite values, which JSON cannot represent,
+// as null
+func (p Point) MarshalJSON() ([]byte, error) {
+	finite := func(v float64) interface{} {
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		return v
+	}
+	return json.Marshal(map[string]interface{}{
+		"step": p.Step, "epoch": p.Epoch, "loss": finite(p.Loss), "accuracy": finite(p.Accuracy),
+	})
+}
+
+// Sample is a misclassified image
+type Sample struct {
+	Image     string `json:"image"` // PNG data URI
+	Label     string `json:"label"`
+	Predicted string `json:"predicted"`
+}
+
+// Filters holds the filters of a convolution layer
+type Filters struct {
+	Layer  int      `json:"layer"`
+	Images []string `json:"images"` // One PNG data URI per filter, its input channels side by side
+}
+
+// State is the whole content of the dashboard, sent to every new client
+type State struct {
+	Labels    []string  `json:"labels"`
+	Points    []Point   `json:"points"`
+	Confusion [][]int   `json:"confusion"` // Rows are the expected labels, columns the predictions
+	Samples   []Sample  `json:"samples"`
+	Filters   []Filters `json:"filters"`
+}
+
+// event is a server-sent event
+type event struct {
+	name string
+	data []byte
+}
+
+// Dashboard collects the progress of a training run and serves it. Its
+// methods are safe to call from the training loop while clients are served.
+// Images are kept raw and only encoded to PNG when a client needs them.
+type Dashboard struct {
+	mu      sync.Mutex
+	state   State // Samples and Filters are encoded from samples and kernels on demand
+	samples []*sample
+	kernels []layerKernels
+	dirty   bool // Confusion matrix or samples changed since the last broadcast
+	clients map[chan event]struct{}
+}
+
+// sample is a misclassified image, encoded by encodeSamples
+type sample struct {
+	image            [][][]precision.Float
+	label, predicted int
+	uri              string // PNG data URI, empty until encoded
+}
+
+// New creates a dashboard for a classifier of the given labels
+func New(labels []string) *Dashboard {
+	d := &Dashboard{clients: map[chan event]struct{}{}}
+	d.state.Labels = labels
+	d.state.Confusion = make([][]int, len(labels))
+	for i := range d.state.Confusion {
+		d.state.Confusion[i] = make([]int, len(labels))
+	}
+	return d
+}
+
+// Register mounts the dashboard on mux at Path
+func (d *Dashboard) Register(mux *http.ServeMux) {
+	mux.Handle(Path, http.StripPrefix(Path[:len(Path)-1], d.Handler()))
+}
+
+// Handler returns the routes of the dashboard: the page at /, its state as
+// JSON at /state and the stream of updates at /events
+func (d *Dashboard) Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/", http.FileServer(http.FS(files)))
+	mux.HandleFunc("/state", d.handleState)
+	mux.HandleFunc("/events", d.handleEvents)
+	return mux
+}
+
+// Record adds a point to the curves and sends the changes of the
+// confusion matrix and samples since the last call
+func (d *Dashboard) Record(step, epoch int, loss, accuracy float64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	p := Point{Step: step, Epoch: epoch, Loss: loss, Accuracy: accuracy}
+	if len(d.state.Points) == MaxPoints {
+		d.state.Points = append(d.state.Points[:0], d.state.Points[1:]...)
+	}
+	d.state.Points = append(d.state.Points, p)
+	d.broadcast("point", p)
+	if d.dirty && len(d.clients) > 0 {
+		d.broadcast("confusion", d.state.Confusion)
+		d.broadcast("samples", d.encodeSamples())
+		d.dirty = false
+	}
+}
+
+// Observe counts a prediction in the confusion matrix and keeps a copy of
+// the image when it was misclassified. Changes are sent by the next Record.
+func (d *Dashboard) Observe(image [][][]precision.Float, label, predicted int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if label < 0 || label >= len(d.state.Labels) || predicted < 0 || predicted >= len(d.state.Labels) {
+		return
+	}
+	d.state.Confusion[label][predicted]++
+	d.dirty = true
+	if label == predicted {
+		return
+	}
+
+	s := &sample{label: label, predicted: predicted}
+	if len(d.samples) == MaxSamples {
+		// Reuse the image of the oldest sample
+		s.image = d.samples[0].image
+		d.samples = append(d.samples[:0], d.samples[1:]...)
+	}
+	s.image = copyVolume(s.image, image)
+	d.samples = append(d.samples, s)
+}
+
+// encodeSamples returns the misclassified samples, encoding the images
+// which were not yet, with d.mu held
+func (d *Dashboard) encodeSamples() []Sample {
+	samples := make([]Sample, len(d.samples))
+	for i, s := range d.samples {
+		if s.uri == "" {
+			s.uri = imageURI(s.image)
+		}
+		samples[i] = Sample{Image: s.uri, Label: d.state.Labels[s.label], Predicted: d.state.Labels[s.predicted]}
+	}
+	return samples
+}
+
+// ResetConfusion clears the confusion matrix, for example at every epoch
+func (d *Dashboard) ResetConfusion() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, row := range d.state.Confusion {
+		for i := range row {
+			row[i] = 0
+		}
+	}
+	d.dirty = true
+}
+
+// ShowFilters keeps a copy of the filters of every convolution layer of
+// c, which are rendered when a client needs them
+func (d *Dashboard) ShowFilters(c *cnn.CNN) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.kernels = copyKernels(d.kernels, c)
+	d.state.Filters = nil
+	if len(d.clients) > 0 {
+		d.broadcast("filters", d.renderFilters())
+	}
+}
+
+// renderFilters returns the rendered filters, rendering them if they
+// changed since the last call, with d.mu held
+func (d *Dashboard) renderFilters() []Filters {
+	if d.state.Filters == nil && len(d.kernels) > 0 {
+		d.state.Filters = renderFilters(d.kernels)
+	}
+	return d.state.Filters
+}
+
+// Snapshot returns a copy of the state of the dashboard
+func (d *Dashboard) Snapshot() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.snapshot()
+}
+
+// snapshot copies the state, with d.mu held
+func (d *Dashboard) snapshot() State {
+	s := State{
+		Labels:    d.state.Labels,
+		Points:    append([]Point{}, d.state.Points...),
+		Confusion: make([][]int, len(d.state.Confusion)),
+		Samples:   d.encodeSamples(),
+		Filters:   d.renderFilters(),
+	}
+	for i, row := range d.state.Confusion {
+		s.Confusion[i] = append([]int(nil), row...)
+	}
+	return s
+}
+
+// broadcast sends an event to every client, with d.mu held. Clients which
+// do not keep up miss events rather than slowing training down.
+func (d *Dashboard) broadcast(name string, v interface{}) {
+	if len(d.clients) == 0 {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	for client := range d.clients {
+		select {
+		case client <- event{name, data}:
+		default:
+		}
+	}
+}
+
+func (d *Dashboard) handleState(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(d.Snapshot())
+}
+
+// handleEvents streams the state, then every update, as server-sent events
+func (d *Dashboard) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	client := make(chan event, 64)
+	d.mu.Lock()
+	state, err := json.Marshal(d.snapshot())
+	d.clients[client] = struct{}{}
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		delete(d.clients, client)
+		d.mu.Unlock()
+	}()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	writeEvent(w, event{"state", state})
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-client:
+			writeEvent(w, e)
+			flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes an event in the text/event-stream format. JSON data
+// holds no newline, so it fits on a single data line.
+func writeEvent(w http.ResponseWriter, e event) {
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.name, e.data)
+}
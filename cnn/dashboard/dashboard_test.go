@@ -0,0 +1,157 @@
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/cnntest"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func newTestServer(t *testing.T, d *Dashboard) *httptest.Server {
+	mux := http.NewServeMux()
+	d.Register(mux)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func get(t *testing.T, url string) (string, http.Header) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: status %d", url, resp.StatusCode)
+	}
+	return string(body), resp.Header
+}
+
+func TestStaticFiles(t *testing.T) {
+	ts := newTestServer(t, New([]string{"a", "b"}))
+	page, _ := get(t, ts.URL+Path)
+	if !strings.Contains(page, `<script src="app.js">`) {
+		t.Errorf("unexpected page:\n%s", page)
+	}
+	for _, file := range []string{"", "app.js", "style.css"} {
+		body, _ := get(t, ts.URL+Path+file)
+		if strings.Contains(body, "http://") || strings.Contains(body, "https://") || strings.Contains(body, `src="//`) {
+			t.Errorf("%q refers to an external resource", file)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	d := New([]string{"a", "b", "c"})
+	image := [][][]precision.Float{{{0, 1}, {2, 3}}}
+	d.Observe(image, 0, 0)
+	d.Observe(image, 1, 2)
+	d.Observe(image, 5, 0) // Unknown labels are ignored
+	d.Record(10, 1, math.NaN(), 0.5)
+	d.ShowFilters(cnntest.NewCNN(4))
+	if d.samples[0].uri != "" || d.state.Filters != nil {
+		t.Error("images were encoded without clients")
+	}
+
+	ts := newTestServer(t, d)
+	body, _ := get(t, ts.URL+Path+"state")
+	var s struct {
+		State
+		Points []map[string]interface{} `json:"points"`
+	}
+	if err := json.Unmarshal([]byte(body), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Points) != 1 || s.Points[0]["loss"] != nil || s.Points[0]["accuracy"] != 0.5 {
+		t.Errorf("points %v, expected a NaN loss as null", s.Points)
+	}
+	if s.Confusion[0][0] != 1 || s.Confusion[1][2] != 1 || s.Confusion[2][0] != 0 {
+		t.Errorf("confusion matrix %v", s.Confusion)
+	}
+	if len(s.Samples) != 1 || s.Samples[0].Label != "b" || s.Samples[0].Predicted != "c" || !strings.HasPrefix(s.Samples[0].Image, "data:image/png;base64,") {
+		t.Errorf("samples %+v", s.Samples)
+	}
+	if len(s.Filters) != 2 || s.Filters[0].Layer != 0 || len(s.Filters[0].Images) != 4 || s.Filters[1].Layer != 2 || len(s.Filters[1].Images) != 6 {
+		t.Errorf("filters of layers %+v", s.Filters)
+	}
+
+	d.ResetConfusion()
+	if c := d.Snapshot().Confusion; c[0][0] != 0 || c[1][2] != 0 {
+		t.Errorf("ResetConfusion left %v", c)
+	}
+	for i := 0; i < MaxSamples+5; i++ {
+		d.Observe(image, 0, 1)
+	}
+	if n := len(d.Snapshot().Samples); n != MaxSamples {
+		t.Errorf("%d samples kept, expected %d", n, MaxSamples)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	d := New([]string{"a", "b"})
+	d.Record(1, 1, 0.5, 0.25)
+	ts := newTestServer(t, d)
+
+	resp, err := http.Get(ts.URL + Path + "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	events := bufio.NewReader(resp.Body)
+	next := func() (name string, data string) {
+		t.Helper()
+		for {
+			line, err := events.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			line = strings.TrimSuffix(line, "\n")
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = line[len("event: "):]
+			case strings.HasPrefix(line, "data: "):
+				data = line[len("data: "):]
+			case line == "":
+				return name, data
+			}
+		}
+	}
+
+	// The state comes first, then every update
+	if name, data := next(); name != "state" || !strings.Contains(data, `"points":[{`) {
+		t.Fatalf("first event %s: %s", name, data)
+	}
+	d.Observe([][][]precision.Float{{{1}}}, 0, 1)
+	d.Record(2, 1, 0.25, 0.5)
+	for _, expected := range []string{"point", "confusion", "samples"} {
+		name, data := next()
+		if name != expected {
+			t.Fatalf("got event %s, expected %s", name, expected)
+		}
+		if name == "point" && data != `{"accuracy":0.5,"epoch":1,"loss":0.25,"step":2}` {
+			t.Errorf("point %s", data)
+		}
+		if name == "confusion" && data != `[[0,1],[0,0]]` {
+			t.Errorf("confusion %s", data)
+		}
+	}
+	d.ShowFilters(cnntest.NewCNN(4))
+	if name, _ := next(); name != "filters" {
+		t.Errorf("got event %s, expected filters", name)
+	}
+}
@@ -0,0 +1,164 @@
+package dashboard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// imageURI renders an input volume as a PNG data URI: three channels as
+// RGB, the first channel in grayscale otherwise. Values are stretched to
+// the full range, since inputs may be normalized in any way.
+func imageURI(volume [][][]precision.Float) string {
+	if len(volume) == 0 || len(volume[0]) == 0 {
+		return ""
+	}
+	height, width := len(volume[0]), len(volume[0][0])
+	lo, hi := precision.Float(math.Inf(1)), precision.Float(math.Inf(-1))
+	for _, m := range volume {
+		for _, row := range m {
+			for _, v := range row {
+				if v < lo {
+					lo = v
+				}
+				if v > hi {
+					hi = v
+				}
+			}
+		}
+	}
+	level := func(v precision.Float) uint8 {
+		if hi <= lo {
+			return 0
+		}
+		return uint8(255 * (v - lo) / (hi - lo))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			g := level(volume[0][y][x])
+			c := color.RGBA{g, g, g, 255}
+			if len(volume) == 3 {
+				c.G, c.B = level(volume[1][y][x]), level(volume[2][y][x])
+			}
+			img.Set(x, y, c)
+		}
+	}
+	return pngURI(img)
+}
+
+// layerKernels holds a copy of the kernels of a convolution layer
+type layerKernels struct {
+	layer   int
+	kernels [][][][]precision.Float
+}
+
+// copyKernels copies the kernels of every convolution layer of c into dst,
+// reusing its buffers when the layers kept their shape
+func copyKernels(dst []layerKernels, c *cnn.CNN) []layerKernels {
+	n := 0
+	for i, layer := range c.Layers {
+		conv, ok := layer.(*layers.ConvLayer)
+		if !ok {
+			continue
+		}
+		if n == len(dst) {
+			dst = append(dst, layerKernels{})
+		}
+		k := &dst[n]
+		k.layer = i
+		if len(k.kernels) != len(conv.Kernels) {
+			k.kernels = make([][][][]precision.Float, len(conv.Kernels))
+		}
+		for f, filter := range conv.Kernels {
+			k.kernels[f] = copyVolume(k.kernels[f], filter)
+		}
+		n++
+	}
+	return dst[:n]
+}
+
+// copyVolume copies src into dst, reusing dst when it has the same shape
+func copyVolume(dst, src [][][]precision.Float) [][][]precision.Float {
+	sameShape := len(dst) == len(src)
+	for c := 0; sameShape && c < len(src); c++ {
+		sameShape = len(dst[c]) == len(src[c])
+		for y := 0; sameShape && y < len(src[c]); y++ {
+			sameShape = len(dst[c][y]) == len(src[c][y])
+		}
+	}
+	if !sameShape {
+		dst = make([][][]precision.Float, len(src))
+		for c, m := range src {
+			dst[c] = make([][]precision.Float, len(m))
+			for y, row := range m {
+				dst[c][y] = make([]precision.Float, len(row))
+			}
+		}
+	}
+	for c, m := range src {
+		for y, row := range m {
+			copy(dst[c][y], row)
+		}
+	}
+	return dst
+}
+
+// renderFilters renders the kernels of every convolution layer. Weights are
+// scaled by the largest magnitude of their layer: zero is gray, positive
+// weights are lighter and negative ones darker.
+func renderFilters(kernels []layerKernels) []Filters {
+	var filters []Filters
+	for _, layer := range kernels {
+		scale := precision.Float(0)
+		for _, filter := range layer.kernels {
+			for _, channel := range filter {
+				for _, row := range channel {
+					for _, w := range row {
+						if w < 0 {
+							w = -w
+						}
+						if w > scale {
+							scale = w
+						}
+					}
+				}
+			}
+		}
+		if scale == 0 {
+			scale = 1
+		}
+
+		// Input channels side by side, one pixel apart
+		f := Filters{Layer: layer.layer}
+		for _, filter := range layer.kernels {
+			k := len(filter[0])
+			img := image.NewGray(image.Rect(0, 0, len(filter)*(k+1)-1, k))
+			for ch, channel := range filter {
+				for y, row := range channel {
+					for x, w := range row {
+						img.SetGray(ch*(k+1)+x, y, color.Gray{uint8(127.5 + 127.5*w/scale)})
+					}
+				}
+			}
+			f.Images = append(f.Images, pngURI(img))
+		}
+		filters = append(filters, f)
+	}
+	return filters
+}
+
+// pngURI encodes an image as a PNG data URI
+func pngURI(img image.Image) string {
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
@@ -0,0 +1,155 @@
+// Live view of a training run, fed by the server-sent events of /events
+"use strict";
+
+const state = {labels: [], points: [], confusion: [], samples: [], filters: []};
+
+// plot draws one series of the points on a canvas, with its range on the axes
+function plot(id, key, color) {
+  const canvas = document.getElementById(id);
+  const ctx = canvas.getContext("2d");
+  const width = canvas.width, height = canvas.height, margin = 40;
+  ctx.clearRect(0, 0, width, height);
+
+  const points = state.points.filter(p => Number.isFinite(p[key]));
+  ctx.strokeStyle = "#999";
+  ctx.strokeRect(margin, 10, width - margin - 10, height - margin);
+  if (points.length === 0) {
+    return;
+  }
+
+  const steps = points.map(p => p.step), values = points.map(p => p[key]);
+  const minX = Math.min(...steps), maxX = Math.max(...steps);
+  let minY = Math.min(...values), maxY = Math.max(...values);
+  if (maxY === minY) {
+    minY -= 0.5;
+    maxY += 0.5;
+  }
+  const x = s => margin + (maxX === minX ? 0 : (s - minX) / (maxX - minX)) * (width - margin - 10);
+  const y = v => 10 + (1 - (v - minY) / (maxY - minY)) * (height - margin);
+
+  ctx.fillStyle = "#555";
+  ctx.font = "11px sans-serif";
+  ctx.fillText(maxY.toPrecision(3), 2, 18);
+  ctx.fillText(minY.toPrecision(3), 2, height - margin + 10);
+  ctx.fillText("step " + minX, margin, height - 12);
+  ctx.textAlign = "right";
+  ctx.fillText("step " + maxX, width - 10, height - 12);
+  ctx.textAlign = "left";
+
+  ctx.strokeStyle = color;
+  ctx.lineWidth = 1.5;
+  ctx.beginPath();
+  points.forEach((p, i) => i === 0 ? ctx.moveTo(x(p.step), y(p[key])) : ctx.lineTo(x(p.step), y(p[key])));
+  ctx.stroke();
+}
+
+// format prints a scaled value, which is null when it was NaN or infinite
+function format(value, scale, digits) {
+  return value === null ? "NaN" : (scale * value).toFixed(digits);
+}
+
+function drawCurves() {
+  plot("loss", "loss", "#c33");
+  plot("accuracy", "accuracy", "#36c");
+  const last = state.points[state.points.length - 1];
+  if (last) {
+    document.getElementById("progress").textContent =
+      `epoch ${last.epoch}, step ${last.step}: loss ${format(last.loss, 1, 4)}, accuracy ${format(last.accuracy, 100, 2)}%`;
+  }
+}
+
+// drawConfusion fills the table, shading every cell by its share of the row
+function drawConfusion() {
+  const table = document.getElementById("confusion");
+  table.replaceChildren();
+  const header = table.insertRow();
+  header.appendChild(document.createElement("th"));
+  state.labels.forEach(label => {
+    const th = document.createElement("th");
+    th.textContent = label;
+    header.appendChild(th);
+  });
+  state.confusion.forEach((row, i) => {
+    const tr = table.insertRow();
+    const th = document.createElement("th");
+    th.textContent = state.labels[i];
+    tr.appendChild(th);
+    const total = row.reduce((a, b) => a + b, 0) || 1;
+    row.forEach((n, j) => {
+      const td = tr.insertCell();
+      td.textContent = n;
+      const share = n / total;
+      td.style.background = i === j ? `rgba(60, 160, 80, ${share})` : `rgba(200, 60, 60, ${share})`;
+    });
+  });
+}
+
+function drawSamples() {
+  const samples = document.getElementById("samples");
+  samples.replaceChildren();
+  state.samples.slice().reverse().forEach(s => {
+    const figure = document.createElement("figure");
+    const img = document.createElement("img");
+    img.src = s.image;
+    const caption = document.createElement("figcaption");
+    caption.textContent = `${s.label} → ${s.predicted}`;
+    figure.append(img, caption);
+    samples.appendChild(figure);
+  });
+}
+
+function drawFilters() {
+  const filters = document.getElementById("filters");
+  filters.replaceChildren();
+  (state.filters || []).forEach(f => {
+    const div = document.createElement("div");
+    div.className = "layer";
+    const title = document.createElement("h3");
+    title.textContent = `Layer ${f.layer}`;
+    div.appendChild(title);
+    f.images.forEach(uri => {
+      const img = document.createElement("img");
+      img.src = uri;
+      div.appendChild(img);
+    });
+    filters.appendChild(div);
+  });
+}
+
+function drawAll() {
+  drawCurves();
+  drawConfusion();
+  drawSamples();
+  drawFilters();
+}
+
+const events = new EventSource("events");
+const statusLabel = document.getElementById("status");
+events.onopen = () => {
+  statusLabel.textContent = "live";
+  statusLabel.className = "live";
+};
+events.onerror = () => {
+  statusLabel.textContent = "disconnected, retrying…";
+  statusLabel.className = "";
+};
+events.addEventListener("state", e => {
+  Object.assign(state, JSON.parse(e.data));
+  drawAll();
+});
+events.addEventListener("point", e => {
+  state.points.push(JSON.parse(e.data));
+  drawCurves();
+});
+events.addEventListener("confusion", e => {
+  state.confusion = JSON.parse(e.data);
+  drawConfusion();
+});
+events.addEventListener("samples", e => {
+  state.samples = JSON.parse(e.data) || [];
+  drawSamples();
+});
+events.addEventListener("filters", e => {
+  state.filters = JSON.parse(e.data);
+  drawFilters();
+});
@@ -0,0 +1,319 @@
+package cnn
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// SaturationMargin is the distance to 0 or 1 under which a sigmoid output
+// counts as saturated
+const SaturationMargin = 0.01
+
+// Diagnostics watches the values flowing through a network while it trains.
+// Set CNN.Diagnostics to enable it: every layer output, backpropagated error,
+// parameter gradient and updated parameter is then checked for NaN and Inf,
+// and statistics are gathered for each layer until Reset.
+type Diagnostics struct {
+	err    *NonFiniteError
+	layers []layerDiagnostics
+}
+
+// LayerStats describes the behaviour of a layer since the diagnostics were reset
+type LayerStats struct {
+	Layer   int    // Index of the layer in the network
+	Name    string // Type of the layer, see LayerName
+	Samples int    // Forward passes observed
+	Steps   int    // Parameter updates observed
+
+	Mean, Std float64 // Mean and standard deviation of the outputs
+	// Dead is the fraction of ReLU units of a ConvLayer which stayed at zero
+	// for every sample, zero for other layers
+	Dead float64
+	// Saturated is the fraction of sigmoid outputs of a FullyConnectedLayer
+	// within SaturationMargin of 0 or 1, zero for other layers
+	Saturated float64
+	// GradNorm is the mean L2 norm of the parameter gradients, before
+	// regularization and clipping
+	GradNorm float64
+	// UpdateRatio is the mean ratio of the L2 norm of the parameter updates
+	// to that of the parameters. Healthy training usually keeps it near 1e-3.
+	UpdateRatio float64
+}
+
+// NonFiniteError reports the first NaN or Inf found by Diagnostics
+type NonFiniteError struct {
+	Layer int    // Index of the layer in the network
+	Name  string // Type of the layer, see LayerName
+	Stage string // "output", "error" (backpropagated to the input), "gradient" or "value"
+	Param string // Name of the parameter for gradients and values
+	Index int    // Index of the value in the flattened tensor
+	Value precision.Float
+}
+
+func (e *NonFiniteError) Error() string {
+	what := "the " + e.Stage
+	if e.Param != "" {
+		what += " of " + e.Param
+	}
+	return fmt.Sprintf("cnn: %v in %s of layer %d (%s) at index %d", e.Value, what, e.Layer, e.Name, e.Index)
+}
+
+// layerDiagnostics accumulates the statistics of one layer
+type layerDiagnostics struct {
+	layer            Layer
+	samples, steps   int
+	count            int // Outputs observed
+	sum, sumSquares  float64
+	saturated        int
+	active           []bool // ReLU units which were positive at least once
+	gradNorm, update float64
+	previous         [][][]precision.Float // Parameter values before the current step
+}
+
+// NewDiagnostics creates diagnostics with no statistics
+func NewDiagnostics() *Diagnostics {
+	return &Diagnostics{}
+}
+
+// Err returns the first NaN or Inf found since the last reset, as a
+// *NonFiniteError, or nil
+func (d *Diagnostics) Err() error {
+	if d.err == nil {
+		return nil
+	}
+	return d.err
+}
+
+// Reset clears the statistics and the error
+func (d *Diagnostics) Reset() {
+	d.err = nil
+	for i := range d.layers {
+		l := &d.layers[i]
+		l.samples, l.steps, l.count = 0, 0, 0
+		l.sum, l.sumSquares, l.gradNorm, l.update = 0, 0, 0, 0
+		l.saturated = 0
+		for j := range l.active {
+			l.active[j] = false
+		}
+	}
+}
+
+// Stats returns the statistics of every layer observed since the last reset
+func (d *Diagnostics) Stats() []LayerStats {
+	stats := make([]LayerStats, len(d.layers))
+	for i, l := range d.layers {
+		s := LayerStats{Layer: i, Samples: l.samples, Steps: l.steps}
+		if l.layer != nil {
+			s.Name = LayerName(l.layer)
+		}
+		if l.count > 0 {
+			s.Mean = l.sum / float64(l.count)
+			s.Std = math.Sqrt(math.Max(l.sumSquares/float64(l.count)-s.Mean*s.Mean, 0))
+			s.Saturated = float64(l.saturated) / float64(l.count)
+		}
+		if len(l.active) > 0 && l.samples > 0 {
+			dead := 0
+			for _, a := range l.active {
+				if !a {
+					dead++
+				}
+			}
+			s.Dead = float64(dead) / float64(len(l.active))
+		}
+		if l.steps > 0 {
+			s.GradNorm = l.gradNorm / float64(l.steps)
+			s.UpdateRatio = l.update / float64(l.steps)
+		}
+		stats[i] = s
+	}
+	return stats
+}
+
+// Report writes a table of the statistics of every layer
+func (d *Diagnostics) Report(w io.Writer) {
+	fmt.Fprintf(w, "%-4s %-22s %10s %10s %8s %8s %10s %10s\n", "#", "Layer", "Mean", "Std", "Dead", "Satur.", "GradNorm", "Update/W")
+	fmt.Fprintln(w, strings.Repeat("-", 89))
+	for _, s := range d.Stats() {
+		dead, saturated := "-", "-"
+		switch d.layers[s.Layer].layer.(type) {
+		case *layers.ConvLayer:
+			dead = fmt.Sprintf("%.1f%%", s.Dead*100)
+		case *layers.FullyConnectedLayer:
+			saturated = fmt.Sprintf("%.1f%%", s.Saturated*100)
+		}
+		grad, ratio := "-", "-"
+		if s.Steps > 0 && ParamCount(d.layers[s.Layer].layer) > 0 {
+			grad, ratio = fmt.Sprintf("%.3g", s.GradNorm), fmt.Sprintf("%.3g", s.UpdateRatio)
+		}
+		fmt.Fprintf(w, "%-4d %-22s %10.4g %10.4g %8s %8s %10s %10s\n", s.Layer, s.Name, s.Mean, s.Std, dead, saturated, grad, ratio)
+	}
+	if d.err != nil {
+		fmt.Fprintln(w, d.err)
+	}
+}
+
+// layer returns the accumulator of the i-th layer, cleared when the network
+// has changed
+func (d *Diagnostics) layer(i int, layer Layer) *layerDiagnostics {
+	for len(d.layers) <= i {
+		d.layers = append(d.layers, layerDiagnostics{})
+	}
+	l := &d.layers[i]
+	if l.layer != layer {
+		*l = layerDiagnostics{layer: layer}
+		if _, ok := layer.(*layers.ConvLayer); ok {
+			l.active = make([]bool, outputSize(layer))
+		}
+	}
+	return l
+}
+
+// fail records the first non-finite value
+func (d *Diagnostics) fail(i int, layer Layer, stage, param string, index int, value precision.Float) {
+	if d.err == nil {
+		d.err = &NonFiniteError{Layer: i, Name: LayerName(layer), Stage: stage, Param: param, Index: index, Value: value}
+	}
+}
+
+// observeOutput checks the output of the i-th layer and adds it to the statistics
+func (d *Diagnostics) observeOutput(i int, layer Layer, output [][][]precision.Float) {
+	l := d.layer(i, layer)
+	l.samples++
+	_, fc := layer.(*layers.FullyConnectedLayer)
+	index := 0
+	for _, m := range output {
+		for _, row := range m {
+			for _, v := range row {
+				if isNonFinite(v) {
+					d.fail(i, layer, "output", "", index, v)
+				}
+				l.sum += float64(v)
+				l.sumSquares += float64(v) * float64(v)
+				if fc && (v < SaturationMargin || v > 1-SaturationMargin) {
+					l.saturated++
+				}
+				if l.active != nil && v > 0 && index < len(l.active) {
+					l.active[index] = true
+				}
+				index++
+			}
+		}
+	}
+	l.count += index
+}
+
+// observeError checks the error backpropagated by the i-th layer
+func (d *Diagnostics) observeError(i int, layer Layer, error [][][]precision.Float) {
+	index := 0
+	for _, m := range error {
+		for _, row := range m {
+			for _, v := range row {
+				if isNonFinite(v) {
+					d.fail(i, layer, "error", "", index, v)
+				}
+				index++
+			}
+		}
+	}
+}
+
+// beforeStep checks the gradients of the layers, measures their norms and
+// keeps the parameter values to compare them after the step
+func (d *Diagnostics) beforeStep(network []Layer) {
+	for i, layer := range network {
+		t, ok := layer.(Trainable)
+		if !ok {
+			continue
+		}
+		l := d.layer(i, layer)
+		params := t.Params()
+		if !fitsParams(l.previous, params) {
+			l.previous = make([][][]precision.Float, len(params))
+			for j, p := range params {
+				l.previous[j] = make([][]precision.Float, len(p.Values))
+				for k, row := range p.Values {
+					l.previous[j][k] = make([]precision.Float, len(row))
+				}
+			}
+		}
+
+		sum := 0.0
+		for j, p := range params {
+			index := 0
+			for k, grads := range p.Grads {
+				for _, g := range grads {
+					if isNonFinite(g) {
+						d.fail(i, layer, "gradient", p.Name, index, g)
+					}
+					sum += float64(g) * float64(g)
+					index++
+				}
+				copy(l.previous[j][k], p.Values[k])
+			}
+		}
+		l.gradNorm += math.Sqrt(sum)
+	}
+}
+
+// afterStep checks the updated parameters and measures the size of the update
+func (d *Diagnostics) afterStep(network []Layer) {
+	for i, layer := range network {
+		t, ok := layer.(Trainable)
+		if !ok {
+			continue
+		}
+		l := d.layer(i, layer)
+		params := t.Params()
+		if !fitsParams(l.previous, params) {
+			continue
+		}
+
+		delta, norm := 0.0, 0.0
+		for j, p := range params {
+			index := 0
+			for k, row := range p.Values {
+				for x, v := range row {
+					if isNonFinite(v) {
+						d.fail(i, layer, "value", p.Name, index, v)
+					}
+					diff := float64(v - l.previous[j][k][x])
+					delta += diff * diff
+					norm += float64(v) * float64(v)
+					index++
+				}
+			}
+		}
+		if norm > 0 {
+			l.update += math.Sqrt(delta / norm)
+		}
+		l.steps++
+	}
+}
+
+// fitsParams tells whether values has the shape of the values of params
+func fitsParams(values [][][]precision.Float, params []layers.Param) bool {
+	if len(values) != len(params) {
+		return false
+	}
+	for j, p := range params {
+		if len(values[j]) != len(p.Values) {
+			return false
+		}
+		for k, row := range p.Values {
+			if len(values[j][k]) != len(row) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isNonFinite tells whether v is NaN or infinite
+func isNonFinite(v precision.Float) bool {
+	return math.IsNaN(float64(v)) || math.IsInf(float64(v), 0)
+}
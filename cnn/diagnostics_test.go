@@ -0,0 +1,129 @@
+package cnn
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// newDiagnosedCNN returns a small network with diagnostics enabled
+func newDiagnosedCNN() *CNN {
+	cn := NewCNN()
+	cn.AddConvLayer(6, 1, 3, 3, 1)
+	cn.AddMaxPoolingLayer(4, 3, 2, 2)
+	cn.AddFullyConnectedLayer(2, 3, 4)
+	cn.Diagnostics = NewDiagnostics()
+	return cn
+}
+
+func TestDiagnosticsNonFinite(t *testing.T) {
+	image := randomInput(rand.New(rand.NewSource(1)), 1, 6, 6)
+
+	cn := newDiagnosedCNN()
+	cn.Layers[2].(*layers.FullyConnectedLayer).Weights[0][2] = precision.Float(math.NaN())
+	cn.ForwardPropagate(image)
+	var nonFinite *NonFiniteError
+	if err := cn.Diagnostics.Err(); !errors.As(err, &nonFinite) {
+		t.Fatalf("Err returned %v after a NaN output", err)
+	}
+	if nonFinite.Layer != 2 || nonFinite.Stage != "output" || nonFinite.Index != 2 {
+		t.Errorf("NaN reported as %+v, expected output 2 of layer 2", nonFinite)
+	}
+	if msg := nonFinite.Error(); !strings.Contains(msg, "NaN in the output of layer 2 (FullyConnectedLayer) at index 2") {
+		t.Errorf("unexpected message %q", msg)
+	}
+
+	// Gradients are checked before clipping could hide an infinite value
+	cn = newDiagnosedCNN()
+	cn.Regularization.ClipValue = 1
+	cn.ForwardPropagate(image)
+	cn.backPropagateError(cn.LastLayerError(1))
+	cn.Layers[0].(*layers.ConvLayer).BiasGrads[1] = precision.Float(math.Inf(1))
+	cn.Update()
+	if !errors.As(cn.Diagnostics.Err(), &nonFinite) || nonFinite.Layer != 0 || nonFinite.Stage != "gradient" ||
+		nonFinite.Param != "biases" || nonFinite.Index != 1 {
+		t.Errorf("infinite gradient reported as %v, expected bias 1 of layer 0", cn.Diagnostics.Err())
+	}
+
+	// Only the first error is kept until the diagnostics are reset
+	cn.Layers[2].(*layers.FullyConnectedLayer).Biases[0] = precision.Float(math.NaN())
+	cn.ForwardPropagate(image)
+	if errors.As(cn.Diagnostics.Err(), &nonFinite); nonFinite.Layer != 0 {
+		t.Errorf("a later error replaced the first one: %v", nonFinite)
+	}
+	cn.Diagnostics.Reset()
+	if err := cn.Diagnostics.Err(); err != nil {
+		t.Errorf("Reset kept the error %v", err)
+	}
+}
+
+func TestDiagnosticsStats(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	cn := newDiagnosedCNN()
+	conv := cn.Layers[0].(*layers.ConvLayer)
+	conv.Biases[0] = -100 // Filter 0 never activates
+	cn.Layers[2].(*layers.FullyConnectedLayer).Biases[3] = 50
+
+	outputs := []precision.Float{}
+	for i := 0; i < 5; i++ {
+		cn.ForwardPropagate(randomInput(rng, 1, 6, 6))
+		outputs = append(outputs, gradcheck.Flatten(cn.Layers[1].(*layers.MaxPoolingLayer).Output)...)
+		cn.BackPropagate(i % 4)
+	}
+
+	stats := cn.Diagnostics.Stats()
+	if len(stats) != 3 || stats[0].Name != "ConvLayer" || stats[0].Samples != 5 || stats[0].Steps != 5 || stats[1].Steps != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	mean, std := 0.0, 0.0
+	for _, v := range outputs {
+		mean += float64(v) / float64(len(outputs))
+	}
+	for _, v := range outputs {
+		std += (float64(v) - mean) * (float64(v) - mean) / float64(len(outputs))
+	}
+	if math.Abs(stats[1].Mean-mean) > 1e-5 || math.Abs(stats[1].Std-math.Sqrt(std)) > 1e-5 {
+		t.Errorf("pooling output has mean %f and std %f, expected %f and %f", stats[1].Mean, stats[1].Std, mean, math.Sqrt(std))
+	}
+	if stats[0].Dead < 1.0/3 || stats[0].Dead == 1 {
+		t.Errorf("%.2f of the ReLU units are dead, expected at least the third of filter 0", stats[0].Dead)
+	}
+	if stats[2].Saturated < 0.25 {
+		t.Errorf("%.2f of the sigmoids are saturated, expected at least output 3", stats[2].Saturated)
+	}
+	for _, i := range []int{0, 2} {
+		if stats[i].GradNorm <= 0 || stats[i].UpdateRatio <= 0 || stats[i].UpdateRatio > 0.1 {
+			t.Errorf("layer %d: gradient norm %g and update ratio %g", i, stats[i].GradNorm, stats[i].UpdateRatio)
+		}
+	}
+
+	var report bytes.Buffer
+	cn.Diagnostics.Report(&report)
+	if lines := strings.Split(strings.TrimSpace(report.String()), "\n"); len(lines) != 5 || !strings.Contains(lines[3], "MaxPoolingLayer") {
+		t.Errorf("unexpected report:\n%s", report.String())
+	}
+}
+
+func TestDiagnosticsDoNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	image := randomInput(rand.New(rand.NewSource(1)), 1, 6, 6)
+	cn := newDiagnosedCNN()
+	step := func() {
+		cn.ForwardPropagate(image)
+		cn.BackPropagate(1)
+	}
+	step() // Warm up the buffers
+
+	if allocs := testing.AllocsPerRun(20, step); allocs != 0 {
+		t.Errorf("a training step with diagnostics performs %v allocations, expected none", allocs)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build cnn_float64
+
+package cnn
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestFloat64GradientsAreTight(t *testing.T) {
+	// In float64 the finite differences are accurate enough for a check far
+	// tighter than the float32 defaults
+	spec, err := ParseSpec([]byte(`{"input": {"shape": [2, 6, 6]}, "layers": [
+		{"type": "conv", "filters": 3, "kernelSize": 3}, {"type": "avgpool", "poolSize": 2, "stride": 1},
+		{"type": "instancenorm"}, {"type": "fc", "units": 3}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := randomInput(rand.New(rand.NewSource(1)), 2, 6, 6)
+	target := make([]precision.Float, 3)
+	target[1] = 1
+	check := gradcheck.Check{Step: 1e-6, Tolerance: 1e-5, Floor: 1e-9}
+	if err := checkNetwork(check, cn, input, target); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFloat64LargeValuesAreFinite(t *testing.T) {
+	for _, v := range []precision.Float{1e300, -1e300, math.MaxFloat64} {
+		if isNonFinite(v) {
+			t.Errorf("%g reported as non-finite", v)
+		}
+	}
+	if !isNonFinite(precision.Float(math.Inf(-1))) {
+		t.Error("-Inf reported as finite")
+	}
+}
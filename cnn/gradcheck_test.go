@@ -0,0 +1,212 @@
+package cnn
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// randomInput returns a volume of normally distributed values
+func randomInput(rng *rand.Rand, depth, height, width int) [][][]precision.Float {
+	input := make3D(depth, height, width)
+	for _, m := range input {
+		for _, row := range m {
+			for i := range row {
+				row[i] = precision.Float(rng.NormFloat64())
+			}
+		}
+	}
+	return input
+}
+
+// gradParams returns params for a gradient check
+func gradParams(params []layers.Param) []gradcheck.Param {
+	var converted []gradcheck.Param
+	for _, p := range params {
+		converted = append(converted, gradcheck.Param{Name: p.Name, Values: p.Values, Grads: p.Grads})
+	}
+	return converted
+}
+
+// checkLayer checks the gradients of a layer and its parameters for an input
+func checkLayer(g gradcheck.Check, l Layer, input [][][]precision.Float) error {
+	var params []layers.Param
+	if t, ok := l.(Trainable); ok {
+		params = t.Params()
+	}
+	return g.Layer(l, gradParams(params), input)
+}
+
+// checkNetwork checks the gradients of a whole network for an input and a
+// target output, with the loss of the network. The gradients of the
+// parameters are cleared.
+func checkNetwork(g gradcheck.Check, c *CNN, input [][][]precision.Float, target []precision.Float) error {
+	params := c.Params()
+	layers.ZeroGrads(params)
+	defer layers.ZeroGrads(params)
+
+	forward := func() []precision.Float {
+		if g.Prepare != nil {
+			g.Prepare()
+		}
+		return c.ForwardPropagate(input)
+	}
+	forward()
+	layerError := c.TargetError(target)
+	for i := len(c.Layers) - 1; i >= 0; i-- {
+		layerError = c.Layers[i].BackPropagate(layerError)
+	}
+
+	loss := func() float64 {
+		return c.TargetLoss(forward(), target)
+	}
+	return g.Compare(input, gradcheck.Flatten(layerError), gradParams(params), loss)
+}
+
+func TestLayerGradients(t *testing.T) {
+	naiveConv := layers.NewConvLayer(6, 2, 3, 3, 1)
+	naiveConv.Algorithm = layers.ConvNaive
+	instanceNorm := func(depth, height, width int) *layers.InstanceNormLayer {
+		l := layers.NewInstanceNormLayer(depth, height, width)
+		l.Momentum = 1 // Keep the running statistics fixed between evaluations
+		return l
+	}
+	fakeQuant := layers.NewFakeQuantLayer(2, 4, 4)
+	fakeQuant.Min, fakeQuant.Max, fakeQuant.Momentum, fakeQuant.Initialized = -1, 1, 1, true
+	dropout := layers.NewDropoutLayer(2, 4, 4, 0.5)
+	spatialDropout := layers.NewSpatialDropoutLayer(4, 3, 3, 0.5)
+
+	for _, tc := range []struct {
+		name  string
+		layer Layer
+		input []int // Shape of the input, that of the layer if nil
+		check gradcheck.Check
+	}{
+		{name: "fc", layer: layers.NewFullyConnectedLayer(3, 2, 5)},
+		{name: "fc flat input", layer: layers.NewFullyConnectedLayer(1, 6, 4)},
+		{name: "conv", layer: layers.NewConvLayer(6, 2, 3, 3, 1)},
+		{name: "conv naive", layer: naiveConv},
+		{name: "conv strided", layer: layers.NewConvLayer(7, 2, 3, 3, 2)},
+		{name: "conv 1x1", layer: layers.NewConvLayer(4, 3, 2, 1, 1)},
+		{name: "maxpool", layer: layers.NewMaxPoolingLayer(6, 2, 2, 2)},
+		{name: "maxpool overlapping", layer: layers.NewMaxPoolingLayer(6, 2, 3, 1)},
+		{name: "avgpool", layer: layers.NewAvgPoolingLayer(6, 2, 2, 2)},
+		{name: "avgpool overlapping", layer: layers.NewAvgPoolingLayer(6, 2, 3, 1)},
+		{name: "global avgpool", layer: layers.NewGlobalAvgPoolingLayer(3, 4, 4)},
+		{name: "global avgpool other size", layer: layers.NewGlobalAvgPoolingLayer(3, 4, 4), input: []int{3, 6, 5}},
+		{name: "global maxpool", layer: layers.NewGlobalMaxPoolingLayer(3, 4, 4)},
+		{name: "global maxpool other size", layer: layers.NewGlobalMaxPoolingLayer(3, 4, 4), input: []int{3, 2, 7}},
+		{name: "instancenorm", layer: instanceNorm(3, 4, 4), check: gradcheck.Check{Step: 1e-2}},
+		{name: "instancenorm features", layer: instanceNorm(1, 1, 6), check: gradcheck.Check{Step: 1e-2}},
+		{name: "dropout", layer: dropout, check: gradcheck.Check{Prepare: func() { *dropout = *layers.NewDropoutLayer(2, 4, 4, 0.5) }}},
+		{name: "spatial dropout", layer: spatialDropout, check: gradcheck.Check{Prepare: func() {
+			*spatialDropout = *layers.NewSpatialDropoutLayer(4, 3, 3, 0.5)
+		}}},
+		// The step spans several quantization levels, so that differences
+		// see the straight-through gradient instead of the rounding steps
+		{name: "fakequant", layer: fakeQuant, input: []int{2, 4, 4}, check: gradcheck.Check{Step: 0.2, Tolerance: 0.05}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewSource(1))
+			// Draw the weights from the generator of the test
+			if l, ok := tc.layer.(interface {
+				Initialize(layers.Initializer, *rand.Rand)
+			}); ok {
+				l.Initialize(layers.HeNormal{}, rng)
+			}
+			shape := tc.input
+			if shape == nil {
+				shape = tc.layer.InputShape()
+			}
+			input := randomInput(rng, shape[0], shape[1], shape[2])
+			if tc.layer == fakeQuant {
+				// Keep the values away from the clipping bounds
+				for _, row := range gradcheck.Rows(input) {
+					for i, v := range row {
+						row[i] = v*0.1 + precision.Float(i%3-1)*2
+					}
+				}
+			}
+			if err := checkLayer(tc.check, tc.layer, input); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestNetworkGradients(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		spec  string
+		loss  string
+		check gradcheck.Check
+	}{
+		{name: "mnist", spec: `{"input": {"shape": [1, 8, 8]}, "layers": [
+			{"type": "conv", "filters": 3, "kernelSize": 3}, {"type": "maxpool", "poolSize": 2},
+			{"type": "fc", "units": 4}]}`},
+		{name: "cross-entropy", loss: LossBCE, spec: `{"input": {"shape": [2, 6, 6]}, "layers": [
+			{"type": "conv", "filters": 3, "kernelSize": 3}, {"type": "avgpool", "poolSize": 2, "stride": 1},
+			{"type": "fc", "units": 3}]}`},
+		{name: "fully convolutional", spec: `{"input": {"shape": [1, 8, 8]}, "layers": [
+			{"type": "conv", "filters": 4, "kernelSize": 3}, {"type": "maxpool", "poolSize": 3, "stride": 1},
+			{"type": "conv", "filters": 3, "kernelSize": 1}, {"type": "globalavgpool"}]}`},
+		{name: "global max and fc", spec: `{"input": {"shape": [1, 6, 6]}, "layers": [
+			{"type": "conv", "filters": 4, "kernelSize": 3}, {"type": "globalmaxpool"}, {"type": "fc", "units": 2}]}`},
+		{name: "instancenorm", spec: `{"input": {"shape": [1, 6, 6]}, "layers": [
+			{"type": "conv", "filters": 2, "kernelSize": 3}, {"type": "instancenorm"}, {"type": "fc", "units": 3}]}`},
+		{name: "dropout", spec: `{"input": {"shape": [1, 6, 6]}, "layers": [
+			{"type": "conv", "filters": 2, "kernelSize": 3}, {"type": "spatialdropout", "rate": 0.5},
+			{"type": "dropout", "rate": 0.25}, {"type": "fc", "units": 3}]}`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			spec, err := ParseSpec([]byte(tc.spec))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cn, err := spec.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			cn.Loss = tc.loss
+
+			// Layers computing differently at every pass are reset to their initial state
+			initial := append([]Layer(nil), cn.Layers...)
+			for i, layer := range cn.Layers {
+				switch l := layer.(type) {
+				case *layers.InstanceNormLayer:
+					l.Momentum = 1
+				case *layers.DropoutLayer:
+					fresh := layers.NewDropoutLayer(l.Depth, l.Height, l.Width, l.Rate)
+					fresh.Seed = l.Seed
+					initial[i] = fresh
+				case *layers.SpatialDropoutLayer:
+					fresh := layers.NewSpatialDropoutLayer(l.Depth, l.Height, l.Width, l.Rate)
+					fresh.Seed = l.Seed
+					initial[i] = fresh
+				}
+			}
+			tc.check.Prepare = func() {
+				for i, layer := range cn.Layers {
+					switch l := layer.(type) {
+					case *layers.DropoutLayer:
+						*l = *initial[i].(*layers.DropoutLayer)
+					case *layers.SpatialDropoutLayer:
+						*l = *initial[i].(*layers.SpatialDropoutLayer)
+					}
+				}
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			shape := spec.Input.Shape
+			input := randomInput(rng, shape[0], shape[1], shape[2])
+			target := make([]precision.Float, len(cn.ForwardPropagate(input)))
+			target[1] = 1
+			if err := checkNetwork(tc.check, cn, input, target); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
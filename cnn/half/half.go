@@ -0,0 +1,143 @@
+// Package half runs trained networks with the weights of their
+// convolutions and fully connected layers held in memory as float16 or
+// bfloat16, half the size of float32 weights, for memory constrained
+// deployments. When a layer runs, its weights are decoded into a layer of
+// package layers, which computes as in the network, so only the weights of
+// the running layer are held in full precision. The other layers hold few
+// or no parameters and run as in the network.
+package half
+
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Layer is a layer of a half precision model
+type Layer interface {
+	Infer(input [][][]precision.Float) [][][]precision.Float
+}
+
+// Model is a network with half precision weights
+type Model struct {
+	Metadata cnn.Metadata
+	Type     precision.Type // Float16 or BFloat16
+	Layers   []Layer
+}
+
+// Convert returns a model running c with its weights stored in t, which
+// must be Float16 or BFloat16. Layers other than convolutions and fully
+// connected layers are shared with c, which must not train while the model
+// is in use. Weights fake quantized for int8 are rejected, see package quant.
+func Convert(c *cnn.CNN, t precision.Type) (*Model, error) {
+	if t != precision.Float16 && t != precision.BFloat16 {
+		return nil, fmt.Errorf("half: %q is not a half precision type", t)
+	}
+	if len(c.Layers) == 0 {
+		return nil, errors.New("half: network has no layers")
+	}
+
+	m := &Model{Metadata: c.Metadata, Type: t}
+	for i, layer := range c.Layers {
+		switch l := layer.(type) {
+		case *layers.ConvLayer:
+			if l.WeightQuant != layers.WeightQuantNone {
+				return nil, fmt.Errorf("half: layer %d: weights are fake quantized for int8", i)
+			}
+			m.Layers = append(m.Layers, convertConv(l, t))
+		case *layers.FullyConnectedLayer:
+			if l.WeightQuant != layers.WeightQuantNone {
+				return nil, fmt.Errorf("half: layer %d: weights are fake quantized for int8", i)
+			}
+			m.Layers = append(m.Layers, convertFC(l, t))
+		default:
+			m.Layers = append(m.Layers, layer)
+		}
+	}
+	return m, nil
+}
+
+// Infer runs an input volume through the model and returns the flattened
+// output of the last layer. Infer only reads the model, so it can be
+// called concurrently.
+func (m *Model) Infer(input [][][]precision.Float) []precision.Float {
+	output := input
+	for _, l := range m.Layers {
+		output = l.Infer(output)
+	}
+
+	var flat []precision.Float
+	for _, channel := range output {
+		for _, row := range channel {
+			flat = append(flat, row...)
+		}
+	}
+	return flat
+}
+
+// Size returns the number of bytes of the weights and biases of the
+// converted layers
+func (m *Model) Size() int {
+	size := 0
+	for _, l := range m.Layers {
+		switch l := l.(type) {
+		case *ConvLayer:
+			size += l.Weights.Size() + floatSize*len(l.Biases)
+		case *FullyConnectedLayer:
+			size += l.Weights.Size() + floatSize*len(l.Biases)
+		}
+	}
+	return size
+}
+
+// floatSize is the number of bytes of a precision.Float
+const floatSize = int(unsafe.Sizeof(precision.Float(0)))
+
+// Tensor holds values in a half precision type
+type Tensor struct {
+	Type precision.Type
+	Bits []uint16
+}
+
+// newTensor rounds rows of values to t
+func newTensor(t precision.Type, rows [][]precision.Float) Tensor {
+	tensor := Tensor{Type: t}
+	for _, row := range rows {
+		for _, v := range row {
+			if t == precision.BFloat16 {
+				tensor.Bits = append(tensor.Bits, uint16(precision.ToBFloat16(float32(v))))
+			} else {
+				tensor.Bits = append(tensor.Bits, uint16(precision.ToFloat16(float32(v))))
+			}
+		}
+	}
+	return tensor
+}
+
+// Widen writes the values of the tensor from start on into dst
+func (t Tensor) Widen(start int, dst []precision.Float) {
+	bits := t.Bits[start : start+len(dst)]
+	if t.Type == precision.BFloat16 {
+		for i, b := range bits {
+			dst[i] = precision.Float(precision.BFloat16Bits(b).Float32())
+		}
+		return
+	}
+	for i, b := range bits {
+		dst[i] = precision.Float(precision.Float16Bits(b).Float32())
+	}
+}
+
+// Len returns the number of values
+func (t Tensor) Len() int {
+	return len(t.Bits)
+}
+
+// Size returns the number of bytes of the values
+func (t Tensor) Size() int {
+	return 2 * len(t.Bits)
+}
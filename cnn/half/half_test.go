@@ -0,0 +1,61 @@
+package half
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/internal/cnntest"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestModelMatchesNetwork(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := cnntest.NewCNN(8)
+	inputs := cnntest.RandomInputs(rng, 10)
+
+	params := 0
+	for _, layer := range c.Layers {
+		params += cnn.ParamCount(layer)
+	}
+
+	for _, tc := range []struct {
+		typ       precision.Type
+		tolerance float64
+	}{
+		{precision.Float16, 1e-3},
+		{precision.BFloat16, 1e-2},
+	} {
+		m, err := Convert(c, tc.typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := m.Size(); size*10 > params*4*6 {
+			t.Errorf("%s: %d bytes for %d parameters", tc.typ, size, params)
+		}
+		for _, input := range inputs {
+			expected, got := c.Infer(input), m.Infer(input)
+			for i := range expected {
+				if math.Abs(float64(got[i]-expected[i])) > tc.tolerance {
+					t.Fatalf("%s: output %v, expected %v", tc.typ, got, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestConvertRejectsInvalidNetworks(t *testing.T) {
+	c := cnntest.NewCNN(4)
+	if _, err := Convert(c, precision.Float32); err == nil {
+		t.Error("Convert accepted float32")
+	}
+	if _, err := Convert(cnn.NewCNN(), precision.Float16); err == nil {
+		t.Error("Convert accepted an empty network")
+	}
+	c.Layers[0].(*layers.ConvLayer).WeightQuant = layers.WeightQuantPerTensor
+	if _, err := Convert(c, precision.Float16); err == nil {
+		t.Error("Convert accepted fake quantized weights")
+	}
+}
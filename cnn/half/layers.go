@@ -0,0 +1,113 @@
+package half
+
+import (
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// ConvLayer is a convolution with half precision kernels followed by ReLU
+type ConvLayer struct {
+	InputSize  int
+	InputDepth int
+	NumFilters int
+	KernelSize int
+	Stride     int
+	Algorithm  layers.ConvAlgorithm
+
+	Weights Tensor // filters x (depth*k*k)
+	Biases  []precision.Float
+}
+
+// convertConv rounds the kernels of a convolution to t
+func convertConv(l *layers.ConvLayer, t precision.Type) *ConvLayer {
+	var rows [][]precision.Float
+	for _, filter := range l.Kernels {
+		for _, channel := range filter {
+			rows = append(rows, channel...)
+		}
+	}
+	return &ConvLayer{
+		InputSize:  l.InputSize,
+		InputDepth: l.InputDepth,
+		NumFilters: l.NumFilters,
+		KernelSize: l.KernelSize,
+		Stride:     l.Stride,
+		Algorithm:  l.Algorithm,
+		Weights:    newTensor(t, rows),
+		Biases:     append([]precision.Float(nil), l.Biases...),
+	}
+}
+
+// Infer decodes the kernels into a layers.ConvLayer, which convolves the
+// input as in the network
+func (l *ConvLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	kernels := make([][][][]precision.Float, l.NumFilters)
+	values := make([]precision.Float, l.Weights.Len())
+	l.Weights.Widen(0, values)
+	for f := range kernels {
+		kernels[f] = make([][][]precision.Float, l.InputDepth)
+		for c := range kernels[f] {
+			kernels[f][c] = make([][]precision.Float, l.KernelSize)
+			for y := range kernels[f][c] {
+				kernels[f][c][y], values = values[:l.KernelSize:l.KernelSize], values[l.KernelSize:]
+			}
+		}
+	}
+
+	conv := &layers.ConvLayer{
+		InputSize:  l.InputSize,
+		InputDepth: l.InputDepth,
+		NumFilters: l.NumFilters,
+		KernelSize: l.KernelSize,
+		Stride:     l.Stride,
+		Algorithm:  l.Algorithm,
+		Kernels:    kernels,
+		Biases:     l.Biases,
+	}
+	return conv.Infer(input)
+}
+
+// FullyConnectedLayer is a fully connected layer with half precision
+// weights followed by a sigmoid
+type FullyConnectedLayer struct {
+	InputSize  int
+	InputWidth int
+	InputDepth int
+	OutputSize int
+
+	Weights Tensor // inputs x outputs
+	Biases  []precision.Float
+}
+
+// convertFC rounds the weights of a fully connected layer to t
+func convertFC(l *layers.FullyConnectedLayer, t precision.Type) *FullyConnectedLayer {
+	return &FullyConnectedLayer{
+		InputSize:  l.InputSize,
+		InputWidth: l.InputWidth,
+		InputDepth: l.InputDepth,
+		OutputSize: l.OutputSize,
+		Weights:    newTensor(t, l.Weights),
+		Biases:     append([]precision.Float(nil), l.Biases...),
+	}
+}
+
+// Infer decodes the weights into a layers.FullyConnectedLayer, which
+// computes the sigmoid outputs as in the network
+func (l *FullyConnectedLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	weights := make([][]precision.Float, l.InputSize)
+	values := make([]precision.Float, l.Weights.Len())
+	l.Weights.Widen(0, values)
+	for i := range weights {
+		weights[i], values = values[:l.OutputSize:l.OutputSize], values[l.OutputSize:]
+	}
+
+	fc := &layers.FullyConnectedLayer{
+		InputSize:  l.InputSize,
+		InputWidth: l.InputWidth,
+		InputDepth: l.InputDepth,
+		OutputSize: l.OutputSize,
+		Weights:    weights,
+		Biases:     l.Biases,
+	}
+	return fc.Infer(input)
+}
@@ -0,0 +1,51 @@
+package cnn
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestInferMatchesForwardPropagate(t *testing.T) {
+	cn := newTestCNN()
+	input := make3D(1, 8, 8)
+	for y := range input[0] {
+		for x := range input[0][y] {
+			input[0][y][x] = precision.Float((x*7+y*3)%5) / 5
+		}
+	}
+
+	expected := append([]precision.Float(nil), cn.ForwardPropagate(input)...)
+	if output := cn.Infer(input); !reflect.DeepEqual(output, expected) {
+		t.Errorf("Infer returned %v, ForwardPropagate returned %v", output, expected)
+	}
+}
+
+func TestConcurrentPredict(t *testing.T) {
+	cn := newTestCNN()
+
+	inputs := make([][][][]precision.Float, 16)
+	expected := make([][]precision.Float, len(inputs))
+	for i := range inputs {
+		inputs[i] = make3D(1, 8, 8)
+		inputs[i][0][i%8][(i*3)%8] = 1
+		_, expected[i], _ = cn.Predict(inputs[i])
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range inputs {
+				_, probs, err := cn.Predict(inputs[i])
+				if err != nil || !reflect.DeepEqual(probs, expected[i]) {
+					t.Errorf("Concurrent prediction %d returned %v (%v), expected %v", i, probs, err, expected[i])
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+// Package cnntest provides the networks and inputs shared by the tests of
+// the packages built on cnn
+package cnntest
+
+import (
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// InputSize is the width and height of the single channel inputs of NewCNN
+const InputSize = 12
+
+// NewCNN returns a small classifier of 5 classes: a convolution of filters
+// filters, max pooling, a convolution of 6 filters and a dense layer. It
+// trains with momentum SGD.
+func NewCNN(filters int) *cnn.CNN {
+	c := cnn.NewCNN()
+	c.Optimizer = cnn.NewSGD(0.05, 0.9)
+	c.AddConvLayer(InputSize, 1, filters, 3, 1)
+	c.AddMaxPoolingLayer(10, filters, 2, 2)
+	c.AddConvLayer(5, filters, 6, 2, 1)
+	c.AddFullyConnectedLayer(4, 6, 5)
+	return c
+}
+
+// RandomInputs returns n inputs of NewCNN with uniform values in [0, 1)
+func RandomInputs(rng *rand.Rand, n int) [][][][]precision.Float {
+	inputs := make([][][][]precision.Float, n)
+	for i := range inputs {
+		inputs[i] = [][][]precision.Float{make([][]precision.Float, InputSize)}
+		for y := range inputs[i][0] {
+			inputs[i][0][y] = make([]precision.Float, InputSize)
+			for x := range inputs[i][0][y] {
+				inputs[i][0][y][x] = precision.Float(rng.Float32())
+			}
+		}
+	}
+	return inputs
+}
@@ -0,0 +1,230 @@
+// Package gradcheck compares the gradients computed by backpropagation with
+// central finite differences of a loss, for every input value and
+// parameter. It only depends on package precision, so that the tests of
+// the layers and of the networks built from them share one checker.
+package gradcheck
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Default settings of a Check
+const (
+	DefaultStep      = 1e-3
+	DefaultTolerance = 1e-2
+	DefaultFloor     = 1e-2
+)
+
+// Layer is the part of a layer the checker drives
+type Layer interface {
+	ForwardPropagate(input [][][]precision.Float) [][][]precision.Float
+	BackPropagate(error [][][]precision.Float) [][][]precision.Float
+}
+
+// Param is a parameter of a layer and the gradient BackPropagate
+// accumulates for it
+type Param struct {
+	Name   string
+	Values [][]precision.Float
+	Grads  [][]precision.Float
+}
+
+// Check holds the settings of a gradient check.
+// Layers must compute the same function at every forward pass: layers
+// updating running statistics need a Momentum of 1, so that their
+// statistics stay put, and layers drawing random numbers, such as dropout,
+// need Prepare to reset them. Fake quantization rounds its values, its
+// straight-through gradient only matches differences taken with a Step
+// larger than the quantization scale.
+//
+// Gradients are compared by their relative error |a-n| / max(|a|, |n|,
+// Floor). The floor keeps the rounding errors of float32 forward passes,
+// which make the finite differences of gradients below about 1e-3 noisy,
+// from failing the check.
+type Check struct {
+	Step      float64 // Finite difference step, DefaultStep if zero
+	Tolerance float64 // Accepted relative error, DefaultTolerance if zero
+	Floor     float64 // Smallest magnitude the error is relative to, DefaultFloor if zero
+	Seed      int64   // Seed of the random loss weights used by Layer
+	Prepare   func()  // Called before every forward pass, if set
+}
+
+// Mismatch is a gradient which differs from its finite difference
+type Mismatch struct {
+	Name              string // "input", or the name of a parameter
+	Index             int    // Position in the flattened input or parameter
+	Analytic, Numeric float64
+}
+
+// String describes the mismatch
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s[%d]: gradient %g, finite difference %g", m.Name, m.Index, m.Analytic, m.Numeric)
+}
+
+// Error lists the gradients which failed a Check
+type Error struct {
+	Checked    int // Number of gradients compared
+	Mismatches []Mismatch
+}
+
+// Error describes the first mismatches
+func (e *Error) Error() string {
+	const shown = 3
+	var first []string
+	for i, m := range e.Mismatches {
+		if i == shown {
+			first = append(first, "...")
+			break
+		}
+		first = append(first, m.String())
+	}
+	return fmt.Sprintf("gradcheck: %d of %d gradients differ: %s", len(e.Mismatches), e.Checked, strings.Join(first, "; "))
+}
+
+// Layer checks the gradients of a layer and of its params for an input,
+// with the loss being a weighted sum of its outputs with random weights.
+// The input is left unchanged and the gradients of params are cleared. It
+// returns an *Error when gradients differ.
+func (g Check) Layer(l Layer, params []Param, input [][][]precision.Float) error {
+	rng := rand.New(rand.NewSource(g.Seed))
+	zeroGrads(params)
+	defer zeroGrads(params)
+
+	forward := func() [][][]precision.Float {
+		if g.Prepare != nil {
+			g.Prepare()
+		}
+		return l.ForwardPropagate(input)
+	}
+
+	weights := clone(forward())
+	for _, row := range Rows(weights) {
+		for i := range row {
+			row[i] = precision.Float(rng.NormFloat64())
+		}
+	}
+	inputGrads := Flatten(l.BackPropagate(clone(weights)))
+
+	loss := func() float64 {
+		sum := 0.0
+		output := forward()
+		for d := range output {
+			for y := range output[d] {
+				for x, v := range output[d][y] {
+					sum += float64(v) * float64(weights[d][y][x])
+				}
+			}
+		}
+		return sum
+	}
+	return g.Compare(input, inputGrads, params, loss)
+}
+
+// Compare perturbs every input value and parameter, and compares the
+// change of loss with inputGrads and the gradients of params, computed by
+// backpropagation before the call. It evaluates loss once more at the end,
+// to leave the layers as computed on the unperturbed input, and returns an
+// *Error when gradients differ.
+func (g Check) Compare(input [][][]precision.Float, inputGrads []precision.Float, params []Param, loss func() float64) error {
+	step, tolerance, floor := g.Step, g.Tolerance, g.Floor
+	if step == 0 {
+		step = DefaultStep
+	}
+	if tolerance == 0 {
+		tolerance = DefaultTolerance
+	}
+	if floor == 0 {
+		floor = DefaultFloor
+	}
+
+	// Gradients are copied first, the loss evaluations overwrite the layers
+	type entry struct {
+		name     string
+		values   [][]precision.Float
+		analytic []precision.Float
+	}
+	entries := []entry{{"input", Rows(input), inputGrads}}
+	for _, p := range params {
+		var grads []precision.Float
+		for _, row := range p.Grads {
+			grads = append(grads, row...)
+		}
+		entries = append(entries, entry{p.Name, p.Values, grads})
+	}
+
+	result := &Error{}
+	for _, e := range entries {
+		index := 0
+		for _, row := range e.values {
+			for i := range row {
+				saved := row[i]
+				row[i] = saved + precision.Float(step)
+				plus := loss()
+				row[i] = saved - precision.Float(step)
+				minus := loss()
+				row[i] = saved
+
+				numeric := (plus - minus) / (2 * step)
+				analytic := float64(e.analytic[index])
+				scale := math.Max(math.Max(math.Abs(analytic), math.Abs(numeric)), floor)
+				if math.Abs(analytic-numeric) > tolerance*scale {
+					result.Mismatches = append(result.Mismatches, Mismatch{e.name, index, analytic, numeric})
+				}
+				result.Checked++
+				index++
+			}
+		}
+	}
+
+	loss()
+	if len(result.Mismatches) > 0 {
+		return result
+	}
+	return nil
+}
+
+// Rows returns the innermost rows of a volume
+func Rows(volume [][][]precision.Float) [][]precision.Float {
+	var r [][]precision.Float
+	for _, m := range volume {
+		r = append(r, m...)
+	}
+	return r
+}
+
+// Flatten returns a copy of the values of a volume
+func Flatten(volume [][][]precision.Float) []precision.Float {
+	var values []precision.Float
+	for _, row := range Rows(volume) {
+		values = append(values, row...)
+	}
+	return values
+}
+
+// clone returns a copy of a volume
+func clone(volume [][][]precision.Float) [][][]precision.Float {
+	c := make([][][]precision.Float, len(volume))
+	for d, m := range volume {
+		c[d] = make([][]precision.Float, len(m))
+		for y, row := range m {
+			c[d][y] = append([]precision.Float(nil), row...)
+		}
+	}
+	return c
+}
+
+// zeroGrads clears the gradients of params
+func zeroGrads(params []Param) {
+	for _, p := range params {
+		for _, row := range p.Grads {
+			for i := range row {
+				row[i] = 0
+			}
+		}
+	}
+}
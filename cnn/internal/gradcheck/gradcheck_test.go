@@ -0,0 +1,65 @@
+package gradcheck_test
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// brokenLayer computes the gradients of a dense layer with an error
+type brokenLayer struct {
+	*layers.FullyConnectedLayer
+	factor precision.Float // Scale of the weight gradients
+}
+
+func (l brokenLayer) BackPropagate(errors [][][]precision.Float) [][][]precision.Float {
+	prevError := l.FullyConnectedLayer.BackPropagate(errors)
+	for _, row := range l.WeightGrads {
+		for i := range row {
+			row[i] *= l.factor
+		}
+	}
+	return prevError
+}
+
+func TestCheckDetectsErrors(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := make([][][]precision.Float, 2)
+	for c := range input {
+		input[c] = make([][]precision.Float, 3)
+		for y := range input[c] {
+			input[c][y] = make([]precision.Float, 3)
+			for x := range input[c][y] {
+				input[c][y][x] = precision.Float(rng.NormFloat64())
+			}
+		}
+	}
+
+	for _, factor := range []precision.Float{1, 0, 0.95, 1.05, -1} {
+		fc := layers.NewFullyConnectedLayer(3, 2, 5)
+		var params []gradcheck.Param
+		for _, p := range fc.Params() {
+			params = append(params, gradcheck.Param{Name: p.Name, Values: p.Values, Grads: p.Grads})
+		}
+		err := gradcheck.Check{}.Layer(brokenLayer{fc, factor}, params, input)
+		if factor == 1 {
+			if err != nil {
+				t.Fatalf("correct gradients failed the check: %v", err)
+			}
+			continue
+		}
+		var mismatches *gradcheck.Error
+		if !errors.As(err, &mismatches) {
+			t.Fatalf("gradients scaled by %g passed the check", factor)
+		}
+		for _, m := range mismatches.Mismatches {
+			if m.Name != "weights" {
+				t.Errorf("gradients scaled by %g: unexpected mismatch %v", factor, m)
+			}
+		}
+	}
+}
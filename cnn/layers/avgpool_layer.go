@@ -0,0 +1,145 @@
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// AvgPoolingLayer averages the values of square windows of every channel
+type AvgPoolingLayer struct {
+	InputSize  int
+	InputDepth int
+	PoolSize   int
+	OutputSize int
+	Stride     int
+	Output     [][][]precision.Float `json:"-"`
+	PrevError  [][][]precision.Float `json:"-"`
+
+	forwardTask  avgPoolTask // Parallel loops of training, reused between steps
+	backwardTask avgPoolBackwardTask
+}
+
+// avgPoolTask averages some channels of input into output
+type avgPoolTask struct {
+	apl           *AvgPoolingLayer
+	input, output [][][]precision.Float
+}
+
+// avgPoolBackwardTask spreads the error of some channels over their windows
+type avgPoolBackwardTask struct {
+	apl   *AvgPoolingLayer
+	error [][][]precision.Float
+}
+
+// NewAvgPoolingLayer creates an AvgPoolingLayer for inputSize x inputSize
+// inputs of inputDepth channels, with poolSize x poolSize windows moving by stride
+func NewAvgPoolingLayer(inputSize, inputDepth, poolSize, stride int) *AvgPoolingLayer {
+	outputSize := ((inputSize - poolSize) / stride) + 1
+	return &AvgPoolingLayer{
+		InputSize:  inputSize,
+		InputDepth: inputDepth,
+		PoolSize:   poolSize,
+		OutputSize: outputSize,
+		Stride:     stride,
+		Output:     make3D[precision.Float](inputDepth, outputSize, outputSize),
+		PrevError:  make3D[precision.Float](inputDepth, inputSize, inputSize),
+	}
+}
+
+// ForwardPropagate averages the windows of the input
+func (apl *AvgPoolingLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	if apl.Output == nil {
+		apl.Output = make3D[precision.Float](apl.InputDepth, apl.OutputSize, apl.OutputSize)
+	}
+	apl.forwardTask = avgPoolTask{apl: apl, input: input, output: apl.Output}
+	parallelRun(apl.InputDepth, apl.grain(), &apl.forwardTask)
+	apl.forwardTask = avgPoolTask{}
+	return apl.Output
+}
+
+// Infer computes the output of the AvgPoolingLayer without storing
+// anything, so it can be called concurrently. The input may have any height
+// and width of at least PoolSize, the output is sized from it.
+func (apl *AvgPoolingLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](apl.InputDepth, windows(len(input[0]), apl.PoolSize, apl.Stride),
+		windows(len(input[0][0]), apl.PoolSize, apl.Stride))
+	parallelRun(apl.InputDepth, apl.grain(), &avgPoolTask{apl: apl, input: input, output: output})
+	return output
+}
+
+// run averages the channels [start, end)
+func (t *avgPoolTask) run(start, end int) {
+	apl := t.apl
+	scale := 1 / precision.Float(apl.PoolSize*apl.PoolSize)
+	for f := start; f < end; f++ {
+		for y := range t.output[f] {
+			for x := range t.output[f][y] {
+				top, left := y*apl.Stride, x*apl.Stride
+				sum := precision.Float(0)
+				for yP := 0; yP < apl.PoolSize; yP++ {
+					for _, v := range t.input[f][top+yP][left : left+apl.PoolSize] {
+						sum += v
+					}
+				}
+				t.output[f][y][x] = sum * scale
+			}
+		}
+	}
+}
+
+// grain returns the number of channels worth handing to a separate goroutine
+func (apl *AvgPoolingLayer) grain() int {
+	return 1 + parallelGrain/(apl.OutputSize*apl.OutputSize*apl.PoolSize*apl.PoolSize)
+}
+
+// BackPropagate spreads the error of every output evenly over its window.
+// Overlapping windows add up their contributions.
+func (apl *AvgPoolingLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	if apl.PrevError == nil {
+		apl.PrevError = make3D[precision.Float](apl.InputDepth, apl.InputSize, apl.InputSize)
+	}
+	apl.backwardTask = avgPoolBackwardTask{apl: apl, error: error}
+	parallelRun(apl.InputDepth, apl.grain(), &apl.backwardTask)
+	apl.backwardTask = avgPoolBackwardTask{}
+	return apl.PrevError
+}
+
+// run spreads the error of the channels [start, end)
+func (t *avgPoolBackwardTask) run(start, end int) {
+	apl := t.apl
+	scale := 1 / precision.Float(apl.PoolSize*apl.PoolSize)
+	for f := start; f < end; f++ {
+		zero3D(apl.PrevError[f : f+1])
+		for y := 0; y < apl.OutputSize; y++ {
+			for x := 0; x < apl.OutputSize; x++ {
+				top, left := y*apl.Stride, x*apl.Stride
+				e := t.error[f][y][x] * scale
+				for yP := 0; yP < apl.PoolSize; yP++ {
+					row := apl.PrevError[f][top+yP][left : left+apl.PoolSize]
+					for i := range row {
+						row[i] += e
+					}
+				}
+			}
+		}
+	}
+}
+
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, or nil if the input has another depth or is
+// smaller than the pooling window
+func (apl *AvgPoolingLayer) OutputShapeFor(input []int) []int {
+	return poolShape(input, apl.InputDepth, apl.PoolSize, apl.Stride)
+}
+
+// GetOutput returns the output value at the specified index
+func (apl *AvgPoolingLayer) GetOutput(index int) precision.Float {
+	panic("Average pooling layers should not be accessed directly.")
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (apl *AvgPoolingLayer) InputShape() []int {
+	return []int{apl.InputDepth, apl.InputSize, apl.InputSize}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (apl *AvgPoolingLayer) OutputShape() []int {
+	return []int{apl.InputDepth, apl.OutputSize, apl.OutputSize}
+}
@@ -0,0 +1,26 @@
+package layers
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestAvgPooling(t *testing.T) {
+	input := [][][]precision.Float{{
+		{1, 2, 3, 4},
+		{5, 6, 7, 8},
+		{9, 10, 11, 12},
+		{13, 14, 15, 16},
+	}}
+	l := NewAvgPoolingLayer(4, 1, 2, 2)
+	assertClose(t, "output", flatten(l.ForwardPropagate(input)), []precision.Float{3.5, 5.5, 11.5, 13.5})
+	assertClose(t, "inferred output", flatten(l.Infer(input)), []precision.Float{3.5, 5.5, 11.5, 13.5})
+
+	// Overlapping windows add up their errors
+	rng := rand.New(rand.NewSource(1))
+	checkGradients(t, "avg", gradcheck.Check{}, NewAvgPoolingLayer(6, 3, 3, 1), nil, randomVolume(rng, 3, 6))
+	checkGradients(t, "avg strided", gradcheck.Check{}, NewAvgPoolingLayer(6, 3, 2, 2), nil, randomVolume(rng, 3, 6))
+}
@@ -1,3 +1,78 @@
 package layers
 
-const learningRate = 0.01 // You can adjust the learning rate
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// DefaultLearningRate is the step size used when no optimizer is configured
+const DefaultLearningRate = 0.01
+
+// Param is a trainable tensor of a layer along with its gradient.
+// Values and Grads are views on the layer storage split into rows, so that
+// kernels, weight matrices and bias vectors share a single representation.
+// BackPropagate accumulates into Grads; optimizers apply and clear them.
+type Param struct {
+	Name   string
+	Values [][]precision.Float
+	Grads  [][]precision.Float
+	Mask   [][]precision.Float // Pruning mask shaped like Values, 0 for pruned values and 1 otherwise (optional)
+	Bias   bool                // Offsets and normalization parameters rather than connection weights
+}
+
+// ApplyMasks zeroes the pruned values of params along with their gradients
+func ApplyMasks(params []Param) {
+	for _, p := range params {
+		for j, mask := range p.Mask {
+			values, grads := p.Values[j], p.Grads[j]
+			for i, m := range mask {
+				if m == 0 {
+					values[i], grads[i] = 0, 0
+				}
+			}
+		}
+	}
+}
+
+// maskRows returns the rows of a mask vector, nil when there is no mask
+func maskRows(mask []precision.Float) [][]precision.Float {
+	if mask == nil {
+		return nil
+	}
+	return [][]precision.Float{mask}
+}
+
+// ZeroGrads clears the gradients of params
+func ZeroGrads(params []Param) {
+	for _, p := range params {
+		for _, row := range p.Grads {
+			for i := range row {
+				row[i] = 0
+			}
+		}
+	}
+}
+
+// windows returns the number of positions of a window of the given size
+// moving by stride over size values, 0 if the window does not fit
+func windows(size, window, stride int) int {
+	if size < window {
+		return 0
+	}
+	return (size-window)/stride + 1
+}
+
+// rows3D returns the innermost rows of a 3D tensor
+func rows3D(x [][][]precision.Float) [][]precision.Float {
+	var rows [][]precision.Float
+	for _, m := range x {
+		rows = append(rows, m...)
+	}
+	return rows
+}
+
+// rows4D returns the innermost rows of a 4D tensor
+func rows4D(x [][][][]precision.Float) [][]precision.Float {
+	var rows [][]precision.Float
+	for _, t := range x {
+		rows = append(rows, rows3D(t)...)
+	}
+	return rows
+}
@@ -3,6 +3,8 @@ package layers
 import (
 	"math"
 	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
 )
 
 // ConvLayer represents a convolutional layer in the CNN
@@ -13,20 +15,59 @@ type ConvLayer struct {
 	KernelSize int
 	OutputSize int
 	Stride     int
-	Biases     []float32
-	Kernels    [][][][]float32
-	Input      [][][]float32
-	Output     [][][]float32
+	Biases     []precision.Float
+	Kernels    [][][][]precision.Float
+	Input      [][][]precision.Float
+	Output     [][][]precision.Float
+
+	KernelGrads [][][][]precision.Float `json:"-"` // Gradients accumulated by BackPropagate
+	BiasGrads   []precision.Float       `json:"-"`
+	KernelMask  [][][][]precision.Float `json:",omitempty"` // Pruning masks, see Param.Mask (optional)
+	BiasMask    []precision.Float       `json:",omitempty"`
+
+	Algorithm   ConvAlgorithm `json:"-"` // Implementation of the convolution, ConvGEMM by default
+	WeightQuant WeightQuant   `json:"-"` // Fake quantization of the kernels during training
+
+	buf       convBuffers           // Scratch memory of training, reused between steps
+	haveCols  bool                  // buf.cols holds the im2col matrix of Input
+	prevError [][][]precision.Float // Returned by BackPropagate
+	params    []Param
+
+	quantKernels [][][][]precision.Float // Kernels rounded to int8 levels, see WeightQuant
+	quantScales  []precision.Float
+	kernels      [][][][]precision.Float // Kernels used by the last ForwardPropagate
 }
 
-// NewConvLayer creates a new ConvLayer object with the specified parameters
-func NewConvLayer(inputSize, inputDepth, numFilters, kernelSize, stride int) *ConvLayer {
+// convBuffers is the scratch memory of the GEMM convolution. The layer keeps
+// one for training so that a training step does not allocate; Infer uses
+// its own so that it can run concurrently.
+type convBuffers struct {
+	cols      []precision.Float // im2col matrix of the input
+	kernels   []precision.Float // Kernels packed as a filters x (depth*k*k) matrix
+	result    []precision.Float // Pre-activation output
+	delta     []precision.Float // Error masked by the ReLU derivative
+	grads     []precision.Float // Kernel gradients of one step
+	colsError []precision.Float // Error of the im2col matrix
+	unfold    im2colTask
+	gemm      gemm
+}
 
-	biases := make([]float32, numFilters)
+// ConvAlgorithm selects how a ConvLayer computes its convolutions
+type ConvAlgorithm int
 
-	// Use He initialization with a mean of 0.0 and standard deviation of sqrt(2 / (inputDepth * kernelSize^2))
-	normal := rand.New(rand.NewSource(42))
-	standardDeviation := float32(math.Sqrt(2.0 / float64(inputDepth*kernelSize*kernelSize)))
+const (
+	// ConvGEMM unfolds the input with im2col and uses a blocked matrix multiplication
+	ConvGEMM ConvAlgorithm = iota
+	// ConvNaive loops directly over the input, kept as the reference implementation
+	ConvNaive
+)
+
+// NewConvLayer creates a new ConvLayer object with the specified parameters.
+// Its kernels are drawn with He initialization from a package generator;
+// CNN.AddConvLayer fills them again from the generator of the network.
+func NewConvLayer(inputSize, inputDepth, numFilters, kernelSize, stride int) *ConvLayer {
+
+	biases := make([]precision.Float, numFilters)
 
 	outputSize := ((inputSize - kernelSize) / stride) + 1
 
@@ -43,95 +84,395 @@ func NewConvLayer(inputSize, inputDepth, numFilters, kernelSize, stride int) *Co
 		Output:     nil,
 	}
 
-	cl.Output = make3D[float32](numFilters, outputSize, outputSize)
-	cl.Kernels = make4D[float32](numFilters, inputDepth, kernelSize, kernelSize)
+	cl.Output = make3D[precision.Float](numFilters, outputSize, outputSize)
+	cl.Kernels = make4D[precision.Float](numFilters, inputDepth, kernelSize, kernelSize)
 	cl.OutputSize = outputSize
 
 	for f := 0; f < numFilters; f++ {
 		biases[f] = 0.1
-		for i := 0; i < inputDepth; i++ {
-			for j := 0; j < kernelSize; j++ {
-				for k := 0; k < kernelSize; k++ {
-					cl.Kernels[f][i][j][k] = float32(normal.NormFloat64()) * standardDeviation
+	}
+
+	// Use He initialization with a mean of 0.0 and standard deviation of sqrt(2 / (inputDepth * kernelSize^2))
+	cl.Initialize(HeNormal{}, defaultRand)
+
+	return cl
+
+}
+
+// Initialize fills the kernels with init, drawing from rng. Every filter is
+// a unit with inputDepth * kernelSize^2 inputs. Biases are left unchanged.
+func (cl *ConvLayer) Initialize(init Initializer, rng *rand.Rand) {
+	area := cl.KernelSize * cl.KernelSize
+	fanIn := cl.InputDepth * area
+	weights := make([]precision.Float, cl.NumFilters*fanIn)
+	init.Init(rng, weights, fanIn, cl.NumFilters*area)
+	for _, row := range rows4D(cl.Kernels) {
+		weights = weights[copy(row, weights):]
+	}
+}
+
+// ForwardPropagate performs forward propagation through the ConvLayer.
+// The returned volume is the Output of the layer, overwritten by the next call.
+func (cl *ConvLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	cl.Input = copy3D(cl.Input, input)
+	cl.kernels = cl.Kernels
+	if cl.WeightQuant != WeightQuantNone {
+		cl.kernels = cl.fakeQuantKernels()
+	}
+
+	if cl.Algorithm == ConvGEMM {
+		cl.forwardGEMM(cl.Input, cl.Output, cl.kernels, &cl.buf)
+		cl.haveCols = true
+	} else {
+		cl.haveCols = false
+		cl.forwardNaive(cl.Input, cl.Output, cl.kernels)
+	}
+
+	return cl.Output
+}
+
+// fakeQuantKernels returns the kernels rounded to int8 levels, in buffers
+// of the layer
+func (cl *ConvLayer) fakeQuantKernels() [][][][]precision.Float {
+	if cl.quantKernels == nil {
+		cl.quantKernels = make4D[precision.Float](cl.NumFilters, cl.InputDepth, cl.KernelSize, cl.KernelSize)
+	}
+	cl.quantScales = grow(cl.quantScales, cl.NumFilters)
+	cl.roundKernels(cl.quantKernels, cl.quantScales)
+	return cl.quantKernels
+}
+
+// roundKernels writes the kernels rounded to int8 levels into dst, and the
+// scale of every filter into scales
+func (cl *ConvLayer) roundKernels(dst [][][][]precision.Float, scales []precision.Float) {
+	for f, filter := range cl.Kernels {
+		scales[f] = 0
+		for _, channel := range filter {
+			for _, row := range channel {
+				for _, w := range row {
+					scales[f] = precision.Float(math.Max(float64(scales[f]), math.Abs(float64(w))))
 				}
 			}
 		}
 	}
+	weightScales(cl.WeightQuant, scales)
 
-	return cl
+	for f, filter := range cl.Kernels {
+		for c, channel := range filter {
+			for y, row := range channel {
+				for x, w := range row {
+					dst[f][c][y][x] = roundWeight(w, scales[f])
+				}
+			}
+		}
+	}
+}
 
+// Infer computes the output of the ConvLayer without storing anything for
+// backpropagation, so it can be called concurrently. The input may have any
+// height and width of at least KernelSize, the output is sized from it.
+func (cl *ConvLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](cl.NumFilters, windows(len(input[0]), cl.KernelSize, cl.Stride),
+		windows(len(input[0][0]), cl.KernelSize, cl.Stride))
+	kernels := cl.Kernels
+	if cl.WeightQuant != WeightQuantNone {
+		kernels = make4D[precision.Float](cl.NumFilters, cl.InputDepth, cl.KernelSize, cl.KernelSize)
+		cl.roundKernels(kernels, make([]precision.Float, cl.NumFilters))
+	}
+
+	if cl.Algorithm == ConvGEMM {
+		cl.forwardGEMM(input, output, kernels, &convBuffers{})
+	} else {
+		cl.forwardNaive(input, output, kernels)
+	}
+
+	return output
 }
 
-// ForwardPropagate performs forward propagation through the ConvLayer
-func (cl *ConvLayer) ForwardPropagate(input [][][]float32) [][][]float32 {
-	cl.Input = clone3D(input)
+// colsSize returns the number of elements of the im2col matrix
+func (cl *ConvLayer) colsSize() int {
+	return cl.InputDepth * cl.KernelSize * cl.KernelSize * cl.OutputSize * cl.OutputSize
+}
 
-	for f := 0; f < cl.NumFilters; f++ {
-		for i := 0; i < cl.OutputSize; i++ {
-			for j := 0; j < cl.OutputSize; j++ {
-				cl.Output[f][i][j] = cl.Biases[f]
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, or nil if the input has another depth or is
+// smaller than the kernels
+func (cl *ConvLayer) OutputShapeFor(input []int) []int {
+	height, width := windows(input[1], cl.KernelSize, cl.Stride), windows(input[2], cl.KernelSize, cl.Stride)
+	if input[0] != cl.InputDepth || height < 1 || width < 1 {
+		return nil
+	}
+	return []int{cl.NumFilters, height, width}
+}
 
-				for f_i := 0; f_i < cl.InputDepth; f_i++ {
-					for y_k := 0; y_k < cl.KernelSize; y_k++ {
-						for x_k := 0; x_k < cl.KernelSize; x_k++ {
-							val := cl.Input[f_i][i*cl.Stride+y_k][j*cl.Stride+x_k]
-							cl.Output[f][i][j] += cl.Kernels[f][f_i][y_k][x_k] * val
+// forwardNaive convolves the input with the kernels and applies ReLU into
+// output, whose height and width are those of the output positions.
+// Filters are processed in parallel.
+func (cl *ConvLayer) forwardNaive(input, output [][][]precision.Float, kernels [][][][]precision.Float) {
+	height, width := len(output[0]), len(output[0][0])
+	ParallelFor(cl.NumFilters, 1, func(start, end int) {
+		for f := start; f < end; f++ {
+			for i := 0; i < height; i++ {
+				for j := 0; j < width; j++ {
+					output[f][i][j] = cl.Biases[f]
+
+					for f_i := 0; f_i < cl.InputDepth; f_i++ {
+						for y_k := 0; y_k < cl.KernelSize; y_k++ {
+							for x_k := 0; x_k < cl.KernelSize; x_k++ {
+								val := input[f_i][i*cl.Stride+y_k][j*cl.Stride+x_k]
+								output[f][i][j] += kernels[f][f_i][y_k][x_k] * val
+							}
 						}
 					}
+
+					// Apply ReLU activation function
+					output[f][i][j] = max(0.0, output[f][i][j])
 				}
 			}
 		}
+	})
+}
+
+// forwardGEMM computes the same result as forwardNaive as a single matrix
+// product: (filters x depth*k*k) kernels times the (depth*k*k x positions)
+// im2col matrix, which is written into b.cols
+func (cl *ConvLayer) forwardGEMM(input, output [][][]precision.Float, kernels [][][][]precision.Float, b *convBuffers) {
+	height, width := len(output[0]), len(output[0][0])
+	positions := height * width
+	patch := cl.InputDepth * cl.KernelSize * cl.KernelSize
+
+	b.cols = grow(b.cols, patch*positions)
+	b.unfold.im2col(input, cl.InputDepth, cl.KernelSize, cl.Stride, height, width, b.cols)
+
+	// Start from the biases, the product is accumulated on top of them
+	result := grow(b.result, cl.NumFilters*positions)
+	b.result = result
+	for f := 0; f < cl.NumFilters; f++ {
+		row := result[f*positions : (f+1)*positions]
+		for p := range row {
+			row[p] = cl.Biases[f]
+		}
 	}
+	b.kernels = cl.packKernels(b.kernels, kernels)
+	b.gemm.sgemm(false, false, cl.NumFilters, positions, patch, b.kernels, patch, b.cols, positions, result, positions)
 
 	// Apply ReLU activation function
 	for f := 0; f < cl.NumFilters; f++ {
-		for i := 0; i < cl.OutputSize; i++ {
-			for j := 0; j < cl.OutputSize; j++ {
-				cl.Output[f][i][j] = max(0.0, cl.Output[f][i][j])
+		for i := 0; i < height; i++ {
+			row := result[f*positions+i*width:]
+			for j := 0; j < width; j++ {
+				output[f][i][j] = max(0.0, row[j])
 			}
 		}
 	}
+}
 
-	return clone3D(cl.Output)
+// packKernels writes kernels into dst as a contiguous filters x (depth*k*k) matrix
+func (cl *ConvLayer) packKernels(dst []precision.Float, kernels [][][][]precision.Float) []precision.Float {
+	packed := grow(dst, cl.NumFilters*cl.InputDepth*cl.KernelSize*cl.KernelSize)[:0]
+	for _, filter := range kernels {
+		for _, channel := range filter {
+			for _, row := range channel {
+				packed = append(packed, row...)
+			}
+		}
+	}
+	return packed
 }
 
-// BackPropagate performs backpropagation through the ConvLayer
-func (cl *ConvLayer) BackPropagate(error [][][]float32) [][][]float32 {
+// BackPropagate performs backpropagation through the ConvLayer.
+// Gradients are accumulated into KernelGrads and BiasGrads, the parameters
+// themselves are left to the optimizer. The returned error is overwritten
+// by the next call.
+func (cl *ConvLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	cl.allocGrads()
+	if cl.prevError == nil {
+		cl.prevError = make3D[precision.Float](cl.InputDepth, cl.InputSize, cl.InputSize)
+	} else {
+		zero3D(cl.prevError)
+	}
+	prevError := cl.prevError
+	if cl.kernels == nil {
+		cl.kernels = cl.Kernels
+	}
 
-	newKernels := clone4D(cl.Kernels)
-	prevError := make3D[float32](cl.InputDepth, cl.InputSize, cl.InputSize)
+	if cl.Algorithm == ConvGEMM {
+		cl.backPropagateGEMM(error, prevError)
+	} else {
+		cl.backPropagateNaive(error, prevError)
+	}
 
-	for y := 0; y < cl.OutputSize; y++ {
-		for x := 0; x < cl.OutputSize; x++ {
-			left := x * cl.Stride
-			top := y * cl.Stride
+	return prevError
+}
 
-			for f := 0; f < cl.NumFilters; f++ {
-				if cl.Output[f][y][x] > 0.0 {
-					cl.Biases[f] -= error[f][y][x] * learningRate
+// backPropagateNaive loops over every output position, it is the reference implementation.
+// Parameter gradients are computed in parallel over filters, then the
+// previous error in parallel over input channels, so that no two
+// goroutines accumulate into the same value.
+func (cl *ConvLayer) backPropagateNaive(error, prevError [][][]precision.Float) {
+	ParallelFor(cl.NumFilters, 1, func(start, end int) {
+		for f := start; f < end; f++ {
+			for y := 0; y < cl.OutputSize; y++ {
+				for x := 0; x < cl.OutputSize; x++ {
+					if cl.Output[f][y][x] <= 0.0 {
+						continue
+					}
+					left := x * cl.Stride
+					top := y * cl.Stride
+					cl.BiasGrads[f] += error[f][y][x]
 
 					for y_k := 0; y_k < cl.KernelSize; y_k++ {
 						for x_k := 0; x_k < cl.KernelSize; x_k++ {
 							for f_i := 0; f_i < cl.InputDepth; f_i++ {
-								prevError[f_i][top+y_k][left+x_k] +=
-									cl.Kernels[f][f_i][y_k][x_k] * error[f][y][x]
+								cl.KernelGrads[f][f_i][y_k][x_k] +=
+									cl.Input[f_i][top+y_k][left+x_k] * error[f][y][x]
+							}
+						}
+					}
+				}
+			}
+		}
+	})
 
-								newKernels[f][f_i][y_k][x_k] -=
-									cl.Input[f_i][top+y_k][left+x_k] * error[f][y][x] * learningRate
+	ParallelFor(cl.InputDepth, 1, func(start, end int) {
+		for y := 0; y < cl.OutputSize; y++ {
+			for x := 0; x < cl.OutputSize; x++ {
+				left := x * cl.Stride
+				top := y * cl.Stride
+
+				for f := 0; f < cl.NumFilters; f++ {
+					if cl.Output[f][y][x] <= 0.0 {
+						continue
+					}
+					for y_k := 0; y_k < cl.KernelSize; y_k++ {
+						for x_k := 0; x_k < cl.KernelSize; x_k++ {
+							for f_i := start; f_i < end; f_i++ {
+								prevError[f_i][top+y_k][left+x_k] +=
+									cl.kernels[f][f_i][y_k][x_k] * error[f][y][x]
 							}
 						}
 					}
 				}
 			}
 		}
+	})
+}
+
+// backPropagateGEMM expresses the backward pass as two matrix products:
+// kernel gradients = delta * cols^T and input gradients = col2im(kernels^T * delta),
+// where delta is the error masked by the ReLU derivative
+func (cl *ConvLayer) backPropagateGEMM(error, prevError [][][]precision.Float) {
+	positions := cl.OutputSize * cl.OutputSize
+	patch := cl.InputDepth * cl.KernelSize * cl.KernelSize
+
+	b := &cl.buf
+	if !cl.haveCols {
+		b.cols = grow(b.cols, cl.colsSize())
+		b.unfold.im2col(cl.Input, cl.InputDepth, cl.KernelSize, cl.Stride, cl.OutputSize, cl.OutputSize, b.cols)
+		cl.haveCols = true
+	}
+
+	delta := grow(b.delta, cl.NumFilters*positions)
+	b.delta = delta
+	for f := 0; f < cl.NumFilters; f++ {
+		for i := 0; i < cl.OutputSize; i++ {
+			for j := 0; j < cl.OutputSize; j++ {
+				if cl.Output[f][i][j] > 0.0 {
+					delta[f*positions+i*cl.OutputSize+j] = error[f][i][j]
+					cl.BiasGrads[f] += error[f][i][j]
+				} else {
+					delta[f*positions+i*cl.OutputSize+j] = 0
+				}
+			}
+		}
 	}
 
-	cl.Kernels = newKernels
+	kernelGrads := zero(grow(b.grads, cl.NumFilters*patch))
+	b.grads = kernelGrads
+	b.gemm.sgemm(false, true, cl.NumFilters, patch, positions, delta, positions, b.cols, positions, kernelGrads, patch)
+	for f, filter := range cl.KernelGrads {
+		r := f * patch
+		for _, channel := range filter {
+			for _, row := range channel {
+				axpy(1, kernelGrads[r:r+cl.KernelSize], row)
+				r += cl.KernelSize
+			}
+		}
+	}
 
-	return prevError
+	colsError := zero(grow(b.colsError, patch*positions))
+	b.colsError = colsError
+	b.kernels = cl.packKernels(b.kernels, cl.kernels)
+	b.gemm.sgemm(true, false, patch, positions, cl.NumFilters, b.kernels, patch, delta, positions, colsError, positions)
+	b.unfold.col2im(colsError, cl.InputDepth, cl.KernelSize, cl.Stride, cl.OutputSize, cl.OutputSize, prevError)
+}
+
+// Params returns the kernels and biases of the ConvLayer with their gradients.
+// The slice is built once, and again when masks are added, and shared between calls.
+func (cl *ConvLayer) Params() []Param {
+	cl.allocGrads()
+	if cl.params == nil || (cl.params[0].Mask == nil) != (cl.KernelMask == nil) {
+		cl.params = []Param{
+			{Name: "kernels", Values: rows4D(cl.Kernels), Grads: rows4D(cl.KernelGrads), Mask: rows4D(cl.KernelMask)},
+			{Name: "biases", Values: [][]precision.Float{cl.Biases}, Grads: [][]precision.Float{cl.BiasGrads}, Mask: maskRows(cl.BiasMask), Bias: true},
+		}
+	}
+	return cl.params
+}
+
+// InitMasks gives the layer masks keeping all of its parameters, unless it
+// already has some
+func (cl *ConvLayer) InitMasks() {
+	if cl.KernelMask != nil {
+		return
+	}
+	cl.KernelMask = make4D[precision.Float](cl.NumFilters, cl.InputDepth, cl.KernelSize, cl.KernelSize)
+	cl.BiasMask = make([]precision.Float, cl.NumFilters)
+	fill(rows4D(cl.KernelMask), 1)
+	fill(maskRows(cl.BiasMask), 1)
+}
+
+// MaxNorm scales down every filter whose L2 norm exceeds limit to that norm
+func (cl *ConvLayer) MaxNorm(limit precision.Float) {
+	for _, filter := range cl.Kernels {
+		sum := precision.Float(0)
+		for _, channel := range filter {
+			for _, row := range channel {
+				sum += dot(row, row)
+			}
+		}
+		if norm := precision.Float(math.Sqrt(float64(sum))); norm > limit {
+			scale := limit / norm
+			for _, channel := range filter {
+				for _, row := range channel {
+					for i := range row {
+						row[i] *= scale
+					}
+				}
+			}
+		}
+	}
+}
+
+// allocGrads creates the gradient buffers, which are not part of saved models
+func (cl *ConvLayer) allocGrads() {
+	if cl.KernelGrads == nil {
+		cl.KernelGrads = make4D[precision.Float](cl.NumFilters, cl.InputDepth, cl.KernelSize, cl.KernelSize)
+		cl.BiasGrads = make([]precision.Float, cl.NumFilters)
+	}
 }
 
 // GetOutput returns the output value at the specified index
-func (cl *ConvLayer) GetOutput(index int) float32 {
+func (cl *ConvLayer) GetOutput(index int) precision.Float {
 	panic("Convolutional layers should not be accessed directly.")
 }
+
+// InputShape returns the depth, height and width of the expected input
+func (cl *ConvLayer) InputShape() []int {
+	return []int{cl.InputDepth, cl.InputSize, cl.InputSize}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (cl *ConvLayer) OutputShape() []int {
+	return []int{cl.NumFilters, cl.OutputSize, cl.OutputSize}
+}
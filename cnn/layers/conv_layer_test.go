@@ -0,0 +1,144 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func randomVolume(rng *rand.Rand, depth, size int) [][][]precision.Float {
+	x := make3D[precision.Float](depth, size, size)
+	for c := range x {
+		for y := range x[c] {
+			for i := range x[c][y] {
+				x[c][y][i] = precision.Float(rng.NormFloat64())
+			}
+		}
+	}
+	return x
+}
+
+func assertClose(t *testing.T, name string, got, expected []precision.Float) {
+	t.Helper()
+	for i := range expected {
+		if math.Abs(float64(got[i]-expected[i])) > 1e-4 {
+			t.Fatalf("%s differs at %d: got %f, expected %f", name, i, got[i], expected[i])
+		}
+	}
+}
+
+// checkGradients runs the shared gradient check on a layer and its params
+func checkGradients(t *testing.T, name string, check gradcheck.Check, l gradcheck.Layer, params []Param, input [][][]precision.Float) {
+	var checked []gradcheck.Param
+	for _, p := range params {
+		checked = append(checked, gradcheck.Param{Name: p.Name, Values: p.Values, Grads: p.Grads})
+	}
+	if err := check.Layer(l, checked, input); err != nil {
+		t.Errorf("%s: %v", name, err)
+	}
+}
+
+func flatten4D(x [][][][]precision.Float) []precision.Float {
+	var flat []precision.Float
+	for _, row := range rows4D(x) {
+		flat = append(flat, row...)
+	}
+	return flat
+}
+
+func TestConvGEMMMatchesNaive(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, tc := range []struct{ inputSize, inputDepth, numFilters, kernelSize, stride int }{
+		{28, 1, 6, 5, 1},
+		{12, 6, 9, 3, 1},
+		{11, 3, 4, 3, 2},
+	} {
+		naive := NewConvLayer(tc.inputSize, tc.inputDepth, tc.numFilters, tc.kernelSize, tc.stride)
+		naive.Algorithm = ConvNaive
+		naive.Initialize(HeNormal{}, rng)
+		gemm := NewConvLayer(tc.inputSize, tc.inputDepth, tc.numFilters, tc.kernelSize, tc.stride)
+		copy4D(gemm.Kernels, naive.Kernels)
+
+		input := randomVolume(rng, tc.inputDepth, tc.inputSize)
+		errors := randomVolume(rng, tc.numFilters, naive.OutputSize)
+
+		assertClose(t, "output", flatten(gemm.ForwardPropagate(input)), flatten(naive.ForwardPropagate(input)))
+		assertClose(t, "inferred output", flatten(gemm.Infer(input)), flatten(naive.Output))
+		assertClose(t, "previous error", flatten(gemm.BackPropagate(clone3D(errors))), flatten(naive.BackPropagate(clone3D(errors))))
+		assertClose(t, "kernel gradients", flatten4D(gemm.KernelGrads), flatten4D(naive.KernelGrads))
+		assertClose(t, "bias gradients", gemm.BiasGrads, naive.BiasGrads)
+	}
+}
+
+func TestInferResizes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	naive := NewConvLayer(6, 2, 3, 3, 2)
+	naive.Algorithm = ConvNaive
+	gemm := NewConvLayer(6, 2, 3, 3, 2)
+	copy4D(gemm.Kernels, naive.Kernels)
+
+	// The outputs of a larger input start with those of its top left corner
+	large := make3D[precision.Float](2, 11, 14)
+	for _, row := range rows3D(large) {
+		for i := range row {
+			row[i] = precision.Float(rng.NormFloat64())
+		}
+	}
+	corner := make3D[precision.Float](2, 6, 6)
+	for c := range corner {
+		for y := range corner[c] {
+			copy(corner[c][y], large[c][y])
+		}
+	}
+	for _, tc := range []struct {
+		name  string
+		layer interface {
+			Infer([][][]precision.Float) [][][]precision.Float
+			OutputShapeFor([]int) []int
+		}
+	}{
+		{"conv naive", naive},
+		{"conv gemm", gemm},
+		{"maxpool", NewMaxPoolingLayer(6, 2, 3, 1)},
+		{"avgpool", NewAvgPoolingLayer(6, 2, 2, 2)},
+	} {
+		output, expected := tc.layer.Infer(large), tc.layer.Infer(corner)
+		shape := tc.layer.OutputShapeFor([]int{2, 11, 14})
+		if len(output) != shape[0] || len(output[0]) != shape[1] || len(output[0][0]) != shape[2] {
+			t.Fatalf("%s: output of %dx%dx%d, expected %v", tc.name, len(output), len(output[0]), len(output[0][0]), shape)
+		}
+		for c := range expected {
+			for y := range expected[c] {
+				assertClose(t, tc.name, output[c][y][:len(expected[c][y])], expected[c][y])
+			}
+		}
+		if tc.layer.OutputShapeFor([]int{2, 1, 14}) != nil || tc.layer.OutputShapeFor([]int{3, 11, 14}) != nil {
+			t.Errorf("%s: accepted an input too small or of another depth", tc.name)
+		}
+	}
+}
+
+func benchmarkConv(b *testing.B, algorithm ConvAlgorithm, inputSize, inputDepth, numFilters, kernelSize int) {
+	rng := rand.New(rand.NewSource(1))
+	cl := NewConvLayer(inputSize, inputDepth, numFilters, kernelSize, 1)
+	cl.Algorithm = algorithm
+	cl.Initialize(HeNormal{}, rng)
+	input := randomVolume(rng, inputDepth, inputSize)
+	errors := randomVolume(rng, numFilters, cl.OutputSize)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cl.ForwardPropagate(input)
+		cl.BackPropagate(errors)
+	}
+}
+
+// The two convolutions of the MNIST example architecture
+func BenchmarkConv1Naive(b *testing.B) { benchmarkConv(b, ConvNaive, 28, 1, 6, 5) }
+func BenchmarkConv1GEMM(b *testing.B)  { benchmarkConv(b, ConvGEMM, 28, 1, 6, 5) }
+func BenchmarkConv2Naive(b *testing.B) { benchmarkConv(b, ConvNaive, 12, 6, 9, 3) }
+func BenchmarkConv2GEMM(b *testing.B)  { benchmarkConv(b, ConvGEMM, 12, 6, 9, 3) }
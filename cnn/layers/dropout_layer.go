@@ -0,0 +1,157 @@
+package layers
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// DropoutLayer zeroes every input value with probability Rate during
+// training and scales the others by 1/(1-Rate), so that the expected output
+// equals the input (inverted dropout). In inference mode, and in Infer, it
+// passes its input through. Masks are drawn from a generator seeded with
+// Seed, which makes training reproducible.
+type DropoutLayer struct {
+	Depth, Height, Width int
+	Rate                 precision.Float // Probability of dropping a value, in [0, 1)
+	Seed                 int64           // Seed of the masks, used from the first ForwardPropagate
+
+	Output    [][][]precision.Float `json:"-"`
+	PrevError [][][]precision.Float `json:"-"`
+
+	inference bool
+	rng       *rand.Rand
+	mask      [][][]precision.Float // Factor applied to every value by the last ForwardPropagate
+	last      [][][]precision.Float // Output of the last ForwardPropagate, the input in inference mode
+}
+
+// SpatialDropoutLayer drops whole channels instead of single values, which
+// suits convolution outputs whose neighbouring values are correlated
+type SpatialDropoutLayer struct {
+	DropoutLayer
+}
+
+// NewDropoutLayer creates a DropoutLayer for inputs of the given shape, with
+// Seed 1. CNN.AddDropoutLayer seeds it from the generator of the network.
+// NewDropoutLayer panics if rate is not in [0, 1).
+func NewDropoutLayer(depth, height, width int, rate precision.Float) *DropoutLayer {
+	if !ValidDropoutRate(rate) {
+		panic(fmt.Sprintf("dropout rate %v is not in [0, 1)", rate))
+	}
+	return &DropoutLayer{Depth: depth, Height: height, Width: width, Rate: rate, Seed: 1}
+}
+
+// ValidDropoutRate tells whether rate is in [0, 1), the rates a dropout
+// layer can scale its kept values for
+func ValidDropoutRate(rate precision.Float) bool {
+	return rate >= 0 && rate < 1
+}
+
+// NewSpatialDropoutLayer creates a SpatialDropoutLayer for inputs of the given shape
+func NewSpatialDropoutLayer(depth, height, width int, rate precision.Float) *SpatialDropoutLayer {
+	return &SpatialDropoutLayer{*NewDropoutLayer(depth, height, width, rate)}
+}
+
+// SetTraining enables dropout for training, or disables it for inference
+func (l *DropoutLayer) SetTraining(training bool) {
+	l.inference = !training
+}
+
+// Training tells whether the layer is in training mode
+func (l *DropoutLayer) Training() bool {
+	return !l.inference
+}
+
+// ForwardPropagate drops values of the input in training mode
+func (l *DropoutLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	return l.forward(input, false)
+}
+
+// ForwardPropagate drops channels of the input in training mode
+func (l *SpatialDropoutLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	return l.forward(input, true)
+}
+
+// forward draws a new mask, per value or per channel, and applies it
+func (l *DropoutLayer) forward(input [][][]precision.Float, spatial bool) [][][]precision.Float {
+	if l.inference {
+		l.last = input
+		return input
+	}
+	if l.Output == nil {
+		l.Output = make3D[precision.Float](l.Depth, l.Height, l.Width)
+		l.mask = make3D[precision.Float](l.Depth, l.Height, l.Width)
+		l.rng = rand.New(rand.NewSource(l.Seed))
+	}
+
+	keep := 1 / (1 - l.Rate)
+	for c := range input {
+		channel := keep
+		if spatial && precision.Float(l.rng.Float32()) < l.Rate {
+			channel = 0
+		}
+		for y := range input[c] {
+			for x, v := range input[c][y] {
+				m := channel
+				if !spatial && precision.Float(l.rng.Float32()) < l.Rate {
+					m = 0
+				}
+				l.mask[c][y][x] = m
+				l.Output[c][y][x] = v * m
+			}
+		}
+	}
+	l.last = l.Output
+	return l.Output
+}
+
+// Infer returns the input, dropout being disabled at inference
+func (l *DropoutLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	return input
+}
+
+// BackPropagate applies the mask of the last ForwardPropagate to the error
+func (l *DropoutLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	if l.inference {
+		return error
+	}
+	if l.PrevError == nil {
+		l.PrevError = make3D[precision.Float](l.Depth, l.Height, l.Width)
+	}
+	for c := range error {
+		for y := range error[c] {
+			for x, e := range error[c][y] {
+				l.PrevError[c][y][x] = e * l.mask[c][y][x]
+			}
+		}
+	}
+	return l.PrevError
+}
+
+// GetOutput returns the value at the specified index of the flattened
+// output of the last ForwardPropagate
+func (l *DropoutLayer) GetOutput(index int) precision.Float {
+	plane := l.Height * l.Width
+	return l.last[index/plane][index%plane/l.Width][index%l.Width]
+}
+
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, the input itself, or nil if the input has
+// another depth
+func (l *DropoutLayer) OutputShapeFor(input []int) []int {
+	if input[0] != l.Depth {
+		return nil
+	}
+	return input
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (l *DropoutLayer) InputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (l *DropoutLayer) OutputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
@@ -0,0 +1,115 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestDropout(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := randomVolume(rng, 4, 20)
+	l := NewDropoutLayer(4, 20, 20, 0.25)
+
+	output := clone3D(l.ForwardPropagate(input))
+	dropped := 0
+	for c := range output {
+		for y := range output[c] {
+			for x, v := range output[c][y] {
+				switch {
+				case v == 0:
+					dropped++
+				case math.Abs(float64(v-input[c][y][x]/0.75)) > 1e-6:
+					t.Fatalf("kept value %f, expected %f", v, input[c][y][x]/0.75)
+				}
+			}
+		}
+	}
+	if rate := float64(dropped) / 1600; math.Abs(rate-0.25) > 0.05 {
+		t.Errorf("dropped %.2f of the values, expected 0.25", rate)
+	}
+
+	// The error goes through the values kept by the forward pass
+	ones := make3D[precision.Float](4, 20, 20)
+	for c := range ones {
+		for y := range ones[c] {
+			for x := range ones[c][y] {
+				ones[c][y][x] = 1
+			}
+		}
+	}
+	prevError := l.BackPropagate(ones)
+	for c := range output {
+		for y := range output[c] {
+			for x, v := range output[c][y] {
+				if (v == 0) != (prevError[c][y][x] == 0) {
+					t.Fatalf("error %f for output %f", prevError[c][y][x], v)
+				}
+			}
+		}
+	}
+
+	// The same seed draws the same masks
+	if again := NewDropoutLayer(4, 20, 20, 0.25).ForwardPropagate(input); !reflect.DeepEqual(again, output) {
+		t.Error("dropout is not reproducible")
+	}
+
+	// GetOutput indexes the flattened output
+	for _, index := range []int{0, 37, 421, 1599} {
+		if got, expected := l.GetOutput(index), output[index/400][index%400/20][index%20]; got != expected {
+			t.Errorf("GetOutput(%d) = %f, expected %f", index, got, expected)
+		}
+	}
+
+	l.SetTraining(false)
+	if !reflect.DeepEqual(l.ForwardPropagate(input), input) || !reflect.DeepEqual(l.Infer(input), input) {
+		t.Error("inference mode changed the input")
+	}
+	if got := l.GetOutput(421); got != input[1][1][1] {
+		t.Errorf("GetOutput(421) = %f in inference mode, expected the input %f", got, input[1][1][1])
+	}
+}
+
+func TestDropoutRejectsInvalidRates(t *testing.T) {
+	for _, rate := range []precision.Float{-0.1, 1, 1.5} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewDropoutLayer accepted rate %v", rate)
+				}
+			}()
+			NewDropoutLayer(1, 2, 2, rate)
+		}()
+	}
+}
+
+func TestSpatialDropout(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := randomVolume(rng, 64, 3)
+	l := NewSpatialDropoutLayer(64, 3, 3, 0.5)
+
+	output := l.ForwardPropagate(input)
+	dropped := 0
+	for c := range output {
+		zeros := 0
+		for y := range output[c] {
+			for _, v := range output[c][y] {
+				if v == 0 {
+					zeros++
+				}
+			}
+		}
+		if zeros != 0 && zeros != 9 {
+			t.Fatalf("channel %d is partially dropped", c)
+		}
+		if zeros == 9 {
+			dropped++
+		}
+	}
+	if dropped == 0 || dropped == 64 {
+		t.Errorf("%d channels out of 64 dropped", dropped)
+	}
+}
@@ -0,0 +1,200 @@
+package layers
+
+import (
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// WeightQuant selects how the weights of a layer are fake-quantized during
+// training. Forward and backward passes then use the weights rounded to
+// int8, while gradients update the float weights: the rounding is treated
+// as the identity when differentiating (straight-through estimator).
+type WeightQuant int
+
+const (
+	WeightQuantNone       WeightQuant = iota // Train with the float weights
+	WeightQuantPerTensor                     // One int8 scale for the whole tensor
+	WeightQuantPerChannel                    // One int8 scale per filter or output neuron
+)
+
+// DefaultFakeQuantMomentum is the weight of the current range when a
+// FakeQuantLayer updates it with a new sample
+const DefaultFakeQuantMomentum = 0.99
+
+// FakeQuantLayer simulates the int8 quantization of activations: outputs
+// are rounded to one of 256 levels spanning [Min, Max]. During training the
+// range follows an exponential moving average of the observed extremes.
+// BackPropagate passes the error straight through for inputs within the
+// range and stops it for clipped inputs.
+type FakeQuantLayer struct {
+	Depth, Height, Width int
+	Min, Max             precision.Float
+	Momentum             precision.Float // Weight of the current range in the moving average
+	Initialized          bool            // Min and Max hold an observed range
+
+	Input     [][][]precision.Float `json:"-"`
+	Output    [][][]precision.Float `json:"-"`
+	PrevError [][][]precision.Float `json:"-"`
+}
+
+// NewFakeQuantLayer creates a FakeQuantLayer for volumes of the given shape
+func NewFakeQuantLayer(depth, height, width int) *FakeQuantLayer {
+	return &FakeQuantLayer{Depth: depth, Height: height, Width: width, Momentum: DefaultFakeQuantMomentum}
+}
+
+// Levels returns the scale and zero point of the int8 grid covering the
+// range, which always includes 0 so that zero padding and ReLU are exact
+func (l *FakeQuantLayer) Levels() (scale precision.Float, zeroPoint int32) {
+	return QuantLevels(l.Min, l.Max)
+}
+
+// QuantLevels returns the scale and zero point mapping the int8 values
+// [-128, 127] onto [min, max] extended to include 0
+func QuantLevels(min, max precision.Float) (scale precision.Float, zeroPoint int32) {
+	min = precision.Float(math.Min(float64(min), 0))
+	max = precision.Float(math.Max(float64(max), 0))
+	if max == min {
+		return 1, 0
+	}
+	scale = (max - min) / 255
+	return scale, int32(math.Round(float64(-128 - min/scale)))
+}
+
+// ForwardPropagate updates the range with the input and rounds it
+func (l *FakeQuantLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	l.Input = copy3D(l.Input, input)
+	l.observe(input)
+	if l.Output == nil {
+		l.Output = make3D[precision.Float](l.Depth, l.Height, l.Width)
+	}
+	l.forward(input, l.Output)
+	return l.Output
+}
+
+// Infer rounds the input with the current range, or passes it through
+// before the first ForwardPropagate. The input may have any height and width.
+func (l *FakeQuantLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](len(input), len(input[0]), len(input[0][0]))
+	l.forward(input, output)
+	return output
+}
+
+// observe moves the range towards the extremes of the input
+func (l *FakeQuantLayer) observe(input [][][]precision.Float) {
+	min, max := precision.Float(math.Inf(1)), precision.Float(math.Inf(-1))
+	for _, m := range input {
+		for _, row := range m {
+			for _, v := range row {
+				if v < min {
+					min = v
+				}
+				if v > max {
+					max = v
+				}
+			}
+		}
+	}
+
+	if !l.Initialized {
+		l.Min, l.Max, l.Initialized = min, max, true
+		return
+	}
+	l.Min = l.Momentum*l.Min + (1-l.Momentum)*min
+	l.Max = l.Momentum*l.Max + (1-l.Momentum)*max
+}
+
+// forward rounds the input into output. Until a range was observed, the
+// input is passed through unchanged.
+func (l *FakeQuantLayer) forward(input, output [][][]precision.Float) {
+	if !l.Initialized {
+		for c := range input {
+			for y := range input[c] {
+				copy(output[c][y], input[c][y])
+			}
+		}
+		return
+	}
+	scale, zeroPoint := l.Levels()
+	for c := range input {
+		for y := range input[c] {
+			for x, v := range input[c][y] {
+				q := precision.Float(math.Round(float64(v/scale))) + precision.Float(zeroPoint)
+				q = precision.Float(math.Max(-128, math.Min(127, float64(q))))
+				output[c][y][x] = (q - precision.Float(zeroPoint)) * scale
+			}
+		}
+	}
+}
+
+// BackPropagate passes the error of the inputs inside the range
+func (l *FakeQuantLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	if l.PrevError == nil {
+		l.PrevError = make3D[precision.Float](l.Depth, l.Height, l.Width)
+	}
+	scale, zeroPoint := l.Levels()
+	low, high := (-128-precision.Float(zeroPoint))*scale, (127-precision.Float(zeroPoint))*scale
+	for c := range error {
+		for y := range error[c] {
+			for x, e := range error[c][y] {
+				if v := l.Input[c][y][x]; v < low || v > high {
+					e = 0
+				}
+				l.PrevError[c][y][x] = e
+			}
+		}
+	}
+	return l.PrevError
+}
+
+// GetOutput returns the output value at the specified index
+func (l *FakeQuantLayer) GetOutput(index int) precision.Float {
+	panic("Fake quantization layers should not be accessed directly.")
+}
+
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, the input itself, or nil if the input has
+// another depth
+func (l *FakeQuantLayer) OutputShapeFor(input []int) []int {
+	if input[0] != l.Depth {
+		return nil
+	}
+	return input
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (l *FakeQuantLayer) InputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (l *FakeQuantLayer) OutputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// weightScales returns the int8 scale of every channel given the largest
+// absolute weight of each, or of all of them for WeightQuantPerTensor
+func weightScales(mode WeightQuant, maxAbs []precision.Float) []precision.Float {
+	if mode == WeightQuantPerTensor {
+		overall := precision.Float(0)
+		for _, v := range maxAbs {
+			overall = precision.Float(math.Max(float64(overall), float64(v)))
+		}
+		for i := range maxAbs {
+			maxAbs[i] = overall
+		}
+	}
+	for i, v := range maxAbs {
+		maxAbs[i] = v / 127
+		if v == 0 {
+			maxAbs[i] = 1
+		}
+	}
+	return maxAbs
+}
+
+// roundWeight rounds w to the closest of the levels -127*scale .. 127*scale
+func roundWeight(w, scale precision.Float) precision.Float {
+	q := math.Round(float64(w / scale))
+	return precision.Float(math.Max(-127, math.Min(127, q))) * scale
+}
@@ -0,0 +1,115 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestFakeQuantLayer(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := NewFakeQuantLayer(2, 5, 5)
+	input := randomVolume(rng, 2, 5)
+
+	// Without a range, values are passed through
+	assertClose(t, "uninitialized output", flatten(input), flatten(l.Infer(input)))
+	output := l.ForwardPropagate(input)
+
+	// The first batch sets the range, every output is one of its levels
+	scale, zeroPoint := l.Levels()
+	for c := range output {
+		for y := range output[c] {
+			for x, v := range output[c][y] {
+				q := float64(v/scale) + float64(zeroPoint)
+				if math.Abs(q-math.Round(q)) > 1e-3 || q < -128.5 || q > 127.5 {
+					t.Fatalf("output %f is not an int8 level", v)
+				}
+				if math.Abs(float64(v-input[c][y][x])) > float64(scale)/2+1e-6 {
+					t.Fatalf("output %f is too far from input %f", v, input[c][y][x])
+				}
+			}
+		}
+	}
+
+	// Inputs outside the range stop the error
+	l.Min, l.Max, l.Momentum = -0.5, 0.5, 1
+	l.ForwardPropagate(input)
+	errors := randomVolume(rng, 2, 5)
+	prevError := l.BackPropagate(errors)
+	for c := range input {
+		for y := range input[c] {
+			for x, v := range input[c][y] {
+				clipped := v < -0.51 || v > 0.51
+				if clipped && prevError[c][y][x] != 0 || !clipped && math.Abs(float64(v)) < 0.49 && prevError[c][y][x] != errors[c][y][x] {
+					t.Fatalf("error %f for input %f", prevError[c][y][x], v)
+				}
+			}
+		}
+	}
+}
+
+func TestWeightFakeQuantization(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, mode := range []WeightQuant{WeightQuantPerTensor, WeightQuantPerChannel} {
+		conv := NewConvLayer(8, 2, 3, 3, 1)
+		conv.Initialize(HeNormal{}, rng)
+		conv.WeightQuant = mode
+		input := randomVolume(rng, 2, 8)
+		output := clone3D(conv.ForwardPropagate(input))
+
+		// The fake-quantized layer computes what the float one does with
+		// the rounded kernels, and keeps training the float kernels
+		rounded := NewConvLayer(8, 2, 3, 3, 1)
+		copy4D(rounded.Kernels, conv.quantKernels)
+		assertClose(t, "output", flatten(output), flatten(rounded.ForwardPropagate(input)))
+		assertClose(t, "inferred output", flatten(output), flatten(conv.Infer(input)))
+		if equal4D(conv.Kernels, conv.quantKernels) {
+			t.Fatalf("%d: kernels were rounded in place", mode)
+		}
+		for f, filter := range conv.quantKernels {
+			levels := map[precision.Float]bool{}
+			for _, row := range rows4D([][][][]precision.Float{filter}) {
+				for _, w := range row {
+					levels[w] = true
+				}
+			}
+			if len(levels) > 255 {
+				t.Fatalf("%d: filter %d uses %d levels", mode, f, len(levels))
+			}
+		}
+
+		fc := NewFullyConnectedLayer(4, 3, 5)
+		fc.Initialize(HeNormal{}, rng)
+		fc.WeightQuant = mode
+		fcInput := randomVolume(rng, 3, 4)
+		fcOutput := append([]precision.Float(nil), fc.ForwardPropagate(fcInput)[0][0]...)
+		roundedFC := NewFullyConnectedLayer(4, 3, 5)
+		for i := range roundedFC.Weights {
+			copy(roundedFC.Weights[i], fc.quantWeights[i])
+		}
+		assertClose(t, "fc output", fcOutput, roundedFC.Infer(fcInput)[0][0])
+		assertClose(t, "inferred fc output", fcOutput, fc.Infer(fcInput)[0][0])
+	}
+}
+
+func copy4D(dst, src [][][][]precision.Float) {
+	srcRows := rows4D(src)
+	for i, row := range rows4D(dst) {
+		copy(row, srcRows[i])
+	}
+}
+
+func equal4D(a, b [][][][]precision.Float) bool {
+	bRows := rows4D(b)
+	for i, row := range rows4D(a) {
+		for j := range row {
+			if row[j] != bRows[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -3,15 +3,17 @@ package layers
 import (
 	"math"
 	"math/rand"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
 )
 
 // Sigmoid activation function
-func sigmoid(x float32) float32 {
-	return 1.0 / (1.0 + float32(math.Exp(-float64(x))))
+func sigmoid(x precision.Float) precision.Float {
+	return 1.0 / (1.0 + precision.Float(math.Exp(-float64(x))))
 }
 
 // Inverse derivative of the sigmoid function
-func invDerivSigmoid(x float32) float32 {
+func invDerivSigmoid(x precision.Float) precision.Float {
 	return x * (1.0 - x)
 }
 
@@ -21,30 +23,54 @@ type FullyConnectedLayer struct {
 	InputWidth int
 	InputDepth int
 	OutputSize int
-	Weights    [][]float32
-	Biases     []float32
-	Input      []float32
-	Output     []float32
+	Weights    [][]precision.Float
+	Biases     []precision.Float
+	Input      []precision.Float
+	Output     []precision.Float
+
+	WeightGrads [][]precision.Float `json:"-"` // Gradients accumulated by BackPropagate
+	BiasGrads   []precision.Float   `json:"-"`
+	WeightMask  [][]precision.Float `json:",omitempty"` // Pruning masks, see Param.Mask (optional)
+	BiasMask    []precision.Float   `json:",omitempty"`
+	WeightQuant WeightQuant         `json:"-"` // Fake quantization of the weights during training
+
+	// Buffers of training, reused between steps
+	output       [][][]precision.Float
+	flatError    []precision.Float
+	prevError    [][][]precision.Float
+	forwardTask  fcForwardTask
+	backwardTask fcBackwardTask
+	params       []Param
+	quantWeights [][]precision.Float // Weights rounded to int8 levels, see WeightQuant
+	quantScales  []precision.Float
+	weights      [][]precision.Float // Weights used by the last ForwardPropagate
 }
 
-// NewFullyConnectedLayer creates a new FullyConnectedLayer object with the specified parameters
-func NewFullyConnectedLayer(inputWidth, inputDepth, outputSize int) *FullyConnectedLayer {
-	inputSize := inputDepth * (inputWidth * inputWidth)
-	biases := make([]float32, outputSize)
-	weights := make([][]float32, inputSize)
+// fcForwardTask computes some outputs of the layer
+type fcForwardTask struct {
+	fcl           *FullyConnectedLayer
+	weights       [][]precision.Float
+	input, output []precision.Float
+}
 
-	// Use He initialization with a mean of 0.0 and standard deviation of sqrt(2 / input_neurons)
-	normal := rand.New(rand.NewSource(42))
-	standardDeviation := float32(math.Sqrt(2.0 / float64(inputSize*inputDepth)))
+// fcBackwardTask accumulates the gradients of some inputs of the layer
+type fcBackwardTask struct {
+	fcl       *FullyConnectedLayer
+	errorData []precision.Float
+}
 
+// NewFullyConnectedLayer creates a new FullyConnectedLayer object with the specified parameters.
+// Its weights are drawn with He initialization from a package generator;
+// CNN.AddFullyConnectedLayer fills them again from the generator of the network.
+func NewFullyConnectedLayer(inputWidth, inputDepth, outputSize int) *FullyConnectedLayer {
+	inputSize := inputDepth * (inputWidth * inputWidth)
+	biases := make([]precision.Float, outputSize)
+	weights := make([][]precision.Float, inputSize)
 	for i := range weights {
-		weights[i] = make([]float32, outputSize)
-		for j := range weights[i] {
-			weights[i][j] = float32(normal.NormFloat64()) * standardDeviation
-		}
+		weights[i] = make([]precision.Float, outputSize)
 	}
 
-	return &FullyConnectedLayer{
+	fcl := &FullyConnectedLayer{
 		InputSize:  inputSize,
 		InputWidth: inputWidth,
 		InputDepth: inputDepth,
@@ -52,13 +78,36 @@ func NewFullyConnectedLayer(inputWidth, inputDepth, outputSize int) *FullyConnec
 		Weights:    weights,
 		Biases:     biases,
 		Input:      nil,
-		Output:     make([]float32, outputSize),
+		Output:     make([]precision.Float, outputSize),
+	}
+
+	// Use He initialization with a mean of 0.0 and standard deviation of sqrt(2 / input_neurons)
+	fcl.Initialize(HeNormal{}, defaultRand)
+
+	return fcl
+}
+
+// Initialize fills the weights with init, drawing from rng. Every output is
+// a unit with InputSize inputs. Biases are left unchanged.
+func (fcl *FullyConnectedLayer) Initialize(init Initializer, rng *rand.Rand) {
+	weights := make([]precision.Float, fcl.OutputSize*fcl.InputSize)
+	init.Init(rng, weights, fcl.InputSize, fcl.OutputSize)
+	// Weights are stored input by input
+	for i, row := range fcl.Weights {
+		for j := range row {
+			row[j] = weights[j*fcl.InputSize+i]
+		}
 	}
 }
 
 // Flatten a 3D vector into a 1D vector
-func flatten(squares [][][]float32) []float32 {
-	flatData := make([]float32, 0)
+func flatten(squares [][][]precision.Float) []precision.Float {
+	return flattenInto(nil, squares)
+}
+
+// flattenInto flattens a 3D vector into dst, reusing its capacity
+func flattenInto(dst []precision.Float, squares [][][]precision.Float) []precision.Float {
+	flatData := dst[:0]
 
 	for _, square := range squares {
 		for _, row := range square {
@@ -69,67 +118,217 @@ func flatten(squares [][][]float32) []float32 {
 	return flatData
 }
 
-// ForwardPropagate performs forward propagation through the FullyConnectedLayer
-func (fcl *FullyConnectedLayer) ForwardPropagate(matrixInput [][][]float32) [][][]float32 {
-	// Flatten the input matrix into a 1D vector
-	input := flatten(matrixInput)
-	// Store the input for backpropagation
-	fcl.Input = input
-	for j := 0; j < fcl.OutputSize; j++ {
-		// Calculate the weighted sum of the inputs
-		fcl.Output[j] = fcl.Biases[j]
-		for i := 0; i < fcl.InputSize; i++ {
-			fcl.Output[j] += input[i] * fcl.Weights[i][j]
-		}
-		// Apply the sigmoid activation function to the output
-		fcl.Output[j] = sigmoid(fcl.Output[j])
+// ForwardPropagate performs forward propagation through the FullyConnectedLayer.
+// The returned vector is the Output of the layer, overwritten by the next call.
+func (fcl *FullyConnectedLayer) ForwardPropagate(matrixInput [][][]precision.Float) [][][]precision.Float {
+	// Flatten the input matrix into a 1D vector, stored for backpropagation
+	fcl.Input = flattenInto(fcl.Input, matrixInput)
+	fcl.weights = fcl.Weights
+	if fcl.WeightQuant != WeightQuantNone {
+		fcl.weights = fcl.fakeQuantWeights()
 	}
+	fcl.forwardTask = fcForwardTask{fcl: fcl, weights: fcl.weights, input: fcl.Input, output: fcl.Output}
+	fcl.forward(&fcl.forwardTask)
+	fcl.forwardTask = fcForwardTask{}
 
 	// Format the output to be a 3D vector
-	formattedOutput := [][][]float32{{fcl.Output}}
-	return formattedOutput
+	if fcl.output == nil {
+		fcl.output = [][][]precision.Float{{fcl.Output}}
+	}
+	return fcl.output
+}
+
+// Infer computes the output of the FullyConnectedLayer without storing
+// anything for backpropagation, so it can be called concurrently
+func (fcl *FullyConnectedLayer) Infer(matrixInput [][][]precision.Float) [][][]precision.Float {
+	output := make([]precision.Float, fcl.OutputSize)
+	weights := fcl.Weights
+	if fcl.WeightQuant != WeightQuantNone {
+		weights = make2D[precision.Float](fcl.InputSize, fcl.OutputSize)
+		fcl.roundWeights(weights, make([]precision.Float, fcl.OutputSize))
+	}
+	fcl.forward(&fcForwardTask{fcl: fcl, weights: weights, input: flatten(matrixInput), output: output})
+
+	return [][][]precision.Float{{output}}
+}
+
+// forward computes the sigmoid activations of the flat input into output,
+// splitting the output neurons between the workers
+func (fcl *FullyConnectedLayer) forward(t *fcForwardTask) {
+	parallelRun(fcl.OutputSize, 1+parallelGrain/fcl.InputSize, t)
+}
+
+// fakeQuantWeights returns the weights rounded to int8 levels, in buffers
+// of the layer
+func (fcl *FullyConnectedLayer) fakeQuantWeights() [][]precision.Float {
+	if fcl.quantWeights == nil {
+		fcl.quantWeights = make2D[precision.Float](fcl.InputSize, fcl.OutputSize)
+	}
+	fcl.quantScales = grow(fcl.quantScales, fcl.OutputSize)
+	fcl.roundWeights(fcl.quantWeights, fcl.quantScales)
+	return fcl.quantWeights
+}
+
+// roundWeights writes the weights rounded to int8 levels into dst, with one
+// scale per output neuron for WeightQuantPerChannel, and the scale of every
+// output neuron into scales
+func (fcl *FullyConnectedLayer) roundWeights(dst [][]precision.Float, scales []precision.Float) {
+	for j := range scales {
+		scales[j] = 0
+	}
+	for _, row := range fcl.Weights {
+		for j, w := range row {
+			scales[j] = precision.Float(math.Max(float64(scales[j]), math.Abs(float64(w))))
+		}
+	}
+	weightScales(fcl.WeightQuant, scales)
+
+	for i, row := range fcl.Weights {
+		for j, w := range row {
+			dst[i][j] = roundWeight(w, scales[j])
+		}
+	}
+}
+
+// run computes the outputs [start, end)
+func (t *fcForwardTask) run(start, end int) {
+	fcl, input := t.fcl, t.input
+
+	// Calculate the weighted sum of the inputs, one row of weights at a time
+	out := t.output[start:end]
+	copy(out, fcl.Biases[start:end])
+	for i := 0; i < fcl.InputSize; i++ {
+		if input[i] != 0 {
+			axpy(input[i], t.weights[i][start:end], out)
+		}
+	}
+	// Apply the sigmoid activation function to the output
+	for j := range out {
+		out[j] = sigmoid(out[j])
+	}
 }
 
-// BackPropagate performs backpropagation through the FullyConnectedLayer
-func (fcl *FullyConnectedLayer) BackPropagate(matrixError [][][]float32) [][][]float32 {
+// BackPropagate performs backpropagation through the FullyConnectedLayer.
+// Gradients are accumulated into WeightGrads and BiasGrads, the parameters
+// themselves are left to the optimizer. The returned error is overwritten
+// by the next call.
+func (fcl *FullyConnectedLayer) BackPropagate(matrixError [][][]precision.Float) [][][]precision.Float {
+	fcl.allocGrads()
+	if fcl.weights == nil {
+		fcl.weights = fcl.Weights
+	}
+
 	// Flatten the error matrix into a 1D vector
 	errorData := matrixError[0][0]
 	for j := 0; j < fcl.OutputSize; j++ {
 		errorData[j] *= invDerivSigmoid(fcl.Output[j])
 	}
 
-	flatError := make([]float32, fcl.InputSize)
+	if fcl.flatError == nil {
+		fcl.flatError = make([]precision.Float, fcl.InputSize)
+		fcl.prevError = make3D[precision.Float](fcl.InputDepth, fcl.InputWidth, fcl.InputWidth)
+	}
 
-	// Update the weights and biases according to their derivatives
+	// Accumulate the derivatives of the weights and biases.
+	// Inputs are split between the workers, each one owning its rows of
+	// WeightGrads and its entries of flatError.
 	for j := 0; j < fcl.OutputSize; j++ {
-		fcl.Biases[j] -= errorData[j] * learningRate
-		for i := 0; i < fcl.InputSize; i++ {
-			flatError[i] += errorData[j] * fcl.Weights[i][j]
-			fcl.Weights[i][j] -= errorData[j] * fcl.Input[i] * learningRate
-		}
+		fcl.BiasGrads[j] += errorData[j]
 	}
+	fcl.backwardTask = fcBackwardTask{fcl: fcl, errorData: errorData}
+	parallelRun(fcl.InputSize, 1+parallelGrain/fcl.OutputSize, &fcl.backwardTask)
+	fcl.backwardTask = fcBackwardTask{}
 
 	// Format the error to be a 3D vector
-	prevError := make([][][]float32, fcl.InputDepth)
+	prevError := fcl.prevError
 	for i := 0; i < fcl.InputDepth; i++ {
-		prevError[i] = make([][]float32, fcl.InputWidth)
 		for j := 0; j < fcl.InputWidth; j++ {
-			prevError[i][j] = make([]float32, fcl.InputWidth)
-			for k := 0; k < fcl.InputWidth; k++ {
-				index := i*fcl.InputWidth*fcl.InputWidth + j*fcl.InputWidth + k
-				prevError[i][j][k] = flatError[index]
-			}
+			index := i*fcl.InputWidth*fcl.InputWidth + j*fcl.InputWidth
+			copy(prevError[i][j], fcl.flatError[index:index+fcl.InputWidth])
 		}
 	}
 
 	return prevError
 }
 
+// run computes the error of the inputs [start, end) and accumulates the
+// gradients of their weights
+func (t *fcBackwardTask) run(start, end int) {
+	fcl := t.fcl
+	for i := start; i < end; i++ {
+		fcl.flatError[i] = dot(t.errorData, fcl.weights[i])
+		axpy(fcl.Input[i], t.errorData, fcl.WeightGrads[i])
+	}
+}
+
+// Params returns the weights and biases of the FullyConnectedLayer with their gradients.
+// The slice is built once, and again when masks are added, and shared between calls.
+func (fcl *FullyConnectedLayer) Params() []Param {
+	fcl.allocGrads()
+	if fcl.params == nil || (fcl.params[0].Mask == nil) != (fcl.WeightMask == nil) {
+		fcl.params = []Param{
+			{Name: "weights", Values: fcl.Weights, Grads: fcl.WeightGrads, Mask: fcl.WeightMask},
+			{Name: "biases", Values: [][]precision.Float{fcl.Biases}, Grads: [][]precision.Float{fcl.BiasGrads}, Mask: maskRows(fcl.BiasMask), Bias: true},
+		}
+	}
+	return fcl.params
+}
+
+// InitMasks gives the layer masks keeping all of its parameters, unless it
+// already has some
+func (fcl *FullyConnectedLayer) InitMasks() {
+	if fcl.WeightMask != nil {
+		return
+	}
+	fcl.WeightMask = make2D[precision.Float](fcl.InputSize, fcl.OutputSize)
+	fcl.BiasMask = make([]precision.Float, fcl.OutputSize)
+	fill(fcl.WeightMask, 1)
+	fill(maskRows(fcl.BiasMask), 1)
+}
+
+// MaxNorm scales down the incoming weights of every output whose L2 norm
+// exceeds limit to that norm
+func (fcl *FullyConnectedLayer) MaxNorm(limit precision.Float) {
+	for j := 0; j < fcl.OutputSize; j++ {
+		sum := precision.Float(0)
+		for _, row := range fcl.Weights {
+			sum += row[j] * row[j]
+		}
+		if norm := precision.Float(math.Sqrt(float64(sum))); norm > limit {
+			scale := limit / norm
+			for _, row := range fcl.Weights {
+				row[j] *= scale
+			}
+		}
+	}
+}
+
+// allocGrads creates the gradient buffers, which are not part of saved models
+func (fcl *FullyConnectedLayer) allocGrads() {
+	if fcl.WeightGrads == nil {
+		fcl.WeightGrads = make([][]precision.Float, fcl.InputSize)
+		for i := range fcl.WeightGrads {
+			fcl.WeightGrads[i] = make([]precision.Float, fcl.OutputSize)
+		}
+		fcl.BiasGrads = make([]precision.Float, fcl.OutputSize)
+	}
+}
+
 // GetOutput returns the output value at the specified index
-func (fcl *FullyConnectedLayer) GetOutput(index int) float32 {
+func (fcl *FullyConnectedLayer) GetOutput(index int) precision.Float {
 	return fcl.Output[index]
 }
 
+// InputShape returns the depth, height and width of the expected input
+func (fcl *FullyConnectedLayer) InputShape() []int {
+	return []int{fcl.InputDepth, fcl.InputWidth, fcl.InputWidth}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (fcl *FullyConnectedLayer) OutputShape() []int {
+	return []int{1, 1, fcl.OutputSize}
+}
+
 /*
 
 func main() {
@@ -0,0 +1,132 @@
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// Block sizes of the matrix multiplication. A blockK x blockN panel of B
+// (128 KiB) stays in L2 while rows of A and C stream through L1.
+const (
+	blockM = 64
+	blockN = 256
+	blockK = 128
+)
+
+// parallelGrain is the approximate number of multiply-adds below which
+// splitting work between goroutines costs more than it saves
+const parallelGrain = 1 << 14
+
+// sgemm computes C += op(A) * op(B) for row-major matrices, where
+// op(X) is X or its transpose. op(A) is m x k, op(B) is k x n and C is m x n.
+// lda, ldb and ldc are the row strides of the stored matrices.
+func sgemm(transA, transB bool, m, n, k int, a []precision.Float, lda int, b []precision.Float, ldb int, c []precision.Float, ldc int) {
+	new(gemm).sgemm(transA, transB, m, n, k, a, lda, b, ldb, c, ldc)
+}
+
+// gemm computes matrix products. It keeps the packed transposed operands
+// and its parallel task between calls, so that layers repeating products
+// of the same size on every training step do not allocate.
+type gemm struct {
+	packA, packB []precision.Float
+	task         gemmTask
+}
+
+// gemmTask is one parallel matrix product, split over the rows or the
+// columns of C
+type gemmTask struct {
+	m, n, k int
+	a       []precision.Float
+	lda     int
+	b       []precision.Float
+	ldb     int
+	c       []precision.Float
+	ldc     int
+	splitN  bool
+}
+
+func (t *gemmTask) run(start, end int) {
+	if t.splitN {
+		sgemmNN(t.m, end-start, t.k, t.a, t.lda, t.b[start:], t.ldb, t.c[start:], t.ldc)
+	} else {
+		sgemmNN(end-start, t.n, t.k, t.a[start*t.lda:], t.lda, t.b, t.ldb, t.c[start*t.ldc:], t.ldc)
+	}
+}
+
+// sgemm is the package level sgemm using the buffers of g
+func (g *gemm) sgemm(transA, transB bool, m, n, k int, a []precision.Float, lda int, b []precision.Float, ldb int, c []precision.Float, ldc int) {
+	if m == 0 || n == 0 || k == 0 {
+		return
+	}
+
+	// Transposed operands are packed once so that the kernel always reads
+	// contiguous rows
+	if transA {
+		g.packA = transpose(g.packA, a, k, m, lda)
+		a, lda = g.packA, k
+	}
+	if transB {
+		g.packB = transpose(g.packB, b, n, k, ldb)
+		b, ldb = g.packB, n
+	}
+
+	// Split the larger dimension of C between the workers. Each element of C
+	// still accumulates its products in the same order, so the result does
+	// not depend on the number of workers.
+	g.task = gemmTask{m: m, n: n, k: k, a: a, lda: lda, b: b, ldb: ldb, c: c, ldc: ldc, splitN: n >= m}
+	grain := 1 + parallelGrain/(k*minInt(m, n))
+	if g.task.splitN {
+		parallelRun(n, grain, &g.task)
+	} else {
+		parallelRun(m, grain, &g.task)
+	}
+	g.task = gemmTask{}
+}
+
+// sgemmNN computes C += A * B with cache blocking. The innermost operation
+// adds a scaled row of B to a row of C, which keeps every access sequential.
+func sgemmNN(m, n, k int, a []precision.Float, lda int, b []precision.Float, ldb int, c []precision.Float, ldc int) {
+	for i0 := 0; i0 < m; i0 += blockM {
+		iMax := minInt(i0+blockM, m)
+		for p0 := 0; p0 < k; p0 += blockK {
+			pMax := minInt(p0+blockK, k)
+			for j0 := 0; j0 < n; j0 += blockN {
+				jMax := minInt(j0+blockN, n)
+
+				for i := i0; i < iMax; i++ {
+					cRow := c[i*ldc+j0 : i*ldc+jMax]
+					aRow := a[i*lda : i*lda+k]
+					for p := p0; p < pMax; p++ {
+						if s := aRow[p]; s != 0 {
+							axpy(s, b[p*ldb+j0:p*ldb+jMax], cRow)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// transpose writes the cols x rows transpose of a rows x cols matrix with
+// row stride ld into dst, which is grown if needed, and returns it
+func transpose(dst, x []precision.Float, rows, cols, ld int) []precision.Float {
+	t := grow(dst, rows*cols)
+	for r := 0; r < rows; r++ {
+		for c := 0; c < cols; c++ {
+			t[c*rows+r] = x[r*ld+c]
+		}
+	}
+	return t
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// grow returns x resized to n elements, reallocating only when its capacity is too small
+func grow(x []precision.Float, n int) []precision.Float {
+	if cap(x) < n {
+		return make([]precision.Float, n)
+	}
+	return x[:n]
+}
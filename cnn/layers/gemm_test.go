@@ -0,0 +1,65 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func randomSlice(rng *rand.Rand, n int) []precision.Float {
+	x := make([]precision.Float, n)
+	for i := range x {
+		x[i] = precision.Float(rng.NormFloat64())
+	}
+	return x
+}
+
+func TestSgemm(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Sizes chosen to cross the block boundaries
+	m, n, k := 70, 300, 150
+	for _, tc := range []struct{ transA, transB bool }{{false, false}, {true, false}, {false, true}, {true, true}} {
+		a := randomSlice(rng, m*k)
+		b := randomSlice(rng, k*n)
+		c := randomSlice(rng, m*n)
+
+		lda, ldb := k, n
+		if tc.transA {
+			lda = m
+		}
+		if tc.transB {
+			ldb = k
+		}
+		at := func(i, p int) precision.Float {
+			if tc.transA {
+				return a[p*lda+i]
+			}
+			return a[i*lda+p]
+		}
+		bt := func(p, j int) precision.Float {
+			if tc.transB {
+				return b[j*ldb+p]
+			}
+			return b[p*ldb+j]
+		}
+
+		expected := append([]precision.Float(nil), c...)
+		for i := 0; i < m; i++ {
+			for j := 0; j < n; j++ {
+				for p := 0; p < k; p++ {
+					expected[i*n+j] += at(i, p) * bt(p, j)
+				}
+			}
+		}
+
+		sgemm(tc.transA, tc.transB, m, n, k, a, lda, b, ldb, c, n)
+		for i := range c {
+			if math.Abs(float64(c[i]-expected[i])) > 1e-3 {
+				t.Fatalf("sgemm(transA=%v, transB=%v) differs at %d: got %f, expected %f", tc.transA, tc.transB, i, c[i], expected[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// GlobalAvgPoolingLayer averages every channel of its input into a single
+// value, producing a Depth x 1 x 1 output. Height and Width are the expected
+// spatial size of the input, but any size is accepted, which lets fully
+// convolutional networks classify inputs of different sizes.
+type GlobalAvgPoolingLayer struct {
+	Depth, Height, Width int
+
+	Output    [][][]precision.Float `json:"-"`
+	PrevError [][][]precision.Float `json:"-"`
+
+	inputHeight, inputWidth int // Spatial size of the last ForwardPropagate input
+}
+
+// GlobalMaxPoolingLayer keeps the highest value of every channel of its
+// input, producing a Depth x 1 x 1 output. Like GlobalAvgPoolingLayer it
+// accepts inputs of any spatial size.
+type GlobalMaxPoolingLayer struct {
+	Depth, Height, Width int
+
+	Output    [][][]precision.Float `json:"-"`
+	PrevError [][][]precision.Float `json:"-"`
+
+	highest [][2]int // Position of the highest value of every channel
+}
+
+// NewGlobalAvgPoolingLayer creates a GlobalAvgPoolingLayer for inputs of the given shape
+func NewGlobalAvgPoolingLayer(depth, height, width int) *GlobalAvgPoolingLayer {
+	return &GlobalAvgPoolingLayer{Depth: depth, Height: height, Width: width}
+}
+
+// NewGlobalMaxPoolingLayer creates a GlobalMaxPoolingLayer for inputs of the given shape
+func NewGlobalMaxPoolingLayer(depth, height, width int) *GlobalMaxPoolingLayer {
+	return &GlobalMaxPoolingLayer{Depth: depth, Height: height, Width: width}
+}
+
+// ForwardPropagate averages every channel of the input
+func (l *GlobalAvgPoolingLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	if l.Output == nil {
+		l.Output = make3D[precision.Float](l.Depth, 1, 1)
+	}
+	l.inputHeight, l.inputWidth = len(input[0]), len(input[0][0])
+	globalAverage(input, l.Output)
+	return l.Output
+}
+
+// Infer averages every channel of the input without storing anything
+func (l *GlobalAvgPoolingLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](l.Depth, 1, 1)
+	globalAverage(input, output)
+	return output
+}
+
+// globalAverage writes the average of every channel of input into output
+func globalAverage(input, output [][][]precision.Float) {
+	for c := range input {
+		sum := precision.Float(0)
+		for _, row := range input[c] {
+			for _, v := range row {
+				sum += v
+			}
+		}
+		output[c][0][0] = sum / precision.Float(len(input[c])*len(input[c][0]))
+	}
+}
+
+// BackPropagate spreads the error of every channel evenly over the input
+func (l *GlobalAvgPoolingLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	l.PrevError = resize3D(l.PrevError, l.Depth, l.inputHeight, l.inputWidth)
+	scale := 1 / precision.Float(l.inputHeight*l.inputWidth)
+	for c := range l.PrevError {
+		e := error[c][0][0] * scale
+		for _, row := range l.PrevError[c] {
+			for x := range row {
+				row[x] = e
+			}
+		}
+	}
+	return l.PrevError
+}
+
+// GetOutput returns the output value of channel index
+func (l *GlobalAvgPoolingLayer) GetOutput(index int) precision.Float {
+	return l.Output[index][0][0]
+}
+
+// OutputShapeFor returns the shape of the output for an input of the given
+// shape, or nil if the input has another depth
+func (l *GlobalAvgPoolingLayer) OutputShapeFor(input []int) []int {
+	return globalShape(input, l.Depth)
+}
+
+// globalShape returns the output shape of a global pooling layer for an
+// input shape, or nil if the input does not fit
+func globalShape(input []int, depth int) []int {
+	if input[0] != depth || input[1] < 1 || input[2] < 1 {
+		return nil
+	}
+	return []int{depth, 1, 1}
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (l *GlobalAvgPoolingLayer) InputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (l *GlobalAvgPoolingLayer) OutputShape() []int {
+	return []int{l.Depth, 1, 1}
+}
+
+// ForwardPropagate keeps the highest value of every channel of the input
+func (l *GlobalMaxPoolingLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	if l.Output == nil {
+		l.Output = make3D[precision.Float](l.Depth, 1, 1)
+		l.highest = make([][2]int, l.Depth)
+	}
+	l.PrevError = resize3D(l.PrevError, l.Depth, len(input[0]), len(input[0][0]))
+	globalMax(input, l.Output, l.highest)
+	return l.Output
+}
+
+// Infer keeps the highest value of every channel of the input without
+// storing anything
+func (l *GlobalMaxPoolingLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](l.Depth, 1, 1)
+	globalMax(input, output, nil)
+	return output
+}
+
+// globalMax writes the highest value of every channel of input into output
+// and, unless highest is nil, its position into highest
+func globalMax(input, output [][][]precision.Float, highest [][2]int) {
+	for c := range input {
+		best, pos := input[c][0][0], [2]int{}
+		for y, row := range input[c] {
+			for x, v := range row {
+				if v > best {
+					best, pos = v, [2]int{y, x}
+				}
+			}
+		}
+		output[c][0][0] = best
+		if highest != nil {
+			highest[c] = pos
+		}
+	}
+}
+
+// BackPropagate routes the error of every channel to its highest value
+func (l *GlobalMaxPoolingLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	zero3D(l.PrevError)
+	for c, pos := range l.highest {
+		l.PrevError[c][pos[0]][pos[1]] = error[c][0][0]
+	}
+	return l.PrevError
+}
+
+// GetOutput returns the output value of channel index
+func (l *GlobalMaxPoolingLayer) GetOutput(index int) precision.Float {
+	return l.Output[index][0][0]
+}
+
+// OutputShapeFor returns the shape of the output for an input of the given
+// shape, or nil if the input has another depth
+func (l *GlobalMaxPoolingLayer) OutputShapeFor(input []int) []int {
+	return globalShape(input, l.Depth)
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (l *GlobalMaxPoolingLayer) InputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (l *GlobalMaxPoolingLayer) OutputShape() []int {
+	return []int{l.Depth, 1, 1}
+}
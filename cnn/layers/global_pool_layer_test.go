@@ -0,0 +1,47 @@
+package layers
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestGlobalPooling(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	input := randomVolume(rng, 3, 5)
+	input[1][3][2] = 10
+
+	avg := NewGlobalAvgPoolingLayer(3, 5, 5)
+	maxPool := NewGlobalMaxPoolingLayer(3, 5, 5)
+	if output := avg.ForwardPropagate(input); len(output) != 3 || len(output[0]) != 1 || len(output[0][0]) != 1 {
+		t.Fatalf("output shape %dx%dx%d, expected 3x1x1", len(output), len(output[0]), len(output[0][0]))
+	}
+	assertClose(t, "average", []precision.Float{avg.GetOutput(0)}, []precision.Float{sum(flatten(input[:1])) / 25})
+	if maxPool.ForwardPropagate(input); maxPool.GetOutput(1) != 10 {
+		t.Errorf("max %f, expected 10", maxPool.GetOutput(1))
+	}
+	assertClose(t, "inferred average", flatten(avg.Infer(input)), flatten(avg.Output))
+	assertClose(t, "inferred max", flatten(maxPool.Infer(input)), flatten(maxPool.Output))
+
+	checkGradients(t, "global avg", gradcheck.Check{}, avg, nil, input)
+	checkGradients(t, "global max", gradcheck.Check{}, maxPool, nil, input)
+
+	// Any spatial size is accepted
+	larger := randomVolume(rng, 3, 9)
+	checkGradients(t, "global avg 9x9", gradcheck.Check{}, avg, nil, larger)
+	checkGradients(t, "global max 9x9", gradcheck.Check{}, maxPool, nil, larger)
+	if len(avg.PrevError[0]) != 9 || len(maxPool.PrevError[0]) != 9 {
+		t.Error("the error does not have the shape of the input")
+	}
+}
+
+// sum adds up values
+func sum(values []precision.Float) precision.Float {
+	s := precision.Float(0)
+	for _, v := range values {
+		s += v
+	}
+	return s
+}
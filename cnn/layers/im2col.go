@@ -0,0 +1,96 @@
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// im2colTask holds the arguments of im2col and col2im so that layers can
+// keep it between calls
+type im2colTask struct {
+	volume                    [][][]precision.Float // Input of im2col, output of col2im
+	cols                      []precision.Float
+	depth, kernelSize, stride int
+	outputHeight, outputWidth int
+}
+
+// im2col unfolds the receptive fields of an input volume into the
+// (depth*kernelSize*kernelSize) x (outputHeight*outputWidth) matrix cols.
+// Row (c*kernelSize+y_k)*kernelSize+x_k holds input channel c shifted by
+// (y_k, x_k); column i*outputWidth+j is the output position (i, j).
+func im2col(input [][][]precision.Float, depth, kernelSize, stride, outputHeight, outputWidth int, cols []precision.Float) {
+	new(im2colTask).im2col(input, depth, kernelSize, stride, outputHeight, outputWidth, cols)
+}
+
+// col2im is the adjoint of im2col: it accumulates every entry of cols back
+// into the input position it was read from
+func col2im(cols []precision.Float, depth, kernelSize, stride, outputHeight, outputWidth int, output [][][]precision.Float) {
+	new(im2colTask).col2im(cols, depth, kernelSize, stride, outputHeight, outputWidth, output)
+}
+
+// im2col is the package level im2col using t as its parallel task
+func (t *im2colTask) im2col(input [][][]precision.Float, depth, kernelSize, stride, outputHeight, outputWidth int, cols []precision.Float) {
+	*t = im2colTask{volume: input, cols: cols, depth: depth, kernelSize: kernelSize, stride: stride,
+		outputHeight: outputHeight, outputWidth: outputWidth}
+
+	// Every row of cols is filled independently
+	parallelRun(depth*kernelSize*kernelSize, 1+parallelGrain/(outputHeight*outputWidth), (*unfoldTask)(t))
+	*t = im2colTask{}
+}
+
+// col2im is the package level col2im using t as its parallel task
+func (t *im2colTask) col2im(cols []precision.Float, depth, kernelSize, stride, outputHeight, outputWidth int, output [][][]precision.Float) {
+	*t = im2colTask{volume: output, cols: cols, depth: depth, kernelSize: kernelSize, stride: stride,
+		outputHeight: outputHeight, outputWidth: outputWidth}
+
+	// Rows of the same channel overlap in the output, so channels are the unit of work
+	parallelRun(depth, 1+parallelGrain/(kernelSize*kernelSize*outputHeight*outputWidth), (*foldTask)(t))
+	*t = im2colTask{}
+}
+
+// unfoldTask fills rows of cols from the input volume
+type unfoldTask im2colTask
+
+func (t *unfoldTask) run(start, end int) {
+	kernelSize, stride, height, width := t.kernelSize, t.stride, t.outputHeight, t.outputWidth
+	positions := height * width
+
+	for r := start; r < end; r++ {
+		c := r / (kernelSize * kernelSize)
+		y_k := r / kernelSize % kernelSize
+		x_k := r % kernelSize
+
+		row := t.cols[r*positions:]
+		for i := 0; i < height; i++ {
+			src := t.volume[c][i*stride+y_k]
+			dst := row[i*width : (i+1)*width]
+			if stride == 1 {
+				copy(dst, src[x_k:x_k+width])
+				continue
+			}
+			for j := range dst {
+				dst[j] = src[j*stride+x_k]
+			}
+		}
+	}
+}
+
+// foldTask accumulates the rows of cols of some channels into the output volume
+type foldTask im2colTask
+
+func (t *foldTask) run(start, end int) {
+	kernelSize, stride, height, width := t.kernelSize, t.stride, t.outputHeight, t.outputWidth
+	positions := height * width
+
+	for c := start; c < end; c++ {
+		for y_k := 0; y_k < kernelSize; y_k++ {
+			for x_k := 0; x_k < kernelSize; x_k++ {
+				row := t.cols[((c*kernelSize+y_k)*kernelSize+x_k)*positions:]
+				for i := 0; i < height; i++ {
+					dst := t.volume[c][i*stride+y_k]
+					src := row[i*width : (i+1)*width]
+					for j, v := range src {
+						dst[j*stride+x_k] += v
+					}
+				}
+			}
+		}
+	}
+}
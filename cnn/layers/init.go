@@ -0,0 +1,196 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Initializer fills the weights of a layer before training
+type Initializer interface {
+	// Init fills weights, a matrix stored row by row with one row of fanIn
+	// values per output unit. fanIn and fanOut scale the values: they count
+	// the inputs and outputs of a unit, times the kernel area for
+	// convolutions.
+	Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int)
+}
+
+// HeNormal draws weights from a normal distribution of standard deviation
+// sqrt(2 / fanIn), which suits ReLU and sigmoid units alike in this package
+type HeNormal struct{}
+
+// HeUniform draws weights uniformly in ±sqrt(6 / fanIn)
+type HeUniform struct{}
+
+// XavierNormal (Glorot) draws weights from a normal distribution of
+// standard deviation sqrt(2 / (fanIn + fanOut))
+type XavierNormal struct{}
+
+// XavierUniform (Glorot) draws weights uniformly in ±sqrt(6 / (fanIn + fanOut))
+type XavierUniform struct{}
+
+// LeCunNormal draws weights from a normal distribution of standard
+// deviation sqrt(1 / fanIn)
+type LeCunNormal struct{}
+
+// LeCunUniform draws weights uniformly in ±sqrt(3 / fanIn)
+type LeCunUniform struct{}
+
+// Orthogonal makes the rows of the weight matrix, or its columns when there
+// are more rows than columns, orthonormal and scales them by Gain (1 if zero)
+type Orthogonal struct {
+	Gain precision.Float
+}
+
+// Constant sets every weight to Value
+type Constant struct {
+	Value precision.Float
+}
+
+// Zeros sets every weight to zero
+type Zeros = Constant
+
+// Init fills weights with He normal values
+func (HeNormal) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillNormal(rng, weights, math.Sqrt(2/float64(fanIn)))
+}
+
+// Init fills weights with He uniform values
+func (HeUniform) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillUniform(rng, weights, math.Sqrt(6/float64(fanIn)))
+}
+
+// Init fills weights with Xavier normal values
+func (XavierNormal) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillNormal(rng, weights, math.Sqrt(2/float64(fanIn+fanOut)))
+}
+
+// Init fills weights with Xavier uniform values
+func (XavierUniform) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillUniform(rng, weights, math.Sqrt(6/float64(fanIn+fanOut)))
+}
+
+// Init fills weights with LeCun normal values
+func (LeCunNormal) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillNormal(rng, weights, math.Sqrt(1/float64(fanIn)))
+}
+
+// Init fills weights with LeCun uniform values
+func (LeCunUniform) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	fillUniform(rng, weights, math.Sqrt(3/float64(fanIn)))
+}
+
+// Init fills weights with an orthogonal matrix, obtained by Gram-Schmidt
+// orthonormalization of normal values
+func (o Orthogonal) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	gain := float64(o.Gain)
+	if gain == 0 {
+		gain = 1
+	}
+
+	// Orthonormalize the shorter dimension: n vectors of size m
+	units := len(weights) / fanIn
+	n, m := units, fanIn
+	if n > m {
+		n, m = m, n
+	}
+	vectors := make([][]float64, n)
+	for i := range vectors {
+		v := make([]float64, m)
+		for {
+			for k := range v {
+				v[k] = rng.NormFloat64()
+			}
+			for _, u := range vectors[:i] {
+				dot := 0.0
+				for k := range v {
+					dot += v[k] * u[k]
+				}
+				for k := range v {
+					v[k] -= dot * u[k]
+				}
+			}
+			norm := 0.0
+			for _, x := range v {
+				norm += x * x
+			}
+			// Draw again in the unlikely case v depends on the previous vectors
+			if norm = math.Sqrt(norm); norm > 1e-6 {
+				for k := range v {
+					v[k] /= norm
+				}
+				break
+			}
+		}
+		vectors[i] = v
+	}
+
+	for r := 0; r < units; r++ {
+		for c := 0; c < fanIn; c++ {
+			w := 0.0
+			if units <= fanIn {
+				w = vectors[r][c]
+			} else {
+				w = vectors[c][r]
+			}
+			weights[r*fanIn+c] = precision.Float(gain * w)
+		}
+	}
+}
+
+// Init sets every weight to the constant
+func (c Constant) Init(rng *rand.Rand, weights []precision.Float, fanIn, fanOut int) {
+	for i := range weights {
+		weights[i] = c.Value
+	}
+}
+
+// fillNormal fills weights with normal values of standard deviation std
+func fillNormal(rng *rand.Rand, weights []precision.Float, std float64) {
+	for i := range weights {
+		weights[i] = precision.Float(rng.NormFloat64() * std)
+	}
+}
+
+// fillUniform fills weights with uniform values in ±limit
+func fillUniform(rng *rand.Rand, weights []precision.Float, limit float64) {
+	for i := range weights {
+		weights[i] = precision.Float((2*rng.Float64() - 1) * limit)
+	}
+}
+
+// DefaultSeed seeds the generator of networks which are not seeded, see
+// CNN.SetSeed, and the generator of the layers created by the constructors
+const DefaultSeed = 42
+
+// defaultRand initializes the layers created by NewConvLayer and
+// NewFullyConnectedLayer, so that layers differ from each other while a
+// program creating them in the same order always gets the same weights.
+// Networks fill the weights again from their own generator, see CNN.SetSeed.
+var defaultRand = rand.New(&lockedSource{src: rand.NewSource(DefaultSeed).(rand.Source64)})
+
+// lockedSource makes a random source safe for concurrent use
+type lockedSource struct {
+	mu  sync.Mutex
+	src rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.src.Uint64()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.src.Seed(seed)
+}
@@ -0,0 +1,116 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// stats returns the mean and standard deviation of values
+func stats(values []precision.Float) (mean, std float64) {
+	for _, v := range values {
+		mean += float64(v)
+	}
+	mean /= float64(len(values))
+	for _, v := range values {
+		std += (float64(v) - mean) * (float64(v) - mean)
+	}
+	return mean, math.Sqrt(std / float64(len(values)))
+}
+
+func TestInitializers(t *testing.T) {
+	const fanIn, fanOut = 200, 100
+	for _, tc := range []struct {
+		name string
+		init Initializer
+		std  float64
+	}{
+		{"he normal", HeNormal{}, math.Sqrt(2.0 / fanIn)},
+		{"he uniform", HeUniform{}, math.Sqrt(2.0 / fanIn)},
+		{"xavier normal", XavierNormal{}, math.Sqrt(2.0 / (fanIn + fanOut))},
+		{"xavier uniform", XavierUniform{}, math.Sqrt(2.0 / (fanIn + fanOut))},
+		{"lecun normal", LeCunNormal{}, math.Sqrt(1.0 / fanIn)},
+		{"lecun uniform", LeCunUniform{}, math.Sqrt(1.0 / fanIn)},
+		{"orthogonal", Orthogonal{}, math.Sqrt(1.0 / fanIn)},
+	} {
+		weights := make([]precision.Float, fanIn*fanOut)
+		tc.init.Init(rand.New(rand.NewSource(1)), weights, fanIn, fanOut)
+		if mean, std := stats(weights); math.Abs(mean) > 0.1*tc.std || math.Abs(std-tc.std) > 0.05*tc.std {
+			t.Errorf("%s: mean %f and standard deviation %f, expected 0 and %f", tc.name, mean, std, tc.std)
+		}
+	}
+
+	weights := make([]precision.Float, 6)
+	Constant{Value: 0.5}.Init(nil, weights, 3, 2)
+	assertClose(t, "constant", weights, []precision.Float{0.5, 0.5, 0.5, 0.5, 0.5, 0.5})
+}
+
+func TestOrthogonal(t *testing.T) {
+	// Rows are orthonormal when they are the shorter side, columns otherwise
+	for _, shape := range [][2]int{{8, 5}, {5, 8}} {
+		fanIn, fanOut := shape[0], shape[1]
+		weights := make([]precision.Float, fanIn*fanOut)
+		Orthogonal{Gain: 2}.Init(rand.New(rand.NewSource(1)), weights, fanIn, fanOut)
+
+		n, m := fanOut, fanIn
+		at := func(i, k int) float64 { return float64(weights[i*fanIn+k]) }
+		if fanOut > fanIn {
+			n, m = fanIn, fanOut
+			at = func(i, k int) float64 { return float64(weights[k*fanIn+i]) }
+		}
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				dot := 0.0
+				for k := 0; k < m; k++ {
+					dot += at(i, k) * at(j, k)
+				}
+				expected := 0.0
+				if i == j {
+					expected = 4 // Squared gain
+				}
+				if math.Abs(dot-expected) > 1e-4 {
+					t.Fatalf("%dx%d: vectors %d and %d have dot product %f, expected %f", fanOut, fanIn, i, j, dot, expected)
+				}
+			}
+		}
+	}
+}
+
+func TestLayerInitialization(t *testing.T) {
+	// Constructors give layers of the same shape different weights, as
+	// does Initialize
+	a, b := NewConvLayer(8, 3, 4, 3, 1), NewConvLayer(8, 3, 4, 3, 1)
+	if flatten4D(a.Kernels)[0] == flatten4D(b.Kernels)[0] {
+		t.Error("two new convolutions have the same weights")
+	}
+	rng := rand.New(rand.NewSource(1))
+	a.Initialize(HeNormal{}, rng)
+	b.Initialize(HeNormal{}, rng)
+	if flatten4D(a.Kernels)[0] == flatten4D(b.Kernels)[0] {
+		t.Error("two convolutions have the same weights")
+	}
+
+	// The standard deviation of dense weights follows the number of inputs
+	fcl := NewFullyConnectedLayer(10, 8, 50)
+	fcl.Initialize(HeNormal{}, rand.New(rand.NewSource(1)))
+	if _, std := stats(flatten([][][]precision.Float{fcl.Weights})); math.Abs(std-math.Sqrt(2.0/800)) > 0.05*math.Sqrt(2.0/800) {
+		t.Errorf("dense weights have standard deviation %f, expected %f", std, math.Sqrt(2.0/800))
+	}
+
+	// Weights are stored input by input: unit j holds column j
+	fcl = NewFullyConnectedLayer(1, 2, 3)
+	fcl.Initialize(Orthogonal{}, rand.New(rand.NewSource(1)))
+	for i := range fcl.Weights {
+		for j := range fcl.Weights {
+			dot := precision.Float(0)
+			for k := 0; k < 3; k++ {
+				dot += fcl.Weights[i][k] * fcl.Weights[j][k]
+			}
+			if i == j && math.Abs(float64(dot-1)) > 1e-5 || i != j && math.Abs(float64(dot)) > 1e-5 {
+				t.Errorf("inputs %d and %d have dot product %f", i, j, dot)
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+package layers
+
+import (
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Default hyperparameters of an InstanceNormLayer
+const (
+	DefaultInstanceNormEpsilon  = 1e-5
+	DefaultInstanceNormMomentum = 0.99
+)
+
+// InstanceNormLayer normalizes every channel of its input to zero mean and
+// unit variance, then scales and shifts it by the learned Gamma and Beta.
+// Channels are the depth slices of a volume, or the features of the flat
+// 1x1xn output of a fully connected layer.
+//
+// Networks train one sample at a time, so there is no batch to take
+// statistics from: a channel of a volume is normalized over its positions
+// in the sample, in training as in inference, which is instance
+// normalization. Channels holding a single value per sample, such as
+// features, are normalized with RunningMean and RunningVar instead, which
+// training updates as exponential moving averages; they are nil otherwise.
+type InstanceNormLayer struct {
+	Depth, Height, Width int
+	Epsilon              precision.Float // Added to the variance to avoid dividing by zero
+	Momentum             precision.Float // Weight of the running statistics when they are updated
+	Gamma                []precision.Float
+	Beta                 []precision.Float
+	RunningMean          []precision.Float `json:",omitempty"`
+	RunningVar           []precision.Float `json:",omitempty"`
+
+	GammaGrads []precision.Float `json:"-"` // Gradients accumulated by BackPropagate
+	BetaGrads  []precision.Float `json:"-"`
+
+	Input     [][][]precision.Float `json:"-"`
+	Output    [][][]precision.Float `json:"-"`
+	PrevError [][][]precision.Float `json:"-"`
+
+	// State of the last ForwardPropagate, reused between steps
+	inference  bool                  // Leave the running statistics alone in ForwardPropagate
+	normalized [][][]precision.Float // Input normalized before scaling
+	invStd     []precision.Float     // Inverse standard deviation of every channel
+	params     []Param
+}
+
+// NewInstanceNormLayer creates an InstanceNormLayer for inputs of the
+// given shape, in training mode
+func NewInstanceNormLayer(depth, height, width int) *InstanceNormLayer {
+	l := &InstanceNormLayer{
+		Depth:    depth,
+		Height:   height,
+		Width:    width,
+		Epsilon:  DefaultInstanceNormEpsilon,
+		Momentum: DefaultInstanceNormMomentum,
+	}
+	channels := l.Channels()
+	l.Gamma = make([]precision.Float, channels)
+	l.Beta = make([]precision.Float, channels)
+	for k := range l.Gamma {
+		l.Gamma[k] = 1
+	}
+	if l.single() {
+		l.RunningMean = make([]precision.Float, channels)
+		l.RunningVar = make([]precision.Float, channels)
+		for k := range l.RunningVar {
+			l.RunningVar[k] = 1
+		}
+	}
+	return l
+}
+
+// flat tells whether the input is the output of a fully connected layer
+func (l *InstanceNormLayer) flat() bool {
+	return l.Depth == 1 && l.Height == 1
+}
+
+// single tells whether channels hold a single value per sample, which
+// cannot be normalized with the statistics of the sample
+func (l *InstanceNormLayer) single() bool {
+	return l.channelSize() == 1
+}
+
+// Channels returns the number of channels normalized separately
+func (l *InstanceNormLayer) Channels() int {
+	if l.flat() {
+		return l.Width
+	}
+	return l.Depth
+}
+
+// channelSize returns the number of values of a channel in one sample
+func (l *InstanceNormLayer) channelSize() int {
+	if l.flat() {
+		return 1
+	}
+	return l.Height * l.Width
+}
+
+// valuesOf returns the number of values of a channel of volume, whose
+// spatial size may differ from that of the layer in Infer
+func (l *InstanceNormLayer) valuesOf(volume [][][]precision.Float) int {
+	if l.flat() {
+		return 1
+	}
+	return len(volume[0]) * len(volume[0][0])
+}
+
+// at returns value i of channel k of a volume
+func (l *InstanceNormLayer) at(volume [][][]precision.Float, k, i int) *precision.Float {
+	if l.flat() {
+		return &volume[0][0][k]
+	}
+	width := len(volume[k][0])
+	return &volume[k][i/width][i%width]
+}
+
+// SetTraining switches between updating the running statistics of single
+// value channels (training) and leaving them alone (inference) in
+// ForwardPropagate
+func (l *InstanceNormLayer) SetTraining(training bool) {
+	l.inference = !training
+}
+
+// Training tells whether the layer is in training mode
+func (l *InstanceNormLayer) Training() bool {
+	return !l.inference
+}
+
+// ForwardPropagate normalizes the input. In training mode it also updates
+// the running statistics of single value channels.
+func (l *InstanceNormLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	l.Input = copy3D(l.Input, input)
+	if l.Output == nil {
+		l.Output = make3D[precision.Float](l.Depth, l.Height, l.Width)
+		l.normalized = make3D[precision.Float](l.Depth, l.Height, l.Width)
+		l.invStd = make([]precision.Float, l.Channels())
+	}
+
+	for k := range l.Gamma {
+		l.invStd[k] = l.normalize(input, l.Output, l.normalized, k)
+		if l.single() && !l.inference {
+			l.updateRunning(k, *l.at(input, k, 0))
+		}
+	}
+	return l.Output
+}
+
+// normalize writes channel k of the input normalized into normalized, when
+// not nil, and scaled and shifted into output. It returns the inverse
+// standard deviation the channel was normalized with.
+func (l *InstanceNormLayer) normalize(input, output, normalized [][][]precision.Float, k int) precision.Float {
+	mean, variance := l.stats(input, k)
+	invStd := 1 / precision.Float(math.Sqrt(float64(variance+l.Epsilon)))
+	for i, m := 0, l.valuesOf(input); i < m; i++ {
+		xhat := (*l.at(input, k, i) - mean) * invStd
+		if normalized != nil {
+			*l.at(normalized, k, i) = xhat
+		}
+		*l.at(output, k, i) = l.Gamma[k]*xhat + l.Beta[k]
+	}
+	return invStd
+}
+
+// stats returns the mean and variance channel k of the input is normalized
+// with: those of the sample, or the running ones for a single value
+func (l *InstanceNormLayer) stats(input [][][]precision.Float, k int) (mean, variance precision.Float) {
+	if l.single() {
+		return l.RunningMean[k], l.RunningVar[k]
+	}
+	m := l.valuesOf(input)
+	sum := precision.Float(0)
+	for i := 0; i < m; i++ {
+		sum += *l.at(input, k, i)
+	}
+	mean = sum / precision.Float(m)
+	sumSq := precision.Float(0)
+	for i := 0; i < m; i++ {
+		d := *l.at(input, k, i) - mean
+		sumSq += d * d
+	}
+	return mean, sumSq / precision.Float(m)
+}
+
+// updateRunning moves the running statistics of channel k towards its value
+func (l *InstanceNormLayer) updateRunning(k int, value precision.Float) {
+	rate := 1 - l.Momentum
+	delta := value - l.RunningMean[k]
+	l.RunningMean[k] += rate * delta
+	l.RunningVar[k] = l.Momentum * (l.RunningVar[k] + rate*delta*delta)
+}
+
+// Infer normalizes the input as ForwardPropagate does, without storing
+// anything. Channels of a volume may have any height and width.
+func (l *InstanceNormLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](len(input), len(input[0]), len(input[0][0]))
+	for k := range l.Gamma {
+		l.normalize(input, output, nil, k)
+	}
+	return output
+}
+
+// BackPropagate accumulates the gradients of Gamma and Beta and returns the
+// error of the input. Channels normalized with the statistics of the sample
+// also propagate the error through the mean and variance.
+func (l *InstanceNormLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+	l.allocGrads()
+	if l.PrevError == nil {
+		l.PrevError = make3D[precision.Float](l.Depth, l.Height, l.Width)
+	}
+
+	m := l.channelSize()
+	for k := range l.Gamma {
+		sumDy, sumDyXhat := precision.Float(0), precision.Float(0)
+		for i := 0; i < m; i++ {
+			dy := *l.at(error, k, i)
+			sumDy += dy
+			sumDyXhat += dy * *l.at(l.normalized, k, i)
+		}
+		l.BetaGrads[k] += sumDy
+		l.GammaGrads[k] += sumDyXhat
+
+		scale := l.Gamma[k] * l.invStd[k]
+		for i := 0; i < m; i++ {
+			dx := *l.at(error, k, i)
+			if !l.single() {
+				dx -= (sumDy + *l.at(l.normalized, k, i)*sumDyXhat) / precision.Float(m)
+			}
+			*l.at(l.PrevError, k, i) = scale * dx
+		}
+	}
+	return l.PrevError
+}
+
+// Params returns Gamma and Beta with their gradients.
+// The slice is built once and shared between calls.
+func (l *InstanceNormLayer) Params() []Param {
+	l.allocGrads()
+	if l.params == nil {
+		l.params = []Param{
+			{Name: "gamma", Values: [][]precision.Float{l.Gamma}, Grads: [][]precision.Float{l.GammaGrads}, Bias: true},
+			{Name: "beta", Values: [][]precision.Float{l.Beta}, Grads: [][]precision.Float{l.BetaGrads}, Bias: true},
+		}
+	}
+	return l.params
+}
+
+// allocGrads creates the gradient buffers, which are not part of saved models
+func (l *InstanceNormLayer) allocGrads() {
+	if l.GammaGrads == nil {
+		l.GammaGrads = make([]precision.Float, len(l.Gamma))
+		l.BetaGrads = make([]precision.Float, len(l.Beta))
+	}
+}
+
+// GetOutput returns the output value at the specified index
+func (l *InstanceNormLayer) GetOutput(index int) precision.Float {
+	return l.Output[0][0][index]
+}
+
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, or nil if the layer cannot normalize it:
+// features only come in the shape the layer was built for
+func (l *InstanceNormLayer) OutputShapeFor(input []int) []int {
+	if input[0] != l.Depth || (l.flat() || l.single()) && (input[1] != l.Height || input[2] != l.Width) {
+		return nil
+	}
+	return input
+}
+
+// InputShape returns the depth, height and width of the expected input
+func (l *InstanceNormLayer) InputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (l *InstanceNormLayer) OutputShape() []int {
+	return []int{l.Depth, l.Height, l.Width}
+}
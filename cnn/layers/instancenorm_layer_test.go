@@ -0,0 +1,73 @@
+package layers
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestInstanceNormGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, shape := range [][3]int{{3, 4, 4}, {1, 1, 6}, {3, 1, 1}} {
+		l := NewInstanceNormLayer(shape[0], shape[1], shape[2])
+		l.Momentum = 1 // Keep the running statistics fixed between evaluations
+		for k := range l.Gamma {
+			l.Gamma[k] = precision.Float(0.5 + rng.Float32())
+			l.Beta[k] = precision.Float(rng.Float32() - 0.5)
+		}
+		for k := range l.RunningMean {
+			l.RunningMean[k] = precision.Float(rng.Float32() - 0.5)
+			l.RunningVar[k] = precision.Float(0.5 + rng.Float32())
+		}
+
+		input := make3D[precision.Float](shape[0], shape[1], shape[2])
+		for _, row := range rows3D(input) {
+			for i := range row {
+				row[i] = precision.Float(rng.NormFloat64())*2 + 1
+			}
+		}
+		checkGradients(t, fmt.Sprint(shape), gradcheck.Check{Step: 1e-2}, l, l.Params(), input)
+	}
+}
+
+func TestInstanceNormModes(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	l := NewInstanceNormLayer(2, 5, 5)
+
+	// Every channel of a volume is normalized with the statistics of the
+	// sample, in training as in inference
+	input := randomVolume(rng, 2, 5)
+	output := l.ForwardPropagate(input)
+	for c := range output {
+		mean, variance := l.stats(output, c)
+		if math.Abs(float64(mean)) > 1e-5 || math.Abs(float64(variance)-1) > 1e-3 {
+			t.Fatalf("channel %d has mean %f and variance %f", c, mean, variance)
+		}
+	}
+	assertClose(t, "volume", flatten(output), flatten(l.Infer(input)))
+
+	// Running statistics of features converge to those of the data
+	features := NewInstanceNormLayer(1, 1, 2)
+	for i := 0; i < 2000; i++ {
+		features.ForwardPropagate([][][]precision.Float{{{precision.Float(rng.NormFloat64())*3 + 2, precision.Float(rng.NormFloat64())*3 + 2}}})
+	}
+	for k := range features.RunningMean {
+		if math.Abs(float64(features.RunningMean[k]-2)) > 0.75 || math.Abs(float64(features.RunningVar[k]-9)) > 3 {
+			t.Errorf("feature %d has running mean %f and variance %f", k, features.RunningMean[k], features.RunningVar[k])
+		}
+	}
+
+	// Inference mode computes what Infer does and leaves the statistics alone
+	features.SetTraining(false)
+	sample := [][][]precision.Float{{{1, -1}}}
+	mean := features.RunningMean[0]
+	assertClose(t, "features", flatten(features.ForwardPropagate(sample)), flatten(features.Infer(sample)))
+	if features.RunningMean[0] != mean || features.Training() {
+		t.Error("inference mode updated the running statistics")
+	}
+}
@@ -1,6 +1,8 @@
 // Package layers provide various layers
 package layers
 
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
 // MaxPoolingLayer represents a max pooling layer in the CNN
 type MaxPoolingLayer struct {
 	InputSize    int
@@ -8,10 +10,25 @@ type MaxPoolingLayer struct {
 	PoolSize     int
 	OutputSize   int
 	Stride       int
-	Output       [][][]float32
-	HighestIndex [][][][]int   // Tuple (x,y,z)[2]int representing the position of the highest value // TODO: WTF
-	PrevError    [][][]float32 // Add this line
+	Output       [][][]precision.Float
+	HighestIndex [][][][]int           // Tuple (x,y,z)[2]int representing the position of the highest value // TODO: WTF
+	PrevError    [][][]precision.Float // Add this line
+
+	forwardTask  poolTask // Parallel loops of training, reused between steps
+	backwardTask poolBackwardTask
+}
+
+// poolTask pools some channels of input into output
+type poolTask struct {
+	mpl           *MaxPoolingLayer
+	input, output [][][]precision.Float
+	highestIndex  [][][][]int // Position of every selected value, unless nil
+}
 
+// poolBackwardTask routes the error of some channels to the selected inputs
+type poolBackwardTask struct {
+	mpl   *MaxPoolingLayer
+	error [][][]precision.Float
 }
 
 // NewMaxPoolingLayer creates a new custom MaxPooling layer.
@@ -33,63 +50,131 @@ func NewMaxPoolingLayer(inputSize, inputDepth, poolSize, stride int) *MaxPooling
 		Stride:     stride,
 	}
 
-	mpl.Output = make3D[float32](inputDepth, outputSize, outputSize)
+	mpl.Output = make3D[precision.Float](inputDepth, outputSize, outputSize)
 	mpl.HighestIndex = make4D[int](inputDepth, outputSize, outputSize, 2)
-	mpl.PrevError = make3D[float32](inputDepth, inputSize, inputSize)
+	mpl.PrevError = make3D[precision.Float](inputDepth, inputSize, inputSize)
 
 	return mpl
 }
 
 // ForwardPropagate reduces the size of the input by using max pooling
-func (mpl *MaxPoolingLayer) ForwardPropagate(input [][][]float32) [][][]float32 {
-	// Loop through each output position in the output volume
-	for y := 0; y < mpl.OutputSize; y++ {
-		for x := 0; x < mpl.OutputSize; x++ {
-			// Calculate the top-left corner of the receptive field
-			left := x * mpl.Stride
-			top := y * mpl.Stride
-			for f := 0; f < mpl.InputDepth; f++ {
-				mpl.Output[f][y][x] = -1.0
-				// Loop through each position in the receptive field
-				// and find the highest value
+func (mpl *MaxPoolingLayer) ForwardPropagate(input [][][]precision.Float) [][][]precision.Float {
+	mpl.forwardTask = poolTask{mpl: mpl, input: input, output: mpl.Output, highestIndex: mpl.HighestIndex}
+	parallelRun(mpl.InputDepth, mpl.grain(), &mpl.forwardTask)
+	mpl.forwardTask = poolTask{}
+	return mpl.Output
+}
+
+// Infer computes the output of the MaxPoolingLayer without storing anything
+// for backpropagation, so it can be called concurrently. The input may have
+// any height and width of at least PoolSize, the output is sized from it.
+func (mpl *MaxPoolingLayer) Infer(input [][][]precision.Float) [][][]precision.Float {
+	output := make3D[precision.Float](mpl.InputDepth, windows(len(input[0]), mpl.PoolSize, mpl.Stride),
+		windows(len(input[0][0]), mpl.PoolSize, mpl.Stride))
+	parallelRun(mpl.InputDepth, mpl.grain(), &poolTask{mpl: mpl, input: input, output: output})
+	return output
+}
+
+// run writes the pooled channels [start, end) of input into output and,
+// unless highestIndex is nil, the position of every selected value into highestIndex
+func (t *poolTask) run(start, end int) {
+	mpl, input, output, highestIndex := t.mpl, t.input, t.output, t.highestIndex
+	for f := start; f < end; f++ {
+		// Loop through each output position in the output volume
+		for y := range output[f] {
+			for x := range output[f][y] {
+				// Calculate the top-left corner of the receptive field
+				left := x * mpl.Stride
+				top := y * mpl.Stride
+
+				// Loop through each position in the receptive field and find
+				// the highest value, starting from the first one so that any
+				// input, however negative, selects a value of its window
+				highest, highestY, highestX := input[f][top][left], top, left
 				for yP := 0; yP < mpl.PoolSize; yP++ {
 					for xP := 0; xP < mpl.PoolSize; xP++ {
 						val := input[f][top+yP][left+xP]
-						if val > mpl.Output[f][y][x] {
-							mpl.Output[f][y][x] = val
-
-							// Store the position of the highest value for backpropagation
-							mpl.HighestIndex[f][y][x] = []int{top + yP, left + xP}
+						if val > highest {
+							highest, highestY, highestX = val, top+yP, left+xP
 						}
 					}
 				}
+				output[f][y][x] = highest
+
+				// Store the position of the highest value for backpropagation
+				if highestIndex != nil {
+					highestIndex[f][y][x] = append(highestIndex[f][y][x][:0], highestY, highestX)
+				}
 			}
 		}
 	}
-	return mpl.Output
+}
+
+// grain returns the number of channels worth handing to a separate goroutine
+func (mpl *MaxPoolingLayer) grain() int {
+	return 1 + parallelGrain/(mpl.OutputSize*mpl.OutputSize*mpl.PoolSize*mpl.PoolSize)
 }
 
 // BackPropagate back propagates the error in a max pooling layer.
-// Takes in the error matrix and returns the previous error matrix
-func (mpl *MaxPoolingLayer) BackPropagate(error [][][]float32) [][][]float32 {
-
-	// Iterate through the output neurons
-	for y := 0; y < mpl.OutputSize; y++ {
-		for x := 0; x < mpl.OutputSize; x++ {
-			// Input depth will always be the same as output depth
-			for f := 0; f < mpl.InputDepth; f++ {
+// Takes in the error matrix and returns the previous error matrix.
+// Every output error goes to the selected input of its window; an input
+// selected by several overlapping windows receives the sum of their errors.
+func (mpl *MaxPoolingLayer) BackPropagate(error [][][]precision.Float) [][][]precision.Float {
+
+	// Iterate through the output neurons, channels are processed in parallel.
+	// Input depth will always be the same as output depth
+	mpl.backwardTask = poolBackwardTask{mpl: mpl, error: error}
+	parallelRun(mpl.InputDepth, mpl.grain(), &mpl.backwardTask)
+	mpl.backwardTask = poolBackwardTask{}
+
+	// Return the previous error vector
+	return mpl.PrevError
+}
+
+// run routes the error of the channels [start, end)
+func (t *poolBackwardTask) run(start, end int) {
+	mpl, error := t.mpl, t.error
+	for f := start; f < end; f++ {
+		// Inputs which are not selected get no error
+		zero3D(mpl.PrevError[f : f+1])
+		for y := 0; y < mpl.OutputSize; y++ {
+			for x := 0; x < mpl.OutputSize; x++ {
 				pos := mpl.HighestIndex[f][y][x]
-				// Update the input error value with the corresponding output error value
-				mpl.PrevError[f][pos[0]][pos[1]] = error[f][y][x]
+				// Add the output error value to the input error value
+				mpl.PrevError[f][pos[0]][pos[1]] += error[f][y][x]
 			}
 		}
 	}
+}
 
-	// Return the previous error vector
-	return mpl.PrevError
+// OutputShapeFor returns the shape of the output Infer computes for an
+// input of the given shape, or nil if the input has another depth or is
+// smaller than the pooling window
+func (mpl *MaxPoolingLayer) OutputShapeFor(input []int) []int {
+	return poolShape(input, mpl.InputDepth, mpl.PoolSize, mpl.Stride)
+}
+
+// poolShape returns the output shape of a pooling layer for an input shape,
+// or nil if the input does not fit
+func poolShape(input []int, depth, poolSize, stride int) []int {
+	height, width := windows(input[1], poolSize, stride), windows(input[2], poolSize, stride)
+	if input[0] != depth || height < 1 || width < 1 {
+		return nil
+	}
+	return []int{depth, height, width}
 }
 
 // GetOutput returns the output value at the specified index
-func (mpl *MaxPoolingLayer) GetOutput(index int) float32 {
+func (mpl *MaxPoolingLayer) GetOutput(index int) precision.Float {
 	panic("Max pooling layers should not be accessed directly.")
 }
+
+// InputShape returns the depth, height and width of the expected input
+func (mpl *MaxPoolingLayer) InputShape() []int {
+	return []int{mpl.InputDepth, mpl.InputSize, mpl.InputSize}
+}
+
+// OutputShape returns the depth, height and width of the output
+func (mpl *MaxPoolingLayer) OutputShape() []int {
+	return []int{mpl.InputDepth, mpl.OutputSize, mpl.OutputSize}
+}
@@ -1,8 +1,12 @@
 package layers
 
 import (
+	"math/rand"
 	"reflect"
 	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/internal/gradcheck"
+	"github.com/ofauchon/go-cnn/cnn/precision"
 )
 
 func TestMPLForwardPropagate(t *testing.T) {
@@ -14,14 +18,14 @@ func TestMPLForwardPropagate(t *testing.T) {
 	mpl := NewMaxPoolingLayer(4, 3, 2, 2)
 
 	// Sample input (4x4 depth=3)
-	input := [][][]float32{
+	input := [][][]precision.Float{
 		{{1.0, 2.0, 3.0, 4.0}, {5.0, 6.0, 7.0, 8.0}, {9.0, 10.0, 11.0, 12.0}, {13.0, 14.0, 15.0, 16.0}},
 		{{17.0, 18.0, 19.0, 20.0}, {21.0, 22.0, 23.0, 24.0}, {25.0, 26.0, 27.0, 28.0}, {29.0, 30.0, 31.0, 32.0}},
 		{{33.0, 34.0, 35.0, 36.0}, {37.0, 38.0, 39.0, 40.0}, {41.0, 42.0, 43.0, 44.0}, {45.0, 46.0, 47.0, 48.0}},
 	}
 
 	// Expected output (2x2 depth=3)
-	expectedOutput := [][][]float32{
+	expectedOutput := [][][]precision.Float{
 		{{6.0, 8.0}, {14.0, 16.0}},
 		{{22.0, 24.0}, {30.0, 32.0}},
 		{{38.0, 40.0}, {46.0, 48.0}},
@@ -35,3 +39,45 @@ func TestMPLForwardPropagate(t *testing.T) {
 		t.Errorf("ForwardPropagate did not produce the expected output. Got %v, expected %v", output, expectedOutput)
 	}
 }
+
+func TestMPLNegativeInputs(t *testing.T) {
+	mpl := NewMaxPoolingLayer(4, 1, 2, 2)
+	input := [][][]precision.Float{{
+		{-5, -3, -2, -9},
+		{-4, -6, -8, -7},
+		{-1.5, -2.5, -12, -11},
+		{-3.5, -4.5, -10, -13},
+	}}
+
+	output := mpl.ForwardPropagate(input)
+	if expected := [][][]precision.Float{{{-3, -2}, {-1.5, -10}}}; !reflect.DeepEqual(output, expected) {
+		t.Errorf("ForwardPropagate returned %v, expected %v", output, expected)
+	}
+
+	prevError := mpl.BackPropagate([][][]precision.Float{{{1, 2}, {3, 4}}})
+	expected := [][][]precision.Float{{
+		{0, 1, 2, 0},
+		{0, 0, 0, 0},
+		{3, 0, 0, 0},
+		{0, 0, 4, 0},
+	}}
+	if !reflect.DeepEqual(prevError, expected) {
+		t.Errorf("BackPropagate returned %v, expected %v", prevError, expected)
+	}
+}
+
+func TestMPLGradients(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	checkGradients(t, "max", gradcheck.Check{}, NewMaxPoolingLayer(6, 3, 2, 2), nil, randomVolume(rng, 3, 6))
+	// Overlapping windows add up their errors
+	checkGradients(t, "max overlapping", gradcheck.Check{}, NewMaxPoolingLayer(6, 3, 3, 1), nil, randomVolume(rng, 3, 6))
+
+	// The error of a sample does not leak into the next one
+	mpl := NewMaxPoolingLayer(4, 1, 2, 2)
+	mpl.ForwardPropagate([][][]precision.Float{{{1, 0, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}}})
+	mpl.BackPropagate([][][]precision.Float{{{1, 1}, {1, 1}}})
+	mpl.ForwardPropagate([][][]precision.Float{{{0, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 0, 0}, {0, 0, 0, 0}}})
+	if prevError := mpl.BackPropagate([][][]precision.Float{{{1, 0}, {0, 0}}}); prevError[0][0][0] != 0 || prevError[0][1][1] != 1 {
+		t.Errorf("BackPropagate returned %v after another sample", prevError)
+	}
+}
@@ -0,0 +1,125 @@
+package layers
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// pool runs the chunks of parallel loops on a fixed set of goroutines.
+// Workers are started once, so that a parallel loop does not allocate.
+type pool struct {
+	workers    int
+	chunks     chan chunk // Unbuffered: a send succeeds only when a worker is idle
+	done       chan struct{}
+	waitGroups sync.Pool
+}
+
+// chunk is a part of a parallel loop handed to a worker
+type chunk struct {
+	task       rangeTask
+	start, end int
+	wg         *sync.WaitGroup
+}
+
+// rangeTask is the body of a parallel loop. Hot paths implement it on
+// structs kept in their layer: unlike a closure, passing a pointer to such
+// a struct does not allocate on every call.
+type rangeTask interface {
+	run(start, end int)
+}
+
+// rangeFunc adapts a function to rangeTask
+type rangeFunc func(start, end int)
+
+func (f rangeFunc) run(start, end int) { f(start, end) }
+
+var workerPool atomic.Pointer[pool]
+
+func init() {
+	SetWorkers(0)
+}
+
+// SetWorkers sets the number of goroutines layer computations may use,
+// across all layers and callers. n < 1 selects runtime.GOMAXPROCS(0).
+// Results do not depend on the number of workers.
+func SetWorkers(n int) {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	p := &pool{workers: n, chunks: make(chan chunk), done: make(chan struct{})}
+	p.waitGroups.New = func() interface{} { return new(sync.WaitGroup) }
+
+	// The caller of a parallel loop is the first worker
+	for i := 1; i < n; i++ {
+		go p.work()
+	}
+	if old := workerPool.Swap(p); old != nil {
+		close(old.done)
+	}
+}
+
+// Workers returns the number of goroutines layer computations may use
+func Workers() int {
+	return workerPool.Load().workers
+}
+
+// work runs the chunks sent to the pool until it is replaced
+func (p *pool) work() {
+	for {
+		select {
+		case c := <-p.chunks:
+			c.task.run(c.start, c.end)
+			c.wg.Done()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// ParallelFor calls body on contiguous chunks covering [0, n), using the
+// worker pool. Chunks hold at least grain items so that tiny loops stay on
+// the calling goroutine. When the pool is busy, for instance because
+// several goroutines run inference at once, chunks run on the caller.
+// body must only write to locations owned by its chunk.
+func ParallelFor(n, grain int, body func(start, end int)) {
+	parallelRun(n, grain, rangeFunc(body))
+}
+
+// parallelRun is ParallelFor for a rangeTask
+func parallelRun(n, grain int, task rangeTask) {
+	p := workerPool.Load()
+	if grain < 1 {
+		grain = 1
+	}
+	chunks := (n + grain - 1) / grain
+	if chunks > p.workers {
+		chunks = p.workers
+	}
+	if chunks <= 1 {
+		task.run(0, n)
+		return
+	}
+
+	wg := p.waitGroups.Get().(*sync.WaitGroup)
+	size := (n + chunks - 1) / chunks
+	for start := 0; start < n; start += size {
+		end := minInt(start+size, n)
+
+		// The last chunk always runs on the caller
+		if end == n {
+			task.run(start, end)
+			break
+		}
+
+		wg.Add(1)
+		select {
+		case p.chunks <- chunk{task: task, start: start, end: end, wg: wg}:
+		default:
+			task.run(start, end)
+			wg.Done()
+		}
+	}
+	wg.Wait()
+	p.waitGroups.Put(wg)
+}
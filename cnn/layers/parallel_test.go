@@ -0,0 +1,88 @@
+package layers
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// runLayers performs a forward and backward pass through every layer type
+// and returns all outputs, previous errors and gradients
+func runLayers(algorithm ConvAlgorithm) []interface{} {
+	rng := rand.New(rand.NewSource(1))
+	cl := NewConvLayer(12, 6, 9, 3, 1)
+	cl.Algorithm = algorithm
+	mpl := NewMaxPoolingLayer(10, 9, 2, 2)
+	fcl := NewFullyConnectedLayer(5, 9, 10)
+	cl.Initialize(HeNormal{}, rng)
+	fcl.Initialize(HeNormal{}, rng)
+
+	input := randomVolume(rng, 6, 12)
+	output := fcl.ForwardPropagate(mpl.ForwardPropagate(cl.ForwardPropagate(input)))
+	errors := [][][]precision.Float{{randomSlice(rng, 10)}}
+	prevError := cl.BackPropagate(mpl.BackPropagate(fcl.BackPropagate(errors)))
+
+	return []interface{}{output, prevError, cl.KernelGrads, cl.BiasGrads, fcl.WeightGrads, fcl.BiasGrads, cl.Infer(input)}
+}
+
+func TestResultsIndependentOfWorkers(t *testing.T) {
+	defer SetWorkers(0)
+
+	for _, algorithm := range []ConvAlgorithm{ConvGEMM, ConvNaive} {
+		SetWorkers(1)
+		expected := runLayers(algorithm)
+
+		for _, workers := range []int{2, 3, 8} {
+			SetWorkers(workers)
+			if got := runLayers(algorithm); !reflect.DeepEqual(got, expected) {
+				t.Errorf("Algorithm %d with %d workers differs from a single worker", algorithm, workers)
+			}
+		}
+	}
+}
+
+func TestParallelForCoversRange(t *testing.T) {
+	defer SetWorkers(0)
+	SetWorkers(4)
+
+	for _, n := range []int{0, 1, 5, 97} {
+		seen := make([]int, n)
+		ParallelFor(n, 2, func(start, end int) {
+			for i := start; i < end; i++ {
+				seen[i]++
+			}
+		})
+		for i, count := range seen {
+			if count != 1 {
+				t.Errorf("ParallelFor(%d) visited %d %d times", n, i, count)
+			}
+		}
+	}
+}
+
+func BenchmarkWorkers(b *testing.B) {
+	defer SetWorkers(0)
+
+	rng := rand.New(rand.NewSource(1))
+	cl := NewConvLayer(28, 8, 16, 5, 1)
+	fcl := NewFullyConnectedLayer(24, 16, 64)
+	cl.Initialize(HeNormal{}, rng)
+	fcl.Initialize(HeNormal{}, rng)
+	input := randomVolume(rng, 8, 28)
+	errors := randomVolume(rng, 16, cl.OutputSize)
+
+	for workers := 1; workers <= runtime.GOMAXPROCS(0); workers *= 2 {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			SetWorkers(workers)
+			for i := 0; i < b.N; i++ {
+				output := cl.ForwardPropagate(input)
+				fcl.ForwardPropagate(output)
+				cl.BackPropagate(errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// useSIMD is set at startup when the CPU supports the assembly kernels of
+// the current architecture (AVX2 and FMA on amd64, NEON on arm64).
+// Tests clear it to compare against the pure Go implementation.
+var useSIMD = hasSIMD
+
+// simdMinLength is the length below which calling the assembly kernels is not worth it
+const simdMinLength = 8
+
+// dot returns the dot product of x and y[:len(x)]
+func dot(x, y []precision.Float) precision.Float {
+	y = y[:len(x)]
+	if useSIMD && len(x) >= simdMinLength {
+		return dotSIMD(x, y)
+	}
+	return dotGeneric(x, y)
+}
+
+// axpy computes y += alpha * x over the first len(x) elements of y
+func axpy(alpha precision.Float, x, y []precision.Float) {
+	y = y[:len(x)]
+	if useSIMD && len(x) >= simdMinLength {
+		axpySIMD(alpha, x, y)
+		return
+	}
+	axpyGeneric(alpha, x, y)
+}
+
+func dotGeneric(x, y []precision.Float) precision.Float {
+	var s0, s1, s2, s3 precision.Float
+	i := 0
+	for ; i <= len(x)-4; i += 4 {
+		s0 += x[i] * y[i]
+		s1 += x[i+1] * y[i+1]
+		s2 += x[i+2] * y[i+2]
+		s3 += x[i+3] * y[i+3]
+	}
+	for ; i < len(x); i++ {
+		s0 += x[i] * y[i]
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func axpyGeneric(alpha precision.Float, x, y []precision.Float) {
+	i := 0
+	for ; i <= len(x)-4; i += 4 {
+		y[i] += alpha * x[i]
+		y[i+1] += alpha * x[i+1]
+		y[i+2] += alpha * x[i+2]
+		y[i+3] += alpha * x[i+3]
+	}
+	for ; i < len(x); i++ {
+		y[i] += alpha * x[i]
+	}
+}
@@ -0,0 +1,51 @@
+//go:build !purego && !cnn_float64
+
+package layers
+
+// hasSIMD reports whether the CPU and the operating system support AVX2 and FMA
+var hasSIMD = detectAVX2FMA()
+
+// detectAVX2FMA follows the checks of golang.org/x/sys/cpu: the CPU must
+// advertise AVX, FMA and AVX2, and the OS must save the YMM registers.
+func detectAVX2FMA() bool {
+	maxLeaf, _, _, _ := cpuid(0, 0)
+	if maxLeaf < 7 {
+		return false
+	}
+
+	_, _, ecx1, _ := cpuid(1, 0)
+	const (
+		fma     = 1 << 12
+		osxsave = 1 << 27
+		avx     = 1 << 28
+	)
+	if ecx1&(fma|osxsave|avx) != fma|osxsave|avx {
+		return false
+	}
+
+	// XCR0 bits 1 and 2: SSE and AVX state enabled by the OS
+	if xcr0, _ := xgetbv(); xcr0&6 != 6 {
+		return false
+	}
+
+	_, ebx7, _, _ := cpuid(7, 0)
+	const avx2 = 1 << 5
+	return ebx7&avx2 != 0
+}
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+// dotSIMD returns the dot product of x and y using AVX2 and FMA.
+// len(y) must be at least len(x).
+//
+//go:noescape
+func dotSIMD(x, y []float32) float32
+
+// axpySIMD computes y += alpha * x using AVX2 and FMA. Every element,
+// including the scalar tail, is computed with a fused multiply-add so that
+// results do not depend on how a vector is split. len(y) must be at least len(x).
+//
+//go:noescape
+func axpySIMD(alpha float32, x, y []float32)
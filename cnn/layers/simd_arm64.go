@@ -0,0 +1,20 @@
+//go:build !purego && !cnn_float64
+
+package layers
+
+// hasSIMD is always true: Advanced SIMD (NEON) with fused multiply-add is a
+// mandatory part of ARMv8-A, so there is nothing to detect at runtime
+const hasSIMD = true
+
+// dotSIMD returns the dot product of x and y using NEON.
+// len(y) must be at least len(x).
+//
+//go:noescape
+func dotSIMD(x, y []float32) float32
+
+// axpySIMD computes y += alpha * x using NEON. Every element, including the
+// scalar tail, is computed with a fused multiply-add so that results do not
+// depend on how a vector is split. len(y) must be at least len(x).
+//
+//go:noescape
+func axpySIMD(alpha float32, x, y []float32)
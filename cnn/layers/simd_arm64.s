@@ -0,0 +1,107 @@
+//go:build !purego && !cnn_float64
+
+#include "textflag.h"
+
+// func dotSIMD(x, y []float32) float32
+TEXT ·dotSIMD(SB), NOSPLIT, $0-52
+	MOVD x_base+0(FP), R0
+	MOVD x_len+8(FP), R2
+	MOVD y_base+24(FP), R1
+	VEOR V0.B16, V0.B16, V0.B16
+	VEOR V1.B16, V1.B16, V1.B16
+
+	// Two independent accumulators hide the FMA latency
+loop8:
+	CMP  $8, R2
+	BLT  loop4
+	VLD1.P 32(R0), [V2.S4, V3.S4]
+	VLD1.P 32(R1), [V4.S4, V5.S4]
+	VFMLA V2.S4, V4.S4, V0.S4
+	VFMLA V3.S4, V5.S4, V1.S4
+	SUB  $8, R2
+	B    loop8
+
+loop4:
+	CMP  $4, R2
+	BLT  reduce
+	VLD1.P 16(R0), [V2.S4]
+	VLD1.P 16(R1), [V4.S4]
+	VFMLA V2.S4, V4.S4, V0.S4
+	SUB  $4, R2
+
+reduce:
+	// Sum the lanes of V0 and V1 into F6
+	FMOVS F0, F6
+	VMOV  V0.S[1], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V0.S[2], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V0.S[3], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V1.S[0], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V1.S[1], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V1.S[2], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+	VMOV  V1.S[3], R3
+	FMOVS R3, F7
+	FADDS F7, F6
+
+tail:
+	CBZ  R2, done
+	FMOVS.P 4(R0), F2
+	FMOVS.P 4(R1), F3
+	FMADDS F2, F6, F3, F6
+	SUB  $1, R2
+	B    tail
+
+done:
+	FMOVS F6, ret+48(FP)
+	RET
+
+// func axpySIMD(alpha float32, x, y []float32)
+TEXT ·axpySIMD(SB), NOSPLIT, $0-56
+	MOVWU alpha+0(FP), R3
+	VDUP  R3, V0.S4
+	MOVD  x_base+8(FP), R0
+	MOVD  x_len+16(FP), R2
+	MOVD  y_base+32(FP), R1
+
+loop8:
+	CMP  $8, R2
+	BLT  loop4
+	VLD1   (R1), [V2.S4, V3.S4]
+	VLD1.P 32(R0), [V4.S4, V5.S4]
+	VFMLA  V0.S4, V4.S4, V2.S4
+	VFMLA  V0.S4, V5.S4, V3.S4
+	VST1.P [V2.S4, V3.S4], 32(R1)
+	SUB  $8, R2
+	B    loop8
+
+loop4:
+	CMP  $4, R2
+	BLT  tail
+	VLD1   (R1), [V2.S4]
+	VLD1.P 16(R0), [V4.S4]
+	VFMLA  V0.S4, V4.S4, V2.S4
+	VST1.P [V2.S4], 16(R1)
+	SUB  $4, R2
+
+tail:
+	CBZ  R2, done
+	FMOVS   (R1), F2
+	FMOVS.P 4(R0), F3
+	FMADDS  F0, F2, F3, F2
+	FMOVS.P F2, 4(R1)
+	SUB  $1, R2
+	B    tail
+
+done:
+	RET
@@ -0,0 +1,16 @@
+//go:build purego || cnn_float64 || (!amd64 && !arm64)
+
+package layers
+
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+// hasSIMD is false: there are no assembly kernels for this architecture
+const hasSIMD = false
+
+func dotSIMD(x, y []precision.Float) precision.Float {
+	return dotGeneric(x, y)
+}
+
+func axpySIMD(alpha precision.Float, x, y []precision.Float) {
+	axpyGeneric(alpha, x, y)
+}
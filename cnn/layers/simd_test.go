@@ -0,0 +1,118 @@
+package layers
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// withSIMD runs f with the assembly kernels enabled or disabled
+func withSIMD(enabled bool, f func()) {
+	saved := useSIMD
+	useSIMD = enabled && hasSIMD
+	defer func() { useSIMD = saved }()
+	f()
+}
+
+func TestDotMatchesGeneric(t *testing.T) {
+	if !hasSIMD {
+		t.Skip("no SIMD kernels on this platform")
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	// Lengths cover the vector loops, their tails and unaligned offsets
+	for n := 0; n < 70; n++ {
+		for offset := 0; offset < 3; offset++ {
+			x := randomSlice(rng, n+offset)[offset:]
+			y := randomSlice(rng, n+offset)[offset:]
+
+			got := dotSIMD(x, y)
+			expected := dotGeneric(x, y)
+			var scale float64
+			for i := range x {
+				scale += math.Abs(float64(x[i] * y[i]))
+			}
+			if math.Abs(float64(got-expected)) > 1e-5*(scale+1) {
+				t.Fatalf("n=%d offset=%d: dotSIMD = %v, dotGeneric = %v", n, offset, got, expected)
+			}
+		}
+	}
+}
+
+func TestAxpyMatchesGeneric(t *testing.T) {
+	if !hasSIMD {
+		t.Skip("no SIMD kernels on this platform")
+	}
+	rng := rand.New(rand.NewSource(1))
+
+	for n := 0; n < 70; n++ {
+		for offset := 0; offset < 3; offset++ {
+			alpha := precision.Float(rng.NormFloat64())
+			x := randomSlice(rng, n+offset)[offset:]
+			y := randomSlice(rng, n+offset+1)[offset:]
+			got := append([]precision.Float(nil), y...)
+			expected := append([]precision.Float(nil), y...)
+
+			axpySIMD(alpha, x, got)
+			axpyGeneric(alpha, x, expected)
+			assertClose(t, "axpy", got, expected)
+
+			// Elements past len(x) must be left alone
+			if got[n] != y[n] {
+				t.Fatalf("n=%d offset=%d: axpySIMD wrote past the end of x", n, offset)
+			}
+		}
+	}
+}
+
+func TestLayersMatchGeneric(t *testing.T) {
+	var generic, simd []interface{}
+	withSIMD(false, func() { generic = runLayers(ConvGEMM) })
+	withSIMD(true, func() { simd = runLayers(ConvGEMM) })
+
+	for i := range generic {
+		var got, expected []precision.Float
+		switch g := generic[i].(type) {
+		case [][][]precision.Float:
+			expected, got = flatten(g), flatten(simd[i].([][][]precision.Float))
+		case [][][][]precision.Float:
+			expected, got = flatten4D(g), flatten4D(simd[i].([][][][]precision.Float))
+		case [][]precision.Float:
+			expected, got = flatten([][][]precision.Float{g}), flatten([][][]precision.Float{simd[i].([][]precision.Float)})
+		case []precision.Float:
+			expected, got = g, simd[i].([]precision.Float)
+		default:
+			t.Fatalf("unexpected result type %T", g)
+		}
+		assertClose(t, "layer result", got, expected)
+	}
+}
+
+func benchmarkDot(b *testing.B, simd bool) {
+	rng := rand.New(rand.NewSource(1))
+	x, y := randomSlice(rng, 1024), randomSlice(rng, 1024)
+	withSIMD(simd, func() {
+		b.SetBytes(2 * 4 * 1024)
+		for i := 0; i < b.N; i++ {
+			dot(x, y)
+		}
+	})
+}
+
+func benchmarkAxpy(b *testing.B, simd bool) {
+	rng := rand.New(rand.NewSource(1))
+	x, y := randomSlice(rng, 1024), randomSlice(rng, 1024)
+	withSIMD(simd, func() {
+		b.SetBytes(3 * 4 * 1024)
+		for i := 0; i < b.N; i++ {
+			axpy(1e-6, x, y)
+		}
+	})
+}
+
+func BenchmarkDotGeneric(b *testing.B)  { benchmarkDot(b, false) }
+func BenchmarkDotSIMD(b *testing.B)     { benchmarkDot(b, true) }
+func BenchmarkAxpyGeneric(b *testing.B) { benchmarkAxpy(b, false) }
+func BenchmarkAxpySIMD(b *testing.B)    { benchmarkAxpy(b, true) }
@@ -1,5 +1,16 @@
 package layers
 
+import "github.com/ofauchon/go-cnn/cnn/precision"
+
+func make2D[T any](d1, d2 int) [][]T {
+
+	x := make([][]T, d1)
+	for i1 := 0; i1 < d1; i1++ {
+		x[i1] = make([]T, d2)
+	}
+	return x
+}
+
 func make3D[T any](d1, d2, d3 int) [][][]T {
 
 	x := make([][][]T, d1)
@@ -24,36 +35,86 @@ func make4D[T any](d1, d2, d3, d4 int) [][][][]T {
 }
 
 // Helper function to clone 3D Matrix
-func clone3D(data [][][]float32) [][][]float32 {
-	clonedInput := make([][][]float32, len(data))
+func clone3D(data [][][]precision.Float) [][][]precision.Float {
+	clonedInput := make([][][]precision.Float, len(data))
 	for i := range data {
-		clonedInput[i] = make([][]float32, len(data[i]))
+		clonedInput[i] = make([][]precision.Float, len(data[i]))
 		for j := range data[i] {
-			clonedInput[i][j] = make([]float32, len(data[i][j]))
+			clonedInput[i][j] = make([]precision.Float, len(data[i][j]))
 			copy(clonedInput[i][j], data[i][j])
 		}
 	}
 	return clonedInput
 }
 
-// Helper function to clone 4D Matrix
-func clone4D(data [][][][]float32) [][][][]float32 {
-	clonedKernels := make([][][][]float32, len(data))
-	for i := range data {
-		clonedKernels[i] = make([][][]float32, len(data[i]))
-		for j := range data[i] {
-			clonedKernels[i][j] = make([][]float32, len(data[i][j]))
-			for k := range data[i][j] {
-				clonedKernels[i][j][k] = make([]float32, len(data[i][j][k]))
-				copy(clonedKernels[i][j][k], data[i][j][k])
+// copy3D copies src into dst and returns it. dst is reused when it has the
+// same shape as src, otherwise a clone of src is returned.
+func copy3D(dst, src [][][]precision.Float) [][][]precision.Float {
+	if !sameShape3D(dst, src) {
+		return clone3D(src)
+	}
+	for i := range src {
+		for j := range src[i] {
+			copy(dst[i][j], src[i][j])
+		}
+	}
+	return dst
+}
+
+// resize3D returns x if it has the given shape, or a new volume of that shape
+func resize3D(x [][][]precision.Float, d1, d2, d3 int) [][][]precision.Float {
+	if len(x) == d1 && len(x[0]) == d2 && len(x[0][0]) == d3 {
+		return x
+	}
+	return make3D[precision.Float](d1, d2, d3)
+}
+
+// sameShape3D tells whether a and b have the same dimensions
+func sameShape3D(a, b [][][]precision.Float) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if len(a[i][j]) != len(b[i][j]) {
+				return false
 			}
 		}
 	}
-	return clonedKernels
+	return true
+}
+
+// zero clears x and returns it
+func zero(x []precision.Float) []precision.Float {
+	for i := range x {
+		x[i] = 0
+	}
+	return x
+}
+
+// fill sets every value of the rows of x to v
+func fill(x [][]precision.Float, v precision.Float) {
+	for _, row := range x {
+		for i := range row {
+			row[i] = v
+		}
+	}
+}
+
+// zero3D clears a 3D matrix
+func zero3D(x [][][]precision.Float) {
+	for _, m := range x {
+		for _, row := range m {
+			zero(row)
+		}
+	}
 }
 
-// Helper function to find the maximum of two float32 values
-func max(a, b float32) float32 {
+// Helper function to find the maximum of two values
+func max(a, b precision.Float) precision.Float {
 	if a > b {
 		return a
 	}
@@ -0,0 +1,58 @@
+package cnn
+
+import (
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Losses supported by CNN.Loss
+const (
+	LossMSE = "mse" // Mean squared error
+	LossBCE = "bce" // Binary cross-entropy, one independent sigmoid per class
+)
+
+// lossEpsilon keeps the cross-entropy derivative finite on saturated outputs
+const lossEpsilon = 1e-7
+
+// lossDerivative returns the derivative of the per-output loss with respect
+// to the output activation, before averaging over the outputs
+func lossDerivative(loss string, output, desired precision.Float) precision.Float {
+	switch loss {
+	case LossBCE:
+		denominator := output * (1 - output)
+		if denominator < lossEpsilon {
+			denominator = lossEpsilon
+		}
+		return (output - desired) / denominator
+	default:
+		return 2 * (output - desired)
+	}
+}
+
+// lossValue returns the per-output loss, whose derivative is lossDerivative
+func lossValue(loss string, output, desired precision.Float) float64 {
+	switch loss {
+	case LossBCE:
+		o := math.Min(math.Max(float64(output), lossEpsilon), 1-lossEpsilon)
+		return -(float64(desired)*math.Log(o) + float64(1-desired)*math.Log(1-o))
+	default:
+		d := float64(output - desired)
+		return d * d
+	}
+}
+
+// TargetLoss returns the loss of an output vector against a target vector,
+// averaged over the outputs. TargetError returns its derivative.
+func (c *CNN) TargetLoss(output, target []precision.Float) float64 {
+	sum := 0.0
+	for i, desired := range target {
+		sum += lossValue(c.Loss, output[i], desired)
+	}
+	return sum / float64(len(target))
+}
+
+// validLoss tells whether loss names a supported loss
+func validLoss(loss string) bool {
+	return loss == "" || loss == LossMSE || loss == LossBCE
+}
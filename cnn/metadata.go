@@ -0,0 +1,124 @@
+package cnn
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+
+	"github.com/nfnt/resize"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Channel orders supported by Metadata.ChannelOrder
+const (
+	ChannelsGray = "L"
+	ChannelsRGB  = "RGB"
+	ChannelsBGR  = "BGR"
+)
+
+// Metadata describes the input a model expects and how to name its outputs.
+// It is saved alongside the layers so that a loaded model can preprocess
+// raw images exactly like the training code did.
+type Metadata struct {
+	InputShape   []int             // Depth, height and width of the input volume
+	ChannelOrder string            // Order of the input channels (L, RGB or BGR)
+	Scale        precision.Float   // Factor applied to 8-bit pixel values, e.g. 1/255
+	Mean         []precision.Float // Per-channel value subtracted after scaling (optional)
+	Std          []precision.Float // Per-channel value dividing after mean subtraction (optional)
+	Labels       []string          // Class names, indexed by output neuron (optional)
+}
+
+// Preprocess converts an image into the input volume expected by the model.
+// The image is resized to the input shape when needed, split into channels
+// and normalized: value = (pixel * Scale - Mean[c]) / Std[c]
+func (m *Metadata) Preprocess(img image.Image) ([][][]precision.Float, error) {
+	if len(m.InputShape) != 3 {
+		return nil, errors.New("cnn: model metadata has no input shape")
+	}
+	depth, height, width := m.InputShape[0], m.InputShape[1], m.InputShape[2]
+
+	channels := m.ChannelOrder
+	if channels == "" {
+		channels = ChannelsGray
+	}
+	if channels != ChannelsGray && channels != ChannelsRGB && channels != ChannelsBGR {
+		return nil, fmt.Errorf("cnn: unknown channel order %q", channels)
+	}
+	if (channels == ChannelsGray && depth != 1) || (channels != ChannelsGray && depth != 3) {
+		return nil, fmt.Errorf("cnn: channel order %q does not match input depth %d", channels, depth)
+	}
+	if (len(m.Mean) != 0 && len(m.Mean) != depth) || (len(m.Std) != 0 && len(m.Std) != depth) {
+		return nil, errors.New("cnn: normalization mean/std do not match input depth")
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() != width || bounds.Dy() != height {
+		img = resize.Resize(uint(width), uint(height), img, resize.Bilinear)
+		bounds = img.Bounds()
+	}
+
+	scale := m.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	input := make([][][]precision.Float, depth)
+	for c := range input {
+		input[c] = make([][]precision.Float, height)
+		for y := range input[c] {
+			input[c][y] = make([]precision.Float, width)
+		}
+	}
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			pixel := img.At(bounds.Min.X+x, bounds.Min.Y+y)
+
+			var values [3]precision.Float
+			if channels == ChannelsGray {
+				values[0] = precision.Float(color.GrayModel.Convert(pixel).(color.Gray).Y)
+			} else {
+				r, g, b, _ := pixel.RGBA()
+				values = [3]precision.Float{precision.Float(r >> 8), precision.Float(g >> 8), precision.Float(b >> 8)}
+				if channels == ChannelsBGR {
+					values[0], values[2] = values[2], values[0]
+				}
+			}
+
+			for c := 0; c < depth; c++ {
+				v := values[c] * scale
+				if len(m.Mean) != 0 {
+					v -= m.Mean[c]
+				}
+				if len(m.Std) != 0 && m.Std[c] != 0 {
+					v /= m.Std[c]
+				}
+				input[c][y][x] = v
+			}
+		}
+	}
+
+	return input, nil
+}
+
+// Label returns the name of a class, or its index when the model has no labels
+func (m *Metadata) Label(class int) string {
+	if class >= 0 && class < len(m.Labels) {
+		return m.Labels[class]
+	}
+	return fmt.Sprint(class)
+}
+
+// PredictImage preprocesses a raw image according to the model metadata,
+// runs it through the network and returns the most likely class along with
+// the normalized output vector
+func (c *CNN) PredictImage(img image.Image) (int, []precision.Float, error) {
+	input, err := c.Metadata.Preprocess(img)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return c.Predict(input)
+}
@@ -0,0 +1,124 @@
+package cnn
+
+import (
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Optimizer applies the gradients accumulated by backpropagation to the parameters
+type Optimizer interface {
+	// Step updates the parameters from their gradients. It is always
+	// called with the parameters of the same network, in the same order.
+	Step(params []layers.Param)
+}
+
+// Trainable is implemented by layers holding trainable parameters
+type Trainable interface {
+	Params() []layers.Param
+}
+
+// SGD is stochastic gradient descent with optional momentum
+type SGD struct {
+	LearningRate precision.Float
+	Momentum     precision.Float
+
+	velocity [][][]precision.Float
+}
+
+// NewSGD creates a new SGD optimizer
+func NewSGD(learningRate, momentum precision.Float) *SGD {
+	return &SGD{LearningRate: learningRate, Momentum: momentum}
+}
+
+// Step performs one gradient descent step. The velocity is reset when the
+// shape of the parameters changes, as when a pruned network is shrunk.
+func (o *SGD) Step(params []layers.Param) {
+	if o.Momentum != 0 && !o.fits(params) {
+		o.velocity = make([][][]precision.Float, len(params))
+		for i, p := range params {
+			o.velocity[i] = make([][]precision.Float, len(p.Values))
+			for j, row := range p.Values {
+				o.velocity[i][j] = make([]precision.Float, len(row))
+			}
+		}
+	}
+
+	for i, p := range params {
+		for j, row := range p.Values {
+			grads := p.Grads[j]
+			if o.Momentum == 0 {
+				for k := range row {
+					row[k] -= o.LearningRate * grads[k]
+				}
+				continue
+			}
+
+			velocity := o.velocity[i][j]
+			for k := range row {
+				velocity[k] = o.Momentum*velocity[k] - o.LearningRate*grads[k]
+				row[k] += velocity[k]
+			}
+		}
+	}
+}
+
+// fits tells whether the velocity has the shape of params
+func (o *SGD) fits(params []layers.Param) bool {
+	if len(o.velocity) != len(params) {
+		return false
+	}
+	for i, p := range params {
+		if len(o.velocity[i]) != len(p.Values) {
+			return false
+		}
+		for j, row := range p.Values {
+			if len(o.velocity[i][j]) != len(row) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Params returns the trainable parameters of every layer, in forward order
+func (c *CNN) Params() []layers.Param {
+	return c.appendParams(nil)
+}
+
+// appendParams appends the trainable parameters of every layer to params
+func (c *CNN) appendParams(params []layers.Param) []layers.Param {
+	for _, layer := range c.Layers {
+		if t, ok := layer.(Trainable); ok {
+			params = append(params, t.Params()...)
+		}
+	}
+	return params
+}
+
+// Update applies the accumulated gradients with the network optimizer and
+// clears them. The regularization penalties and clipping apply to the
+// gradients before the step, the max-norm constraint to the parameters after.
+func (c *CNN) Update() {
+	if c.Optimizer == nil {
+		c.Optimizer = NewSGD(layers.DefaultLearningRate, 0)
+	}
+
+	// The parameter list is rebuilt in place, so that layers may change
+	// between steps without allocating a new list every time
+	c.params = c.appendParams(c.params[:0])
+
+	// Pruned parameters get no gradient and stay at zero whatever the
+	// optimizer does with them
+	layers.ApplyMasks(c.params)
+	if c.Diagnostics != nil {
+		c.Diagnostics.beforeStep(c.Layers)
+	}
+	c.Regularization.penalize(c.params)
+	c.Optimizer.Step(c.params)
+	c.Regularization.constrain(c.Layers)
+	layers.ApplyMasks(c.params)
+	if c.Diagnostics != nil {
+		c.Diagnostics.afterStep(c.Layers)
+	}
+	layers.ZeroGrads(c.params)
+}
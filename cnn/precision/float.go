@@ -0,0 +1,12 @@
+//go:build !cnn_float64
+
+package precision
+
+// Float is the type networks compute with. Build with the cnn_float64 tag
+// to compute in float64, for gradient checks or scientific use. The tag
+// applies to the whole program: one binary cannot run float32 and float64
+// networks side by side.
+type Float = float32
+
+// Native is the storage type of Float, which keeps parameters exactly
+const Native = Float32
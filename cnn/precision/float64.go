@@ -0,0 +1,9 @@
+//go:build cnn_float64
+
+package precision
+
+// Float is the type networks compute with, float64 in this build
+type Float = float64
+
+// Native is the storage type of Float, which keeps parameters exactly
+const Native = Float64
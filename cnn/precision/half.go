@@ -0,0 +1,85 @@
+package precision
+
+import "math"
+
+// Float16Bits is an IEEE 754 half precision number
+type Float16Bits uint16
+
+// BFloat16Bits is a bfloat16 number, the upper half of a float32
+type BFloat16Bits uint16
+
+// ToFloat16 rounds x to the nearest half precision number, ties to even.
+// Values too large for half precision become infinities.
+func ToFloat16(x float32) Float16Bits {
+	bits := math.Float32bits(x)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	if exp == 0xff {
+		if mant != 0 {
+			return Float16Bits(sign | 0x7e00) // Quiet NaN
+		}
+		return Float16Bits(sign | 0x7c00)
+	}
+
+	e := exp - 127 + 15
+	switch {
+	case e >= 0x1f:
+		return Float16Bits(sign | 0x7c00)
+	case e <= 0:
+		// Subnormal result: the implicit leading bit becomes explicit
+		if e < -10 {
+			return Float16Bits(sign)
+		}
+		return Float16Bits(sign | uint16(roundShift(mant|0x800000, uint(14-e))))
+	}
+
+	// A carry out of the mantissa correctly increments the exponent
+	return Float16Bits(sign | uint16(roundShift(uint32(e)<<23|mant, 13)))
+}
+
+// roundShift returns x >> shift rounded to the nearest integer, ties to even
+func roundShift(x uint32, shift uint) uint32 {
+	result := x >> shift
+	rem := x & (1<<shift - 1)
+	half := uint32(1) << (shift - 1)
+	if rem > half || rem == half && result&1 == 1 {
+		result++
+	}
+	return result
+}
+
+// Float32 returns the value of h
+func (h Float16Bits) Float32() float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h) & 0x3ff
+
+	switch exp {
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	case 0:
+		// Zero or subnormal: mant * 2^-24
+		v := float32(mant) / (1 << 24)
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | mant<<13)
+}
+
+// ToBFloat16 rounds x to the nearest bfloat16 number, ties to even
+func ToBFloat16(x float32) BFloat16Bits {
+	bits := math.Float32bits(x)
+	if x != x {
+		return BFloat16Bits(bits>>16 | 0x40) // Keep NaNs quiet after truncation
+	}
+	return BFloat16Bits(roundShift(bits, 16))
+}
+
+// Float32 returns the value of b
+func (b BFloat16Bits) Float32() float32 {
+	return math.Float32frombits(uint32(b) << 16)
+}
@@ -0,0 +1,101 @@
+// Package precision defines Float, the type networks compute with, and
+// converts parameters between it and the types they can be stored in:
+// float32, float64 for lossless archival and float16 or bfloat16 to halve
+// the size of deployed models.
+package precision
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Type is a storage type for parameters
+type Type string
+
+// Storage types. Computations happen in Float; values stored in a narrower
+// type are widened when loaded.
+const (
+	Float32  Type = "float32"
+	Float64  Type = "float64"
+	Float16  Type = "float16"  // IEEE 754 half precision: 5 exponent bits, 10 mantissa bits
+	BFloat16 Type = "bfloat16" // float32 truncated to 16 bits: 8 exponent bits, 7 mantissa bits
+)
+
+// Parse returns the storage type named s. An empty name is Float32.
+func Parse(s string) (Type, error) {
+	switch t := Type(s); t {
+	case "":
+		return Float32, nil
+	case Float32, Float64, Float16, BFloat16:
+		return t, nil
+	}
+	return "", fmt.Errorf("precision: unknown type %q", s)
+}
+
+// Size returns the number of bytes of one value
+func (t Type) Size() int {
+	switch t {
+	case Float64:
+		return 8
+	case Float16, BFloat16:
+		return 2
+	}
+	return 4
+}
+
+// Round returns x after a round trip through the storage type
+func Round(t Type, x Float) Float {
+	switch t {
+	case Float16:
+		return Float(ToFloat16(float32(x)).Float32())
+	case BFloat16:
+		return Float(ToBFloat16(float32(x)).Float32())
+	case Float64:
+		return x
+	}
+	return Float(float32(x))
+}
+
+// Encode stores values as little-endian numbers of the given type
+func Encode(t Type, values []Float) []byte {
+	size := t.Size()
+	data := make([]byte, len(values)*size)
+	for i, v := range values {
+		b := data[i*size:]
+		switch t {
+		case Float64:
+			binary.LittleEndian.PutUint64(b, math.Float64bits(float64(v)))
+		case Float16:
+			binary.LittleEndian.PutUint16(b, uint16(ToFloat16(float32(v))))
+		case BFloat16:
+			binary.LittleEndian.PutUint16(b, uint16(ToBFloat16(float32(v))))
+		default:
+			binary.LittleEndian.PutUint32(b, math.Float32bits(float32(v)))
+		}
+	}
+	return data
+}
+
+// Decode reads values written by Encode
+func Decode(t Type, data []byte) ([]Float, error) {
+	size := t.Size()
+	if len(data)%size != 0 {
+		return nil, fmt.Errorf("precision: %d bytes is not a whole number of %s values", len(data), t)
+	}
+	values := make([]Float, len(data)/size)
+	for i := range values {
+		b := data[i*size:]
+		switch t {
+		case Float64:
+			values[i] = Float(math.Float64frombits(binary.LittleEndian.Uint64(b)))
+		case Float16:
+			values[i] = Float(Float16Bits(binary.LittleEndian.Uint16(b)).Float32())
+		case BFloat16:
+			values[i] = Float(BFloat16Bits(binary.LittleEndian.Uint16(b)).Float32())
+		default:
+			values[i] = Float(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,96 @@
+package precision
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFloat16(t *testing.T) {
+	for _, tc := range []struct {
+		x    float32
+		bits Float16Bits
+	}{
+		{0, 0x0000},
+		{float32(math.Copysign(0, -1)), 0x8000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{0.5, 0x3800},
+		{65504, 0x7bff},                // Largest finite value
+		{65520, 0x7c00},                // Rounds to infinity
+		{float32(math.Inf(1)), 0x7c00}, //
+		{1.0 / (1 << 24), 0x0001},      // Smallest subnormal
+		{1.0 / (1 << 25), 0x0000},      // Tie, rounds to even zero
+		{1.5 / (1 << 24), 0x0002},      // Tie, rounds to even 2
+		{1.0 / (1 << 14), 0x0400},      // Smallest normal
+		{1 + 1.0/(1<<11), 0x3c00},      // Tie, rounds down to even
+		{1 + 3.0/(1<<11), 0x3c02},      // Tie, rounds up to even
+		{1 + 1.0/(1<<11) + 1.0/(1<<20), 0x3c01},
+	} {
+		if got := ToFloat16(tc.x); got != tc.bits {
+			t.Errorf("ToFloat16(%g) = %#04x, expected %#04x", tc.x, got, tc.bits)
+		}
+	}
+
+	if got := ToFloat16(float32(math.NaN())).Float32(); got == got {
+		t.Errorf("NaN converted to %g", got)
+	}
+}
+
+func TestFloat16RoundTrip(t *testing.T) {
+	// Every finite half precision value survives a round trip through float32
+	for bits := 0; bits < 1<<16; bits++ {
+		h := Float16Bits(bits)
+		if bits&0x7c00 == 0x7c00 && bits&0x3ff != 0 {
+			continue // NaN
+		}
+		if got := ToFloat16(h.Float32()); got != h {
+			t.Fatalf("%#04x converted to %g and back to %#04x", bits, h.Float32(), got)
+		}
+	}
+}
+
+func TestBFloat16(t *testing.T) {
+	for _, tc := range []struct {
+		x    float32
+		bits BFloat16Bits
+	}{
+		{1, 0x3f80},
+		{-2, 0xc000},
+		{1 + 1.0/(1<<8), 0x3f80}, // Tie, rounds down to even
+		{1 + 3.0/(1<<8), 0x3f82}, // Tie, rounds up to even
+		{float32(math.Inf(-1)), 0xff80},
+		{math.MaxFloat32, 0x7f80},
+	} {
+		if got := ToBFloat16(tc.x); got != tc.bits {
+			t.Errorf("ToBFloat16(%g) = %#04x, expected %#04x", tc.x, got, tc.bits)
+		}
+	}
+
+	if got := ToBFloat16(float32(math.NaN())).Float32(); got == got {
+		t.Errorf("NaN converted to %g", got)
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	values := []Float{0, 1, -0.333, 1e-3, 1234.5}
+	for _, typ := range []Type{Float32, Float64, Float16, BFloat16} {
+		data := Encode(typ, values)
+		if len(data) != len(values)*typ.Size() {
+			t.Errorf("%s: encoded %d values in %d bytes", typ, len(values), len(data))
+		}
+
+		decoded, err := Decode(typ, data)
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		for i, v := range values {
+			if decoded[i] != Round(typ, v) {
+				t.Errorf("%s: %g decoded as %g, expected %g", typ, v, decoded[i], Round(typ, v))
+			}
+		}
+	}
+
+	if _, err := Decode(Float16, []byte{1, 2, 3}); err == nil {
+		t.Error("Decode accepted a truncated value")
+	}
+}
@@ -0,0 +1,199 @@
+package cnn
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Prediction is one scored class returned by the Predict helpers
+type Prediction struct {
+	Class       int             `json:"class"`       // Index of the output neuron
+	Label       string          `json:"label"`       // Class name from the model metadata, or the index
+	Probability precision.Float `json:"probability"` // Output normalized so that all classes sum to 1
+}
+
+// Predict runs an input volume through the network and returns the most
+// likely class along with the normalized output vector.
+// It returns an error instead of panicking when the input does not have the
+// shape expected by the first layer.
+// Predict and the other prediction helpers only read the network, so a
+// single loaded model can serve many goroutines concurrently.
+func (c *CNN) Predict(input [][][]precision.Float) (int, []precision.Float, error) {
+	if err := c.CheckInput(input); err != nil {
+		return 0, nil, err
+	}
+
+	probs := c.normalize(c.Infer(input))
+	return Argmax(probs), probs, nil
+}
+
+// PredictTopK returns the k most likely classes for an input volume,
+// highest probability first
+func (c *CNN) PredictTopK(input [][][]precision.Float, k int) ([]Prediction, error) {
+	_, probs, err := c.Predict(input)
+	if err != nil {
+		return nil, err
+	}
+	return c.topK(probs, k), nil
+}
+
+// PredictBatch returns the most likely class of every input volume
+func (c *CNN) PredictBatch(inputs [][][][]precision.Float) ([]Prediction, error) {
+	topK, err := c.PredictTopKBatch(inputs, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	predictions := make([]Prediction, len(topK))
+	for i := range topK {
+		predictions[i] = topK[i][0]
+	}
+	return predictions, nil
+}
+
+// PredictTopKBatch returns the k most likely classes of every input volume.
+// Inputs are classified in parallel using the layers worker pool.
+func (c *CNN) PredictTopKBatch(inputs [][][][]precision.Float, k int) ([][]Prediction, error) {
+	for i, input := range inputs {
+		if err := c.CheckInput(input); err != nil {
+			return nil, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+
+	predictions := make([][]Prediction, len(inputs))
+	layers.ParallelFor(len(inputs), 1, func(start, end int) {
+		for i := start; i < end; i++ {
+			predictions[i] = c.topK(c.normalize(c.Infer(inputs[i])), k)
+		}
+	})
+	return predictions, nil
+}
+
+// Argmax returns the index of the highest value in the output vector
+func Argmax(output []precision.Float) int {
+	index := 0
+	for i := range output {
+		if output[i] > output[index] {
+			index = i
+		}
+	}
+	return index
+}
+
+// CheckInput verifies that the network can process the input volume, as
+// the prediction helpers do before computing anything. A fully
+// convolutional network, see Resizable, takes inputs of any height and
+// width which its windows fit in; other networks take inputs of the shape
+// of their first layer.
+func (c *CNN) CheckInput(input [][][]precision.Float) error {
+	if len(c.Layers) == 0 {
+		return errors.New("cnn: network has no layers")
+	}
+
+	shape := c.Layers[0].InputShape()
+	resizable := c.resizable()
+	if len(input) != shape[0] {
+		return fmt.Errorf("cnn: input depth is %d, expected %d", len(input), shape[0])
+	}
+	if resizable && len(input) > 0 && len(input[0]) > 0 {
+		shape = []int{len(input), len(input[0]), len(input[0][0])}
+	}
+	for d := range input {
+		if len(input[d]) != shape[1] {
+			return fmt.Errorf("cnn: input height is %d in channel %d, expected %d", len(input[d]), d, shape[1])
+		}
+		for y := range input[d] {
+			if len(input[d][y]) != shape[2] {
+				return fmt.Errorf("cnn: input width is %d in channel %d row %d, expected %d", len(input[d][y]), d, y, shape[2])
+			}
+		}
+	}
+	if !resizable {
+		return nil
+	}
+
+	for i, layer := range c.Layers {
+		next := layer.(Resizable).OutputShapeFor(shape)
+		if next == nil {
+			return fmt.Errorf("cnn: layer %d (%s) cannot process a %dx%dx%d input", i, LayerName(layer), shape[0], shape[1], shape[2])
+		}
+		shape = next
+	}
+	return nil
+}
+
+// resizable tells whether every layer of the network is Resizable
+func (c *CNN) resizable() bool {
+	for _, layer := range c.Layers {
+		if _, ok := layer.(Resizable); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// topK returns the k highest scored classes of a probability vector
+func (c *CNN) topK(probs []precision.Float, k int) []Prediction {
+	predictions := make([]Prediction, len(probs))
+	for i, p := range probs {
+		predictions[i] = Prediction{Class: i, Label: c.Metadata.Label(i), Probability: p}
+	}
+
+	sort.SliceStable(predictions, func(i, j int) bool {
+		return predictions[i].Probability > predictions[j].Probability
+	})
+
+	if k < 1 {
+		k = 1
+	}
+	if k > len(predictions) {
+		k = len(predictions)
+	}
+	return predictions[:k]
+}
+
+// normalize turns the output activations into probabilities summing to 1.
+// The sigmoid outputs of a fully connected last layer are independent and
+// positive, so they are scaled by their sum, which gives a comparable score
+// per class; an all-zero output yields a uniform vector. Other last layers,
+// such as global pooling, give unbounded scores which go through a softmax.
+func (c *CNN) normalize(output []precision.Float) []precision.Float {
+	if _, ok := c.Layers[len(c.Layers)-1].(*layers.FullyConnectedLayer); !ok {
+		return softmax(output)
+	}
+
+	probs := make([]precision.Float, len(output))
+	sum := precision.Float(0)
+	for _, v := range output {
+		sum += v
+	}
+	for i, v := range output {
+		if sum > 0 {
+			probs[i] = v / sum
+		} else {
+			probs[i] = 1 / precision.Float(len(output))
+		}
+	}
+	return probs
+}
+
+// softmax returns the exponentials of the scores divided by their sum,
+// shifted by the highest score so that they cannot overflow
+func softmax(scores []precision.Float) []precision.Float {
+	probs := make([]precision.Float, len(scores))
+	highest := scores[Argmax(scores)]
+	sum := precision.Float(0)
+	for i, v := range scores {
+		probs[i] = precision.Float(math.Exp(float64(v - highest)))
+		sum += probs[i]
+	}
+	for i := range probs {
+		probs[i] /= sum
+	}
+	return probs
+}
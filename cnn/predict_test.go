@@ -0,0 +1,156 @@
+package cnn
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func make3D(d1, d2, d3 int) [][][]precision.Float {
+	x := make([][][]precision.Float, d1)
+	for i := range x {
+		x[i] = make([][]precision.Float, d2)
+		for j := range x[i] {
+			x[i][j] = make([]precision.Float, d3)
+		}
+	}
+	return x
+}
+
+func TestPredictRejectsWrongShape(t *testing.T) {
+	cn := newTestCNN()
+
+	for _, input := range [][][][]precision.Float{
+		make3D(2, 8, 8),
+		make3D(1, 7, 8),
+		make3D(1, 8, 9),
+		nil,
+	} {
+		if _, _, err := cn.Predict(input); err == nil {
+			t.Errorf("Predict accepted an input of depth %d", len(input))
+		}
+	}
+
+	if _, _, err := NewCNN().Predict(make3D(1, 8, 8)); err == nil {
+		t.Errorf("Predict on an empty network should fail")
+	}
+}
+
+func TestPredictTopK(t *testing.T) {
+	cn := newTestCNN()
+	input := make3D(1, 8, 8)
+	input[0][3][4] = 1
+
+	class, probs, err := cn.Predict(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top, err := cn.PredictTopK(input, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(top) != 3 || top[0].Class != class || top[0].Label != cn.Metadata.Labels[class] {
+		t.Errorf("PredictTopK disagrees with Predict: %+v, class %d", top, class)
+	}
+	for i := 1; i < len(top); i++ {
+		if top[i].Probability > top[i-1].Probability {
+			t.Errorf("PredictTopK is not sorted: %+v", top)
+		}
+	}
+
+	sum := precision.Float(0)
+	for _, p := range probs {
+		sum += p
+	}
+	if sum < 0.999 || sum > 1.001 {
+		t.Errorf("Probabilities sum to %f", sum)
+	}
+
+	batch, err := cn.PredictBatch([][][][]precision.Float{input, input})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 2 || batch[1].Class != class {
+		t.Errorf("PredictBatch returned %+v, expected class %d twice", batch, class)
+	}
+}
+
+func TestPredictSoftmaxForScores(t *testing.T) {
+	// The channels of the global pooling layer are scores, not sigmoids
+	cn := NewCNN()
+	cn.AddConvLayer(8, 1, 3, 1, 1)
+	cn.AddGlobalAvgPoolingLayer(3, 8, 8)
+	conv := cn.Layers[0].(*layers.ConvLayer)
+	for f := range conv.Kernels {
+		conv.Kernels[f][0][0][0] = 0
+	}
+	copy(conv.Biases, []precision.Float{2, 1, 0})
+
+	_, probs, err := cn.Predict(make3D(1, 8, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := math.Exp(2) + math.Exp(1) + 1
+	for i, expected := range []float64{math.Exp(2) / sum, math.Exp(1) / sum, 1 / sum} {
+		if math.Abs(float64(probs[i])-expected) > 1e-5 {
+			t.Fatalf("probabilities %v, expected the softmax of the scores", probs)
+		}
+	}
+}
+
+func TestPredictFullyConvolutionalSizes(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"input": {"shape": [2, 8, 8]}, "layers": [
+		{"type": "conv", "filters": 4, "kernelSize": 3}, {"type": "maxpool", "poolSize": 2},
+		{"type": "conv", "filters": 3, "kernelSize": 1}, {"type": "globalavgpool"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A constant image gives the same averages at any size
+	constant := func(height, width int) [][][]precision.Float {
+		input := make3D(2, height, width)
+		for c := range input {
+			for y := range input[c] {
+				for x := range input[c][y] {
+					input[c][y][x] = precision.Float(c) - 0.5
+				}
+			}
+		}
+		return input
+	}
+	_, expected, err := cn.Predict(constant(8, 8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, probs, err := cn.Predict(constant(13, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range expected {
+		if math.Abs(float64(probs[i]-expected[i])) > 1e-5 {
+			t.Fatalf("probabilities %v on a 13x10 input, expected %v as on 8x8", probs, expected)
+		}
+	}
+
+	for _, input := range [][][][]precision.Float{make3D(2, 3, 8), make3D(1, 12, 12)} {
+		if _, _, err := cn.Predict(input); err == nil {
+			t.Errorf("Predict accepted a %dx%dx%d input", len(input), len(input[0]), len(input[0][0]))
+		}
+	}
+}
+
+func TestArgmax(t *testing.T) {
+	if i := Argmax([]precision.Float{0, 0, 0}); i != 0 {
+		t.Errorf("Argmax of zeros is %d, expected 0", i)
+	}
+	if i := Argmax([]precision.Float{-3, -1, -2}); i != 1 {
+		t.Errorf("Argmax of negative values is %d, expected 1", i)
+	}
+}
@@ -0,0 +1,261 @@
+// Package prune removes the least important weights of a trained network.
+// Unstructured pruning zeroes individual weights of small magnitude;
+// structured pruning zeroes whole convolution filters, which Shrink then
+// removes along with the matching input channels of the next layer,
+// producing a smaller and faster model, and whole inputs of fully
+// connected layers. Pruned weights are masked so that
+// training keeps them at zero, which allows pruning gradually while
+// fine-tuning (see Pruner).
+package prune
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Method selects what is pruned
+type Method string
+
+const (
+	Unstructured Method = "unstructured" // Individual weights of smallest magnitude
+	Structured   Method = "structured"   // Whole filters or inputs of smallest L1 norm
+)
+
+// Prune masks the given fraction of the weights of every convolution and
+// fully connected layer. Sparsity is reached layer by layer, so that no
+// layer is emptied. Structured pruning masks whole filters of convolutions
+// and whole inputs of fully connected layers, that is all the weights
+// leaving a neuron of the previous layer; the outputs of a fully connected
+// layer are kept, since the last one gives the classes. At least one filter
+// or input of every layer is kept.
+// Masks only grow: weights pruned earlier stay pruned.
+func Prune(c *cnn.CNN, method Method, sparsity float64) error {
+	if sparsity < 0 || sparsity >= 1 {
+		return fmt.Errorf("prune: sparsity %g is not in [0, 1)", sparsity)
+	}
+	if method != Unstructured && method != Structured {
+		return fmt.Errorf("prune: unknown method %q", method)
+	}
+
+	for _, layer := range c.Layers {
+		switch l := layer.(type) {
+		case *layers.ConvLayer:
+			l.InitMasks()
+			if method == Structured {
+				pruneFilters(l, sparsity)
+			} else {
+				pruneSmallest(rows4D(l.Kernels), rows4D(l.KernelMask), sparsity)
+			}
+		case *layers.FullyConnectedLayer:
+			l.InitMasks()
+			if method == Structured {
+				pruneInputs(l, sparsity)
+			} else {
+				pruneSmallest(l.Weights, l.WeightMask, sparsity)
+			}
+		}
+	}
+	layers.ApplyMasks(c.Params())
+	return nil
+}
+
+// Sparsity returns the fraction of the convolution kernels and fully
+// connected weights of a network which are zero
+func Sparsity(c *cnn.CNN) float64 {
+	zeros, total := 0, 0
+	count := func(rows [][]precision.Float) {
+		for _, row := range rows {
+			for _, w := range row {
+				if w == 0 {
+					zeros++
+				}
+			}
+			total += len(row)
+		}
+	}
+	for _, layer := range c.Layers {
+		switch l := layer.(type) {
+		case *layers.ConvLayer:
+			count(rows4D(l.Kernels))
+		case *layers.FullyConnectedLayer:
+			count(l.Weights)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(zeros) / float64(total)
+}
+
+// pruneSmallest masks the values of smallest magnitude until the given
+// fraction of them is masked
+func pruneSmallest(values, mask [][]precision.Float, sparsity float64) {
+	type entry struct {
+		row, col  int
+		magnitude precision.Float
+	}
+	var entries []entry
+	for i, row := range values {
+		for j, w := range row {
+			magnitude := precision.Float(math.Abs(float64(w)))
+			if mask[i][j] == 0 {
+				magnitude = -1 // Already pruned
+			}
+			entries = append(entries, entry{i, j, magnitude})
+		}
+	}
+
+	// A stable sort keeps pruning deterministic between equal weights
+	sort.SliceStable(entries, func(a, b int) bool { return entries[a].magnitude < entries[b].magnitude })
+	for _, e := range entries[:int(sparsity*float64(len(entries)))] {
+		mask[e.row][e.col] = 0
+	}
+}
+
+// pruneFilters masks the filters of smallest L1 norm, with their bias,
+// until the given fraction of them is masked
+func pruneFilters(l *layers.ConvLayer, sparsity float64) {
+	norms := make([]float64, l.NumFilters)
+	order := make([]int, l.NumFilters)
+	for f := range norms {
+		order[f] = f
+		if l.BiasMask[f] == 0 {
+			norms[f] = -1 // Already pruned
+			continue
+		}
+		for _, row := range rows4D(l.Kernels[f : f+1]) {
+			for _, w := range row {
+				norms[f] += math.Abs(float64(w))
+			}
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return norms[order[a]] < norms[order[b]] })
+
+	n := int(sparsity * float64(l.NumFilters))
+	if n >= l.NumFilters {
+		n = l.NumFilters - 1
+	}
+	for _, f := range order[:n] {
+		for _, row := range rows4D(l.KernelMask[f : f+1]) {
+			for j := range row {
+				row[j] = 0
+			}
+		}
+		l.BiasMask[f] = 0
+	}
+}
+
+// pruneInputs masks the inputs of a fully connected layer whose weights
+// have the smallest L1 norm, until the given fraction of them is masked
+func pruneInputs(l *layers.FullyConnectedLayer, sparsity float64) {
+	norms := make([]float64, l.InputSize)
+	order := make([]int, l.InputSize)
+	for i, row := range l.Weights {
+		order[i] = i
+		if !live(l.WeightMask[i]) {
+			norms[i] = -1 // Already pruned
+			continue
+		}
+		for _, w := range row {
+			norms[i] += math.Abs(float64(w))
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool { return norms[order[a]] < norms[order[b]] })
+
+	n := int(sparsity * float64(l.InputSize))
+	if n >= l.InputSize {
+		n = l.InputSize - 1
+	}
+	for _, i := range order[:n] {
+		for j := range l.WeightMask[i] {
+			l.WeightMask[i][j] = 0
+		}
+	}
+}
+
+// live tells whether a mask keeps any value
+func live(mask []precision.Float) bool {
+	for _, m := range mask {
+		if m != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// rows4D returns the innermost rows of a 4D tensor
+func rows4D(x [][][][]precision.Float) [][]precision.Float {
+	var rows [][]precision.Float
+	for _, t := range x {
+		for _, m := range t {
+			rows = append(rows, m...)
+		}
+	}
+	return rows
+}
+
+// Pruner prunes a network gradually while it trains, following a schedule
+type Pruner struct {
+	Method   Method
+	Schedule Schedule
+}
+
+// Step prunes the network to the sparsity of the schedule when step, the
+// number of training steps done so far, is a pruning step. It returns
+// whether the network was pruned.
+func (p *Pruner) Step(c *cnn.CNN, step int) (bool, error) {
+	if !p.Schedule.Prunes(step) {
+		return false, nil
+	}
+	return true, Prune(c, p.Method, p.Schedule.Sparsity(step))
+}
+
+// Schedule increases sparsity from InitialSparsity at StartStep to
+// FinalSparsity at EndStep, pruning every Frequency steps. Sparsity follows
+// the cubic curve of Zhu and Gupta (2017): it rises quickly while many
+// weights are redundant and slowly as the network gets sparse.
+type Schedule struct {
+	InitialSparsity float64
+	FinalSparsity   float64
+	StartStep       int
+	EndStep         int
+	Frequency       int // Steps between two pruning steps, 1 if 0
+}
+
+// Sparsity returns the target sparsity at a step
+func (s Schedule) Sparsity(step int) float64 {
+	if step <= s.StartStep {
+		return s.InitialSparsity
+	}
+	if step >= s.EndStep {
+		return s.FinalSparsity
+	}
+	progress := float64(step-s.StartStep) / float64(s.EndStep-s.StartStep)
+	return s.FinalSparsity + (s.InitialSparsity-s.FinalSparsity)*math.Pow(1-progress, 3)
+}
+
+// Prunes tells whether the network is pruned at a step
+func (s Schedule) Prunes(step int) bool {
+	frequency := s.Frequency
+	if frequency < 1 {
+		frequency = 1
+	}
+	return step >= s.StartStep && step <= s.EndStep && (step-s.StartStep)%frequency == 0
+}
+
+// Validate checks that the schedule describes a gradual pruning
+func (s Schedule) Validate() error {
+	if s.InitialSparsity < 0 || s.FinalSparsity >= 1 || s.InitialSparsity > s.FinalSparsity {
+		return errors.New("prune: sparsities must satisfy 0 <= initial <= final < 1")
+	}
+	if s.StartStep < 0 || s.EndStep < s.StartStep {
+		return errors.New("prune: steps must satisfy 0 <= start <= end")
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package prune
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/internal/cnntest"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func train(c *cnn.CNN, inputs [][][][]precision.Float) {
+	for i, input := range inputs {
+		c.ForwardPropagate(input)
+		c.BackPropagate(i % 5)
+	}
+}
+
+func TestUnstructuredPruningSurvivesTraining(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := cnntest.NewCNN(8)
+	if err := Prune(c, Unstructured, 0.6); err != nil {
+		t.Fatal(err)
+	}
+	if s := Sparsity(c); s < 0.59 || s > 0.61 {
+		t.Fatalf("sparsity %g after pruning 60%%", s)
+	}
+
+	train(c, cnntest.RandomInputs(rng, 20))
+	if s := Sparsity(c); s < 0.59 {
+		t.Fatalf("sparsity %g after training, pruned weights were updated", s)
+	}
+}
+
+func TestScheduleIsGradual(t *testing.T) {
+	s := Schedule{InitialSparsity: 0.1, FinalSparsity: 0.8, StartStep: 10, EndStep: 110, Frequency: 10}
+	if err := s.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	previous := 0.0
+	for step := 0; step <= 120; step++ {
+		sparsity := s.Sparsity(step)
+		if sparsity < previous {
+			t.Fatalf("sparsity decreases at step %d", step)
+		}
+		previous = sparsity
+	}
+	if s.Sparsity(0) != 0.1 || s.Sparsity(200) != 0.8 {
+		t.Errorf("sparsity %g at start and %g at end", s.Sparsity(0), s.Sparsity(200))
+	}
+	if !s.Prunes(10) || s.Prunes(15) || !s.Prunes(110) || s.Prunes(120) {
+		t.Error("pruning steps do not follow the frequency")
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	c := cnntest.NewCNN(8)
+	p := &Pruner{Method: Unstructured, Schedule: s}
+	for step, input := range cnntest.RandomInputs(rng, 120) {
+		c.ForwardPropagate(input)
+		c.BackPropagate(step % 5)
+		if _, err := p.Step(c, step); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sparsity := Sparsity(c); math.Abs(sparsity-0.8) > 0.01 {
+		t.Errorf("sparsity %g at the end of the schedule, expected 0.8", sparsity)
+	}
+}
+
+func TestShrinkKeepsOutputs(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inputs := cnntest.RandomInputs(rng, 20)
+	c := cnntest.NewCNN(8)
+	if err := Prune(c, Structured, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	train(c, inputs)
+
+	expected := make([][]precision.Float, len(inputs))
+	for i, input := range inputs {
+		expected[i] = c.Infer(input)
+	}
+	params := 0
+	for _, layer := range c.Layers {
+		params += cnn.ParamCount(layer)
+	}
+
+	if removed := Shrink(c); removed != 4+3 {
+		t.Fatalf("%d filters removed, expected 7", removed)
+	}
+	if depth := c.Layers[2].(*layers.ConvLayer).InputDepth; depth != 4 {
+		t.Errorf("next convolution has an input depth of %d, expected 4", depth)
+	}
+	if depth := c.Layers[3].(*layers.FullyConnectedLayer).InputDepth; depth != 3 {
+		t.Errorf("fully connected layer has an input depth of %d, expected 3", depth)
+	}
+	shrunk := 0
+	for _, layer := range c.Layers {
+		shrunk += cnn.ParamCount(layer)
+	}
+	if shrunk*2 > params {
+		t.Errorf("%d parameters left out of %d", shrunk, params)
+	}
+
+	for i, input := range inputs {
+		got := c.Infer(input)
+		for j := range got {
+			if math.Abs(float64(got[j]-expected[i][j])) > 1e-5 {
+				t.Fatalf("output %v, expected %v", got, expected[i])
+			}
+		}
+	}
+
+	// The shrunk network keeps training with its masks
+	train(c, inputs)
+	if Shrink(c) != 0 {
+		t.Error("pruned filters came back to life")
+	}
+}
+
+func TestPruneRejectsInvalidArguments(t *testing.T) {
+	c := cnntest.NewCNN(8)
+	if err := Prune(c, Unstructured, 1); err == nil {
+		t.Error("Prune accepted a sparsity of 1")
+	}
+	if err := Prune(c, "random", 0.5); err == nil {
+		t.Error("Prune accepted an unknown method")
+	}
+}
+
+func TestStructuredPruningMasksWholeInputs(t *testing.T) {
+	c := cnntest.NewCNN(8)
+	if err := Prune(c, Structured, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	fc := c.Layers[len(c.Layers)-1].(*layers.FullyConnectedLayer)
+	pruned := 0
+	for i, row := range fc.Weights {
+		zeros := 0
+		for _, w := range row {
+			if w == 0 {
+				zeros++
+			}
+		}
+		if zeros == len(row) {
+			pruned++
+		} else if !live(fc.WeightMask[i]) {
+			t.Fatalf("input %d is masked but has weights", i)
+		}
+	}
+	if pruned != fc.InputSize/2 {
+		t.Errorf("%d inputs pruned out of %d, expected half", pruned, fc.InputSize)
+	}
+}
+
+func TestMasksAreSaved(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inputs := cnntest.RandomInputs(rng, 20)
+	for _, p := range []precision.Type{precision.Float32, precision.Float16} {
+		c := cnntest.NewCNN(8)
+		if err := Prune(c, Unstructured, 0.6); err != nil {
+			t.Fatal(err)
+		}
+		c.Precision = p
+		decoded := cnn.DecodeCNN(cnn.EncodeCNN(c))
+		train(&decoded, inputs)
+		if s := Sparsity(&decoded); s < 0.59 {
+			t.Errorf("%s: sparsity %g after training a loaded model, pruned weights came back", p, s)
+		}
+	}
+}
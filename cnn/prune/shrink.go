@@ -0,0 +1,192 @@
+package prune
+
+import (
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Shrink removes the filters of every convolution whose output is always
+// zero, that is filters with zero kernels and a bias of at most zero, as
+// left by structured pruning. The layers the output goes through shrink
+// with it, up to the next convolution or fully connected layer, which
+// loses the matching input channels. The network computes the same
+// outputs, with fewer parameters and operations. Filters whose output goes
+// through other layers, such as instance normalization, are kept.
+// Layers are rebuilt, so that buffers and the optimizer state are reset;
+// masks, fake quantization and the convolution algorithm are kept.
+// Shrink returns the number of filters removed.
+func Shrink(c *cnn.CNN) int {
+	removed := 0
+	for i, layer := range c.Layers {
+		conv, ok := layer.(*layers.ConvLayer)
+		if !ok {
+			continue
+		}
+		keep := liveFilters(conv)
+		if len(keep) == conv.NumFilters || !shrinkable(c.Layers[i+1:]) {
+			continue
+		}
+
+		c.Layers[i] = keepFilters(conv, keep)
+		for j := i + 1; j < len(c.Layers); j++ {
+			next, done := keepChannels(c.Layers[j], keep)
+			c.Layers[j] = next
+			if done {
+				break
+			}
+		}
+		removed += conv.NumFilters - len(keep)
+	}
+	return removed
+}
+
+// liveFilters returns the filters of a convolution whose output is not
+// always zero. At least one filter is kept.
+func liveFilters(l *layers.ConvLayer) []int {
+	var keep []int
+	for f := range l.Kernels {
+		if l.Biases[f] > 0 {
+			keep = append(keep, f)
+			continue
+		}
+	rows:
+		for _, row := range rows4D(l.Kernels[f : f+1]) {
+			for _, w := range row {
+				if w != 0 {
+					keep = append(keep, f)
+					break rows
+				}
+			}
+		}
+	}
+	if len(keep) == 0 {
+		keep = []int{0}
+	}
+	return keep
+}
+
+// shrinkable tells whether the layers following a convolution can lose
+// some of its channels: they must be pooling, fake quantization or dropout
+// layers up to a convolution or a fully connected layer
+func shrinkable(next []cnn.Layer) bool {
+	for _, layer := range next {
+		switch layer.(type) {
+		case *layers.MaxPoolingLayer, *layers.AvgPoolingLayer, *layers.GlobalAvgPoolingLayer, *layers.GlobalMaxPoolingLayer,
+			*layers.FakeQuantLayer, *layers.DropoutLayer, *layers.SpatialDropoutLayer:
+		case *layers.ConvLayer, *layers.FullyConnectedLayer:
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// keepFilters returns a copy of a convolution with only the given filters
+func keepFilters(l *layers.ConvLayer, keep []int) *layers.ConvLayer {
+	shrunk := layers.NewConvLayer(l.InputSize, l.InputDepth, len(keep), l.KernelSize, l.Stride)
+	shrunk.Algorithm, shrunk.WeightQuant = l.Algorithm, l.WeightQuant
+	if l.KernelMask != nil {
+		shrunk.InitMasks()
+	}
+	for i, f := range keep {
+		copy4D(shrunk.Kernels[i:i+1], l.Kernels[f:f+1])
+		shrunk.Biases[i] = l.Biases[f]
+		if l.KernelMask != nil {
+			copy4D(shrunk.KernelMask[i:i+1], l.KernelMask[f:f+1])
+			shrunk.BiasMask[i] = l.BiasMask[f]
+		}
+	}
+	return shrunk
+}
+
+// keepChannels returns a copy of a layer taking only the given channels of
+// its input, and whether it is the last layer to shrink
+func keepChannels(layer cnn.Layer, keep []int) (cnn.Layer, bool) {
+	switch l := layer.(type) {
+	case *layers.MaxPoolingLayer:
+		return layers.NewMaxPoolingLayer(l.InputSize, len(keep), l.PoolSize, l.Stride), false
+
+	case *layers.AvgPoolingLayer:
+		return layers.NewAvgPoolingLayer(l.InputSize, len(keep), l.PoolSize, l.Stride), false
+
+	case *layers.GlobalAvgPoolingLayer:
+		return layers.NewGlobalAvgPoolingLayer(len(keep), l.Height, l.Width), false
+
+	case *layers.GlobalMaxPoolingLayer:
+		return layers.NewGlobalMaxPoolingLayer(len(keep), l.Height, l.Width), false
+
+	case *layers.FakeQuantLayer:
+		shrunk := layers.NewFakeQuantLayer(len(keep), l.Height, l.Width)
+		shrunk.Min, shrunk.Max, shrunk.Momentum, shrunk.Initialized = l.Min, l.Max, l.Momentum, l.Initialized
+		return shrunk, false
+
+	case *layers.DropoutLayer:
+		shrunk := layers.NewDropoutLayer(len(keep), l.Height, l.Width, l.Rate)
+		shrunk.Seed = l.Seed
+		shrunk.SetTraining(l.Training())
+		return shrunk, false
+
+	case *layers.SpatialDropoutLayer:
+		shrunk := layers.NewSpatialDropoutLayer(len(keep), l.Height, l.Width, l.Rate)
+		shrunk.Seed = l.Seed
+		shrunk.SetTraining(l.Training())
+		return shrunk, false
+
+	case *layers.ConvLayer:
+		shrunk := layers.NewConvLayer(l.InputSize, len(keep), l.NumFilters, l.KernelSize, l.Stride)
+		shrunk.Algorithm, shrunk.WeightQuant = l.Algorithm, l.WeightQuant
+		copy(shrunk.Biases, l.Biases)
+		if l.KernelMask != nil {
+			shrunk.InitMasks()
+			copy(shrunk.BiasMask, l.BiasMask)
+		}
+		for f := range shrunk.Kernels {
+			for i, ch := range keep {
+				copyMatrix(shrunk.Kernels[f][i], l.Kernels[f][ch])
+				if l.KernelMask != nil {
+					copyMatrix(shrunk.KernelMask[f][i], l.KernelMask[f][ch])
+				}
+			}
+		}
+		return shrunk, true
+
+	case *layers.FullyConnectedLayer:
+		// Inputs are flattened channel by channel
+		area := l.InputWidth * l.InputWidth
+		shrunk := layers.NewFullyConnectedLayer(l.InputWidth, len(keep), l.OutputSize)
+		shrunk.WeightQuant = l.WeightQuant
+		copy(shrunk.Biases, l.Biases)
+		if l.WeightMask != nil {
+			shrunk.InitMasks()
+			copy(shrunk.BiasMask, l.BiasMask)
+		}
+		for i, ch := range keep {
+			for k := 0; k < area; k++ {
+				copy(shrunk.Weights[i*area+k], l.Weights[ch*area+k])
+				if l.WeightMask != nil {
+					copy(shrunk.WeightMask[i*area+k], l.WeightMask[ch*area+k])
+				}
+			}
+		}
+		return shrunk, true
+	}
+	panic("prune: layer cannot be shrunk")
+}
+
+// copyMatrix copies a matrix into another of the same shape
+func copyMatrix(dst, src [][]precision.Float) {
+	for i := range src {
+		copy(dst[i], src[i])
+	}
+}
+
+// copy4D copies filters into others of the same shape
+func copy4D(dst, src [][][][]precision.Float) {
+	for f := range src {
+		for c := range src[f] {
+			copyMatrix(dst[f][c], src[f][c])
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package quant
+
+import "math"
+
+// multiplier is a positive real number m * 2^-31 * 2^-shift used to rescale
+// int32 accumulators without floating point arithmetic
+type multiplier struct {
+	m     int32
+	shift int
+}
+
+// newMultiplier returns the multiplier closest to x
+func newMultiplier(x float64) multiplier {
+	if x <= 0 {
+		return multiplier{}
+	}
+	frac, exp := math.Frexp(x) // x = frac * 2^exp, frac in [0.5, 1)
+	m := int64(math.Round(frac * (1 << 31)))
+	if m == 1<<31 {
+		m /= 2
+		exp++
+	}
+	return multiplier{m: int32(m), shift: -exp}
+}
+
+// apply returns round(x * multiplier), rounding halves up
+func (mu multiplier) apply(x int32) int32 {
+	shift := 31 + mu.shift
+	product := int64(x) * int64(mu.m)
+	switch {
+	case shift <= 0:
+		product <<= uint(-shift)
+	case shift >= 63:
+		return 0
+	default:
+		product = (product + 1<<uint(shift-1)) >> uint(shift)
+	}
+
+	if product > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if product < math.MinInt32 {
+		return math.MinInt32
+	}
+	return int32(product)
+}
@@ -0,0 +1,157 @@
+package quant
+
+import (
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Layer is a layer of a quantized model
+type Layer interface {
+	Forward(input Tensor) Tensor
+	prepare()
+}
+
+// ConvLayer is a quantized convolution followed by ReLU
+type ConvLayer struct {
+	InputSize  int
+	InputDepth int
+	NumFilters int
+	KernelSize int
+	OutputSize int
+	Stride     int
+
+	Weights      Int8s             // filters x (depth*k*k), symmetric
+	WeightScales []precision.Float // One per filter, or a single one
+	Biases       []int32           // In units of Input.Scale * WeightScale
+	Input        QParams
+	Output       QParams
+
+	multipliers []multiplier // Accumulator to output rescaling of every filter
+}
+
+func (l *ConvLayer) prepare() {
+	l.multipliers = make([]multiplier, l.NumFilters)
+	for f := range l.multipliers {
+		l.multipliers[f] = newMultiplier(float64(l.Input.Scale) * float64(scaleOf(l.WeightScales, f)) / float64(l.Output.Scale))
+	}
+}
+
+// Forward convolves the input with integer arithmetic only
+func (l *ConvLayer) Forward(input Tensor) Tensor {
+	output := Tensor{
+		Shape:  []int{l.NumFilters, l.OutputSize, l.OutputSize},
+		Data:   make([]int8, l.NumFilters*l.OutputSize*l.OutputSize),
+		Params: l.Output,
+	}
+	patch := l.InputDepth * l.KernelSize * l.KernelSize
+	zeroIn := l.Input.ZeroPoint
+
+	layers.ParallelFor(l.NumFilters, 1, func(start, end int) {
+		for f := start; f < end; f++ {
+			weights := l.Weights[f*patch : (f+1)*patch]
+			for i := 0; i < l.OutputSize; i++ {
+				for j := 0; j < l.OutputSize; j++ {
+					acc := l.Biases[f]
+					w := 0
+					for c := 0; c < l.InputDepth; c++ {
+						for y := 0; y < l.KernelSize; y++ {
+							row := input.Data[(c*l.InputSize+i*l.Stride+y)*l.InputSize+j*l.Stride:]
+							for x := 0; x < l.KernelSize; x++ {
+								acc += (int32(row[x]) - zeroIn) * int32(weights[w])
+								w++
+							}
+						}
+					}
+
+					// ReLU keeps the values at or above the real zero
+					q := l.multipliers[f].apply(acc) + l.Output.ZeroPoint
+					if q < l.Output.ZeroPoint {
+						q = l.Output.ZeroPoint
+					}
+					output.Data[(f*l.OutputSize+i)*l.OutputSize+j] = clampInt8(q)
+				}
+			}
+		}
+	})
+	return output
+}
+
+// MaxPoolingLayer is max pooling on quantized values, which keep their parameters
+type MaxPoolingLayer struct {
+	InputSize  int
+	InputDepth int
+	PoolSize   int
+	OutputSize int
+	Stride     int
+}
+
+func (l *MaxPoolingLayer) prepare() {}
+
+// Forward pools the input
+func (l *MaxPoolingLayer) Forward(input Tensor) Tensor {
+	output := Tensor{
+		Shape:  []int{l.InputDepth, l.OutputSize, l.OutputSize},
+		Data:   make([]int8, l.InputDepth*l.OutputSize*l.OutputSize),
+		Params: input.Params,
+	}
+	for c := 0; c < l.InputDepth; c++ {
+		for i := 0; i < l.OutputSize; i++ {
+			for j := 0; j < l.OutputSize; j++ {
+				highest := int8(math.MinInt8)
+				for y := 0; y < l.PoolSize; y++ {
+					row := input.Data[(c*l.InputSize+i*l.Stride+y)*l.InputSize+j*l.Stride:]
+					for x := 0; x < l.PoolSize; x++ {
+						if row[x] > highest {
+							highest = row[x]
+						}
+					}
+				}
+				output.Data[(c*l.OutputSize+i)*l.OutputSize+j] = highest
+			}
+		}
+	}
+	return output
+}
+
+// FullyConnectedLayer is a quantized fully connected layer followed by a sigmoid
+type FullyConnectedLayer struct {
+	InputSize  int
+	OutputSize int
+
+	Weights      Int8s             // outputs x inputs, symmetric
+	WeightScales []precision.Float // One per output, or a single one
+	Biases       []int32           // In units of Input.Scale * WeightScale
+	Input        QParams
+}
+
+// sigmoidParams quantizes outputs in [0, 1]
+var sigmoidParams = paramsFor(0, 1)
+
+func (l *FullyConnectedLayer) prepare() {}
+
+// Forward returns the quantized sigmoid outputs
+func (l *FullyConnectedLayer) Forward(input Tensor) Tensor {
+	output := Tensor{Shape: []int{1, 1, l.OutputSize}, Params: sigmoidParams}
+	for _, v := range l.Infer(input) {
+		output.Data = append(output.Data, sigmoidParams.Quantize(v))
+	}
+	return output
+}
+
+// Infer returns the sigmoid outputs in floating point. Products are
+// accumulated in int32; the accumulator is only converted to compute the sigmoid.
+func (l *FullyConnectedLayer) Infer(input Tensor) []precision.Float {
+	output := make([]precision.Float, l.OutputSize)
+	zeroIn := l.Input.ZeroPoint
+	for j := range output {
+		acc := l.Biases[j]
+		for i, w := range l.Weights[j*l.InputSize : (j+1)*l.InputSize] {
+			acc += (int32(input.Data[i]) - zeroIn) * int32(w)
+		}
+		x := float64(acc) * float64(l.Input.Scale) * float64(scaleOf(l.WeightScales, j))
+		output[j] = precision.Float(1 / (1 + math.Exp(-x)))
+	}
+	return output
+}
@@ -0,0 +1,303 @@
+package quant
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// FileFormat identifies quantized model files
+const FileFormat = "go-cnn-int8"
+
+// Model is a network quantized to int8
+type Model struct {
+	Metadata    cnn.Metadata
+	Granularity Granularity
+	Input       QParams // Quantization of the network input
+	Layers      []Layer
+}
+
+// prepare computes the fixed-point multipliers of every layer
+func (m *Model) prepare() {
+	for _, l := range m.Layers {
+		l.prepare()
+	}
+}
+
+// Infer quantizes an input volume and runs it through the network. It
+// returns the sigmoid outputs of the last layer. Infer only reads the
+// model, so it can be called concurrently.
+func (m *Model) Infer(input [][][]precision.Float) []precision.Float {
+	t := QuantizeVolume(input, m.Input)
+	last := len(m.Layers) - 1
+	for _, l := range m.Layers[:last] {
+		t = l.Forward(t)
+	}
+	return m.Layers[last].(*FullyConnectedLayer).Infer(t)
+}
+
+// Predict returns the most likely class of an input volume and the outputs
+// normalized to sum to 1, like cnn.CNN.Predict
+func (m *Model) Predict(input [][][]precision.Float) (int, []precision.Float, error) {
+	if len(m.Layers) == 0 {
+		return 0, nil, errors.New("quant: model has no layers")
+	}
+	shape := inputShape(m.Layers[0])
+	if !hasShape(input, shape) {
+		return 0, nil, fmt.Errorf("quant: input does not have shape %v", shape)
+	}
+
+	output := m.Infer(input)
+	sum := precision.Float(0)
+	for _, v := range output {
+		sum += v
+	}
+	for i := range output {
+		output[i] /= sum
+	}
+	return cnn.Argmax(output), output, nil
+}
+
+// inputShape returns the depth, height and width expected by a layer
+func inputShape(l Layer) []int {
+	switch l := l.(type) {
+	case *ConvLayer:
+		return []int{l.InputDepth, l.InputSize, l.InputSize}
+	case *MaxPoolingLayer:
+		return []int{l.InputDepth, l.InputSize, l.InputSize}
+	case *FullyConnectedLayer:
+		return []int{1, 1, l.InputSize}
+	}
+	return nil
+}
+
+// outputShape returns the depth, height and width of the output of a layer
+func outputShape(l Layer) []int {
+	switch l := l.(type) {
+	case *ConvLayer:
+		return []int{l.NumFilters, l.OutputSize, l.OutputSize}
+	case *MaxPoolingLayer:
+		return []int{l.InputDepth, l.OutputSize, l.OutputSize}
+	case *FullyConnectedLayer:
+		return []int{1, 1, l.OutputSize}
+	}
+	return nil
+}
+
+// fitsShape tells whether a layer accepts the output of the previous one.
+// Fully connected layers take any shape of the right size.
+func fitsShape(l Layer, in, out []int) bool {
+	if _, ok := l.(*FullyConnectedLayer); ok {
+		return in[2] == out[0]*out[1]*out[2]
+	}
+	return in[0] == out[0] && in[1] == out[1] && in[2] == out[2]
+}
+
+// checkLayer validates the sizes of the parameters of a decoded layer
+func checkLayer(l Layer) error {
+	switch l := l.(type) {
+	case *ConvLayer:
+		if err := checkWindow(l.InputSize, l.KernelSize, l.Stride, l.OutputSize); err != nil {
+			return err
+		}
+		if l.InputDepth < 1 || l.NumFilters < 1 {
+			return fmt.Errorf("invalid input depth %d or number of filters %d", l.InputDepth, l.NumFilters)
+		}
+		return checkParams(l.Weights, l.WeightScales, l.Biases, l.NumFilters, l.InputDepth*l.KernelSize*l.KernelSize)
+	case *MaxPoolingLayer:
+		if l.InputDepth < 1 {
+			return fmt.Errorf("invalid input depth %d", l.InputDepth)
+		}
+		return checkWindow(l.InputSize, l.PoolSize, l.Stride, l.OutputSize)
+	case *FullyConnectedLayer:
+		if l.InputSize < 1 || l.OutputSize < 1 {
+			return fmt.Errorf("invalid input size %d or output size %d", l.InputSize, l.OutputSize)
+		}
+		return checkParams(l.Weights, l.WeightScales, l.Biases, l.OutputSize, l.InputSize)
+	}
+	return nil
+}
+
+// checkWindow checks the sizes of a sliding window over a square input
+func checkWindow(inputSize, windowSize, stride, outputSize int) error {
+	if windowSize < 1 || stride < 1 || inputSize < windowSize {
+		return fmt.Errorf("invalid window %d with stride %d over inputs of size %d", windowSize, stride, inputSize)
+	}
+	if expected := (inputSize-windowSize)/stride + 1; outputSize != expected {
+		return fmt.Errorf("output size %d, expected %d", outputSize, expected)
+	}
+	return nil
+}
+
+// checkParams checks the lengths of the parameters of units outputs of
+// fanIn weights each
+func checkParams(weights Int8s, scales []precision.Float, biases []int32, units, fanIn int) error {
+	if len(weights) != units*fanIn {
+		return fmt.Errorf("%d weights, expected %d", len(weights), units*fanIn)
+	}
+	if len(scales) != 1 && len(scales) != units {
+		return fmt.Errorf("%d weight scales, expected 1 or %d", len(scales), units)
+	}
+	if len(biases) != units {
+		return fmt.Errorf("%d biases, expected %d", len(biases), units)
+	}
+	return nil
+}
+
+// Size returns the number of bytes taken by the parameters of the model
+func (m *Model) Size() int {
+	size := 0
+	for _, l := range m.Layers {
+		switch l := l.(type) {
+		case *ConvLayer:
+			size += len(l.Weights) + 4*len(l.WeightScales) + 4*len(l.Biases)
+		case *FullyConnectedLayer:
+			size += len(l.Weights) + 4*len(l.WeightScales) + 4*len(l.Biases)
+		}
+	}
+	return size
+}
+
+// Int8s is an int8 slice stored in base64 in JSON
+type Int8s []int8
+
+// MarshalJSON encodes the values as base64
+func (s Int8s) MarshalJSON() ([]byte, error) {
+	data := make([]byte, len(s))
+	for i, v := range s {
+		data[i] = byte(v)
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(data))
+}
+
+// UnmarshalJSON decodes values encoded by MarshalJSON
+func (s *Int8s) UnmarshalJSON(b []byte) error {
+	var text string
+	if err := json.Unmarshal(b, &text); err != nil {
+		return err
+	}
+	data, err := base64.StdEncoding.DecodeString(text)
+	if err != nil {
+		return err
+	}
+	*s = make(Int8s, len(data))
+	for i, v := range data {
+		(*s)[i] = int8(v)
+	}
+	return nil
+}
+
+// file is the on-disk representation of a Model
+type file struct {
+	Format      string
+	Metadata    cnn.Metadata
+	Granularity Granularity
+	Input       QParams
+	Layers      []layerInfo
+}
+
+type layerInfo struct {
+	Type       string // ConvLayer, MaxPoolingLayer or FullyConnectedLayer
+	Properties json.RawMessage
+}
+
+// Encode serializes a quantized model to JSON
+func Encode(m *Model) ([]byte, error) {
+	f := file{Format: FileFormat, Metadata: m.Metadata, Granularity: m.Granularity, Input: m.Input}
+	for _, l := range m.Layers {
+		var name string
+		switch l.(type) {
+		case *ConvLayer:
+			name = "ConvLayer"
+		case *MaxPoolingLayer:
+			name = "MaxPoolingLayer"
+		case *FullyConnectedLayer:
+			name = "FullyConnectedLayer"
+		default:
+			return nil, fmt.Errorf("quant: unknown layer %T", l)
+		}
+		properties, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		f.Layers = append(f.Layers, layerInfo{Type: name, Properties: properties})
+	}
+	return json.Marshal(f)
+}
+
+// Decode rebuilds a quantized model from its JSON representation
+func Decode(data []byte) (*Model, error) {
+	var f file
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if f.Format != FileFormat {
+		return nil, fmt.Errorf("quant: not a quantized model (format %q)", f.Format)
+	}
+
+	m := &Model{Metadata: f.Metadata, Granularity: f.Granularity, Input: f.Input}
+	for i, info := range f.Layers {
+		var l Layer
+		switch info.Type {
+		case "ConvLayer":
+			l = &ConvLayer{}
+		case "MaxPoolingLayer":
+			l = &MaxPoolingLayer{}
+		case "FullyConnectedLayer":
+			l = &FullyConnectedLayer{}
+		default:
+			return nil, fmt.Errorf("quant: layer %d: unknown layer type %q", i, info.Type)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(info.Properties))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(l); err != nil {
+			return nil, fmt.Errorf("quant: layer %d (%s): %w", i, info.Type, err)
+		}
+		if err := checkLayer(l); err != nil {
+			return nil, fmt.Errorf("quant: layer %d (%s): %w", i, info.Type, err)
+		}
+		if i > 0 {
+			if in, out := inputShape(l), outputShape(m.Layers[i-1]); !fitsShape(l, in, out) {
+				return nil, fmt.Errorf("quant: layer %d (%s) expects an input of shape %v, got %v", i, info.Type, in, out)
+			}
+		}
+		m.Layers = append(m.Layers, l)
+	}
+	if len(m.Layers) == 0 {
+		return nil, errors.New("quant: model has no layers")
+	}
+	if _, ok := m.Layers[len(m.Layers)-1].(*FullyConnectedLayer); !ok {
+		return nil, errors.New("quant: the last layer must be fully connected")
+	}
+
+	m.prepare()
+	return m, nil
+}
+
+// Save writes a quantized model to a file
+func Save(m *Model, path string) error {
+	data, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Load reads a quantized model written by Save
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m, err := Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return m, nil
+}
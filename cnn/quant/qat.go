@@ -0,0 +1,58 @@
+package quant
+
+import (
+	"fmt"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+)
+
+// PrepareQAT sets up a float network for quantization-aware training.
+// Weights of the convolution and fully connected layers are fake-quantized
+// with the given granularity, and FakeQuantLayers are inserted on the input
+// and after every convolution, where Quantize places activation ranges.
+// Fine-tuning the network for a few epochs then lets it adapt to int8
+// rounding, and Quantize takes the learned ranges instead of calibrating
+// them. Layers already prepared are left as they are, so PrepareQAT can be
+// called again on a network reloaded from a checkpoint.
+func PrepareQAT(c *cnn.CNN, granularity Granularity) error {
+	var mode layers.WeightQuant
+	switch granularity {
+	case PerTensor:
+		mode = layers.WeightQuantPerTensor
+	case PerChannel:
+		mode = layers.WeightQuantPerChannel
+	default:
+		return fmt.Errorf("quant: unknown granularity %q", granularity)
+	}
+	if len(c.Layers) == 0 {
+		return fmt.Errorf("quant: network has no layers")
+	}
+
+	prepared := make([]cnn.Layer, 0, len(c.Layers)*2)
+	if _, ok := c.Layers[0].(*layers.FakeQuantLayer); !ok {
+		prepared = append(prepared, newFakeQuant(c.Layers[0].InputShape()))
+	}
+	for i, layer := range c.Layers {
+		prepared = append(prepared, layer)
+		switch l := layer.(type) {
+		case *layers.ConvLayer:
+			l.WeightQuant = mode
+			if i+1 < len(c.Layers) {
+				if _, ok := c.Layers[i+1].(*layers.FakeQuantLayer); ok {
+					continue
+				}
+			}
+			prepared = append(prepared, newFakeQuant(l.OutputShape()))
+		case *layers.FullyConnectedLayer:
+			l.WeightQuant = mode
+		}
+	}
+	c.Layers = prepared
+	return nil
+}
+
+// newFakeQuant creates a FakeQuantLayer for volumes of the given shape
+func newFakeQuant(shape []int) *layers.FakeQuantLayer {
+	return layers.NewFakeQuantLayer(shape[0], shape[1], shape[2])
+}
@@ -0,0 +1,337 @@
+// Package quant converts trained networks to 8-bit integers for small
+// devices. Weights are quantized symmetrically per tensor or per output
+// channel, activations asymmetrically with ranges calibrated on a
+// representative dataset. Quantized models run with integer arithmetic:
+// int8 products are accumulated in int32 and rescaled with fixed-point
+// multipliers; only the final sigmoid is computed in floating point.
+package quant
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Granularity selects how many scales a weight tensor uses
+type Granularity string
+
+const (
+	PerTensor  Granularity = "per-tensor"  // One scale for the whole tensor
+	PerChannel Granularity = "per-channel" // One scale per filter or output neuron
+)
+
+// QParams maps int8 values q to real values (q - ZeroPoint) * Scale
+type QParams struct {
+	Scale     precision.Float
+	ZeroPoint int32
+}
+
+// Quantize returns the int8 value closest to x, saturating
+func (p QParams) Quantize(x precision.Float) int8 {
+	return clampInt8(int32(math.Round(float64(x/p.Scale))) + p.ZeroPoint)
+}
+
+// Dequantize returns the real value of q
+func (p QParams) Dequantize(q int8) precision.Float {
+	return precision.Float(int32(q)-p.ZeroPoint) * p.Scale
+}
+
+// paramsFor returns the asymmetric parameters covering [min, max].
+// The range always includes 0 so that zero padding and ReLU are exact.
+func paramsFor(min, max precision.Float) QParams {
+	min = precision.Float(math.Min(float64(min), 0))
+	max = precision.Float(math.Max(float64(max), 0))
+	if max == min {
+		return QParams{Scale: 1}
+	}
+	scale := (max - min) / 255
+	zeroPoint := int32(math.Round(float64(-128 - min/scale)))
+	return QParams{Scale: scale, ZeroPoint: zeroPoint}
+}
+
+// Tensor is a quantized volume, stored depth, height, width major
+type Tensor struct {
+	Shape  []int // Depth, height and width
+	Data   []int8
+	Params QParams
+}
+
+// QuantizeVolume quantizes a float volume with the given parameters
+func QuantizeVolume(volume [][][]precision.Float, params QParams) Tensor {
+	t := Tensor{Params: params}
+	if len(volume) > 0 && len(volume[0]) > 0 {
+		t.Shape = []int{len(volume), len(volume[0]), len(volume[0][0])}
+	}
+	for _, m := range volume {
+		for _, row := range m {
+			for _, v := range row {
+				t.Data = append(t.Data, params.Quantize(v))
+			}
+		}
+	}
+	return t
+}
+
+// hasShape tells whether a volume has the given depth, height and width
+func hasShape(volume [][][]precision.Float, shape []int) bool {
+	if len(volume) != shape[0] {
+		return false
+	}
+	for _, m := range volume {
+		if len(m) != shape[1] {
+			return false
+		}
+		for _, row := range m {
+			if len(row) != shape[2] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Calibration holds the range of the network input and of every layer output
+type Calibration struct {
+	Min, Max []precision.Float // Index 0 is the input, index i+1 the output of layer i
+}
+
+// Calibrate runs representative inputs through the float network and
+// records the range of its activations
+func Calibrate(c *cnn.CNN, inputs [][][][]precision.Float) (*Calibration, error) {
+	if len(inputs) == 0 {
+		return nil, errors.New("quant: no calibration inputs")
+	}
+	if len(c.Layers) == 0 {
+		return nil, errors.New("quant: network has no layers")
+	}
+
+	cal := &Calibration{
+		Min: make([]precision.Float, len(c.Layers)+1),
+		Max: make([]precision.Float, len(c.Layers)+1),
+	}
+	for i := range cal.Min {
+		cal.Min[i] = precision.Float(math.Inf(1))
+		cal.Max[i] = precision.Float(math.Inf(-1))
+	}
+
+	shape := c.Layers[0].InputShape()
+	for n, input := range inputs {
+		if !hasShape(input, shape) {
+			return nil, fmt.Errorf("quant: calibration input %d does not have shape %v", n, shape)
+		}
+		cal.observe(0, input)
+		for i, layer := range c.Layers {
+			input = layer.Infer(input)
+			cal.observe(i+1, input)
+		}
+	}
+	return cal, nil
+}
+
+// observe extends the range of activation i with a volume
+func (cal *Calibration) observe(i int, volume [][][]precision.Float) {
+	for _, m := range volume {
+		for _, row := range m {
+			for _, v := range row {
+				if v < cal.Min[i] {
+					cal.Min[i] = v
+				}
+				if v > cal.Max[i] {
+					cal.Max[i] = v
+				}
+			}
+		}
+	}
+}
+
+// Quantize converts a trained network to int8. Activations are calibrated
+// on inputs, which should be a few hundred samples representative of the
+// data the model will see. The network must end with its only fully
+// connected layer, as networks built from specs do.
+// Networks trained with PrepareQAT take the activation ranges learned by
+// their FakeQuantLayers rather than the calibrated ones.
+func Quantize(c *cnn.CNN, inputs [][][][]precision.Float, granularity Granularity) (*Model, error) {
+	if granularity != PerTensor && granularity != PerChannel {
+		return nil, fmt.Errorf("quant: unknown granularity %q", granularity)
+	}
+	cal, err := Calibrate(c, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	// A fake quantization layer fixes the range of its input, which is the
+	// output of the previous layer, and of its own output
+	for i, layer := range c.Layers {
+		if l, ok := layer.(*layers.FakeQuantLayer); ok && l.Initialized {
+			cal.Min[i], cal.Max[i] = l.Min, l.Max
+			cal.Min[i+1], cal.Max[i+1] = l.Min, l.Max
+		}
+	}
+
+	m := &Model{
+		Metadata:    c.Metadata,
+		Granularity: granularity,
+		Input:       paramsFor(cal.Min[0], cal.Max[0]),
+	}
+	in := m.Input
+	last := len(c.Layers) - 1
+	for i, layer := range c.Layers {
+		switch l := layer.(type) {
+		case *layers.ConvLayer:
+			q := quantizeConv(l, in, paramsFor(cal.Min[i+1], cal.Max[i+1]), granularity)
+			m.Layers = append(m.Layers, q)
+			in = q.Output
+		case *layers.MaxPoolingLayer:
+			// The maximum of quantized values is the quantized maximum
+			m.Layers = append(m.Layers, &MaxPoolingLayer{
+				InputSize: l.InputSize, InputDepth: l.InputDepth, PoolSize: l.PoolSize, OutputSize: l.OutputSize, Stride: l.Stride,
+			})
+		case *layers.FullyConnectedLayer:
+			if i != last {
+				return nil, fmt.Errorf("quant: layer %d: only the last layer can be fully connected", i)
+			}
+			m.Layers = append(m.Layers, quantizeFC(l, in, granularity))
+		case *layers.FakeQuantLayer:
+			// Its rounding is what the quantized layers already do
+		case *layers.DropoutLayer, *layers.SpatialDropoutLayer:
+			// Dropout is the identity at inference
+		default:
+			return nil, fmt.Errorf("quant: layer %d (%s) cannot be quantized", i, cnn.LayerName(layer))
+		}
+	}
+	if len(m.Layers) == 0 {
+		return nil, errors.New("quant: the last layer must be fully connected")
+	}
+	if _, ok := m.Layers[len(m.Layers)-1].(*FullyConnectedLayer); !ok {
+		return nil, errors.New("quant: the last layer must be fully connected")
+	}
+
+	m.prepare()
+	return m, nil
+}
+
+// quantizeWeights quantizes channels of weights symmetrically to [-127, 127].
+// channel(i) returns the weights of channel i; there is one scale per
+// channel, or a single one for all of them.
+func quantizeWeights(channels int, channel func(i int) []precision.Float, granularity Granularity) ([][]int8, []precision.Float) {
+	maxAbs := make([]precision.Float, channels)
+	for i := range maxAbs {
+		for _, w := range channel(i) {
+			maxAbs[i] = precision.Float(math.Max(float64(maxAbs[i]), math.Abs(float64(w))))
+		}
+	}
+	if granularity == PerTensor {
+		overall := precision.Float(0)
+		for _, v := range maxAbs {
+			overall = precision.Float(math.Max(float64(overall), float64(v)))
+		}
+		maxAbs = []precision.Float{overall}
+	}
+
+	scales := make([]precision.Float, len(maxAbs))
+	for i, v := range maxAbs {
+		scales[i] = v / 127
+		if v == 0 {
+			scales[i] = 1
+		}
+	}
+
+	q := make([][]int8, channels)
+	for i := range q {
+		scale := scales[0]
+		if len(scales) > 1 {
+			scale = scales[i]
+		}
+		for _, w := range channel(i) {
+			v := int32(math.Round(float64(w / scale)))
+			if v > 127 {
+				v = 127
+			} else if v < -127 {
+				v = -127
+			}
+			q[i] = append(q[i], int8(v))
+		}
+	}
+	return q, scales
+}
+
+// quantizeBias converts biases to int32 in units of the accumulator scale
+func quantizeBias(biases []precision.Float, input QParams, scales []precision.Float) []int32 {
+	q := make([]int32, len(biases))
+	for i, b := range biases {
+		scale := input.Scale * scaleOf(scales, i)
+		q[i] = int32(math.Round(float64(b / scale)))
+	}
+	return q
+}
+
+// scaleOf returns the scale of channel i
+func scaleOf(scales []precision.Float, i int) precision.Float {
+	if len(scales) == 1 {
+		return scales[0]
+	}
+	return scales[i]
+}
+
+func quantizeConv(l *layers.ConvLayer, input, output QParams, granularity Granularity) *ConvLayer {
+	weights, scales := quantizeWeights(l.NumFilters, func(f int) []precision.Float {
+		var w []precision.Float
+		for _, channel := range l.Kernels[f] {
+			for _, row := range channel {
+				w = append(w, row...)
+			}
+		}
+		return w
+	}, granularity)
+
+	q := &ConvLayer{
+		InputSize: l.InputSize, InputDepth: l.InputDepth, NumFilters: l.NumFilters,
+		KernelSize: l.KernelSize, OutputSize: l.OutputSize, Stride: l.Stride,
+		WeightScales: scales,
+		Biases:       quantizeBias(l.Biases, input, scales),
+		Input:        input,
+		Output:       output,
+	}
+	for _, w := range weights {
+		q.Weights = append(q.Weights, w...)
+	}
+	return q
+}
+
+func quantizeFC(l *layers.FullyConnectedLayer, input QParams, granularity Granularity) *FullyConnectedLayer {
+	// Float weights are stored input major, quantized ones output major so
+	// that every output is a contiguous dot product
+	weights, scales := quantizeWeights(l.OutputSize, func(j int) []precision.Float {
+		w := make([]precision.Float, l.InputSize)
+		for i := range w {
+			w[i] = l.Weights[i][j]
+		}
+		return w
+	}, granularity)
+
+	q := &FullyConnectedLayer{
+		InputSize:    l.InputSize,
+		OutputSize:   l.OutputSize,
+		WeightScales: scales,
+		Biases:       quantizeBias(l.Biases, input, scales),
+		Input:        input,
+	}
+	for _, w := range weights {
+		q.Weights = append(q.Weights, w...)
+	}
+	return q
+}
+
+func clampInt8(v int32) int8 {
+	if v > math.MaxInt8 {
+		return math.MaxInt8
+	}
+	if v < math.MinInt8 {
+		return math.MinInt8
+	}
+	return int8(v)
+}
@@ -0,0 +1,175 @@
+package quant
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/internal/cnntest"
+	"github.com/ofauchon/go-cnn/cnn/layers"
+)
+
+func TestMultiplier(t *testing.T) {
+	for _, x := range []float64{0.5, 0.001234, 0.9999999, 3.5, 1e-9} {
+		mu := newMultiplier(x)
+		for _, acc := range []int32{0, 1, -1, 1000, -123456, 1 << 20} {
+			expected := math.Floor(float64(acc)*x + 0.5)
+			if got := mu.apply(acc); math.Abs(float64(got)-expected) > 1 {
+				t.Errorf("%d * %g = %d, expected %g", acc, x, got, expected)
+			}
+		}
+	}
+}
+
+func TestQuantizedModelMatchesFloat(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := cnntest.NewCNN(4)
+	inputs := cnntest.RandomInputs(rng, 50)
+
+	for _, granularity := range []Granularity{PerTensor, PerChannel} {
+		m, err := Quantize(c, inputs, granularity)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, input := range inputs[:10] {
+			expected := c.Infer(input)
+			got := m.Infer(input)
+			for i := range expected {
+				if math.Abs(float64(got[i]-expected[i])) > 0.02 {
+					t.Fatalf("%s: output %v, expected %v", granularity, got, expected)
+				}
+			}
+		}
+
+		labels := make([]int, len(inputs))
+		if _, err := Compare(c, m, inputs, labels[1:]); err == nil {
+			t.Errorf("%s: Compare accepted fewer labels than inputs", granularity)
+		}
+		report, err := Compare(c, m, inputs, labels)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.QuantizedBytes*3 > report.FloatBytes {
+			t.Errorf("%s: %d quantized bytes for %d float bytes", granularity, report.QuantizedBytes, report.FloatBytes)
+		}
+		var buf bytes.Buffer
+		report.Write(&buf)
+		if buf.Len() == 0 {
+			t.Error("empty report")
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := cnntest.NewCNN(4)
+	inputs := cnntest.RandomInputs(rng, 20)
+	m, err := Quantize(c, inputs, PerChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := Encode(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, m) {
+		t.Errorf("decoded model differs from the original")
+	}
+	if !reflect.DeepEqual(decoded.Infer(inputs[0]), m.Infer(inputs[0])) {
+		t.Errorf("decoded model computes different outputs")
+	}
+
+	if _, err := Decode(cnn.EncodeCNN(c)); err == nil {
+		t.Error("Decode accepted a float model")
+	}
+
+	// Parameters which do not fit the layer sizes are rejected
+	for name, corrupt := range map[string]func(m *Model){
+		"weights":       func(m *Model) { l := m.Layers[0].(*ConvLayer); l.Weights = l.Weights[1:] },
+		"weight scales": func(m *Model) { l := m.Layers[0].(*ConvLayer); l.WeightScales = l.WeightScales[1:] },
+		"biases":        func(m *Model) { l := m.Layers[len(m.Layers)-1].(*FullyConnectedLayer); l.Biases = l.Biases[1:] },
+		"output size":   func(m *Model) { m.Layers[0].(*ConvLayer).OutputSize++ },
+		"input shape":   func(m *Model) { l := m.Layers[len(m.Layers)-1].(*FullyConnectedLayer); l.InputSize++ },
+	} {
+		corrupted, err := Decode(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrupt(corrupted)
+		encoded, err := Encode(corrupted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decode(encoded); err == nil {
+			t.Errorf("Decode accepted a model with corrupted %s", name)
+		}
+	}
+}
+
+func TestQuantizeRejectsUnsupportedNetworks(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	c := cnn.NewCNN()
+	c.AddConvLayer(12, 1, 4, 3, 1)
+	if _, err := Quantize(c, cnntest.RandomInputs(rng, 2), PerTensor); err == nil {
+		t.Error("Quantize accepted a network without a fully connected output")
+	}
+	if _, err := Quantize(cnntest.NewCNN(4), nil, PerTensor); err == nil {
+		t.Error("Quantize accepted an empty calibration set")
+	}
+}
+
+func TestQuantizationAwareTraining(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	inputs := cnntest.RandomInputs(rng, 40)
+
+	for _, granularity := range []Granularity{PerTensor, PerChannel} {
+		c := cnntest.NewCNN(4)
+		if err := PrepareQAT(c, granularity); err != nil {
+			t.Fatal(err)
+		}
+		if err := PrepareQAT(c, granularity); err != nil || len(c.Layers) != 7 {
+			t.Fatalf("%s: %d layers after preparing twice, expected 7", granularity, len(c.Layers))
+		}
+		for epoch := 0; epoch < 3; epoch++ {
+			for i, input := range inputs {
+				c.ForwardPropagate(input)
+				c.BackPropagate(i % 5)
+				c.Update()
+			}
+		}
+
+		// The fake-quantization layers survive a save and reload
+		decoded := cnn.DecodeCNN(cnn.EncodeCNN(c))
+		got, expected := decoded.Layers[0].(*layers.FakeQuantLayer), c.Layers[0].(*layers.FakeQuantLayer)
+		if got.Min != expected.Min || got.Max != expected.Max || !got.Initialized {
+			t.Fatalf("%s: decoded range [%g, %g], expected [%g, %g]", granularity, got.Min, got.Max, expected.Min, expected.Max)
+		}
+
+		// The int8 model computes what the network saw while training
+		m, err := Quantize(&decoded, inputs[:5], granularity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(m.Layers) != 4 {
+			t.Fatalf("%s: quantized model has %d layers, expected 4", granularity, len(m.Layers))
+		}
+		for _, input := range inputs[:10] {
+			expected := c.ForwardPropagate(input)
+			got := m.Infer(input)
+			for i := range expected {
+				if math.Abs(float64(got[i]-expected[i])) > 0.01 {
+					t.Fatalf("%s: output %v, expected %v", granularity, got, expected)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package quant
+
+import (
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Report compares a quantized model with the float network it comes from
+type Report struct {
+	Samples           int
+	FloatAccuracy     float64 // Fraction of samples classified correctly by the float network
+	QuantizedAccuracy float64 // Same for the quantized model
+	Agreement         float64 // Fraction of samples where both predict the same class
+	MaxOutputError    float64 // Largest difference between normalized outputs
+	FloatBytes        int     // Size of the floating point parameters
+	QuantizedBytes    int     // Size of the quantized parameters
+}
+
+// Compare classifies labelled inputs with both networks
+func Compare(c *cnn.CNN, m *Model, inputs [][][][]precision.Float, labels []int) (Report, error) {
+	if len(labels) != len(inputs) {
+		return Report{}, fmt.Errorf("quant: %d labels for %d inputs", len(labels), len(inputs))
+	}
+	r := Report{Samples: len(inputs), QuantizedBytes: m.Size()}
+	for _, p := range c.Params() {
+		for _, row := range p.Values {
+			r.FloatBytes += precision.Native.Size() * len(row)
+		}
+	}
+	if len(inputs) == 0 {
+		return r, nil
+	}
+
+	floatCorrect, quantCorrect, agree := 0, 0, 0
+	for i, input := range inputs {
+		floatClass, floatOutput, err := c.Predict(input)
+		if err != nil {
+			return r, err
+		}
+		quantClass, quantOutput, err := m.Predict(input)
+		if err != nil {
+			return r, err
+		}
+
+		if floatClass == labels[i] {
+			floatCorrect++
+		}
+		if quantClass == labels[i] {
+			quantCorrect++
+		}
+		if floatClass == quantClass {
+			agree++
+		}
+		for j := range floatOutput {
+			r.MaxOutputError = math.Max(r.MaxOutputError, math.Abs(float64(floatOutput[j]-quantOutput[j])))
+		}
+	}
+
+	n := float64(len(inputs))
+	r.FloatAccuracy = float64(floatCorrect) / n
+	r.QuantizedAccuracy = float64(quantCorrect) / n
+	r.Agreement = float64(agree) / n
+	return r, nil
+}
+
+// Write prints the report as a table
+func (r Report) Write(w io.Writer) {
+	fmt.Fprintf(w, "%-20s %12s %12s\n", "", precision.Native, "int8")
+	fmt.Fprintf(w, "%-20s %11.2f%% %11.2f%%\n", "Accuracy", 100*r.FloatAccuracy, 100*r.QuantizedAccuracy)
+	fmt.Fprintf(w, "%-20s %12d %12d\n", "Parameter bytes", r.FloatBytes, r.QuantizedBytes)
+	fmt.Fprintf(w, "Agreement: %.2f%% of %d samples, max output difference %.4f\n", 100*r.Agreement, r.Samples, r.MaxOutputError)
+}
@@ -0,0 +1,112 @@
+package cnn
+
+import (
+	"math"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Regularization penalizes and constrains the parameters of every layer
+// when the network is updated. Zero values disable each feature.
+type Regularization struct {
+	// L1 and L2 add L1 * sum(|w|) + L2/2 * sum(w²) to the loss, that is
+	// L1 * sign(w) + L2 * w to the gradient of every parameter w
+	L1 precision.Float `json:"l1,omitempty" yaml:"l1,omitempty"`
+	L2 precision.Float `json:"l2,omitempty" yaml:"l2,omitempty"`
+	// ExcludeBiases leaves biases and normalization parameters out of the penalties
+	ExcludeBiases bool `json:"excludeBiases,omitempty" yaml:"excludeBiases,omitempty"`
+	// MaxNorm bounds the L2 norm of the incoming weights of every
+	// convolution filter and dense output after each step
+	MaxNorm precision.Float `json:"maxNorm,omitempty" yaml:"maxNorm,omitempty"`
+	// ClipValue bounds every gradient to [-ClipValue, ClipValue]
+	ClipValue precision.Float `json:"clipValue,omitempty" yaml:"clipValue,omitempty"`
+	// ClipNorm scales the gradients down when their global L2 norm exceeds it
+	ClipNorm precision.Float `json:"clipNorm,omitempty" yaml:"clipNorm,omitempty"`
+}
+
+// MaxNormer is implemented by layers whose units can be constrained to a
+// maximum norm, such as ConvLayer and FullyConnectedLayer
+type MaxNormer interface {
+	MaxNorm(limit precision.Float)
+}
+
+// penalize adds the L1 and L2 penalties to the gradients, then clips them.
+// The penalties are part of the loss, so they are clipped along with it.
+func (r *Regularization) penalize(params []layers.Param) {
+	if r.L1 != 0 || r.L2 != 0 {
+		for _, p := range params {
+			if p.Bias && r.ExcludeBiases {
+				continue
+			}
+			for j, row := range p.Values {
+				grads := p.Grads[j]
+				for k, w := range row {
+					grads[k] += r.L2 * w
+					if w > 0 {
+						grads[k] += r.L1
+					} else if w < 0 {
+						grads[k] -= r.L1
+					}
+				}
+			}
+		}
+	}
+
+	if r.ClipValue != 0 {
+		for _, p := range params {
+			for _, grads := range p.Grads {
+				for k, g := range grads {
+					if g > r.ClipValue {
+						grads[k] = r.ClipValue
+					} else if g < -r.ClipValue {
+						grads[k] = -r.ClipValue
+					}
+				}
+			}
+		}
+	}
+
+	if r.ClipNorm != 0 {
+		if norm := GradientNorm(params); norm > float64(r.ClipNorm) {
+			scale := r.ClipNorm / precision.Float(norm)
+			for _, p := range params {
+				for _, grads := range p.Grads {
+					for k := range grads {
+						grads[k] *= scale
+					}
+				}
+			}
+		}
+	}
+}
+
+// constrain applies the max-norm constraint to the layers
+func (r *Regularization) constrain(layers []Layer) {
+	if r.MaxNorm == 0 {
+		return
+	}
+	for _, layer := range layers {
+		if l, ok := layer.(MaxNormer); ok {
+			l.MaxNorm(r.MaxNorm)
+		}
+	}
+}
+
+// validate checks that no setting is negative
+func (r *Regularization) validate() bool {
+	return r.L1 >= 0 && r.L2 >= 0 && r.MaxNorm >= 0 && r.ClipValue >= 0 && r.ClipNorm >= 0
+}
+
+// GradientNorm returns the global L2 norm of the gradients of params
+func GradientNorm(params []layers.Param) float64 {
+	sum := 0.0
+	for _, p := range params {
+		for _, grads := range p.Grads {
+			for _, g := range grads {
+				sum += float64(g) * float64(g)
+			}
+		}
+	}
+	return math.Sqrt(sum)
+}
@@ -0,0 +1,115 @@
+package cnn
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestWeightDecay(t *testing.T) {
+	for _, tc := range []struct {
+		name           string
+		regularization Regularization
+		weight, bias   precision.Float // Expected change of a weight of 0.5 and a bias of -0.1
+	}{
+		{"l2", Regularization{L2: 0.1}, -0.1 * 0.1 * 0.5, -0.1 * 0.1 * -0.1},
+		{"l1", Regularization{L1: 0.1}, -0.1 * 0.1, 0.1 * 0.1},
+		{"l2 without biases", Regularization{L2: 0.1, ExcludeBiases: true}, -0.1 * 0.1 * 0.5, 0},
+	} {
+		cn := NewCNN()
+		cn.AddFullyConnectedLayer(1, 2, 2)
+		cn.Optimizer = NewSGD(0.1, 0)
+		cn.Regularization = tc.regularization
+		fcl := cn.Layers[0].(*layers.FullyConnectedLayer)
+		fcl.Weights[0][0], fcl.Biases[0] = 0.5, -0.1
+
+		// Without any gradient of the loss, only the penalties move the parameters
+		cn.Update()
+		if d := fcl.Weights[0][0] - 0.5; math.Abs(float64(d-tc.weight)) > 1e-6 {
+			t.Errorf("%s: weight changed by %f, expected %f", tc.name, d, tc.weight)
+		}
+		if d := fcl.Biases[0] + 0.1; math.Abs(float64(d-tc.bias)) > 1e-6 {
+			t.Errorf("%s: bias changed by %f, expected %f", tc.name, d, tc.bias)
+		}
+	}
+}
+
+func TestGradientClipping(t *testing.T) {
+	grads := [][]precision.Float{{3, -4, 0.5}, {-0.2}}
+	params := []layers.Param{{Values: [][]precision.Float{make([]precision.Float, 3), make([]precision.Float, 1)}, Grads: grads}}
+
+	(&Regularization{ClipValue: 1}).penalize(params)
+	assertEqual(t, "clipped by value", grads[0], []precision.Float{1, -1, 0.5})
+
+	grads[0][0], grads[0][1] = 3, -4
+	(&Regularization{ClipNorm: 1}).penalize(params)
+	if norm := GradientNorm(params); math.Abs(norm-1) > 1e-6 {
+		t.Errorf("gradient norm %f after clipping, expected 1", norm)
+	}
+	if ratio := grads[0][0] / grads[0][1]; math.Abs(float64(ratio+0.75)) > 1e-6 {
+		t.Errorf("clipping by norm changed the gradient direction")
+	}
+
+	// Gradients below the norm are left alone
+	before := append([]precision.Float(nil), grads[0]...)
+	(&Regularization{ClipNorm: 2}).penalize(params)
+	assertEqual(t, "small gradients", grads[0], before)
+}
+
+func TestMaxNorm(t *testing.T) {
+	cn := NewCNN()
+	cn.AddConvLayer(6, 2, 3, 3, 1)
+	cn.AddFullyConnectedLayer(4, 3, 5)
+	cn.Regularization = Regularization{MaxNorm: 0.5}
+	conv := cn.Layers[0].(*layers.ConvLayer)
+	fcl := cn.Layers[1].(*layers.FullyConnectedLayer)
+	conv.Kernels[1][0][0][0] = 10
+	small := conv.Kernels[2][1][1][1] * 1e-3
+	for _, filter := range conv.Kernels[2] {
+		for _, row := range filter {
+			for i := range row {
+				row[i] *= 1e-3
+			}
+		}
+	}
+
+	cn.Update()
+	for f, filter := range conv.Kernels {
+		sum := 0.0
+		for _, channel := range filter {
+			for _, row := range channel {
+				for _, w := range row {
+					sum += float64(w * w)
+				}
+			}
+		}
+		if norm := math.Sqrt(sum); norm > 0.5+1e-5 {
+			t.Errorf("filter %d has norm %f", f, norm)
+		}
+	}
+	if conv.Kernels[2][1][1][1] != small {
+		t.Error("a filter within the norm was scaled")
+	}
+	for j := 0; j < fcl.OutputSize; j++ {
+		sum := 0.0
+		for _, row := range fcl.Weights {
+			sum += float64(row[j] * row[j])
+		}
+		if norm := math.Sqrt(sum); norm > 0.5+1e-5 {
+			t.Errorf("output %d has norm %f", j, norm)
+		}
+	}
+}
+
+// assertEqual compares float slices exactly
+func assertEqual(t *testing.T, name string, got, expected []precision.Float) {
+	t.Helper()
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("%s: got %v, expected %v", name, got, expected)
+			return
+		}
+	}
+}
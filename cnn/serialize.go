@@ -1,17 +1,38 @@
 package cnn
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
 
 	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
 )
 
 type LayerInfo struct {
-	Type       string      // Layer type identifier (FullyConnectedLayer, ConvLayer, MaxPoolingLayer)
-	Properties interface{} // Layer-specific properties
+	Type       string            // Layer type identifier (FullyConnectedLayer, ConvLayer, InstanceNormLayer...)
+	Properties interface{}       // Layer-specific properties
+	Tensors    map[string][]byte `json:",omitempty"` // Parameters by name, in the precision of the file
 }
 
+// ModelFile is the on-disk representation of a CNN
+type ModelFile struct {
+	Metadata  Metadata       // Input preprocessing and class labels
+	Precision precision.Type `json:",omitempty"` // Storage type of the parameters, float32 if empty
+	Layers    []LayerInfo    // Layers, in forward order
+}
+
+// EncodeCNN serializes the layers and metadata of a CNN to JSON.
+// With a Precision other than float32, only the hyperparameters of the
+// layers are kept in their properties: parameters are stored in Tensors
+// and the training buffers are left out.
 func EncodeCNN(cnn *CNN) []byte {
+	if compactPrecision(cnn.Precision) {
+		return encodeCompact(cnn)
+	}
+
 	// Create a slice to store LayerInfo structs
 	layerInfos := []LayerInfo{}
 
@@ -26,12 +47,15 @@ func EncodeCNN(cnn *CNN) []byte {
 					InputWidth int
 					InputDepth int
 					OutputSize int
-					Weights    [][]float32
-					Biases     []float32
-					Input      []float32
-					Output     []float32
+					Weights    [][]precision.Float
+					Biases     []precision.Float
+					Input      []precision.Float
+					Output     []precision.Float
+					WeightMask [][]precision.Float `json:",omitempty"`
+					BiasMask   []precision.Float   `json:",omitempty"`
 				}{
-					layer.InputSize, layer.InputWidth, layer.InputDepth, layer.OutputSize, layer.Weights, layer.Biases, layer.Input, layer.Output},
+					layer.InputSize, layer.InputWidth, layer.InputDepth, layer.OutputSize, layer.Weights, layer.Biases, layer.Input, layer.Output,
+					layer.WeightMask, layer.BiasMask},
 			}
 			layerInfos = append(layerInfos, layerInfo)
 		case *layers.ConvLayer:
@@ -44,12 +68,15 @@ func EncodeCNN(cnn *CNN) []byte {
 					KernelSize int
 					OutputSize int
 					Stride     int
-					Biases     []float32
-					Kernels    [][][][]float32
-					Input      [][][]float32
-					Output     [][][]float32
+					Biases     []precision.Float
+					Kernels    [][][][]precision.Float
+					Input      [][][]precision.Float
+					Output     [][][]precision.Float
+					KernelMask [][][][]precision.Float `json:",omitempty"`
+					BiasMask   []precision.Float       `json:",omitempty"`
 				}{
-					layer.InputSize, layer.InputDepth, layer.NumFilters, layer.KernelSize, layer.OutputSize, layer.Stride, layer.Biases, layer.Kernels, layer.Input, layer.Output},
+					layer.InputSize, layer.InputDepth, layer.NumFilters, layer.KernelSize, layer.OutputSize, layer.Stride, layer.Biases, layer.Kernels, layer.Input, layer.Output,
+					layer.KernelMask, layer.BiasMask},
 			}
 			layerInfos = append(layerInfos, layerInfo)
 		case *layers.MaxPoolingLayer:
@@ -61,18 +88,41 @@ func EncodeCNN(cnn *CNN) []byte {
 					PoolSize     int
 					OutputSize   int
 					Stride       int
-					Output       [][][]float32
-					HighestIndex [][][][]int   // Tuple (x,y,z)[2]int representing the position of the highest value
-					PrevError    [][][]float32 // Add this line
+					Output       [][][]precision.Float
+					HighestIndex [][][][]int           // Tuple (x,y,z)[2]int representing the position of the highest value
+					PrevError    [][][]precision.Float // Add this line
 				}{
 					layer.InputSize, layer.InputDepth, layer.PoolSize, layer.OutputSize, layer.Stride, layer.Output, layer.HighestIndex, layer.PrevError},
 			}
 			layerInfos = append(layerInfos, layerInfo)
+		case *layers.FakeQuantLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "FakeQuantLayer", Properties: fakeQuantProperties(layer)})
+		case *layers.InstanceNormLayer:
+			layerInfo := LayerInfo{
+				Type: "InstanceNormLayer",
+				Properties: struct {
+					Depth, Height, Width    int
+					Epsilon, Momentum       precision.Float
+					Gamma, Beta             []precision.Float
+					RunningMean, RunningVar []precision.Float
+				}{layer.Depth, layer.Height, layer.Width, layer.Epsilon, layer.Momentum, layer.Gamma, layer.Beta, layer.RunningMean, layer.RunningVar},
+			}
+			layerInfos = append(layerInfos, layerInfo)
+		case *layers.DropoutLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "DropoutLayer", Properties: dropoutProperties(layer)})
+		case *layers.SpatialDropoutLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "SpatialDropoutLayer", Properties: dropoutProperties(&layer.DropoutLayer)})
+		case *layers.AvgPoolingLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "AvgPoolingLayer", Properties: avgPoolProperties(layer)})
+		case *layers.GlobalAvgPoolingLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "GlobalAvgPoolingLayer", Properties: globalPoolProperties(layer.Depth, layer.Height, layer.Width)})
+		case *layers.GlobalMaxPoolingLayer:
+			layerInfos = append(layerInfos, LayerInfo{Type: "GlobalMaxPoolingLayer", Properties: globalPoolProperties(layer.Depth, layer.Height, layer.Width)})
 		}
 	}
 
 	// Convert layerInfos into JSON-like representation
-	jsonData, err := json.Marshal(layerInfos)
+	jsonData, err := json.Marshal(ModelFile{Metadata: cnn.Metadata, Layers: layerInfos})
 	if err != nil {
 		panic(err)
 	}
@@ -80,16 +130,57 @@ func EncodeCNN(cnn *CNN) []byte {
 	return jsonData
 }
 
+// DecodeCNN rebuilds a CNN from its JSON representation.
+// Files written before metadata was introduced only hold the layer list,
+// they are still accepted and decode with empty metadata.
+// It panics on malformed data, see LoadCNN for an error returning variant.
 func DecodeCNN(jsonData []byte) CNN {
-	// Decode JSON-like representation into layerInfos slice
-	var layerInfos []LayerInfo
-	err := json.Unmarshal(jsonData, &layerInfos)
+	cnn, err := decodeCNN(jsonData)
 	if err != nil {
 		panic(err)
 	}
+	return cnn
+}
+
+// SaveCNN writes the JSON representation of a CNN to a file
+func SaveCNN(cnn *CNN, path string) error {
+	return os.WriteFile(path, EncodeCNN(cnn), 0644)
+}
+
+// LoadCNN reads a CNN from a file written by SaveCNN or EncodeCNN
+func LoadCNN(path string) (*CNN, error) {
+	jsonData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cnn, err := decodeCNN(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &cnn, nil
+}
+
+func decodeCNN(jsonData []byte) (CNN, error) {
+	// Decode JSON-like representation into layerInfos slice
+	var model ModelFile
+	if bytes.HasPrefix(bytes.TrimSpace(jsonData), []byte("[")) {
+		err := json.Unmarshal(jsonData, &model.Layers)
+		if err != nil {
+			return CNN{}, err
+		}
+	} else {
+		err := json.Unmarshal(jsonData, &model)
+		if err != nil {
+			return CNN{}, err
+		}
+	}
+	layerInfos := model.Layers
 
 	// Create the CNN struct
-	cnn := CNN{}
+	cnn := CNN{Metadata: model.Metadata, Precision: model.Precision}
+	if _, err := precision.Parse(string(model.Precision)); err != nil {
+		return CNN{}, err
+	}
 
 	// Iterate through layerInfos and reconstruct layers
 	for _, layerInfo := range layerInfos {
@@ -99,12 +190,12 @@ func DecodeCNN(jsonData []byte) CNN {
 
 			d, err := json.Marshal(layerInfo.Properties)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 
 			err = json.Unmarshal(d, &fullyConnectedLayer)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 			cnn.Layers = append(cnn.Layers, fullyConnectedLayer)
 
@@ -113,12 +204,12 @@ func DecodeCNN(jsonData []byte) CNN {
 
 			d, err := json.Marshal(layerInfo.Properties)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 
 			err = json.Unmarshal(d, &convLayer)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 			cnn.Layers = append(cnn.Layers, convLayer)
 
@@ -127,16 +218,371 @@ func DecodeCNN(jsonData []byte) CNN {
 
 			d, err := json.Marshal(layerInfo.Properties)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 
 			err = json.Unmarshal(d, &maxPoolingLayer)
 			if err != nil {
-				panic(err)
+				return CNN{}, err
 			}
 			cnn.Layers = append(cnn.Layers, maxPoolingLayer)
+
+		case "FakeQuantLayer":
+			fakeQuantLayer := &layers.FakeQuantLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &fakeQuantLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			cnn.Layers = append(cnn.Layers, fakeQuantLayer)
+
+		case "InstanceNormLayer":
+			instanceNormLayer := &layers.InstanceNormLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &instanceNormLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			cnn.Layers = append(cnn.Layers, instanceNormLayer)
+
+		case "DropoutLayer":
+			dropoutLayer := &layers.DropoutLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &dropoutLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			if !layers.ValidDropoutRate(dropoutLayer.Rate) {
+				return CNN{}, fmt.Errorf("cnn: layer %d (DropoutLayer): rate %v is not in [0, 1)", len(cnn.Layers), dropoutLayer.Rate)
+			}
+			cnn.Layers = append(cnn.Layers, dropoutLayer)
+
+		case "SpatialDropoutLayer":
+			spatialDropoutLayer := &layers.SpatialDropoutLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &spatialDropoutLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			if !layers.ValidDropoutRate(spatialDropoutLayer.Rate) {
+				return CNN{}, fmt.Errorf("cnn: layer %d (SpatialDropoutLayer): rate %v is not in [0, 1)", len(cnn.Layers), spatialDropoutLayer.Rate)
+			}
+			cnn.Layers = append(cnn.Layers, spatialDropoutLayer)
+
+		case "AvgPoolingLayer":
+			avgPoolingLayer := &layers.AvgPoolingLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &avgPoolingLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			cnn.Layers = append(cnn.Layers, avgPoolingLayer)
+
+		case "GlobalAvgPoolingLayer":
+			globalAvgPoolingLayer := &layers.GlobalAvgPoolingLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &globalAvgPoolingLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			cnn.Layers = append(cnn.Layers, globalAvgPoolingLayer)
+
+		case "GlobalMaxPoolingLayer":
+			globalMaxPoolingLayer := &layers.GlobalMaxPoolingLayer{}
+
+			d, err := json.Marshal(layerInfo.Properties)
+			if err != nil {
+				return CNN{}, err
+			}
+
+			err = json.Unmarshal(d, &globalMaxPoolingLayer)
+			if err != nil {
+				return CNN{}, err
+			}
+			cnn.Layers = append(cnn.Layers, globalMaxPoolingLayer)
+
+		default:
+			return CNN{}, fmt.Errorf("cnn: unknown layer type %q", layerInfo.Type)
+		}
+
+		i := len(cnn.Layers) - 1
+		if compactPrecision(model.Precision) {
+			layer, err := restoreLayer(cnn.Layers[i], model.Precision, layerInfo.Tensors)
+			if err != nil {
+				return CNN{}, fmt.Errorf("cnn: layer %d (%s): %w", i, layerInfo.Type, err)
+			}
+			cnn.Layers[i] = layer
+		} else if err := checkMasks(cnn.Layers[i]); err != nil {
+			return CNN{}, fmt.Errorf("cnn: layer %d (%s): %w", i, layerInfo.Type, err)
 		}
 	}
 
-	return cnn
+	return cnn, nil
+}
+
+// fakeQuantProperties returns the properties of a FakeQuantLayer, which
+// are the same in every precision
+func fakeQuantProperties(layer *layers.FakeQuantLayer) interface{} {
+	return struct {
+		Depth, Height, Width int
+		Min, Max, Momentum   precision.Float
+		Initialized          bool
+	}{layer.Depth, layer.Height, layer.Width, layer.Min, layer.Max, layer.Momentum, layer.Initialized}
+}
+
+// dropoutProperties returns the properties of a dropout layer, which are
+// the same in every precision
+func dropoutProperties(layer *layers.DropoutLayer) interface{} {
+	return struct {
+		Depth, Height, Width int
+		Rate                 precision.Float
+		Seed                 int64
+	}{layer.Depth, layer.Height, layer.Width, layer.Rate, layer.Seed}
+}
+
+// avgPoolProperties returns the properties of an AvgPoolingLayer, which
+// are the same in every precision
+func avgPoolProperties(layer *layers.AvgPoolingLayer) interface{} {
+	return struct {
+		InputSize, InputDepth, PoolSize, OutputSize, Stride int
+	}{layer.InputSize, layer.InputDepth, layer.PoolSize, layer.OutputSize, layer.Stride}
+}
+
+// globalPoolProperties returns the properties of a global pooling layer,
+// which are the same in every precision
+func globalPoolProperties(depth, height, width int) interface{} {
+	return struct {
+		Depth, Height, Width int
+	}{depth, height, width}
+}
+
+// compactPrecision tells whether parameters stored in t go to LayerInfo.Tensors
+func compactPrecision(t precision.Type) bool {
+	return t != "" && t != precision.Float32
+}
+
+// encodeCompact serializes a CNN with its parameters stored in cnn.Precision
+func encodeCompact(cnn *CNN) []byte {
+	layerInfos := []LayerInfo{}
+
+	for _, layer := range cnn.Layers {
+		var properties interface{}
+		switch layer := layer.(type) {
+		case *layers.FullyConnectedLayer:
+			properties = struct {
+				InputSize, InputWidth, InputDepth, OutputSize int
+			}{layer.InputSize, layer.InputWidth, layer.InputDepth, layer.OutputSize}
+		case *layers.ConvLayer:
+			properties = struct {
+				InputSize, InputDepth, NumFilters, KernelSize, OutputSize, Stride int
+			}{layer.InputSize, layer.InputDepth, layer.NumFilters, layer.KernelSize, layer.OutputSize, layer.Stride}
+		case *layers.MaxPoolingLayer:
+			properties = struct {
+				InputSize, InputDepth, PoolSize, OutputSize, Stride int
+			}{layer.InputSize, layer.InputDepth, layer.PoolSize, layer.OutputSize, layer.Stride}
+		case *layers.FakeQuantLayer:
+			properties = fakeQuantProperties(layer)
+		case *layers.InstanceNormLayer:
+			// The running statistics are not parameters, they keep full precision
+			properties = struct {
+				Depth, Height, Width    int
+				Epsilon, Momentum       precision.Float
+				RunningMean, RunningVar []precision.Float
+			}{layer.Depth, layer.Height, layer.Width, layer.Epsilon, layer.Momentum, layer.RunningMean, layer.RunningVar}
+		case *layers.DropoutLayer:
+			properties = dropoutProperties(layer)
+		case *layers.SpatialDropoutLayer:
+			properties = dropoutProperties(&layer.DropoutLayer)
+		case *layers.AvgPoolingLayer:
+			properties = avgPoolProperties(layer)
+		case *layers.GlobalAvgPoolingLayer:
+			properties = globalPoolProperties(layer.Depth, layer.Height, layer.Width)
+		case *layers.GlobalMaxPoolingLayer:
+			properties = globalPoolProperties(layer.Depth, layer.Height, layer.Width)
+		default:
+			continue
+		}
+
+		info := LayerInfo{Type: LayerName(layer), Properties: properties}
+		if t, ok := layer.(Trainable); ok {
+			info.Tensors = map[string][]byte{}
+			for _, p := range t.Params() {
+				info.Tensors[p.Name] = precision.Encode(cnn.Precision, flatten(p.Values))
+				if p.Mask != nil {
+					info.Tensors[p.Name+maskSuffix] = precision.Encode(cnn.Precision, flatten(p.Mask))
+				}
+			}
+		}
+		layerInfos = append(layerInfos, info)
+	}
+
+	jsonData, err := json.Marshal(ModelFile{Metadata: cnn.Metadata, Precision: cnn.Precision, Layers: layerInfos})
+	if err != nil {
+		panic(err)
+	}
+	return jsonData
+}
+
+// maskSuffix ends the name of the tensor holding the pruning mask of a parameter
+const maskSuffix = ".mask"
+
+// flatten returns the values of rows in a single slice
+func flatten(rows [][]precision.Float) []precision.Float {
+	var values []precision.Float
+	for _, row := range rows {
+		values = append(values, row...)
+	}
+	return values
+}
+
+// masked is a layer which can be given pruning masks
+type masked interface {
+	InitMasks()
+}
+
+// restoreLayer rebuilds a layer decoded from a compact file, which only
+// holds its hyperparameters, and loads its parameters and their pruning
+// masks from tensors
+func restoreLayer(layer Layer, t precision.Type, tensors map[string][]byte) (Layer, error) {
+	switch l := layer.(type) {
+	case *layers.FullyConnectedLayer:
+		layer = layers.NewFullyConnectedLayer(l.InputWidth, l.InputDepth, l.OutputSize)
+	case *layers.ConvLayer:
+		layer = layers.NewConvLayer(l.InputSize, l.InputDepth, l.NumFilters, l.KernelSize, l.Stride)
+	case *layers.MaxPoolingLayer:
+		layer = layers.NewMaxPoolingLayer(l.InputSize, l.InputDepth, l.PoolSize, l.Stride)
+	case *layers.InstanceNormLayer:
+		restored := layers.NewInstanceNormLayer(l.Depth, l.Height, l.Width)
+		restored.Epsilon, restored.Momentum = l.Epsilon, l.Momentum
+		if len(l.RunningMean) != len(restored.RunningMean) || len(l.RunningVar) != len(restored.RunningVar) {
+			return nil, fmt.Errorf("running statistics do not have %d channels", restored.Channels())
+		}
+		copy(restored.RunningMean, l.RunningMean)
+		copy(restored.RunningVar, l.RunningVar)
+		layer = restored
+	}
+
+	trainable, ok := layer.(Trainable)
+	if !ok {
+		return layer, nil
+	}
+	if m, ok := layer.(masked); ok {
+		for name := range tensors {
+			if strings.HasSuffix(name, maskSuffix) {
+				m.InitMasks()
+				break
+			}
+		}
+	}
+	for _, p := range trainable.Params() {
+		if err := loadTensor(p.Values, t, tensors, p.Name); err != nil {
+			return nil, err
+		}
+		if p.Mask != nil {
+			if err := loadTensor(p.Mask, t, tensors, p.Name+maskSuffix); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return layer, nil
+}
+
+// loadTensor decodes the tensor of the given name into rows
+func loadTensor(rows [][]precision.Float, t precision.Type, tensors map[string][]byte, name string) error {
+	data, ok := tensors[name]
+	if !ok {
+		return fmt.Errorf("missing tensor %q", name)
+	}
+	values, err := precision.Decode(t, data)
+	if err != nil {
+		return fmt.Errorf("tensor %q: %w", name, err)
+	}
+
+	size := 0
+	for _, row := range rows {
+		size += len(row)
+	}
+	if len(values) != size {
+		return fmt.Errorf("tensor %q holds %d values, expected %d", name, len(values), size)
+	}
+	for _, row := range rows {
+		values = values[copy(row, values):]
+	}
+	return nil
+}
+
+// checkMasks checks that the pruning masks of a decoded layer, if any, are
+// shaped like its parameters
+func checkMasks(layer Layer) error {
+	var values, mask [][]precision.Float
+	var biases, biasMask []precision.Float
+	switch l := layer.(type) {
+	case *layers.FullyConnectedLayer:
+		values, mask, biases, biasMask = l.Weights, l.WeightMask, l.Biases, l.BiasMask
+	case *layers.ConvLayer:
+		values, mask, biases, biasMask = flattenRows(l.Kernels), flattenRows(l.KernelMask), l.Biases, l.BiasMask
+	default:
+		return nil
+	}
+	if mask == nil && biasMask == nil {
+		return nil
+	}
+	if !sameShape(mask, values) || len(biasMask) != len(biases) {
+		return fmt.Errorf("pruning masks are not shaped like the parameters")
+	}
+	return nil
+}
+
+// flattenRows returns the innermost rows of a 4D tensor
+func flattenRows(x [][][][]precision.Float) [][]precision.Float {
+	var rows [][]precision.Float
+	for _, t := range x {
+		for _, m := range t {
+			rows = append(rows, m...)
+		}
+	}
+	return rows
+}
+
+// sameShape tells whether two matrices have rows of the same lengths
+func sameShape(a, b [][]precision.Float) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+	}
+	return true
 }
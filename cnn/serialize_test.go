@@ -0,0 +1,184 @@
+package cnn
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func newTestCNN() *CNN {
+	cn := NewCNN()
+	cn.AddConvLayer(8, 1, 2, 3, 1)
+	cn.AddMaxPoolingLayer(6, 2, 2, 2)
+	cn.AddFullyConnectedLayer(3, 2, 4)
+	cn.Metadata = Metadata{
+		InputShape:   []int{1, 8, 8},
+		ChannelOrder: ChannelsGray,
+		Scale:        1 / 255.0,
+		Labels:       []string{"a", "b", "c", "d"},
+	}
+	return cn
+}
+
+func TestEncodeDecodeKeepsMetadata(t *testing.T) {
+	cn := newTestCNN()
+	decoded := DecodeCNN(EncodeCNN(cn))
+
+	if !reflect.DeepEqual(decoded.Metadata, cn.Metadata) {
+		t.Errorf("Metadata was not preserved. Got %+v, expected %+v", decoded.Metadata, cn.Metadata)
+	}
+	if len(decoded.Layers) != len(cn.Layers) {
+		t.Errorf("Got %d layers, expected %d", len(decoded.Layers), len(cn.Layers))
+	}
+}
+
+func TestDecodeLegacyLayerList(t *testing.T) {
+	legacy := `[{"Type":"MaxPoolingLayer","Properties":{"InputSize":4,"InputDepth":1,"PoolSize":2,"OutputSize":2,"Stride":2}}]`
+	decoded := DecodeCNN([]byte(legacy))
+
+	if len(decoded.Layers) != 1 || len(decoded.Metadata.InputShape) != 0 {
+		t.Errorf("Legacy model was not decoded correctly: %+v", decoded)
+	}
+}
+
+func TestPreprocess(t *testing.T) {
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	img.SetGray(1, 0, color.Gray{Y: 255})
+	img.SetGray(0, 1, color.Gray{Y: 51})
+
+	m := Metadata{InputShape: []int{1, 2, 2}, ChannelOrder: ChannelsGray, Scale: 1 / 255.0, Mean: []precision.Float{0.5}, Std: []precision.Float{0.5}}
+	input, err := m.Preprocess(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][][]precision.Float{{{-1, 1}, {-0.6, -1}}}
+	for y := range expected[0] {
+		for x := range expected[0][y] {
+			if d := input[0][y][x] - expected[0][y][x]; d > 1e-6 || d < -1e-6 {
+				t.Errorf("Preprocess did not produce the expected output. Got %v, expected %v", input, expected)
+			}
+		}
+	}
+
+	m.InputShape = nil
+	if _, err := m.Preprocess(img); err == nil {
+		t.Errorf("Preprocess without input shape should fail")
+	}
+}
+
+func TestPredictImageResizes(t *testing.T) {
+	cn := newTestCNN()
+	class, output, err := cn.PredictImage(image.NewGray(image.Rect(0, 0, 16, 16)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(output) != 4 || class < 0 || class >= 4 {
+		t.Errorf("Unexpected prediction: class %d, output %v", class, output)
+	}
+}
+
+func TestEncodePrecision(t *testing.T) {
+	cn := newTestCNN()
+	input := make3D(1, 8, 8)
+	for y := range input[0] {
+		for x := range input[0][y] {
+			input[0][y][x] = precision.Float(x*y) / 49
+		}
+	}
+	expected := cn.Infer(input)
+	full := len(EncodeCNN(cn))
+
+	for _, typ := range []precision.Type{precision.Float64, precision.Float16, precision.BFloat16} {
+		cn.Precision = typ
+		data := EncodeCNN(cn)
+		decoded := DecodeCNN(data)
+		if decoded.Precision != typ {
+			t.Errorf("%s: decoded precision %q", typ, decoded.Precision)
+		}
+		if typ != precision.Float64 && len(data) >= full/2 {
+			t.Errorf("%s: %d bytes is not smaller than half the float32 model (%d bytes)", typ, len(data), full)
+		}
+
+		// Parameters are rounded to the storage type
+		params, decodedParams := cn.Params(), decoded.Params()
+		for i := range params {
+			for j, row := range params[i].Values {
+				for k, v := range row {
+					if got := decodedParams[i].Values[j][k]; got != precision.Round(typ, v) {
+						t.Fatalf("%s: %s[%d][%d] decoded as %g, expected %g", typ, params[i].Name, j, k, got, precision.Round(typ, v))
+					}
+				}
+			}
+		}
+
+		// The decoded network is fully usable
+		output := decoded.Infer(input)
+		for i := range expected {
+			if d := output[i] - expected[i]; d > 1e-2 || d < -1e-2 {
+				t.Errorf("%s: output %v, expected %v", typ, output, expected)
+				break
+			}
+		}
+		decoded.ForwardPropagate(input)
+		decoded.BackPropagate(1)
+	}
+}
+
+func TestInstanceNormSerialization(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"input": {"shape": [1, 8, 8]}, "layers": [
+		{"type": "conv", "filters": 2, "kernelSize": 3}, {"type": "instancenorm"},
+		{"type": "maxpool", "poolSize": 2}, {"type": "fc", "units": 4}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	input := make3D(1, 8, 8)
+	for i := 0; i < 20; i++ {
+		for y := range input[0] {
+			for x := range input[0][y] {
+				input[0][y][x] = precision.Float((x+i)*y%7) / 7
+			}
+		}
+		cn.ForwardPropagate(input)
+		cn.BackPropagate(i % 4)
+	}
+
+	// In inference mode the network computes what Infer does
+	expected := cn.Infer(input)
+	cn.SetTraining(false)
+	if output := cn.ForwardPropagate(input); !reflect.DeepEqual(output, expected) {
+		t.Errorf("inference mode output %v, expected %v", output, expected)
+	}
+
+	for _, typ := range []precision.Type{precision.Float32, precision.Float16} {
+		cn.Precision = typ
+		decoded := DecodeCNN(EncodeCNN(cn))
+		if norm := decoded.Layers[1].(*layers.InstanceNormLayer); norm.RunningMean != nil || norm.RunningVar != nil {
+			t.Errorf("%s: running statistics restored for a volume", typ)
+		}
+		output := decoded.Infer(input)
+		for i := range expected {
+			if d := output[i] - expected[i]; d > 1e-2 || d < -1e-2 {
+				t.Errorf("%s: output %v, expected %v", typ, output, expected)
+				break
+			}
+		}
+	}
+
+	rebuilt, err := NewSpec(cn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebuilt.Layers[1].Type != SpecIN {
+		t.Errorf("NewSpec described the instance normalization as %+v", rebuilt.Layers[1])
+	}
+}
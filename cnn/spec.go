@@ -0,0 +1,398 @@
+package cnn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+	"gopkg.in/yaml.v3"
+)
+
+// Layer types accepted in a LayerSpec
+const (
+	SpecConv    = "conv"
+	SpecMaxPool = "maxpool"
+	SpecFC      = "fc"
+	SpecIN      = "instancenorm"
+	SpecDropout = "dropout"
+	SpecSpatial = "spatialdropout"
+	SpecAvgPool = "avgpool"
+	SpecGAP     = "globalavgpool"
+	SpecGMP     = "globalmaxpool"
+)
+
+// Optimizer types accepted in an OptimizerSpec
+const (
+	SpecSGD = "sgd"
+)
+
+// Weight initializers accepted in a LayerSpec
+const (
+	InitHeNormal      = "he_normal"
+	InitHeUniform     = "he_uniform"
+	InitXavierNormal  = "xavier_normal"
+	InitXavierUniform = "xavier_uniform"
+	InitLeCunNormal   = "lecun_normal"
+	InitLeCunUniform  = "lecun_uniform"
+	InitOrthogonal    = "orthogonal"
+	InitZeros         = "zeros"
+	InitConstant      = "constant" // Every weight set to LayerSpec.Value
+)
+
+// ModelSpec is a declarative description of a network: its input, its
+// layers and how to train it. Specs are read from and written to JSON or
+// YAML so that architectures can be versioned as configuration files.
+type ModelSpec struct {
+	Name      string        `json:"name,omitempty" yaml:"name,omitempty"`
+	Input     InputSpec     `json:"input" yaml:"input"`
+	Labels    []string      `json:"labels,omitempty" yaml:"labels,omitempty,flow"`
+	Layers    []LayerSpec   `json:"layers" yaml:"layers"`
+	Seed      int64         `json:"seed,omitempty" yaml:"seed,omitempty"` // Seed of the weights and dropout masks, layers.DefaultSeed if zero
+	Optimizer OptimizerSpec `json:"optimizer" yaml:"optimizer"`
+	Loss      string        `json:"loss,omitempty" yaml:"loss,omitempty"`
+
+	Regularization *Regularization `json:"regularization,omitempty" yaml:"regularization,omitempty"`
+}
+
+// InputSpec describes the input volume and its preprocessing, see Metadata
+type InputSpec struct {
+	Shape        []int             `json:"shape" yaml:"shape,flow"`
+	ChannelOrder string            `json:"channelOrder,omitempty" yaml:"channelOrder,omitempty"`
+	Scale        precision.Float   `json:"scale,omitempty" yaml:"scale,omitempty"`
+	Mean         []precision.Float `json:"mean,omitempty" yaml:"mean,omitempty,flow"`
+	Std          []precision.Float `json:"std,omitempty" yaml:"std,omitempty,flow"`
+}
+
+// LayerSpec describes one layer. Only the fields relevant to Type are used;
+// the input size and depth of every layer are inferred from the previous one.
+type LayerSpec struct {
+	Type        string          `json:"type" yaml:"type"`
+	Filters     int             `json:"filters,omitempty" yaml:"filters,omitempty"`         // conv
+	KernelSize  int             `json:"kernelSize,omitempty" yaml:"kernelSize,omitempty"`   // conv
+	PoolSize    int             `json:"poolSize,omitempty" yaml:"poolSize,omitempty"`       // maxpool, avgpool
+	Stride      int             `json:"stride,omitempty" yaml:"stride,omitempty"`           // conv (default 1), maxpool and avgpool (default PoolSize)
+	Units       int             `json:"units,omitempty" yaml:"units,omitempty"`             // fc
+	Rate        precision.Float `json:"rate,omitempty" yaml:"rate,omitempty"`               // dropout, spatialdropout
+	Initializer string          `json:"initializer,omitempty" yaml:"initializer,omitempty"` // conv, fc (default he_normal)
+	Value       precision.Float `json:"value,omitempty" yaml:"value,omitempty"`             // constant initializer
+}
+
+// OptimizerSpec describes the optimizer used for training
+type OptimizerSpec struct {
+	Type         string          `json:"type" yaml:"type"`
+	LearningRate precision.Float `json:"learningRate" yaml:"learningRate"`
+	Momentum     precision.Float `json:"momentum,omitempty" yaml:"momentum,omitempty"`
+}
+
+// ParseSpec decodes a JSON or YAML model specification. Unknown keys are rejected.
+func ParseSpec(data []byte) (*ModelSpec, error) {
+	spec := &ModelSpec{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(spec); err != nil {
+			return nil, fmt.Errorf("cnn: invalid JSON spec: %w", err)
+		}
+	} else {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(spec); err != nil {
+			return nil, fmt.Errorf("cnn: invalid YAML spec: %w", err)
+		}
+	}
+	return spec, nil
+}
+
+// LoadSpec reads a model specification from a JSON or YAML file
+func LoadSpec(path string) (*ModelSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParseSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Marshal encodes the specification as "json" or "yaml"
+func (s *ModelSpec) Marshal(format string) ([]byte, error) {
+	switch format {
+	case "json":
+		return json.MarshalIndent(s, "", "  ")
+	case "yaml", "yml":
+		return yaml.Marshal(s)
+	}
+	return nil, fmt.Errorf("cnn: unknown spec format %q", format)
+}
+
+// Save writes the specification to a file, in YAML or JSON depending on its extension
+func (s *ModelSpec) Save(path string) error {
+	format := strings.TrimPrefix(filepath.Ext(path), ".")
+	data, err := s.Marshal(format)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// Shapes validates the specification and infers the output shape of every
+// layer (depth, height, width). The error names the first invalid layer.
+func (s *ModelSpec) Shapes() ([][]int, error) {
+	if len(s.Input.Shape) != 3 {
+		return nil, errors.New("cnn: input shape must be [depth, height, width]")
+	}
+	depth, height, width := s.Input.Shape[0], s.Input.Shape[1], s.Input.Shape[2]
+	if depth < 1 || height < 1 || height != width {
+		return nil, fmt.Errorf("cnn: input shape %v must be positive and square", s.Input.Shape)
+	}
+	if len(s.Layers) == 0 {
+		return nil, errors.New("cnn: spec has no layers")
+	}
+	if !validLoss(s.Loss) {
+		return nil, fmt.Errorf("cnn: unknown loss %q", s.Loss)
+	}
+	if s.Optimizer.Type != "" && s.Optimizer.Type != SpecSGD {
+		return nil, fmt.Errorf("cnn: unknown optimizer %q", s.Optimizer.Type)
+	}
+	if s.Optimizer.LearningRate < 0 || s.Optimizer.Momentum < 0 || s.Optimizer.Momentum >= 1 {
+		return nil, errors.New("cnn: optimizer learning rate must be positive and momentum in [0, 1)")
+	}
+	if s.Regularization != nil && !s.Regularization.validate() {
+		return nil, errors.New("cnn: regularization settings must be positive")
+	}
+
+	shapes := make([][]int, len(s.Layers))
+	size := height
+	for i, l := range s.Layers {
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("cnn: layer %d (%s): %s", i, l.Type, fmt.Sprintf(format, args...))
+		}
+		if depth == 0 {
+			return nil, fail("no layer can follow a fully connected layer")
+		}
+		if _, ok := initializer(l.Initializer, l.Value); !ok {
+			return nil, fail("unknown initializer %q", l.Initializer)
+		}
+
+		switch l.Type {
+		case SpecConv:
+			stride := orDefault(l.Stride, 1)
+			if l.Filters < 1 || l.KernelSize < 1 || stride < 1 {
+				return nil, fail("filters, kernelSize and stride must be positive")
+			}
+			if l.KernelSize > size {
+				return nil, fail("kernel size %d is larger than the %dx%d input", l.KernelSize, size, size)
+			}
+			depth, size = l.Filters, (size-l.KernelSize)/stride+1
+			shapes[i] = []int{depth, size, size}
+
+		case SpecMaxPool, SpecAvgPool:
+			stride := orDefault(l.Stride, l.PoolSize)
+			if l.PoolSize < 1 || stride < 1 {
+				return nil, fail("poolSize and stride must be positive")
+			}
+			if l.PoolSize > size {
+				return nil, fail("pool size %d is larger than the %dx%d input", l.PoolSize, size, size)
+			}
+			size = (size-l.PoolSize)/stride + 1
+			shapes[i] = []int{depth, size, size}
+
+		case SpecIN:
+			shapes[i] = []int{depth, size, size}
+
+		case SpecGAP, SpecGMP:
+			size = 1
+			shapes[i] = []int{depth, size, size}
+
+		case SpecDropout, SpecSpatial:
+			if !layers.ValidDropoutRate(l.Rate) {
+				return nil, fail("rate must be in [0, 1)")
+			}
+			shapes[i] = []int{depth, size, size}
+
+		case SpecFC:
+			if l.Units < 1 {
+				return nil, fail("units must be positive")
+			}
+			// The output is a flat vector which cannot feed another layer
+			depth, size = 0, 0
+			shapes[i] = []int{1, 1, l.Units}
+
+		default:
+			return nil, fail("unknown layer type")
+		}
+	}
+
+	// Fully convolutional networks end with a global pooling layer whose
+	// channels are the outputs
+	last := s.Layers[len(s.Layers)-1]
+	if last.Type != SpecFC && last.Type != SpecGAP && last.Type != SpecGMP {
+		return nil, errors.New("cnn: the last layer must be fully connected or global pooling")
+	}
+	outputs := shapes[len(shapes)-1]
+	if len(s.Labels) != 0 && len(s.Labels) != outputs[0]*outputs[2] {
+		return nil, fmt.Errorf("cnn: %d labels given for %d outputs", len(s.Labels), outputs[0]*outputs[2])
+	}
+	return shapes, nil
+}
+
+// Validate checks the specification without building the network
+func (s *ModelSpec) Validate() error {
+	_, err := s.Shapes()
+	return err
+}
+
+// Build validates the specification and creates the described network
+func (s *ModelSpec) Build() (*CNN, error) {
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := NewCNN()
+	if s.Seed != 0 {
+		c.SetSeed(s.Seed)
+	}
+	depth, size := s.Input.Shape[0], s.Input.Shape[1]
+	for _, l := range s.Layers {
+		c.Initializer, _ = initializer(l.Initializer, l.Value)
+		switch l.Type {
+		case SpecConv:
+			c.AddConvLayer(size, depth, l.Filters, l.KernelSize, orDefault(l.Stride, 1))
+		case SpecMaxPool:
+			c.AddMaxPoolingLayer(size, depth, l.PoolSize, orDefault(l.Stride, l.PoolSize))
+		case SpecAvgPool:
+			c.AddAvgPoolingLayer(size, depth, l.PoolSize, orDefault(l.Stride, l.PoolSize))
+		case SpecGAP:
+			c.AddGlobalAvgPoolingLayer(depth, size, size)
+		case SpecGMP:
+			c.AddGlobalMaxPoolingLayer(depth, size, size)
+		case SpecFC:
+			c.AddFullyConnectedLayer(size, depth, l.Units)
+		case SpecIN:
+			c.AddInstanceNormLayer(depth, size, size)
+		case SpecDropout:
+			c.AddDropoutLayer(depth, size, size, l.Rate)
+		case SpecSpatial:
+			c.AddSpatialDropoutLayer(depth, size, size, l.Rate)
+		}
+		shape := c.Layers[len(c.Layers)-1].OutputShape()
+		depth, size = shape[0], shape[1]
+	}
+	c.Initializer = nil
+
+	c.Metadata = Metadata{
+		InputShape:   append([]int(nil), s.Input.Shape...),
+		ChannelOrder: s.Input.ChannelOrder,
+		Scale:        s.Input.Scale,
+		Mean:         s.Input.Mean,
+		Std:          s.Input.Std,
+		Labels:       s.Labels,
+	}
+	c.Loss = s.Loss
+	if s.Regularization != nil {
+		c.Regularization = *s.Regularization
+	}
+	if s.Optimizer.LearningRate > 0 {
+		c.Optimizer = NewSGD(s.Optimizer.LearningRate, s.Optimizer.Momentum)
+	}
+	return c, nil
+}
+
+// NewSpec describes an existing network as a ModelSpec
+func NewSpec(c *CNN) (*ModelSpec, error) {
+	s := &ModelSpec{
+		Input: InputSpec{
+			Shape:        c.Metadata.InputShape,
+			ChannelOrder: c.Metadata.ChannelOrder,
+			Scale:        c.Metadata.Scale,
+			Mean:         c.Metadata.Mean,
+			Std:          c.Metadata.Std,
+		},
+		Labels:    c.Metadata.Labels,
+		Optimizer: OptimizerSpec{Type: SpecSGD, LearningRate: layers.DefaultLearningRate},
+		Loss:      c.Loss,
+	}
+	if len(s.Input.Shape) == 0 && len(c.Layers) > 0 {
+		s.Input.Shape = c.Layers[0].InputShape()
+	}
+	if c.Regularization != (Regularization{}) {
+		regularization := c.Regularization
+		s.Regularization = &regularization
+	}
+	if sgd, ok := c.Optimizer.(*SGD); ok {
+		s.Optimizer.LearningRate = sgd.LearningRate
+		s.Optimizer.Momentum = sgd.Momentum
+	}
+
+	for i, layer := range c.Layers {
+		switch layer := layer.(type) {
+		case *layers.ConvLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecConv, Filters: layer.NumFilters, KernelSize: layer.KernelSize, Stride: layer.Stride})
+		case *layers.MaxPoolingLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecMaxPool, PoolSize: layer.PoolSize, Stride: layer.Stride})
+		case *layers.AvgPoolingLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecAvgPool, PoolSize: layer.PoolSize, Stride: layer.Stride})
+		case *layers.GlobalAvgPoolingLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecGAP})
+		case *layers.GlobalMaxPoolingLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecGMP})
+		case *layers.FullyConnectedLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecFC, Units: layer.OutputSize})
+		case *layers.InstanceNormLayer:
+			if layer.Channels() != layer.Depth {
+				return nil, fmt.Errorf("cnn: layer %d (%s) normalizes features, which a spec cannot describe", i, LayerName(layer))
+			}
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecIN})
+		case *layers.DropoutLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecDropout, Rate: layer.Rate})
+		case *layers.SpatialDropoutLayer:
+			s.Layers = append(s.Layers, LayerSpec{Type: SpecSpatial, Rate: layer.Rate})
+		default:
+			return nil, fmt.Errorf("cnn: layer %d (%s) cannot be described by a spec", i, LayerName(layer))
+		}
+	}
+	return s, nil
+}
+
+// initializer returns the initializer of the given name, He normal if the
+// name is empty. Glorot is accepted as another name of Xavier, and value is
+// the weight of the constant initializer.
+func initializer(name string, value precision.Float) (layers.Initializer, bool) {
+	switch name {
+	case "", InitHeNormal:
+		return layers.HeNormal{}, true
+	case InitHeUniform:
+		return layers.HeUniform{}, true
+	case InitXavierNormal, "glorot_normal":
+		return layers.XavierNormal{}, true
+	case InitXavierUniform, "glorot_uniform":
+		return layers.XavierUniform{}, true
+	case InitLeCunNormal:
+		return layers.LeCunNormal{}, true
+	case InitLeCunUniform:
+		return layers.LeCunUniform{}, true
+	case InitOrthogonal:
+		return layers.Orthogonal{}, true
+	case InitZeros:
+		return layers.Zeros{}, true
+	case InitConstant:
+		return layers.Constant{Value: value}, true
+	}
+	return nil, false
+}
+
+// orDefault returns v, or def when v is not set
+func orDefault(v, def int) int {
+	if v == 0 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,279 @@
+package cnn
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestParseSpecYAML(t *testing.T) {
+	spec, err := LoadSpec("../examples/mnist/model.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shapes, err := spec.Shapes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]int{{6, 24, 24}, {6, 12, 12}, {9, 10, 10}, {9, 5, 5}, {1, 1, 10}}
+	if !reflect.DeepEqual(shapes, expected) {
+		t.Errorf("Shapes returned %v, expected %v", shapes, expected)
+	}
+
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cn.Layers) != 5 || len(cn.Metadata.Labels) != 10 || cn.Optimizer.(*SGD).LearningRate != 0.01 {
+		t.Errorf("Build did not follow the spec: %+v", cn)
+	}
+}
+
+func TestSpecRoundTrip(t *testing.T) {
+	spec, err := NewSpec(newTestCNN())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"json", "yaml"} {
+		data, err := spec.Marshal(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := ParseSpec(data)
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", format, err, data)
+		}
+		if !reflect.DeepEqual(parsed, spec) {
+			t.Errorf("%s round trip changed the spec. Got %+v, expected %+v", format, parsed, spec)
+		}
+	}
+}
+
+func TestSpecValidation(t *testing.T) {
+	for _, tc := range []struct {
+		spec  string
+		error string
+	}{
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "conv", "filters": 2, "kernelSize": 9}, {"type": "fc", "units": 2}]}`, "larger than"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "fc", "units": 2}, {"type": "fc", "units": 2}]}`, "follow a fully connected"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "maxpool", "poolSize": 2}]}`, "last layer"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "dense", "units": 2}]}`, "unknown layer type"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "fc", "units": 2}], "loss": "hinge"}`, "unknown loss"},
+		{`{"input": {"shape": [1, 8]}, "layers": [{"type": "fc", "units": 2}]}`, "input shape"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "dropout", "rate": 1}, {"type": "fc", "units": 2}]}`, "rate"},
+		{`{"input": {"shape": [2, 8, 8]}, "labels": ["a", "b", "c"], "layers": [{"type": "globalmaxpool"}]}`, "3 labels given for 2 outputs"},
+		{`{"input": {"shape": [1, 8, 8]}, "layers": [{"type": "fc", "units": 2}], "regularization": {"l2": -0.1}}`, "regularization"},
+	} {
+		spec, err := ParseSpec([]byte(tc.spec))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := spec.Validate(); err == nil || !strings.Contains(err.Error(), tc.error) {
+			t.Errorf("Validate(%s) returned %v, expected an error containing %q", tc.spec, err, tc.error)
+		}
+	}
+
+	if _, err := ParseSpec([]byte("input: {shape: [1, 8, 8]}\nlayer: []\n")); err == nil {
+		t.Errorf("ParseSpec accepted an unknown key")
+	}
+}
+
+func TestSpecDropout(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"input": {"shape": [1, 8, 8]}, "layers": [
+		{"type": "conv", "filters": 2, "kernelSize": 3, "stride": 1}, {"type": "spatialdropout", "rate": 0.2},
+		{"type": "maxpool", "poolSize": 2, "stride": 2}, {"type": "dropout", "rate": 0.5}, {"type": "fc", "units": 4}],
+		"optimizer": {"type": "sgd", "learningRate": 0.01}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Dropout layers survive a save and reload, and only act while training
+	decoded := DecodeCNN(EncodeCNN(cn))
+	rebuilt, err := NewSpec(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rebuilt.Layers, spec.Layers) {
+		t.Errorf("decoded network has layers %+v, expected %+v", rebuilt.Layers, spec.Layers)
+	}
+
+	input := make3D(1, 8, 8)
+	for y := range input[0] {
+		for x := range input[0][y] {
+			input[0][y][x] = precision.Float(x+y) / 14
+		}
+	}
+	expected := append([]precision.Float(nil), cn.Infer(input)...)
+	if reflect.DeepEqual(cn.ForwardPropagate(input), expected) {
+		t.Error("dropout did not change the training output")
+	}
+	cn.SetTraining(false)
+	if !reflect.DeepEqual(cn.ForwardPropagate(input), expected) {
+		t.Error("dropout changed the inference output")
+	}
+}
+
+func TestSpecFullyConvolutional(t *testing.T) {
+	// The seed starts every class channel active, ReLU outputs which are all
+	// zero would get no gradient
+	spec, err := ParseSpec([]byte(`{"input": {"shape": [1, 8, 8]}, "seed": 4, "labels": ["a", "b", "c"], "layers": [
+		{"type": "conv", "filters": 4, "kernelSize": 3, "stride": 1}, {"type": "avgpool", "poolSize": 2, "stride": 2},
+		{"type": "conv", "filters": 3, "kernelSize": 1, "stride": 1}, {"type": "globalavgpool"}],
+		"optimizer": {"type": "sgd", "learningRate": 0.05}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The channels of the global pooling layer are the class scores
+	input := make3D(1, 8, 8)
+	for y := range input[0] {
+		for x := range input[0][y] {
+			input[0][y][x] = precision.Float((x*5+y*3)%7) / 7
+		}
+	}
+	for i := 0; i < 200; i++ {
+		cn.ForwardPropagate(input)
+		cn.BackPropagate(2)
+	}
+	if label, probs, err := cn.Predict(input); err != nil || label != 2 {
+		t.Errorf("Predict returned %d %v (%v), expected class 2", label, probs, err)
+	}
+
+	decoded := DecodeCNN(EncodeCNN(cn))
+	rebuilt, err := NewSpec(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(rebuilt.Layers, spec.Layers) {
+		t.Errorf("decoded network has layers %+v, expected %+v", rebuilt.Layers, spec.Layers)
+	}
+	if output, expected := decoded.Infer(input), cn.Infer(input); !reflect.DeepEqual(output, expected) {
+		t.Errorf("decoded network output %v, expected %v", output, expected)
+	}
+}
+
+func TestSpecInitialization(t *testing.T) {
+	build := func(seed int64, initializer string) *CNN {
+		spec := &ModelSpec{
+			Input: InputSpec{Shape: []int{1, 8, 8}},
+			Seed:  seed,
+			Layers: []LayerSpec{
+				{Type: SpecConv, Filters: 2, KernelSize: 3, Initializer: initializer},
+				{Type: SpecConv, Filters: 2, KernelSize: 3, Initializer: initializer},
+				{Type: SpecFC, Units: 3, Initializer: initializer},
+			},
+		}
+		cn, err := spec.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cn
+	}
+	kernel := func(cn *CNN, i int) precision.Float {
+		return cn.Layers[i].(*layers.ConvLayer).Kernels[0][0][0][0]
+	}
+
+	// The seed makes networks reproducible, while layers differ from each other
+	a, b := build(7, ""), build(7, "")
+	if !reflect.DeepEqual(a.Params(), b.Params()) {
+		t.Error("networks built from the same seed differ")
+	}
+	if kernel(a, 0) == kernel(a, 1) {
+		t.Error("layers of the same network have the same weights")
+	}
+	if kernel(build(8, ""), 0) == kernel(a, 0) {
+		t.Error("networks built from different seeds have the same weights")
+	}
+
+	if zeros := build(7, InitZeros); kernel(zeros, 1) != 0 || zeros.Layers[2].(*layers.FullyConnectedLayer).Weights[0][0] != 0 {
+		t.Error("the zeros initializer left weights")
+	}
+	constant, err := ParseSpec([]byte(`{"input": {"shape": [1, 8, 8]}, "layers": [
+		{"type": "conv", "filters": 2, "kernelSize": 3, "initializer": "constant", "value": 0.5},
+		{"type": "fc", "units": 2}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn, err := constant.Build(); err != nil {
+		t.Fatal(err)
+	} else if kernel(cn, 0) != 0.5 {
+		t.Errorf("the constant initializer gave weight %v, expected 0.5", kernel(cn, 0))
+	}
+	for _, name := range []string{InitHeUniform, InitXavierNormal, "glorot_uniform", InitLeCunNormal, InitOrthogonal} {
+		if kernel(build(7, name), 0) == kernel(a, 0) {
+			t.Errorf("initializer %s gave He normal weights", name)
+		}
+	}
+	if _, err := (&ModelSpec{Input: InputSpec{Shape: []int{1, 8, 8}}, Layers: []LayerSpec{{Type: SpecFC, Units: 2, Initializer: "ones"}}}).Build(); err == nil {
+		t.Error("Build accepted an unknown initializer")
+	}
+}
+
+func TestSpecDropoutSeeds(t *testing.T) {
+	build := func(seed int64) []int64 {
+		spec := &ModelSpec{
+			Input: InputSpec{Shape: []int{1, 8, 8}},
+			Seed:  seed,
+			Layers: []LayerSpec{
+				{Type: SpecConv, Filters: 2, KernelSize: 3}, {Type: SpecSpatial, Rate: 0.5},
+				{Type: SpecDropout, Rate: 0.5}, {Type: SpecFC, Units: 3},
+			},
+		}
+		cn, err := spec.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return []int64{cn.Layers[1].(*layers.SpatialDropoutLayer).Seed, cn.Layers[2].(*layers.DropoutLayer).Seed}
+	}
+
+	// Masks follow the seed of the network, and differ between layers
+	a := build(7)
+	if !reflect.DeepEqual(a, build(7)) {
+		t.Error("networks built from the same seed have different dropout seeds")
+	}
+	if a[0] == a[1] {
+		t.Errorf("both dropout layers have seed %d", a[0])
+	}
+	if b := build(8); b[0] == a[0] || b[1] == a[1] {
+		t.Errorf("networks built from seeds 7 and 8 have dropout seeds %v and %v", a, b)
+	}
+}
+
+func TestSpecRegularization(t *testing.T) {
+	spec, err := ParseSpec([]byte(`
+input: {shape: [1, 8, 8]}
+layers: [{type: conv, filters: 2, kernelSize: 3}, {type: fc, units: 2}]
+regularization: {l2: 0.0005, excludeBiases: true, maxNorm: 3, clipNorm: 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cn, err := spec.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Regularization{L2: 0.0005, ExcludeBiases: true, MaxNorm: 3, ClipNorm: 1}
+	if cn.Regularization != expected {
+		t.Errorf("Build set regularization %+v, expected %+v", cn.Regularization, expected)
+	}
+
+	written, err := NewSpec(cn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written.Regularization == nil || *written.Regularization != expected {
+		t.Errorf("NewSpec wrote regularization %+v, expected %+v", written.Regularization, expected)
+	}
+}
@@ -0,0 +1,75 @@
+package cnn
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+)
+
+// LayerName returns the type identifier of a layer, as used in model files
+func LayerName(layer Layer) string {
+	switch layer.(type) {
+	case *layers.FullyConnectedLayer:
+		return "FullyConnectedLayer"
+	case *layers.ConvLayer:
+		return "ConvLayer"
+	case *layers.MaxPoolingLayer:
+		return "MaxPoolingLayer"
+	case *layers.FakeQuantLayer:
+		return "FakeQuantLayer"
+	case *layers.InstanceNormLayer:
+		return "InstanceNormLayer"
+	case *layers.DropoutLayer:
+		return "DropoutLayer"
+	case *layers.SpatialDropoutLayer:
+		return "SpatialDropoutLayer"
+	case *layers.AvgPoolingLayer:
+		return "AvgPoolingLayer"
+	case *layers.GlobalAvgPoolingLayer:
+		return "GlobalAvgPoolingLayer"
+	case *layers.GlobalMaxPoolingLayer:
+		return "GlobalMaxPoolingLayer"
+	}
+	return fmt.Sprintf("%T", layer)
+}
+
+// ParamCount returns the number of trainable parameters of a layer
+func ParamCount(layer Layer) int {
+	switch layer := layer.(type) {
+	case *layers.FullyConnectedLayer:
+		return layer.InputSize*layer.OutputSize + layer.OutputSize
+	case *layers.ConvLayer:
+		return layer.NumFilters*layer.InputDepth*layer.KernelSize*layer.KernelSize + layer.NumFilters
+	case *layers.InstanceNormLayer:
+		return 2 * layer.Channels()
+	}
+	return 0
+}
+
+// Summary writes a table describing every layer of the network with its
+// output shape and parameter count
+func (c *CNN) Summary(w io.Writer) {
+	fmt.Fprintf(w, "%-4s %-22s %-14s %-14s %10s\n", "#", "Layer", "Input", "Output", "Params")
+	fmt.Fprintln(w, strings.Repeat("-", 68))
+
+	total := 0
+	for i, layer := range c.Layers {
+		params := ParamCount(layer)
+		total += params
+		fmt.Fprintf(w, "%-4d %-22s %-14s %-14s %10d\n", i, LayerName(layer), formatShape(layer.InputShape()), formatShape(layer.OutputShape()), params)
+	}
+
+	fmt.Fprintln(w, strings.Repeat("-", 68))
+	fmt.Fprintf(w, "Total params: %d\n", total)
+}
+
+// formatShape prints a shape as depth x height x width
+func formatShape(shape []int) string {
+	parts := make([]string, len(shape))
+	for i, d := range shape {
+		parts[i] = fmt.Sprint(d)
+	}
+	return strings.Join(parts, "x")
+}
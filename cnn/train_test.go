@@ -0,0 +1,75 @@
+package cnn
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/layers"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+func TestTrainingStepDoesNotAllocate(t *testing.T) {
+	if raceEnabled {
+		t.Skip("the race detector allocates")
+	}
+	defer layers.SetWorkers(0)
+
+	rng := rand.New(rand.NewSource(1))
+	image := make3D(1, 28, 28)
+	for _, row := range image[0] {
+		for i := range row {
+			row[i] = precision.Float(rng.Float32())
+		}
+	}
+
+	for _, workers := range []int{1, 4} {
+		layers.SetWorkers(workers)
+
+		// The MNIST architecture, large enough for the layers to split their work
+		cn := NewCNN()
+		cn.AddConvLayer(28, 1, 6, 5, 1)
+		cn.AddMaxPoolingLayer(24, 6, 2, 2)
+		cn.AddConvLayer(12, 6, 9, 3, 1)
+		cn.AddMaxPoolingLayer(10, 9, 2, 2)
+		cn.AddFullyConnectedLayer(5, 9, 10)
+		cn.Optimizer = NewSGD(0.01, 0.9)
+		cn.Regularization = Regularization{L1: 1e-5, L2: 1e-4, MaxNorm: 3, ClipValue: 1, ClipNorm: 5}
+
+		step := func() {
+			cn.ForwardPropagate(image)
+			cn.BackPropagate(3)
+		}
+		step() // Warm up the buffers
+
+		if allocs := testing.AllocsPerRun(20, step); allocs != 0 {
+			t.Errorf("%d workers: a training step performs %v allocations, expected none", workers, allocs)
+		}
+	}
+}
+
+func BenchmarkTrainingStep(b *testing.B) {
+	for _, workers := range []int{1, 4} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defer layers.SetWorkers(0)
+			layers.SetWorkers(workers)
+
+			cn := NewCNN()
+			cn.AddConvLayer(28, 1, 6, 5, 1)
+			cn.AddMaxPoolingLayer(24, 6, 2, 2)
+			cn.AddConvLayer(12, 6, 9, 3, 1)
+			cn.AddMaxPoolingLayer(10, 9, 2, 2)
+			cn.AddFullyConnectedLayer(5, 9, 10)
+			image := make3D(1, 28, 28)
+			cn.ForwardPropagate(image)
+			cn.BackPropagate(0)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cn.ForwardPropagate(image)
+				cn.BackPropagate(i % 10)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package trainlog
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io"
+	"strconv"
+	"time"
+)
+
+// CSVWriter writes one "step,wall_time,tag,value" row per value, so that
+// new tags may appear at any step. Histograms are summarized by their
+// min, max, mean and std, tagged "<tag>/min" and so on, and by the count of
+// NaN and infinite values, tagged "<tag>/nonfinite", when there are any.
+type CSVWriter struct {
+	w      io.Writer
+	buffer *bufio.Writer
+	csv    *csv.Writer
+	header bool
+}
+
+// NewCSVWriter creates a writer of CSV rows to w, which is closed along
+// with the writer when it is an io.Closer
+func NewCSVWriter(w io.Writer) Writer {
+	buffer := bufio.NewWriter(w)
+	return &CSVWriter{w: w, buffer: buffer, csv: csv.NewWriter(buffer)}
+}
+
+// WriteScalars writes one row per scalar
+func (c *CSVWriter) WriteScalars(step int, wall time.Time, tags []string, values []float64) error {
+	for i, tag := range tags {
+		c.row(step, wall, tag, values[i])
+	}
+	return c.flush()
+}
+
+// WriteHistograms writes four or five rows per histogram
+func (c *CSVWriter) WriteHistograms(step int, wall time.Time, tags []string, histograms []*Histogram) error {
+	for i, tag := range tags {
+		h := histograms[i]
+		c.row(step, wall, tag+"/min", h.Min)
+		c.row(step, wall, tag+"/max", h.Max)
+		c.row(step, wall, tag+"/mean", h.Mean())
+		c.row(step, wall, tag+"/std", h.Std())
+		if h.NonFinite > 0 {
+			c.row(step, wall, tag+"/nonfinite", h.NonFinite)
+		}
+	}
+	return c.flush()
+}
+
+// Close flushes the rows and closes the underlying writer
+func (c *CSVWriter) Close() error {
+	if err := c.flush(); err != nil {
+		return err
+	}
+	return closeWriter(c.w)
+}
+
+// row writes a row, after the header for the first one
+func (c *CSVWriter) row(step int, wall time.Time, tag string, value float64) {
+	if !c.header {
+		c.csv.Write([]string{"step", "wall_time", "tag", "value"})
+		c.header = true
+	}
+	c.csv.Write([]string{
+		strconv.Itoa(step),
+		strconv.FormatFloat(wallTime(wall), 'f', 3, 64),
+		tag,
+		strconv.FormatFloat(value, 'g', -1, 64),
+	})
+}
+
+// flush writes the buffered rows through, so that the file can be
+// followed while training
+func (c *CSVWriter) flush() error {
+	c.csv.Flush()
+	if err := c.csv.Error(); err != nil {
+		return err
+	}
+	return c.buffer.Flush()
+}
+
+// wallTime returns a time in seconds since the Unix epoch
+func wallTime(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
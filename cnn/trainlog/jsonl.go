@@ -0,0 +1,57 @@
+package trainlog
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"time"
+)
+
+// JSONLWriter writes one JSON object per line and per call, holding the
+// step, the wall time in seconds and either "scalars" or "histograms"
+// keyed by tag. NaN and infinite scalars are written as null.
+type JSONLWriter struct {
+	w   io.Writer
+	enc *json.Encoder
+}
+
+// jsonlRecord is a line of a JSONLWriter
+type jsonlRecord struct {
+	Step       int                   `json:"step"`
+	WallTime   float64               `json:"wallTime"`
+	Scalars    map[string]*float64   `json:"scalars,omitempty"`
+	Histograms map[string]*Histogram `json:"histograms,omitempty"`
+}
+
+// NewJSONLWriter creates a writer of JSON lines to w, which is closed along
+// with the writer when it is an io.Closer
+func NewJSONLWriter(w io.Writer) Writer {
+	return &JSONLWriter{w: w, enc: json.NewEncoder(w)}
+}
+
+// WriteScalars writes a line of scalars
+func (j *JSONLWriter) WriteScalars(step int, wall time.Time, tags []string, values []float64) error {
+	r := jsonlRecord{Step: step, WallTime: wallTime(wall), Scalars: map[string]*float64{}}
+	for i, tag := range tags {
+		if v := values[i]; math.IsNaN(v) || math.IsInf(v, 0) {
+			r.Scalars[tag] = nil
+		} else {
+			r.Scalars[tag] = &v
+		}
+	}
+	return j.enc.Encode(r)
+}
+
+// WriteHistograms writes a line of histograms
+func (j *JSONLWriter) WriteHistograms(step int, wall time.Time, tags []string, histograms []*Histogram) error {
+	r := jsonlRecord{Step: step, WallTime: wallTime(wall), Histograms: map[string]*Histogram{}}
+	for i, tag := range tags {
+		r.Histograms[tag] = histograms[i]
+	}
+	return j.enc.Encode(r)
+}
+
+// Close closes the underlying writer
+func (j *JSONLWriter) Close() error {
+	return closeWriter(j.w)
+}
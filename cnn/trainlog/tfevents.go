@@ -0,0 +1,180 @@
+package trainlog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// EventWriter writes TensorBoard event files: a sequence of TFRecords, each
+// holding an Event protocol buffer. Only the few fields needed for scalars
+// and histograms are encoded, by hand, to avoid depending on TensorFlow.
+type EventWriter struct {
+	w       io.Writer
+	started bool
+	buffer  []byte
+}
+
+// Field numbers of the protocol buffers of tensorflow/core/util/event.proto
+// and tensorflow/core/framework/summary.proto
+const (
+	eventWallTime    = 1 // double
+	eventStep        = 2 // int64
+	eventFileVersion = 3 // string
+	eventSummary     = 5 // Summary
+
+	summaryValue = 1 // repeated Summary.Value
+
+	valueTag    = 1 // string
+	valueSimple = 2 // float
+	valueHisto  = 5 // HistogramProto
+
+	histoMin         = 1 // double
+	histoMax         = 2 // double
+	histoNum         = 3 // double
+	histoSum         = 4 // double
+	histoSumSquares  = 5 // double
+	histoBucketLimit = 6 // packed double
+	histoBucket      = 7 // packed double
+)
+
+// Protocol buffer wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// NewEventWriter creates a writer of TensorBoard events to w, which is
+// closed along with the writer when it is an io.Closer
+func NewEventWriter(w io.Writer) Writer {
+	return &EventWriter{w: w}
+}
+
+// EventFileName returns the conventional name of an event file created at t,
+// which TensorBoard requires to find it
+func EventFileName(t time.Time) string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return fmt.Sprintf("events.out.tfevents.%d.%s", t.Unix(), host)
+}
+
+// WriteScalars writes an event of simple values
+func (e *EventWriter) WriteScalars(step int, wall time.Time, tags []string, values []float64) error {
+	var summary []byte
+	for i, tag := range tags {
+		var value []byte
+		value = appendString(value, valueTag, tag)
+		value = appendFixed32(value, valueSimple, math.Float32bits(float32(values[i])))
+		summary = appendBytes(summary, summaryValue, value)
+	}
+	return e.writeEvent(step, wall, summary)
+}
+
+// WriteHistograms writes an event of histograms
+func (e *EventWriter) WriteHistograms(step int, wall time.Time, tags []string, histograms []*Histogram) error {
+	var summary []byte
+	for i, tag := range tags {
+		h := histograms[i]
+		var histo []byte
+		histo = appendDouble(histo, histoMin, h.Min)
+		histo = appendDouble(histo, histoMax, h.Max)
+		histo = appendDouble(histo, histoNum, h.Num)
+		histo = appendDouble(histo, histoSum, h.Sum)
+		histo = appendDouble(histo, histoSumSquares, h.SumSquares)
+		histo = appendPackedDoubles(histo, histoBucketLimit, h.Limits)
+		histo = appendPackedDoubles(histo, histoBucket, h.Counts)
+
+		var value []byte
+		value = appendString(value, valueTag, tag)
+		value = appendBytes(value, valueHisto, histo)
+		summary = appendBytes(summary, summaryValue, value)
+	}
+	return e.writeEvent(step, wall, summary)
+}
+
+// Close closes the underlying writer
+func (e *EventWriter) Close() error {
+	return closeWriter(e.w)
+}
+
+// writeEvent writes an event holding a summary, after the file version
+// event which starts every file
+func (e *EventWriter) writeEvent(step int, wall time.Time, summary []byte) error {
+	if !e.started {
+		var event []byte
+		event = appendDouble(event, eventWallTime, wallTime(wall))
+		event = appendString(event, eventFileVersion, "brain.Event:2")
+		if err := e.writeRecord(event); err != nil {
+			return err
+		}
+		e.started = true
+	}
+
+	var event []byte
+	event = appendDouble(event, eventWallTime, wallTime(wall))
+	event = appendVarint(appendTag(event, eventStep, wireVarint), uint64(step))
+	event = appendBytes(event, eventSummary, summary)
+	return e.writeRecord(event)
+}
+
+// writeRecord writes data framed as a TFRecord: its length, the masked
+// CRC of the length, the data and the masked CRC of the data
+func (e *EventWriter) writeRecord(data []byte) error {
+	b := e.buffer[:0]
+	b = binary.LittleEndian.AppendUint64(b, uint64(len(data)))
+	b = binary.LittleEndian.AppendUint32(b, maskedCRC(b[:8]))
+	b = append(b, data...)
+	b = binary.LittleEndian.AppendUint32(b, maskedCRC(data))
+	e.buffer = b
+	_, err := e.w.Write(b)
+	return err
+}
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// maskedCRC returns the CRC-32C of data masked as TFRecords require
+func maskedCRC(data []byte) uint32 {
+	crc := crc32.Checksum(data, crcTable)
+	return (crc>>15 | crc<<17) + 0xa282ead8
+}
+
+func appendTag(b []byte, field, wire int) []byte {
+	return appendVarint(b, uint64(field<<3|wire))
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	return binary.AppendUvarint(b, v)
+}
+
+func appendDouble(b []byte, field int, v float64) []byte {
+	return binary.LittleEndian.AppendUint64(appendTag(b, field, wireFixed64), math.Float64bits(v))
+}
+
+func appendFixed32(b []byte, field int, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(appendTag(b, field, wireFixed32), v)
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendString(b []byte, field int, s string) []byte {
+	return appendBytes(b, field, []byte(s))
+}
+
+func appendPackedDoubles(b []byte, field int, values []float64) []byte {
+	b = appendVarint(appendTag(b, field, wireBytes), uint64(8*len(values)))
+	for _, v := range values {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	return b
+}
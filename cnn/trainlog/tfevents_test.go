@@ -0,0 +1,134 @@
+package trainlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// readRecords splits TFRecords and checks their checksums
+func readRecords(t *testing.T, r io.Reader) [][]byte {
+	t.Helper()
+	var records [][]byte
+	for {
+		header := make([]byte, 12)
+		if _, err := io.ReadFull(r, header); err == io.EOF {
+			return records
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if binary.LittleEndian.Uint32(header[8:]) != maskedCRC(header[:8]) {
+			t.Fatal("wrong length checksum")
+		}
+		data := make([]byte, binary.LittleEndian.Uint64(header)+4)
+		if _, err := io.ReadFull(r, data); err != nil {
+			t.Fatal(err)
+		}
+		n := len(data) - 4
+		if binary.LittleEndian.Uint32(data[n:]) != maskedCRC(data[:n]) {
+			t.Fatal("wrong data checksum")
+		}
+		records = append(records, data[:n])
+	}
+}
+
+// field is a decoded protocol buffer field
+type field struct {
+	number int
+	value  uint64 // Varint and fixed values
+	data   []byte // Length-delimited values
+}
+
+// decode splits a protocol buffer message into its fields
+func decode(t *testing.T, b []byte) []field {
+	t.Helper()
+	var fields []field
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := field{number: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.value, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.value, b = binary.LittleEndian.Uint64(b), b[8:]
+		case wireFixed32:
+			f.value, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.data, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// get returns the fields with the given number
+func get(fields []field, number int) []field {
+	var matching []field
+	for _, f := range fields {
+		if f.number == number {
+			matching = append(matching, f)
+		}
+	}
+	return matching
+}
+
+func TestEventWriter(t *testing.T) {
+	var out bytes.Buffer
+	l := newTestLogger(NewEventWriter(&out))
+	if err := l.Scalars(7, map[string]float64{Loss: 0.25, Accuracy: 0.75}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Histograms(8, map[string]*Histogram{"w": NewHistogram([]precision.Float{-1, 0, 1, 1}, 2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	records := readRecords(t, &out)
+	if len(records) != 3 {
+		t.Fatalf("got %d records, expected the file version and 2 events", len(records))
+	}
+	version := decode(t, records[0])
+	if v := get(version, eventFileVersion); len(v) != 1 || string(v[0].data) != "brain.Event:2" {
+		t.Errorf("first event %v does not hold the file version", version)
+	}
+
+	event := decode(t, records[1])
+	if w := get(event, eventWallTime); len(w) != 1 || math.Float64frombits(w[0].value) != 1700000000.5 {
+		t.Errorf("wrong wall time in %v", event)
+	}
+	if s := get(event, eventStep); len(s) != 1 || s[0].value != 7 {
+		t.Errorf("wrong step in %v", event)
+	}
+	values := get(decode(t, get(event, eventSummary)[0].data), summaryValue)
+	expected := map[string]float32{Accuracy: 0.75, Loss: 0.25}
+	if len(values) != len(expected) {
+		t.Fatalf("got %d values, expected %d", len(values), len(expected))
+	}
+	for _, v := range values {
+		fields := decode(t, v.data)
+		tag := string(get(fields, valueTag)[0].data)
+		if simple := math.Float32frombits(uint32(get(fields, valueSimple)[0].value)); simple != expected[tag] {
+			t.Errorf("%s: got %f, expected %f", tag, simple, expected[tag])
+		}
+	}
+
+	event = decode(t, records[2])
+	value := decode(t, get(decode(t, get(event, eventSummary)[0].data), summaryValue)[0].data)
+	histo := decode(t, get(value, valueHisto)[0].data)
+	double := func(number int) float64 { return math.Float64frombits(get(histo, number)[0].value) }
+	if double(histoMin) != -1 || double(histoMax) != 1 || double(histoNum) != 4 || double(histoSum) != 1 || double(histoSumSquares) != 3 {
+		t.Errorf("wrong histogram statistics %v", histo)
+	}
+	counts := get(histo, histoBucket)[0].data
+	if len(counts) != 16 || math.Float64frombits(binary.LittleEndian.Uint64(counts)) != 1 || math.Float64frombits(binary.LittleEndian.Uint64(counts[8:])) != 3 {
+		t.Errorf("wrong bucket counts %v", counts)
+	}
+}
@@ -0,0 +1,269 @@
+// Package trainlog records training metrics so that runs can be compared
+// after the fact. A Logger sends scalars, such as the loss, accuracy and
+// learning rate, and histograms of the weights of every layer to any number
+// of Writers: CSV, JSON lines and TensorBoard event files are provided.
+package trainlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// Common scalar tags
+const (
+	Loss         = "loss"
+	Accuracy     = "accuracy"
+	LearningRate = "learning_rate"
+	Epoch        = "epoch"
+)
+
+// DefaultBuckets is the number of histogram buckets used when Logger.Buckets is zero
+const DefaultBuckets = 30
+
+// Writer stores the values recorded by a Logger. Tags are given in
+// increasing order.
+type Writer interface {
+	WriteScalars(step int, wall time.Time, tags []string, values []float64) error
+	WriteHistograms(step int, wall time.Time, tags []string, histograms []*Histogram) error
+	Close() error
+}
+
+// Histogram summarizes a set of values. Bucket i counts the values from the
+// previous limit, or Min for the first bucket, to Limits[i], which is
+// excluded except for the last bucket. NaN and infinite values are only
+// counted by NonFinite, the other fields summarize the finite values.
+type Histogram struct {
+	Min        float64   `json:"min"`
+	Max        float64   `json:"max"`
+	Num        float64   `json:"num"`
+	Sum        float64   `json:"sum"`
+	SumSquares float64   `json:"sumSquares"`
+	Limits     []float64 `json:"limits"`
+	Counts     []float64 `json:"counts"`
+	NonFinite  float64   `json:"nonFinite,omitempty"`
+}
+
+// NewHistogram returns the histogram of values over buckets of equal width
+func NewHistogram(values []precision.Float, buckets int) *Histogram {
+	if buckets < 1 {
+		buckets = 1
+	}
+	h := &Histogram{Min: math.Inf(1), Max: math.Inf(-1)}
+	for _, v := range values {
+		x := float64(v)
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			h.NonFinite++
+			continue
+		}
+		h.Min = math.Min(h.Min, x)
+		h.Max = math.Max(h.Max, x)
+		h.Sum += x
+		h.SumSquares += x * x
+		h.Num++
+	}
+	if h.Num == 0 {
+		h.Min, h.Max = 0, 0
+	}
+
+	if h.Max == h.Min {
+		buckets = 1
+	}
+	width := (h.Max - h.Min) / float64(buckets)
+	h.Limits = make([]float64, buckets)
+	h.Counts = make([]float64, buckets)
+	for i := range h.Limits {
+		h.Limits[i] = h.Min + float64(i+1)*width
+	}
+	h.Limits[buckets-1] = h.Max
+	for _, v := range values {
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			continue
+		}
+		i := buckets - 1
+		if width > 0 {
+			i = int((float64(v) - h.Min) / width)
+			if i >= buckets {
+				i = buckets - 1
+			}
+		}
+		h.Counts[i]++
+	}
+	return h
+}
+
+// Mean returns the mean of the values
+func (h *Histogram) Mean() float64 {
+	if h.Num == 0 {
+		return 0
+	}
+	return h.Sum / h.Num
+}
+
+// Std returns the standard deviation of the values
+func (h *Histogram) Std() float64 {
+	if h.Num == 0 {
+		return 0
+	}
+	mean := h.Mean()
+	return math.Sqrt(math.Max(h.SumSquares/h.Num-mean*mean, 0))
+}
+
+// Logger records training metrics to its writers
+type Logger struct {
+	Writers []Writer
+	Buckets int // Number of buckets of the weight histograms, DefaultBuckets if zero
+
+	now func() time.Time
+}
+
+// New creates a logger writing to the given writers
+func New(writers ...Writer) *Logger {
+	return &Logger{Writers: writers, now: time.Now}
+}
+
+// Names of the metric files created by Open
+const (
+	CSVFile   = "metrics.csv"
+	JSONLFile = "metrics.jsonl"
+)
+
+// Open creates dir if needed and returns a logger writing to metrics.csv,
+// metrics.jsonl and a TensorBoard event file in it, so that
+// "tensorboard --logdir dir" shows the run
+func Open(dir string) (*Logger, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	l := New()
+	for _, open := range []func() (Writer, error){
+		func() (Writer, error) { return createWriter(filepath.Join(dir, CSVFile), NewCSVWriter) },
+		func() (Writer, error) { return createWriter(filepath.Join(dir, JSONLFile), NewJSONLWriter) },
+		func() (Writer, error) {
+			return createWriter(filepath.Join(dir, EventFileName(time.Now())), NewEventWriter)
+		},
+	} {
+		w, err := open()
+		if err != nil {
+			l.Close()
+			return nil, err
+		}
+		l.Writers = append(l.Writers, w)
+	}
+	return l, nil
+}
+
+// createWriter creates a file and a writer on it, which closes the file
+func createWriter(path string, newWriter func(io.Writer) Writer) (Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(f), nil
+}
+
+// Scalars records named values at a training step
+func (l *Logger) Scalars(step int, scalars map[string]float64) error {
+	tags := sortedTags(scalars)
+	values := make([]float64, len(tags))
+	for i, tag := range tags {
+		values[i] = scalars[tag]
+	}
+	wall := l.clock()
+	var errs []error
+	for _, w := range l.Writers {
+		errs = append(errs, w.WriteScalars(step, wall, tags, values))
+	}
+	return errors.Join(errs...)
+}
+
+// Histograms records named histograms at a training step
+func (l *Logger) Histograms(step int, histograms map[string]*Histogram) error {
+	tags := sortedTags(histograms)
+	values := make([]*Histogram, len(tags))
+	for i, tag := range tags {
+		values[i] = histograms[tag]
+	}
+	wall := l.clock()
+	var errs []error
+	for _, w := range l.Writers {
+		errs = append(errs, w.WriteHistograms(step, wall, tags, values))
+	}
+	return errors.Join(errs...)
+}
+
+// Weights records the histogram of every parameter of the network, tagged
+// with the index and type of its layer, such as "0_ConvLayer/kernels"
+func (l *Logger) Weights(step int, c *cnn.CNN) error {
+	buckets := l.Buckets
+	if buckets == 0 {
+		buckets = DefaultBuckets
+	}
+	histograms := map[string]*Histogram{}
+	for i, layer := range c.Layers {
+		t, ok := layer.(cnn.Trainable)
+		if !ok {
+			continue
+		}
+		for _, p := range t.Params() {
+			var values []precision.Float
+			for _, row := range p.Values {
+				values = append(values, row...)
+			}
+			histograms[fmt.Sprintf("%d_%s/%s", i, cnn.LayerName(layer), p.Name)] = NewHistogram(values, buckets)
+		}
+	}
+	return l.Histograms(step, histograms)
+}
+
+// Close closes every writer
+func (l *Logger) Close() error {
+	var errs []error
+	for _, w := range l.Writers {
+		errs = append(errs, w.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// clock returns the wall time of a record
+func (l *Logger) clock() time.Time {
+	if l.now == nil {
+		return time.Now()
+	}
+	return l.now()
+}
+
+// OptimizerLearningRate returns the learning rate of an optimizer, 0 when
+// it has none
+func OptimizerLearningRate(o cnn.Optimizer) float64 {
+	if sgd, ok := o.(*cnn.SGD); ok {
+		return float64(sgd.LearningRate)
+	}
+	return 0
+}
+
+// sortedTags returns the keys of a map in increasing order
+func sortedTags[T any](m map[string]T) []string {
+	tags := make([]string, 0, len(m))
+	for tag := range m {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// closeWriter closes w if it is a Closer
+func closeWriter(w io.Writer) error {
+	if c, ok := w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package trainlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+)
+
+// newTestLogger returns a logger with a fixed clock
+func newTestLogger(writers ...Writer) *Logger {
+	l := New(writers...)
+	l.now = func() time.Time { return time.Unix(1700000000, 500000000) }
+	return l
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]precision.Float{-1, 0, 0.5, 1, 3}, 4)
+	expected := &Histogram{
+		Min: -1, Max: 3, Num: 5, Sum: 3.5, SumSquares: 11.25,
+		Limits: []float64{0, 1, 2, 3},
+		Counts: []float64{1, 2, 1, 1},
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("got %+v, expected %+v", h, expected)
+	}
+	if h.Mean() != 0.7 {
+		t.Errorf("mean %f, expected 0.7", h.Mean())
+	}
+
+	// Constant values fall in a single bucket
+	h = NewHistogram([]precision.Float{2, 2}, 10)
+	if !reflect.DeepEqual(h.Limits, []float64{2}) || !reflect.DeepEqual(h.Counts, []float64{2}) || h.Std() != 0 {
+		t.Errorf("constant values gave %+v", h)
+	}
+
+	// NaN and infinite values are counted apart
+	inf := precision.Float(math.Inf(1))
+	h = NewHistogram([]precision.Float{-1, inf, 1, precision.Float(math.NaN()), -inf}, 2)
+	expected = &Histogram{
+		Min: -1, Max: 1, Num: 2, Sum: 0, SumSquares: 2,
+		Limits:    []float64{0, 1},
+		Counts:    []float64{1, 1},
+		NonFinite: 3,
+	}
+	if !reflect.DeepEqual(h, expected) {
+		t.Errorf("got %+v, expected %+v", h, expected)
+	}
+	if h = NewHistogram([]precision.Float{inf}, 2); h.Num != 0 || h.NonFinite != 1 || h.Min != 0 || h.Max != 0 {
+		t.Errorf("only infinite values gave %+v", h)
+	}
+}
+
+func TestCSVAndJSONL(t *testing.T) {
+	var csvOut, jsonOut bytes.Buffer
+	l := newTestLogger(NewCSVWriter(&csvOut), NewJSONLWriter(&jsonOut))
+	if err := l.Scalars(10, map[string]float64{Loss: 0.25, Accuracy: 0.5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Histograms(20, map[string]*Histogram{"w": NewHistogram([]precision.Float{1, 3}, 2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&csvOut).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]string{
+		{"step", "wall_time", "tag", "value"},
+		{"10", "1700000000.500", "accuracy", "0.5"},
+		{"10", "1700000000.500", "loss", "0.25"},
+		{"20", "1700000000.500", "w/min", "1"},
+		{"20", "1700000000.500", "w/max", "3"},
+		{"20", "1700000000.500", "w/mean", "2"},
+		{"20", "1700000000.500", "w/std", "1"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("CSV rows %v, expected %v", rows, expected)
+	}
+
+	var records []jsonlRecord
+	scanner := bufio.NewScanner(&jsonOut)
+	for scanner.Scan() {
+		var r jsonlRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("%v: %s", err, scanner.Text())
+		}
+		records = append(records, r)
+	}
+	if len(records) != 2 || records[0].Step != 10 || *records[0].Scalars[Loss] != 0.25 || records[0].WallTime != 1700000000.5 ||
+		records[1].Histograms["w"].Max != 3 || !reflect.DeepEqual(records[1].Histograms["w"].Counts, []float64{1, 1}) {
+		t.Errorf("unexpected JSON lines %+v", records)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	c := cnn.NewCNN()
+	c.AddConvLayer(6, 1, 2, 3, 1)
+	c.AddMaxPoolingLayer(4, 2, 2, 2)
+	c.AddFullyConnectedLayer(2, 2, 3)
+
+	var out bytes.Buffer
+	l := newTestLogger(NewJSONLWriter(&out))
+	l.Buckets = 5
+	if err := l.Weights(1, c); err != nil {
+		t.Fatal(err)
+	}
+	var r jsonlRecord
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	tags := sortedTags(r.Histograms)
+	expected := []string{"0_ConvLayer/biases", "0_ConvLayer/kernels", "2_FullyConnectedLayer/biases", "2_FullyConnectedLayer/weights"}
+	if !reflect.DeepEqual(tags, expected) {
+		t.Errorf("histogram tags %v, expected %v", tags, expected)
+	}
+	if h := r.Histograms["0_ConvLayer/kernels"]; h.Num != 18 || len(h.Counts) != 5 {
+		t.Errorf("kernel histogram %+v, expected 18 values in 5 buckets", h)
+	}
+}
+
+func TestOpen(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "run")
+	l, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Scalars(1, map[string]float64{Loss: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if len(names) != 3 || !strings.HasPrefix(names[0], "events.out.tfevents.") || names[1] != CSVFile || names[2] != JSONLFile {
+		t.Errorf("Open created %v", names)
+	}
+}
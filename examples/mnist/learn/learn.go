@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"math/rand"
@@ -11,47 +12,49 @@ import (
 	"time"
 
 	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/augment"
+	"github.com/ofauchon/go-cnn/cnn/dashboard"
+	"github.com/ofauchon/go-cnn/cnn/precision"
+	"github.com/ofauchon/go-cnn/cnn/trainlog"
 	"github.com/petar/GoMNIST"
 )
 
 // ConvertRawImageToFloat32 converts a raw MNIST image to [][]float32
-func ConvertRawImageToFloat32(rawImage GoMNIST.RawImage) [][][]float32 {
+func ConvertRawImageToFloat32(rawImage GoMNIST.RawImage) [][][]precision.Float {
 	imgWidth := 28
 	imgHeight := 28
 
-	var floatImage [][]float32
+	var floatImage [][]precision.Float
 
 	for h := 0; h < imgHeight; h++ {
-		var row []float32
+		var row []precision.Float
 
 		for w := 0; w < imgWidth; w++ {
 			// Convert raw byte to float32 and normalize to [0.0, 1.0]
-			pixelValue := float32(rawImage[h*imgWidth+w]) / 255.0
+			pixelValue := precision.Float(rawImage[h*imgWidth+w]) / 255.0
 			row = append(row, pixelValue)
 		}
 
 		floatImage = append(floatImage, row)
 	}
 
-	var ret [][][]float32
+	var ret [][][]precision.Float
 	ret = append(ret, floatImage)
 
 	return ret
 }
 
-// Returns the index of the highest value in the output vector
-func highestIndex(output []float32) uint8 {
-	var highestIndex uint8
-	var highestValue float32
+// mnistDataset exposes a GoMNIST set to the augmentation loader
+type mnistDataset struct {
+	set *GoMNIST.Set
+}
 
-	for i := 0; i < len(output); i++ {
-		if output[i] > highestValue {
-			highestValue = output[i]
-			highestIndex = uint8(i)
-		}
-	}
+func (d mnistDataset) Len() int {
+	return d.set.Count()
+}
 
-	return highestIndex
+func (d mnistDataset) Get(index int) ([][][]precision.Float, int) {
+	return ConvertRawImageToFloat32(d.set.Images[index]), int(d.set.Labels[index])
 }
 
 func convertLabelsToUint8(labels []GoMNIST.Label) []uint8 {
@@ -65,6 +68,10 @@ func convertLabelsToUint8(labels []GoMNIST.Label) []uint8 {
 }
 
 func main() {
+	serveDashboard := flag.Bool("dashboard", false, "serve a live view of training at http://localhost:6060/dashboard/")
+	logDir := flag.String("logdir", "", "write metrics as CSV, JSON lines and TensorBoard events to this directory")
+	flag.Parse()
+
 	rand.Seed(time.Now().UnixNano())
 
 	// For profiler
@@ -90,46 +97,86 @@ func main() {
 	cn.AddMaxPoolingLayer(10, 9, 2, 2)
 	cn.AddFullyConnectedLayer(5, 9, 10)
 
+	// Describe the expected input so that the saved model can preprocess raw images
+	cn.Metadata = cnn.Metadata{
+		InputShape:   []int{1, 28, 28},
+		ChannelOrder: cnn.ChannelsGray,
+		Scale:        1 / 255.0,
+		Labels:       []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"},
+	}
+
 	// Training speed/length
 	epochs := int(10)
 	batchSize := int(500)
 
 	// Keep track of detection success for statistics
 	resultsHistory := []bool{}
-	accuracy := float32(0)
+	accuracy := precision.Float(0)
 
 	accuracyTarget := 0.98
 
+	// Training samples are lightly shifted and rotated on the fly
+	loader := augment.NewLoader(mnistDataset{trainData}, 10, batchSize, 1)
+	loader.Shuffle = true
+	loader.Transform = augment.RandomApply(0.5, augment.Compose(
+		augment.Shift{MaxDX: 2, MaxDY: 2},
+		augment.Rotate{MaxDegrees: 10},
+	))
+
+	// Live dashboard next to the profiler
+	var board *dashboard.Dashboard
+	if *serveDashboard {
+		board = dashboard.New(cn.Metadata.Labels)
+		board.Register(http.DefaultServeMux)
+	}
+
+	// Metrics go to CSV, JSON lines and TensorBoard files
+	var logger *trainlog.Logger
+	if *logDir != "" {
+		logger, err = trainlog.Open(*logDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer logger.Close()
+	}
+	step := 0
+
 	// Iteration of training with whole dataset
 	for epoch := 1; epoch <= epochs; epoch++ {
 
 		// Batch processing
-		trainLength := trainData.Count()
+		loader.Reset()
+		batchStart := 0
+		if board != nil {
+			board.ResetConfusion()
+		}
 
-		for batchStart := 0; batchStart < trainLength && accuracy < float32(accuracyTarget); batchStart += batchSize {
-			batchEnd := batchStart + batchSize
-			if batchEnd > trainData.Count() {
-				batchEnd = trainData.Count()
-			}
+		for batch := loader.Next(); batch != nil && accuracy < precision.Float(accuracyTarget); batch = loader.Next() {
+			batchEnd := batchStart + len(batch)
 			resultsHistory = []bool{}
+			loss := 0.0
 
 			fmt.Printf("Epoch: %d, Acc: %.2fpct Batch %d-%d \n", epoch, accuracy*100, batchStart, batchEnd)
 
-			// Get a batch of train data (images/label)
-			batch := trainData.Images[batchStart:batchEnd]
-			labels := trainData.Labels[batchStart:batchEnd]
-
-			for i := 0; i < len(batch); i++ {
+			for _, sample := range batch {
 
 				// Forward pass
-				output := cn.ForwardPropagate(ConvertRawImageToFloat32(batch[i]))
+				output := cn.ForwardPropagate(sample.Image)
 
 				// Check result and store it in result history
-				result := highestIndex(output) == uint8(labels[i])
+				predicted := cnn.Argmax(output)
+				result := predicted == sample.Label
 				resultsHistory = append(resultsHistory, result)
+				if board != nil {
+					board.Observe(sample.Image, sample.Label, predicted)
+				}
+				if logger != nil || board != nil {
+					loss += cn.TargetLoss(output, sample.Target)
+				}
+				step++
 
 				// Back propagation
-				cn.BackPropagate(int(labels[i]))
+				cn.BackPropagateTarget(sample.Target)
 			}
 
 			// Compute results stats
@@ -139,14 +186,36 @@ func main() {
 					trueCnt += 1
 				}
 			}
-			accuracy = float32(trueCnt) / float32(len(resultsHistory))
+			accuracy = precision.Float(trueCnt) / precision.Float(len(resultsHistory))
+			batchStart = batchEnd
+
+			if logger != nil {
+				err := logger.Scalars(step, map[string]float64{
+					trainlog.Loss:         loss / float64(len(batch)),
+					trainlog.Accuracy:     float64(accuracy),
+					trainlog.LearningRate: trainlog.OptimizerLearningRate(cn.Optimizer),
+					trainlog.Epoch:        float64(epoch),
+				})
+				if err != nil {
+					log.Fatal(err)
+				}
+			}
+			if board != nil {
+				board.Record(step, epoch, loss/float64(len(batch)), float64(accuracy))
+				board.ShowFilters(cn)
+			}
 
 			//optimizer.update(cnn) // Adjust this based on your optimizer implementation
 		}
+		if logger != nil {
+			if err := logger.Weights(step, cn); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
 
 	fn := "/tmp/cnn.json"
-	if accuracy > float32(accuracyTarget) {
+	if accuracy > precision.Float(accuracyTarget) {
 		data := cnn.EncodeCNN(cn)
 
 		err := os.WriteFile(fn, data, 0644)
@@ -9,49 +9,10 @@ import (
 	"runtime/pprof"
 
 	"github.com/ofauchon/go-cnn/cnn"
+	"github.com/ofauchon/go-cnn/cnn/precision"
 	"github.com/petar/GoMNIST"
 )
 
-// Returns the index of the highest value in the output vector
-func highestIndex(output []float32) uint8 {
-	var highestIndex uint8
-	var highestValue float32
-
-	for i := 0; i < len(output); i++ {
-		if output[i] > highestValue {
-			highestValue = output[i]
-			highestIndex = uint8(i)
-		}
-	}
-
-	return highestIndex
-}
-
-// ConvertRawImageToFloat32 converts a raw MNIST image to [][]float32
-func ConvertRawImageToFloat32(rawImage GoMNIST.RawImage) [][][]float32 {
-	imgWidth := 28
-	imgHeight := 28
-
-	var floatImage [][]float32
-
-	for h := 0; h < imgHeight; h++ {
-		var row []float32
-
-		for w := 0; w < imgWidth; w++ {
-			// Convert raw byte to float32 and normalize to [0.0, 1.0]
-			pixelValue := float32(rawImage[h*imgWidth+w]) / 255.0
-			row = append(row, pixelValue)
-		}
-
-		floatImage = append(floatImage, row)
-	}
-
-	var ret [][][]float32
-	ret = append(ret, floatImage)
-
-	return ret
-}
-
 func main() {
 
 	// For profiler
@@ -80,7 +41,7 @@ func main() {
 
 	// Keep track of detection success for statistics
 	resultsHistory := []bool{}
-	accuracy := float32(0)
+	accuracy := precision.Float(0)
 
 	for i := 0; i < len(testData.Images); i++ {
 		if i%10 == 0 {
@@ -91,12 +52,16 @@ func main() {
 					trueCnt += 1
 				}
 			}
-			accuracy = float32(trueCnt) / float32(len(resultsHistory))
+			accuracy = precision.Float(trueCnt) / precision.Float(len(resultsHistory))
 			fmt.Printf("Image %d, Accuracy %f\n", i, accuracy*100)
 		}
 
-		output := cn.ForwardPropagate(ConvertRawImageToFloat32(testData.Images[i]))
-		result := highestIndex(output) == uint8(testData.Labels[i])
+		// The model metadata takes care of normalizing the raw image
+		class, _, err := cn.PredictImage(testData.Images[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+		result := class == int(testData.Labels[i])
 
 		resultsHistory = append(resultsHistory, result)
 
@@ -6,3 +6,5 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/petar/GoMNIST v0.0.0-20150320212226-2fbe10d0fa63
 )
+
+require gopkg.in/yaml.v3 v3.0.1